@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogEntry is an operation recorded by Options.AccessLog.
+type AccessLogEntry struct {
+	Time time.Time
+	// Op is "get", "once", "set", or "delete".
+	Op  string
+	Key string
+	// Outcome is "ok", "miss", or "error".
+	Outcome string
+	Latency time.Duration
+	Err     string `json:",omitempty"`
+}
+
+// accessRing keeps the most recent entries of one prefix.
+type accessRing struct {
+	mu      sync.Mutex
+	entries []AccessLogEntry
+	next    int
+	full    bool
+}
+
+func (r *accessRing) add(e AccessLogEntry) {
+	r.mu.Lock()
+	r.entries[r.next] = e
+	r.next++
+	if r.next == len(r.entries) {
+		r.next, r.full = 0, true
+	}
+	r.mu.Unlock()
+}
+
+// recent returns the entries, oldest first.
+func (r *accessRing) recent() []AccessLogEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]AccessLogEntry(nil), r.entries[:r.next]...)
+	}
+	entries := make([]AccessLogEntry, 0, len(r.entries))
+	entries = append(entries, r.entries[r.next:]...)
+	return append(entries, r.entries[:r.next]...)
+}
+
+func newAccessRings(sizes map[string]int) map[string]*accessRing {
+	rings := make(map[string]*accessRing, len(sizes))
+	for prefix, size := range sizes {
+		if size > 0 {
+			rings[prefix] = &accessRing{entries: make([]AccessLogEntry, size)}
+		}
+	}
+	return rings
+}
+
+// logAccess records the operation in the ring of the longest
+// Options.AccessLog prefix of the key. errp points to the result of the
+// operation, so it can be deferred.
+func (cd *Cache) logAccess(op, key string, start time.Time, errp *error) {
+	var ring *accessRing
+	prefixLen := -1
+	for prefix, r := range cd.accessRings {
+		if len(prefix) > prefixLen && strings.HasPrefix(key, prefix) {
+			ring, prefixLen = r, len(prefix)
+		}
+	}
+	if ring == nil {
+		return
+	}
+
+	e := AccessLogEntry{
+		Time:    cd.now(),
+		Op:      op,
+		Key:     key,
+		Outcome: "ok",
+		Latency: time.Since(start),
+	}
+	switch err := *errp; err {
+	case nil:
+	case ErrCacheMiss:
+		e.Outcome = "miss"
+	default:
+		e.Outcome, e.Err = "error", err.Error()
+	}
+	ring.add(e)
+}
+
+// AccessLog returns the recent operations on keys under the prefix of
+// Options.AccessLog, oldest first.
+func (cd *Cache) AccessLog(prefix string) []AccessLogEntry {
+	ring, ok := cd.accessRings[prefix]
+	if !ok {
+		return nil
+	}
+	return ring.recent()
+}
+
+// AccessLogHandler serves the recent operations of every Options.AccessLog
+// prefix as a JSON object keyed by prefix, or only those of the prefix
+// query parameter, e.g. for an admin endpoint.
+func (cd *Cache) AccessLogHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		logs := make(map[string][]AccessLogEntry)
+		if prefix, ok := req.URL.Query()["prefix"]; ok {
+			for _, p := range prefix {
+				if ring, ok := cd.accessRings[p]; ok {
+					logs[p] = ring.recent()
+				}
+			}
+		} else {
+			for p, ring := range cd.accessRings {
+				logs[p] = ring.recent()
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(logs)
+	})
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	frequencySketchWidth = 1 << 14
+	frequencySketchDepth = 4
+	// frequencySketchResetAt is the number of reads after which the
+	// counts are halved, so the sketch follows recent reads.
+	frequencySketchResetAt = 10 * frequencySketchWidth
+)
+
+// frequencySketch is a count-min sketch of key reads.
+type frequencySketch struct {
+	mu     sync.Mutex
+	counts [frequencySketchDepth][frequencySketchWidth]uint32
+	added  int
+}
+
+func frequencyIndexes(key string) [frequencySketchDepth]uint32 {
+	h := xxhash.Sum64String(key)
+	h1, h2 := uint32(h), uint32(h>>32)|1
+
+	var idx [frequencySketchDepth]uint32
+	for i := range idx {
+		idx[i] = (h1 + uint32(i)*h2) % frequencySketchWidth
+	}
+	return idx
+}
+
+func (s *frequencySketch) add(key string) {
+	idx := frequencyIndexes(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, j := range idx {
+		if s.counts[i][j] < math.MaxUint32 {
+			s.counts[i][j]++
+		}
+	}
+
+	s.added++
+	if s.added >= frequencySketchResetAt {
+		s.added = 0
+		for i := range s.counts {
+			for j := range s.counts[i] {
+				s.counts[i][j] /= 2
+			}
+		}
+	}
+}
+
+// estimate returns the estimated number of recent reads of the key.
+func (s *frequencySketch) estimate(key string) uint32 {
+	idx := frequencyIndexes(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	min := uint32(math.MaxUint32)
+	for i, j := range idx {
+		if c := s.counts[i][j]; c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// costAdmission scores values loaded by Item.Do by their load cost times
+// their read frequency.
+type costAdmission struct {
+	reads  frequencySketch
+	scores *sizeSketch
+}
+
+func newCostAdmission(quantile float64) *costAdmission {
+	return &costAdmission{
+		scores: newQuantileSketch(quantile, 0, math.MaxInt32, 0),
+	}
+}
+
+// admit records the score of the loaded key and reports whether it
+// ranks above the quantile of recent scores.
+func (a *costAdmission) admit(key string, cost time.Duration) bool {
+	us := cost.Microseconds() + 1
+	score := us * int64(a.reads.estimate(key)+1)
+	if score > math.MaxInt32 {
+		score = math.MaxInt32
+	}
+
+	a.scores.add(int(score))
+	return score >= atomic.LoadInt64(&a.scores.threshold)
+}
+
+// loadValue returns the value of the item and whether it may be written
+// to Redis while it is under memory pressure.
+func (cd *Cache) loadValue(item *Item) (interface{}, bool, error) {
+	if cd.admission == nil || item.Do == nil {
+		value, err := item.value()
+		return value, true, err
+	}
+
+	start := time.Now()
+	value, err := item.value()
+	if err != nil {
+		return nil, false, err
+	}
+	return value, cd.admission.admit(item.Key, time.Since(start)), nil
+}
@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ageBuckets are the upper bounds of AgeStats buckets.
+var ageBuckets = []time.Duration{
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+// AgeBucket counts values served with an age in (previous Max, Max].
+// The last bucket has zero Max and counts older values.
+type AgeBucket struct {
+	Max   time.Duration
+	Count uint64
+}
+
+// AgeStats is the distribution of ages of values served by Get and Once,
+// measured from the creation time stored with Options.StoreCreationTime,
+// per tier the value was served from.
+type AgeStats struct {
+	Local []AgeBucket
+	Redis []AgeBucket
+}
+
+type ageTier int
+
+const (
+	ageLocal ageTier = iota
+	ageRedis
+)
+
+type ageTracker struct {
+	mu     sync.Mutex
+	counts [2][]uint64
+}
+
+func newAgeTracker() *ageTracker {
+	t := new(ageTracker)
+	for i := range t.counts {
+		t.counts[i] = make([]uint64, len(ageBuckets)+1)
+	}
+	return t
+}
+
+func (t *ageTracker) add(tier ageTier, age time.Duration) {
+	i := sort.Search(len(ageBuckets), func(i int) bool {
+		return age <= ageBuckets[i]
+	})
+
+	t.mu.Lock()
+	t.counts[tier][i]++
+	t.mu.Unlock()
+}
+
+func (t *ageTracker) stats() *AgeStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	buckets := func(counts []uint64) []AgeBucket {
+		bs := make([]AgeBucket, len(counts))
+		for i, n := range counts {
+			if i < len(ageBuckets) {
+				bs[i].Max = ageBuckets[i]
+			}
+			bs[i].Count = n
+		}
+		return bs
+	}
+	return &AgeStats{
+		Local: buckets(t.counts[ageLocal]),
+		Redis: buckets(t.counts[ageRedis]),
+	}
+}
+
+// age returns how long ago the value was created or false when the
+// payload has no plausible creation time.
+func (cd *Cache) age(key string, b []byte) (time.Duration, bool) {
+	if !cd.hasTrailer(key) {
+		return 0, false
+	}
+	tm, ok := createdAt(b)
+	// string and []byte values have no trailer; their last bytes may
+	// still look like a creation time.
+	if !ok || tm.Unix() < epoch {
+		return 0, false
+	}
+	age := cd.now().Sub(tm)
+	if age < 0 {
+		return 0, false
+	}
+	return age, true
+}
+
+func (cd *Cache) recordAge(tier ageTier, key string, b []byte) {
+	if cd.ages == nil {
+		return
+	}
+	if age, ok := cd.age(key, b); ok {
+		cd.ages.add(tier, age)
+	}
+}
+
+// AgeStats returns the distribution of ages of values served by this
+// process. It returns nil unless Options.StatsEnabled and
+// Options.StoreCreationTime are set.
+func (cd *Cache) AgeStats() *AgeStats {
+	if cd.ages == nil {
+		return nil
+	}
+	return cd.ages.stats()
+}
+
+// GetWithAge is like Get, but also returns how long ago the value was
+// created, e.g. for an HTTP Age header. The age is the same whichever
+// tier served the value. It is negative when the value has no creation
+// time, see Options.StoreCreationTime.
+func (cd *Cache) GetWithAge(ctx context.Context, key string, value interface{}) (time.Duration, error) {
+	b, err := cd.getBytes(ctx, key, false)
+	if err != nil {
+		return 0, err
+	}
+	if err := cd.UnmarshalKey(key, b, value); err != nil {
+		return 0, err
+	}
+
+	switch value.(type) {
+	case *string, *[]byte:
+		return -1, nil
+	}
+	age, ok := cd.age(key, b)
+	if !ok {
+		return -1, nil
+	}
+	return age, nil
+}
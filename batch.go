@@ -0,0 +1,341 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const defaultBatchSize = 1000
+
+var errBatchUnsupported = errors.New("cache: Batch doesn't support FencingToken, Immutable, Critical, bundled keys, or Tombstones")
+
+// Batch accumulates writes and sends them to Redis in pipelines of
+// Options.BatchSize writes, for jobs that write many keys at once. It is
+// not safe for concurrent use.
+type Batch struct {
+	cd *Cache
+
+	pending []batchWrite
+	errs    map[string]error
+	total   int // writes since the last Flush
+	done    int // sent or failed writes since the last Flush
+
+	spreadBase   time.Duration
+	spreadWindow time.Duration
+}
+
+type batchWrite struct {
+	item    *Item
+	b       []byte
+	skipped bool
+}
+
+// NewBatch returns a new Batch writing to the cache.
+func (cd *Cache) NewBatch() *Batch {
+	return &Batch{cd: cd}
+}
+
+func (cd *Cache) batchSize() int {
+	if cd.opt.BatchSize > 0 {
+		return cd.opt.BatchSize
+	}
+	return defaultBatchSize
+}
+
+// Set adds the item to the batch. It marshals the value right away and
+// sends a pipeline once Options.BatchSize writes are pending, reporting
+// progress to the item context. Errors of the marshaling are returned;
+// errors of the writes are reported by Flush.
+func (b *Batch) Set(item *Item) error {
+	if err := b.add(item); err != nil {
+		return err
+	}
+	if len(b.pending) >= b.cd.batchSize() {
+		b.flushPending(item.Context())
+	}
+	return nil
+}
+
+func (b *Batch) add(item *Item) error {
+	w, err := b.cd.prepareWrite(b.spreadItem(item))
+	if err != nil {
+		return err
+	}
+	b.addPrepared(w)
+	return nil
+}
+
+func (b *Batch) addPrepared(w batchWrite) {
+	b.pending = append(b.pending, w)
+	b.total++
+}
+
+// prepareWrite marshals the item for a batch write. It is safe to call
+// concurrently.
+func (cd *Cache) prepareWrite(item *Item) (batchWrite, error) {
+	if err := cd.checkBatchItem(item); err != nil {
+		return batchWrite{}, err
+	}
+
+	value, err := item.value()
+	if err != nil {
+		return batchWrite{}, err
+	}
+	var buf bytes.Buffer
+	v, err := cd.marshalItem(&buf, item, value)
+	if err != nil {
+		return batchWrite{}, err
+	}
+	return cd.prepareEncoded(item, value, v)
+}
+
+// checkBatchItem rejects items a batch write doesn't write, returning
+// errBatchUnsupported for the ones Set can write.
+func (cd *Cache) checkBatchItem(item *Item) error {
+	if err := cd.checkItem(item); err != nil {
+		return err
+	}
+	if cd.opt.ReadOnly {
+		return ErrReadOnly
+	}
+	if cd.opt.Redis == nil && cd.local == nil {
+		return errRedisLocalCacheNil
+	}
+	if item.FencingToken > 0 || item.Immutable || item.Critical || cd.opt.Tombstones {
+		return errBatchUnsupported
+	}
+	if _, ok := cd.bundlePrefix(item.Key); ok {
+		return errBatchUnsupported
+	}
+	return nil
+}
+
+// prepareEncoded is like prepareWrite for a value that is already
+// marshaled, e.g. the value FanoutSet shares between keys.
+func (cd *Cache) prepareEncoded(item *Item, value interface{}, v []byte) (batchWrite, error) {
+	if err := cd.checkEncoded(item, value, v); err != nil {
+		return batchWrite{}, err
+	}
+
+	cd.maybeLogWrite(item, value, v)
+
+	w := batchWrite{item: item, b: v}
+	if cd.useRedis() {
+		if cd.stopPressure != nil {
+			w.item, w.skipped = cd.pressureItem(item, true)
+		}
+		if !w.skipped {
+			w.item = cd.spreadExpiry(w.item)
+		}
+		if cd.quotas != nil && !w.skipped {
+			if err := cd.checkQuota(item.Key, v, item.ttl()); err != nil {
+				return batchWrite{}, err
+			}
+		}
+	}
+	return w, nil
+}
+
+// Flush sends the pending writes. It returns a *MultiError mapping every
+// key that failed since the previous Flush to its error.
+func (b *Batch) Flush(ctx context.Context) error {
+	b.flushPending(ctx)
+
+	errs, total := b.errs, b.total
+	b.errs, b.total, b.done = nil, 0, 0
+	return multiError(errs, total)
+}
+
+func (b *Batch) fail(w batchWrite, err error) {
+	b.failKey(w.item.Key, err)
+}
+
+func (b *Batch) failKey(key string, err error) {
+	if b.errs == nil {
+		b.errs = make(map[string]error)
+	}
+	b.errs[key] = err
+}
+
+func (b *Batch) flushPending(ctx context.Context) {
+	writes := b.pending
+	b.pending = nil
+	if len(writes) == 0 {
+		return
+	}
+
+	b.done += len(writes)
+	defer func() {
+		reportProgress(ctx, b.done, len(b.errs))
+	}()
+
+	if err := ctx.Err(); err != nil {
+		for _, w := range writes {
+			b.fail(w, err)
+		}
+		return
+	}
+
+	stored := b.cd.writeBatch(writes, false, b.fail)
+
+	for _, w := range stored {
+		if err := b.cd.updateDependencies(w.item); err != nil {
+			b.fail(w, err)
+		}
+	}
+}
+
+// writeBatch writes the values in a single pipeline, wrapped in MULTI/EXEC
+// with tx, and returns the writes that were stored. Failed writes are
+// reported to fail.
+func (cd *Cache) writeBatch(writes []batchWrite, tx bool, fail func(batchWrite, error)) []batchWrite {
+	keys := make([]string, len(writes))
+	for i, w := range writes {
+		keys[i] = w.item.Key
+	}
+
+	if cd.writes != nil {
+		defer func() {
+			for _, key := range keys {
+				cd.writes.touch(key)
+			}
+		}()
+	}
+
+	unlock := cd.keyLocks.lockAll(keys)
+	defer unlock()
+
+	if !cd.useRedis() {
+		if cd.useLocalCache() {
+			for _, w := range writes {
+				cd.localSetOnWrite(w.item.Key, w.b, w.item.SkipLocalOnSet)
+			}
+		}
+		return writes
+	}
+
+	signed := make([][]byte, len(writes))
+	for i, w := range writes {
+		var err error
+		if signed[i], err = cd.sign(w.item.Key, w.b); err != nil {
+			fail(w, err)
+			signed[i] = nil
+		}
+	}
+
+	pipelined := cd.pipelined
+	if tx {
+		pipelined = cd.txPipelined
+	}
+	cmds := make([]redis.Cmder, len(writes))
+	pipelined(func(pipe RemoteStore) {
+		for i, w := range writes {
+			if signed[i] == nil {
+				continue
+			}
+			item, ttl := w.item, w.item.ttl()
+			switch {
+			case w.skipped:
+				cmds[i] = pipe.Del(item.Key)
+				continue
+			case item.IfExists:
+				cmds[i] = pipe.SetXX(item.Key, signed[i], ttl)
+			case item.IfNotExists:
+				cmds[i] = pipe.SetNX(item.Key, signed[i], ttl)
+			default:
+				cmds[i] = pipe.Set(item.Key, signed[i], ttl)
+			}
+		}
+	})
+
+	written := make([]batchWrite, 0, len(writes))
+	for i, w := range writes {
+		if cmds[i] == nil {
+			continue
+		}
+		if err := cmds[i].Err(); err != nil {
+			cd.checkOOM(err)
+			if !w.skipped {
+				cd.queueSet(w.item, w.b, err)
+			}
+			fail(w, err)
+			continue
+		}
+		cd.replayed(w.item.Key)
+		if cmd, ok := cmds[i].(*redis.BoolCmd); ok && !cmd.Val() {
+			// The condition of IfExists or IfNotExists didn't hold.
+			continue
+		}
+		written = append(written, w)
+	}
+	if cd.opt.ContentHash {
+		written = cd.writeHashes(written, fail)
+	}
+
+	stored := make([]batchWrite, 0, len(written))
+	storedKeys := make([]string, 0, len(written))
+	values := make([][]byte, 0, len(written))
+	for _, w := range written {
+		if cd.useLocalCache() {
+			cd.localSetOnWrite(w.item.Key, w.b, w.item.SkipLocalOnSet)
+		}
+		if !w.skipped {
+			cd.recordTTL(w.item)
+			if cd.opt.TrackMetadata {
+				if err := cd.writeMetadata(w.item); err != nil {
+					fail(w, err)
+				}
+			}
+		}
+		stored = append(stored, w)
+		storedKeys = append(storedKeys, w.item.Key)
+		values = append(values, w.b)
+	}
+
+	cd.invalidate(opSet, storedKeys, values)
+	return stored
+}
+
+// writeHashes writes the content hashes of the stored writes in a second
+// pipeline, so that writes whose IfExists or IfNotExists condition didn't
+// hold keep the hash of the stored value. It returns the writes whose hash
+// was written.
+func (cd *Cache) writeHashes(writes []batchWrite, fail func(batchWrite, error)) []batchWrite {
+	if len(writes) == 0 {
+		return writes
+	}
+
+	hashes := make([][]byte, len(writes))
+	for i, w := range writes {
+		var err error
+		if hashes[i], err = cd.sign(hashKey(w.item.Key), encodeHash(contentHash(w.b))); err != nil {
+			fail(w, err)
+		}
+	}
+
+	cmds := make([]*redis.StatusCmd, len(writes))
+	cd.pipelined(func(pipe RemoteStore) {
+		for i, w := range writes {
+			if hashes[i] != nil {
+				cmds[i] = pipe.Set(hashKey(w.item.Key), hashes[i], w.item.ttl())
+			}
+		}
+	})
+
+	ok := writes[:0]
+	for i, w := range writes {
+		if cmds[i] == nil {
+			continue
+		}
+		if err := cmds[i].Err(); err != nil {
+			fail(w, err)
+			continue
+		}
+		ok = append(ok, w)
+	}
+	return ok
+}
@@ -0,0 +1,89 @@
+// Package boltstore provides a cache.LocalStore persisted with bbolt,
+// so edge nodes keep a warm local cache across restarts.
+package boltstore
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("cache")
+
+// Store is a cache.LocalStore backed by a bbolt database file.
+// Writes are batched, so Set and Del block until the batch is committed.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the database file at path.
+// Call Close when done.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// HasGet appends the value of the key to dst
+// and reports whether the key exists.
+func (s *Store) HasGet(dst, k []byte) ([]byte, bool) {
+	var ok bool
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucket).Get(k)
+		if v != nil {
+			// v is only valid inside the transaction.
+			dst = append(dst, v...)
+			ok = true
+		}
+		return nil
+	})
+	return dst, ok
+}
+
+// Set stores the value of the key.
+func (s *Store) Set(k, v []byte) {
+	_ = s.db.Batch(func(tx *bolt.Tx) error {
+		// bbolt requires a non-nil value.
+		if v == nil {
+			v = []byte{}
+		}
+		return tx.Bucket(bucket).Put(k, v)
+	})
+}
+
+// Del deletes the key.
+func (s *Store) Del(k []byte) {
+	_ = s.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete(k)
+	})
+}
+
+// Reset deletes all keys.
+func (s *Store) Reset() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(bucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(bucket)
+		return err
+	})
+}
+
+// Close closes the database file.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
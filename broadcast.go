@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// Broadcast propagates local cache invalidations between processes that
+// share the remote tier. Messages are opaque to the transport.
+type Broadcast interface {
+	// Publish sends the message to all subscribers.
+	Publish(msg []byte) error
+	// Subscribe calls fn with every published message, including messages
+	// published by this process, until Close is called.
+	Subscribe(fn func(msg []byte)) error
+	Close() error
+}
+
+func newOrigin() uint64 {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// invalidate tells other processes to drop the keys from their local tier.
+// values holds the bytes written for opSet, so receivers that already
+// have them can keep their copy.
+func (cd *Cache) invalidate(op invalidationOp, keys []string, values [][]byte) {
+	if cd.opt.Broadcast == nil {
+		return
+	}
+
+	msg := &invalidation{
+		Version: invalidationVersion,
+		Origin:  cd.origin,
+		Entries: make([]invalidationEntry, len(keys)),
+	}
+	gen := uint64(cd.now().UnixNano())
+	for i, key := range keys {
+		msg.Entries[i] = invalidationEntry{
+			Key:        key,
+			Generation: gen,
+			Op:         op,
+		}
+		if i < len(values) {
+			msg.Entries[i].Hash = contentHash(values[i])
+		}
+	}
+
+	if err := cd.opt.Broadcast.Publish(msg.marshal()); err != nil {
+		atomic.AddUint64(&cd.errs, 1)
+	}
+}
+
+func (cd *Cache) onInvalidation(b []byte) {
+	var msg invalidation
+	if err := msg.unmarshal(b); err != nil || msg.Version != invalidationVersion {
+		atomic.AddUint64(&cd.errs, 1)
+		return
+	}
+	// Skip invalidations of this process so it keeps the values it wrote.
+	if msg.Origin == cd.origin || cd.local == nil {
+		return
+	}
+	for _, e := range msg.Entries {
+		key := e.Namespace + e.Key
+		if cd.generations != nil && !cd.generations.advance(key, e.Generation) {
+			continue
+		}
+		if e.Op == opSet && e.Hash != 0 {
+			if hash, ok := cd.localHash(key); ok && hash == e.Hash {
+				continue
+			}
+		}
+		cd.local.Del([]byte(key))
+	}
+}
+
+// localHash returns the content hash of the local copy of the key.
+func (cd *Cache) localHash(key string) (uint64, bool) {
+	b, ok := cd.local.HasGet(nil, []byte(key))
+	if !ok {
+		return 0, false
+	}
+	if cd.opt.LocalCacheStoreTTL > 0 && len(b) >= 4 {
+		b = b[:len(b)-4]
+	}
+	return contentHash(b), true
+}
+
+// generationTracker remembers the newest generation received for every
+// key, so invalidations that arrive late or twice don't evict a local
+// copy loaded after a newer one.
+type generationTracker struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]trackedGeneration
+	nextPrune time.Time
+}
+
+type trackedGeneration struct {
+	gen      uint64
+	deadline time.Time
+}
+
+func newGenerationTracker(window time.Duration, now func() time.Time) *generationTracker {
+	return &generationTracker{
+		window: window,
+		now:    now,
+		keys:   make(map[string]trackedGeneration),
+	}
+}
+
+// advance records gen for the key and reports whether it is newer than
+// the generations received within the window. Unknown (zero) generations
+// are only dropped when redelivered.
+func (t *generationTracker) advance(key string, gen uint64) bool {
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.After(t.nextPrune) {
+		for k, g := range t.keys {
+			if now.After(g.deadline) {
+				delete(t.keys, k)
+			}
+		}
+		t.nextPrune = now.Add(t.window)
+	}
+
+	if g, ok := t.keys[key]; ok && !now.After(g.deadline) {
+		if gen == g.gen || gen != 0 && gen < g.gen {
+			return false
+		}
+		if gen == 0 {
+			return true
+		}
+	}
+	t.keys[key] = trackedGeneration{gen: gen, deadline: now.Add(t.window)}
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+type redisPubSuber interface {
+	Publish(channel string, message interface{}) *redis.IntCmd
+	Subscribe(channels ...string) *redis.PubSub
+}
+
+// RedisBroadcast is a Broadcast over a Redis pub/sub channel.
+type RedisBroadcast struct {
+	client  redisPubSuber
+	channel string
+
+	mu     sync.Mutex
+	pubsub *redis.PubSub
+	done   chan struct{}
+}
+
+var _ Broadcast = (*RedisBroadcast)(nil)
+
+// NewRedisBroadcast returns a Broadcast publishing to the channel
+// with the client, e.g. *redis.Client.
+func NewRedisBroadcast(client redisPubSuber, channel string) *RedisBroadcast {
+	return &RedisBroadcast{
+		client:  client,
+		channel: channel,
+	}
+}
+
+func (b *RedisBroadcast) Publish(msg []byte) error {
+	return b.client.Publish(b.channel, msg).Err()
+}
+
+func (b *RedisBroadcast) Subscribe(fn func(msg []byte)) error {
+	pubsub := b.client.Subscribe(b.channel)
+	// Wait for the subscription so no message published after
+	// Subscribe returns is missed.
+	if _, err := pubsub.Receive(); err != nil {
+		_ = pubsub.Close()
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range pubsub.Channel() {
+			fn([]byte(msg.Payload))
+		}
+	}()
+
+	b.mu.Lock()
+	b.pubsub = pubsub
+	b.done = done
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *RedisBroadcast) Close() error {
+	b.mu.Lock()
+	pubsub, done := b.pubsub, b.done
+	b.pubsub, b.done = nil, nil
+	b.mu.Unlock()
+
+	if pubsub == nil {
+		return nil
+	}
+	err := pubsub.Close()
+	<-done
+	return err
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// cacheBudget returns how long Once may spend on the cache tiers before
+// calling Item.Do, or zero when the lookup is not limited.
+func (cd *Cache) cacheBudget(ctx context.Context) time.Duration {
+	if cd.opt.CacheBudget <= 0 {
+		return 0
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	budget := time.Duration(float64(time.Until(deadline)) * cd.opt.CacheBudget)
+	if budget <= 0 {
+		// Leave what is left to the loader.
+		return time.Nanosecond
+	}
+	return budget
+}
+
+// getBytesWithin is like getBytes, but gives up after the
+// Options.CacheBudget share of the item deadline, so a slow Redis
+// leaves the rest of the deadline to Item.Do. The abandoned lookup
+// finishes in the background.
+func (cd *Cache) getBytesWithin(item *Item, skipLocalCache bool) ([]byte, error) {
+	ctx := item.Context()
+	budget := cd.cacheBudget(ctx)
+	if budget == 0 {
+		return cd.getBytes(ctx, item.Key, skipLocalCache)
+	}
+
+	type result struct {
+		b   []byte
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		b, err := cd.getBytes(ctx, item.Key, skipLocalCache)
+		ch <- result{b, err}
+	}()
+
+	timer := time.NewTimer(budget)
+	defer timer.Stop()
+
+	select {
+	case res := <-ch:
+		return res.b, res.err
+	case <-timer.C:
+		atomic.AddUint64(&cd.errs, 1)
+		return nil, context.DeadlineExceeded
+	}
+}
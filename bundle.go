@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var (
+	errBundlesUnsupported = errors.New("cache: Redis client does not support hashes")
+	errBundledCondition   = errors.New("cache: bundled keys don't support IfExists, IfNotExists, FencingToken, Immutable, or Critical")
+)
+
+type hasher interface {
+	HSet(key string, values ...interface{}) *redis.IntCmd
+	HGetAll(key string) *redis.StringStringMapCmd
+	HDel(key string, fields ...string) *redis.IntCmd
+}
+
+// bundlePrefix returns the longest of Options.BundlePrefixes the key
+// starts with.
+func (cd *Cache) bundlePrefix(key string) (string, bool) {
+	var prefix string
+	var ok bool
+	for _, p := range cd.opt.BundlePrefixes {
+		if len(key) > len(p) && len(p) >= len(prefix) && strings.HasPrefix(key, p) {
+			prefix, ok = p, true
+		}
+	}
+	return prefix, ok
+}
+
+// bundleSet stores the value as a field of the bundle hash and resets the
+// TTL of the bundle.
+func (cd *Cache) bundleSet(item *Item, prefix string, b []byte) error {
+	if item.IfExists || item.IfNotExists || item.FencingToken > 0 || item.Immutable || item.Critical {
+		return errBundledCondition
+	}
+	if _, ok := cd.opt.Redis.(hasher); !ok {
+		return errBundlesUnsupported
+	}
+
+	ttl := item.ttl()
+	var hset *redis.IntCmd
+	var expire *redis.BoolCmd
+	var err error
+	cd.txPipelined(func(pipe RemoteStore) {
+		h, ok := pipe.(hasher)
+		if !ok {
+			err = errBundlesUnsupported
+			return
+		}
+		hset = h.HSet(prefix, item.Key[len(prefix):], b)
+		if e, ok := pipe.(expirer); ok {
+			if ttl > 0 {
+				expire = e.PExpire(prefix, ttl)
+			} else {
+				expire = e.Persist(prefix)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err := hset.Err(); err != nil {
+		return err
+	}
+	if expire != nil {
+		return expire.Err()
+	}
+	return nil
+}
+
+// bundleGet fetches the whole bundle of the key once for all concurrent
+// callers, fills the local cache with every value of the bundle, and
+// returns the value of the key.
+func (cd *Cache) bundleGet(prefix, key string, skipLocalCache bool) ([]byte, error) {
+	h, ok := cd.opt.Redis.(hasher)
+	if !ok {
+		return nil, errBundlesUnsupported
+	}
+
+	v, err := cd.group.Do(bundleGroupKey(prefix), func() (interface{}, error) {
+		return h.HGetAll(prefix).Result()
+	})
+	if err != nil {
+		atomic.AddUint64(&cd.errs, 1)
+		return nil, err
+	}
+
+	var b []byte
+	for field, value := range v.(map[string]string) {
+		fb, err := cd.verify(prefix+field, []byte(value))
+		if err != nil {
+			atomic.AddUint64(&cd.errs, 1)
+			continue
+		}
+		if isTombstone(fb) {
+			continue
+		}
+		if prefix+field == key {
+			b = fb
+			continue
+		}
+		if cd.useLocalCache() && !cd.recentlyWritten(prefix+field) {
+			cd.localSet(prefix+field, fb)
+		}
+	}
+
+	if b == nil {
+		if cd.opt.StatsEnabled {
+			atomic.AddUint64(&cd.misses, 1)
+		}
+		return nil, ErrCacheMiss
+	}
+	if cd.opt.StatsEnabled {
+		atomic.AddUint64(&cd.hits, 1)
+	}
+	if !skipLocalCache && cd.useLocalCache() {
+		cd.localSet(key, b)
+	}
+	return b, nil
+}
+
+func (cd *Cache) bundleDel(prefix, key string) (int64, error) {
+	h, ok := cd.opt.Redis.(hasher)
+	if !ok {
+		return 0, errBundlesUnsupported
+	}
+	return h.HDel(prefix, key[len(prefix):]).Result()
+}
+
+// bundleGroupKey can't collide with keys loaded by Once.
+func bundleGroupKey(prefix string) string {
+	return "\x00bundle:" + prefix
+}
@@ -19,17 +19,45 @@ import (
 	"go4.org/syncutil/singleflight"
 )
 
-const compressionThreshold = 64
+const defaultCompressionThreshold = 64
 
 const (
-	noCompression = 0x0
-	s2Compression = 0x1
+	noCompression   = 0x0
+	s2Compression   = 0x1
+	zstdCompression = 0x2
+	lz4Compression  = 0x3
 )
 
 var ErrCacheMiss = errors.New("cache: key is missing")
+
+// ErrReadOnly is returned by writes to a cache with Options.ReadOnly.
+var ErrReadOnly = errors.New("cache: cache is read-only")
+
+// ErrNilValue is returned by Set for items without Value and Do, and by
+// Get of an existing key with a nil value or of an empty stored value,
+// unless Options.AllowEmpty is set. Use Exists to check for keys.
+var ErrNilValue = errors.New("cache: nil value")
+
+// ErrImmutable is returned when an Item.Immutable is set to
+// an existing key.
+var ErrImmutable = errors.New("cache: key is immutable")
 var errRedisLocalCacheNil = errors.New("cache: both Redis and LocalCache are nil")
 
-type rediser interface {
+// DecodeError is returned in the strict decode mode when a stored value
+// does not match the destination, e.g. because of schema drift between
+// writer and reader versions.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return "cache: can't decode value: " + e.Err.Error()
+}
+
+// RemoteStore is the shared cache tier set in Options.Redis. Redis clients
+// implement it, and sqlitestore implements it on SQLite for deployments
+// without Redis.
+type RemoteStore interface {
 	Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd
 	SetXX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd
 	SetNX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd
@@ -59,6 +87,76 @@ type Item struct {
 
 	// SkipLocalCache skips local cache as if it is not set.
 	SkipLocalCache bool
+
+	// SkipLocalOnSet writes the item only to Redis and invalidates the
+	// local copy, leaving the local cache to be filled on read.
+	SkipLocalOnSet bool
+
+	// FencingToken is a logical timestamp stored with the value in Redis.
+	// A Set with a token older than the stored one fails with
+	// ErrStaleFencingToken, so a slow write can't overwrite a newer value
+	// after a retry or failover. The token outlives Delete until the TTL
+	// of the last fenced write passes. Zero disables fencing.
+	FencingToken uint64
+
+	// Immutable stores the value only if the key doesn't exist yet, e.g.
+	// for content-addressed keys like "blob:<sha256>". Setting an existing
+	// key returns ErrImmutable, so every writer of the key should set
+	// Immutable. Local copies written or loaded by Once with Immutable
+	// never expire. IfExists, IfNotExists, and FencingToken are ignored.
+	Immutable bool
+
+	// Critical is for keys that must be correct, e.g. kill switches. The
+	// value is written to Redis first and read back, failing with
+	// ErrCriticalWriteLost if it doesn't match, and only then to the
+	// local cache, ignoring SkipLocalOnSet. Every process serves its
+	// local copy for at most Options.CriticalStaleness and never serves
+	// a stale copy on Redis errors. Values must be encoded with a
+	// trailer: string, []byte, PlainFormats, and bundled keys are not
+	// supported.
+	Critical bool
+
+	// LowPriority items are not written to Redis while it is under
+	// memory pressure, see Options.MemoryPressureInterval.
+	LowPriority bool
+
+	// DependsOn lists keys the value is derived from. With
+	// Options.TrackDependencies, setting or deleting any of them deletes
+	// the item from both tiers, following dependents of the item too.
+	DependsOn []string
+
+	// Tags are names of groups the item belongs to, e.g. "user:42".
+	// InvalidateTag deletes all items of a tag.
+	Tags []string
+
+	// StaleIfError keeps the value in Redis for this long after TTL.
+	// Once treats such values as misses in every tier and calls Do, but
+	// when Do fails it serves the value and sets Stale, like HTTP
+	// stale-if-error. It is ignored for string, []byte, plain format,
+	// and bundled values and for items without a TTL.
+	StaleIfError time.Duration
+	// Stale is set by Once when it served a value past its TTL because
+	// Do failed.
+	Stale bool
+
+	// SiblingKeys returns keys likely to be read soon after the key, e.g.
+	// the next pages. When Once misses the local cache, it loads them from
+	// Redis into the local cache in the background with a single pipeline.
+	SiblingKeys func(key string) []string
+
+	// Marshal and Unmarshal replace the codec of the cache for this item,
+	// e.g. for values serialized upstream. Set and Once store exactly
+	// what Marshal returns, and Once decodes with Unmarshal. Values are
+	// stored without the trailer byte, like with Options.Marshal, so
+	// StaleIfError and validation don't apply. Get still uses the codec
+	// of the cache; get such values into *[]byte.
+	Marshal   func(interface{}) ([]byte, error)
+	Unmarshal func([]byte, interface{}) error
+
+	// NoCompression stores the value uncompressed whatever its size, e.g.
+	// for values holding already compressed data like images. The trailer
+	// records it like for small values, so readers need no changes.
+	NoCompression bool
 }
 
 func (item *Item) Context() context.Context {
@@ -91,85 +189,971 @@ func (item *Item) ttl() time.Duration {
 //------------------------------------------------------------------------------
 
 type Options struct {
-	Redis rediser
+	Redis RemoteStore
 
 	LocalCache         *fastcache.Cache
 	LocalCacheTTL      time.Duration
 	LocalCacheStoreTTL time.Duration
 
+	// LocalTTLRamp shortens local TTLs of entries stored within the
+	// duration after New, growing them linearly to the full TTL, so
+	// a fleet restart doesn't keep values fetched during the warmup for
+	// the whole TTL. It requires LocalCacheStoreTTL. Zero disables it.
+	LocalTTLRamp time.Duration
+
+	// DiskCache is a persistent local tier, e.g. boltstore.Store, that
+	// keeps the cache warm across restarts. It is used instead of
+	// LocalCache when that is nil, or beneath it: LocalCache misses are
+	// looked up in DiskCache and promoted to LocalCache. LocalCacheTTL and
+	// LocalCacheStoreTTL apply to both.
+	DiskCache LocalStore
+
+	// LocalOnRead makes writes invalidate the local cache instead of
+	// populating it, so it is only filled with values this process reads.
+	// It has no effect without Redis.
+	LocalOnRead bool
+
+	// MaxLocalEntryBytes is the maximum serialized size of a value stored
+	// in the local cache. Larger values are only stored in Redis so they
+	// don't evict many smaller entries. Zero means no limit.
+	MaxLocalEntryBytes int
+
+	// LocalCacheSize is the size in bytes of a local cache created and
+	// owned by the Cache when LocalCache is nil. It is released by Close.
+	LocalCacheSize int
+
 	StatsEnabled     bool
 	BackgroundUpdate bool //是否启用后台更新策略
 	ErrUseStale      bool //异常可使用过期的数据
 	Retry            int  //重试次数
+
+	// RetryBudget limits retries to about this fraction of Redis reads
+	// across all operations, e.g. 0.1 for 10%, so retries back off under
+	// a widespread Redis failure instead of multiplying the load. Up to
+	// RetryBudgetBurst retries, 10 by default, may be made at once. Zero
+	// doesn't limit retries.
+	RetryBudget      float64
+	RetryBudgetBurst int
+
+	// ReadYourWritesWindow makes reads skip the local cache for keys
+	// that were set or deleted by this process within the window, so the
+	// caller always sees its own writes. Zero disables it.
+	ReadYourWritesWindow time.Duration
+
+	// ContentHash stores a hash of the value next to each Redis entry
+	// so GetIfChanged can skip transferring unchanged values.
+	ContentHash bool
+
+	// SkipUnchangedWrites makes Set refresh only the TTL instead of
+	// writing the value again when Redis already holds the same bytes,
+	// saving bandwidth for refresh jobs that mostly rewrite unchanged
+	// values. With ContentHash the hash stored in Redis is compared;
+	// otherwise the local copy is, which assumes it is kept up to date,
+	// e.g. with Broadcast. Conditional, fenced, immutable, critical, and
+	// bundled writes are always written.
+	SkipUnchangedWrites bool
+
+	// BatchSize is how many writes a Batch sends in a single pipeline.
+	// Default is 1000.
+	BatchSize int
+
+	// TrashTTL is how long SoftDelete keeps deleted values for Restore.
+	// Default is 15 minutes.
+	TrashTTL time.Duration
+
+	// DeltaSnapshotEvery is how many deltas SetDelta writes before
+	// writing a new full snapshot. Default is 60.
+	DeltaSnapshotEvery int
+
+	// ValidateSampleRate is the fraction of writes that are decoded back
+	// and deep compared with the original value before they are written,
+	// returning a *ValidationError on mismatch. It is meant for catching
+	// codec bugs in staging. Zero disables it.
+	ValidateSampleRate float64
+
+	// WriteLogSampleRate is the fraction of writes passed to WriteLogger
+	// with their key, size, and TTL. Zero disables it.
+	WriteLogSampleRate float64
+	// WriteLogger receives the sampled writes. It is called on the
+	// writing goroutine, so it should be fast. Default logs them with
+	// the log package.
+	WriteLogger func(WriteLogEntry)
+	// RedactPayload returns what of a sampled value may be logged, e.g.
+	// the value without personal data. Default logs no payload.
+	RedactPayload func(key string, value interface{}) interface{}
+
+	// DecodedCacheSize enables a cache of up to this many decoded values
+	// above the byte tiers, keyed by key and destination type, so Get
+	// and Once of hot keys skip unmarshaling while the byte tiers hold
+	// the same bytes. Callers get shallow copies of the cached values,
+	// so they must treat them as immutable, unless CopyOnRead is set.
+	// Zero disables it.
+	DecodedCacheSize int
+
+	// ShareDecoded makes concurrent Once calls waiting for the same key
+	// decode the value once per destination type and get shallow copies
+	// of it, instead of every caller decoding the same bytes during a
+	// stampede. Maps, slices, and pointers inside the value are shared
+	// by the callers, so they must treat the value as immutable, unless
+	// CopyOnRead is set.
+	ShareDecoded bool
+
+	// CopyOnRead makes values served by DecodedCacheSize and ShareDecoded
+	// deep copies, so callers may mutate them without affecting other
+	// callers. Copying is cheaper than decoding, but not free.
+	CopyOnRead bool
+
+	// StoreCreationTime stores the time a value was marshaled in its
+	// payload, so GetFresh can reject values older than a maximum age
+	// and GetWithAge and AgeStats report ages the same way in every
+	// tier. Readers of older versions can't decode such values.
+	// Every write stores a new time, so SkipUnchangedWrites never skips.
+	StoreCreationTime bool
+
+	// SchemaVersion, up to 65535, is stored in the payload of every value
+	// when set. Values with an older version, including ones written
+	// without it as version 0, are upgraded on read by Migrations before
+	// decoding, so the schema of cached types can change without flushing
+	// the cache. Readers of older versions can't decode such values.
+	SchemaVersion int
+
+	// Migrations upgrade the msgpack body of a value from the schema
+	// version of the map key to the next version. A value that can't be
+	// upgraded to SchemaVersion fails to decode, so Once loads it again.
+	Migrations map[int]func([]byte) ([]byte, error)
+
+	// RewriteMigrated makes Get and Once write values upgraded by
+	// Migrations back to Redis with their remaining TTL, so they are
+	// migrated once instead of on every read.
+	RewriteMigrated bool
+
+	// FieldHashKey is the HMAC key for struct fields tagged
+	// `cache:"hash"`, so their hashes can't be reversed by hashing
+	// guesses. Default is plain SHA-256.
+	FieldHashKey []byte
+
+	// StrictDecode makes Unmarshal fail with a *DecodeError when a value
+	// has fields unknown to the destination struct instead of silently
+	// dropping them. msgpack already refuses to decode floats into
+	// integers.
+	StrictDecode bool
+
+	// SortMapKeys encodes the keys of map[string]string and
+	// map[string]interface{} values in increasing order, so equal values
+	// marshal to equal bytes, e.g. for ContentHash and GetIfChanged.
+	// Keys of other map types are still encoded in random order. Times
+	// are always encoded with the msgpack timestamp extension, which
+	// doesn't depend on the location of the time.
+	SortMapKeys bool
+	// DeterministicMarshal makes equal values marshal to identical bytes:
+	// the entries of all maps are sorted by their encoded keys, and
+	// negative zero and NaN floats are encoded as zero and a single NaN.
+	// It costs a second pass over every marshaled value.
+	DeterministicMarshal bool
+	// StructAsArray encodes structs as arrays of field values instead of
+	// maps, which is smaller but can't decode values cached before fields
+	// were added, removed, or reordered.
+	StructAsArray bool
+	// UseJSONTag names struct fields without a msgpack tag by their json
+	// tag when encoding and decoding.
+	UseJSONTag bool
+
+	// TypeFingerprint stores a short fingerprint of the value type with
+	// each value so decoding it into a different type fails with
+	// ErrTypeMismatch instead of producing garbage. Types registered
+	// with RegisterType are fingerprinted regardless.
+	TypeFingerprint bool
+
+	// CompressionThreshold is the size from which marshaled values are
+	// compressed. Default is 64 bytes.
+	CompressionThreshold int
+	// AdaptiveCompression learns the sizes of marshaled values and
+	// compresses values larger than CompressionQuantile of them instead of
+	// values of at least CompressionThreshold bytes. The threshold stays within
+	// MinCompressionThreshold and MaxCompressionThreshold.
+	AdaptiveCompression bool
+	// CompressionQuantile defaults to 0.5, compressing the larger half
+	// of the values.
+	CompressionQuantile float64
+	// MinCompressionThreshold defaults to 16 bytes and
+	// MaxCompressionThreshold to 64 KB.
+	MinCompressionThreshold int
+	MaxCompressionThreshold int
+
+	// StreamChunkSize is the number of values SetStream stores per chunk.
+	// Default is 1000.
+	StreamChunkSize int
+
+	// LocalSweepInterval enables a background sweeper that deletes expired
+	// local cache entries every interval instead of leaving them until
+	// they are read. It requires LocalCacheStoreTTL. Zero disables it.
+	LocalSweepInterval time.Duration
+
+	// LocalCompactInterval enables a background job that every interval
+	// checks LocalCompactSample local cache keys (default 100) in Redis
+	// and deletes the local copies of keys that have already expired
+	// there, reclaiming local space for valid entries. Zero disables it.
+	LocalCompactInterval time.Duration
+	LocalCompactSample   int
+
+	// AllowEmpty lets Set store empty values for items without Value and
+	// Do, and Get succeed with a nil value or an empty stored value,
+	// instead of returning ErrNilValue.
+	AllowEmpty bool
+
+	// ReadOnly makes Set, Delete, and other writes return ErrReadOnly,
+	// e.g. for deployments that must never mutate shared cache state.
+	// Once still loads values with Item.Do on a miss but doesn't cache them.
+	ReadOnly bool
+
+	// Faults injects failures into the cache tiers. It is meant for tests.
+	Faults *Faults
+
+	// Now returns the current time used for local cache expiration and
+	// other time based decisions. It lets tests control the cache clock.
+	// Default is time.Now.
+	Now func() time.Time
+
+	// PlainFormats maps key prefixes to plain storage formats readable by
+	// other languages. Keys without a matching prefix use DefaultFormat
+	// or the msgpack format with a trailer byte. SetDelta and SetStream
+	// always use the msgpack format.
+	PlainFormats map[string]PlainFormat
+
+	// DefaultFormat is the plain format of keys without a matching
+	// PlainFormats prefix, e.g. &PlainFormat{Codec: PlainJSON} to store
+	// every value as uncompressed JSON readable by services in other
+	// languages. Nil keeps the msgpack format with a trailer byte.
+	DefaultFormat *PlainFormat
+
+	// Marshal and Unmarshal replace the default msgpack encoding of
+	// values other than string and []byte, e.g. with flatbuffers. Values
+	// are stored exactly as Marshal returns them, without the trailer
+	// byte, so they are not compressed and features that store data in
+	// the trailer, e.g. TypeFingerprint, StoreCreationTime,
+	// SchemaVersion, and Item.StaleIfError, don't apply. Set both.
+	Marshal   func(interface{}) ([]byte, error)
+	Unmarshal func([]byte, interface{}) error
+
+	// Compressor compresses values larger than the compression
+	// threshold. Values compressed by any of the built-in compressors
+	// are readable whichever is set, so it can be changed without
+	// flushing the cache. SetStream always uses s2. Default is
+	// S2Compressor.
+	Compressor Compressor
+	// CompressionLevel and CompressionConcurrency configure the built-in
+	// compressors. Higher levels trade CPU for smaller values.
+	// CompressionConcurrency limits how many values ZstdCompressor
+	// compresses at once and defaults to GOMAXPROCS. Custom compressors
+	// are used as is.
+	CompressionLevel       CompressionLevel
+	CompressionConcurrency int
+
+	// PayloadHeader prefixes written values with a small header holding
+	// a magic number, the format version, the codec, and the compression
+	// method, so values are self-describing, raw strings and []byte
+	// included. Readers only look for the header when it is set, so raw
+	// values starting with the header magic are stored as is without it.
+	// Readers with it set understand values with and without the header,
+	// so turn it on for readers first. Keys with a plain format and values
+	// written with Marshal get no header.
+	PayloadHeader bool
+
+	// Checksum prefixes values written to Redis with a CRC-32C of the
+	// payload, so truncated or otherwise corrupted values are reported as
+	// a *ChecksumError instead of failing to decode. Values written
+	// without it are still read, but readers only verify and strip the
+	// checksum when it is set, so turn it on for readers first. []byte
+	// values written without it that start with the checksum magic,
+	// 0xc1 0xcc, are ambiguous.
+	Checksum bool
+
+	// FallbackFormats are tried in order when a value fails to decode in
+	// the storage format of its key, e.g. to read JSON or msgpack entries
+	// written by another caching library. The error of the storage format
+	// is returned when none of them decodes the value.
+	FallbackFormats []PlainFormat
+
+	// CacheBudget is the fraction of the time left until the Item.Ctx
+	// deadline that Once spends on the local cache and Redis before it
+	// calls Item.Do, e.g. 0.2 leaves 80% of the deadline to the loader
+	// when Redis is slow. Zero doesn't limit the lookup.
+	CacheBudget float64
+
+	// Tombstones makes Set fail with ErrTombstoned instead of overwriting
+	// a tombstone written by Tombstone. Sets use a Lua script then.
+	// IfNotExists never overwrites tombstones; FencingToken and
+	// BundlePrefixes ignore them.
+	Tombstones bool
+
+	// TrackDependencies makes Set, Delete, and Once delete the values
+	// derived from the written key, e.g. views of GetView, in both tiers.
+	// It costs one more Redis command per write.
+	TrackDependencies bool
+
+	// TrackMetadata keeps a hash next to every value written to Redis
+	// with the times the key was created and last written, the Writer
+	// of the last write, and the number of writes, so Describe can tell
+	// who wrote a key and when. It costs one more Redis round trip per
+	// write.
+	TrackMetadata bool
+	// Writer identifies this process in key metadata, e.g.
+	// "billing/pod-7". Default is the host name and process ID.
+	Writer string
+
+	// BundlePrefixes lists key prefixes whose keys are stored together in
+	// one Redis hash named by the prefix, for many tiny values that are
+	// always read together, e.g. feature flags. A miss of one key fetches
+	// the whole bundle and fills the local cache with all of its keys.
+	// The TTL of the last Set applies to the whole bundle. Bundled keys
+	// don't support IfExists, IfNotExists, FencingToken, and ContentHash.
+	BundlePrefixes []string
+
+	// MaxTotalBytesPerPrefix limits the bytes that keys with a prefix may
+	// hold in Redis, so one feature can't fill the shared Redis. The size
+	// is estimated from the keys and values this process writes and their
+	// TTLs; overwritten and deleted values count until they would have
+	// expired. Sets over the limit fail with ErrQuotaExceeded. The
+	// longest matching prefix applies.
+	MaxTotalBytesPerPrefix map[string]int64
+
+	// CriticalStaleness bounds the age of local copies of Item.Critical
+	// values. Default is 1 second.
+	CriticalStaleness time.Duration
+
+	// MemoryPressureInterval enables reading INFO memory of Redis every
+	// interval. While used_memory is above MemoryPressureHigh of
+	// maxmemory, or after Redis refused a write with an OOM error, TTLs of
+	// writes are capped at MemoryPressureTTL and items with LowPriority
+	// are only cached locally, until used_memory drops below
+	// MemoryPressureLow. Zero disables it.
+	MemoryPressureInterval time.Duration
+	// MemoryPressureHigh and MemoryPressureLow are the fractions of
+	// maxmemory that start and end memory pressure.
+	// Defaults are 0.9 and 0.8.
+	MemoryPressureHigh float64
+	MemoryPressureLow  float64
+	// MemoryPressureTTL caps TTLs under memory pressure.
+	// Default is 5 minutes.
+	MemoryPressureTTL time.Duration
+	// CostAdmission scores values loaded by Item.Do by their load time
+	// times their recent read count. Under memory pressure, values
+	// scoring below the quantile of recent scores, e.g. 0.5, are only
+	// cached locally, so Redis keeps the values that are expensive to
+	// load and often read. Zero disables it.
+	CostAdmission float64
+
+	// DegradedErrorRate enables extending local TTLs to DegradedLocalTTL
+	// while more than this fraction of Redis reads, e.g. 0.5, fail, so the
+	// local cache absorbs more traffic during a Redis outage. The error
+	// rate is checked every DegradedWindow, 10 seconds by default, and
+	// local TTLs revert once it drops below the threshold. It requires
+	// LocalCacheStoreTTL. Zero disables it.
+	DegradedErrorRate float64
+	DegradedWindow    time.Duration
+	// DegradedLocalTTL caps the extended local TTLs.
+	// Default is 10 minutes.
+	DegradedLocalTTL time.Duration
+
+	// ReplayQueueSize enables queueing up to this many Sets and Deletes
+	// that failed because Redis was unavailable. They still return the
+	// error, but are replayed in order every ReplayInterval, 1 second by
+	// default, once Redis is back, so Redis doesn't keep values that were
+	// overwritten or deleted during the outage. ReplayOverflow decides
+	// which write is dropped when the queue is full. Conditional,
+	// immutable, fenced, and critical writes are not queued. Zero
+	// disables it.
+	ReplayQueueSize int
+	ReplayOverflow  ReplayOverflow
+	ReplayInterval  time.Duration
+
+	// HedgePercentile enables hedged Redis reads: a GET slower than the
+	// percentile of recent GETs, e.g. 0.95, is sent again and the first
+	// reply wins, cutting tail latency. Zero disables it.
+	HedgePercentile float64
+	// HedgeMinDelay is the lower bound of the hedge delay.
+	HedgeMinDelay time.Duration
+	// HedgeRedis receives hedged GETs, e.g. a client of a replica.
+	// Default is Redis.
+	HedgeRedis RemoteStore
+
+	// MaxInflightLoads limits the number of Item.Do calls that Once runs
+	// at the same time, so a cache miss storm can't pile up requests to
+	// the database. ShedPolicy decides what happens to the calls over the
+	// limit. Zero means no limit.
+	MaxInflightLoads int
+	ShedPolicy       ShedPolicy
+	// LoadQueueTimeout limits how long ShedQueue waits for a free slot.
+	// Zero waits until the item context is done.
+	LoadQueueTimeout time.Duration
+
+	// Compat adjusts the cache to Redis compatible servers,
+	// e.g. CompatKeyDB or CompatDragonfly. Nil means Redis.
+	Compat *Compat
+
+	// Broadcast propagates local cache invalidations to other processes,
+	// so their local tiers drop values this process sets or deletes.
+	// Close closes it.
+	Broadcast Broadcast
+	// InvalidationDedupWindow remembers the newest generation of every
+	// invalidated key for the window and drops received invalidations
+	// that are not newer, so redelivered or reordered messages don't
+	// evict keys reloaded in the meantime. It assumes writers' clocks
+	// are synchronized well within the window. Zero disables it.
+	InvalidationDedupWindow time.Duration
+
+	// SigningKeys enables signing values written to Redis with HMAC-SHA256
+	// so values forged by other Redis tenants are rejected with
+	// ErrInvalidSignature. Values are signed with the key SigningKeyID and
+	// verified with the key whose ID is stored in the value, which allows
+	// rotating keys: add the new key, switch SigningKeyID to it, and remove
+	// the old key once values signed with it have expired.
+	SigningKeys  map[byte][]byte
+	SigningKeyID byte
+
+	// Encryption enables encrypting values written to Redis with
+	// AES-GCM using the keys it provides, after compression and before
+	// signing. The local cache holds values in clear text. Values written
+	// before it was set are still read, so it can be turned on without
+	// flushing the cache.
+	Encryption KeyProvider
+
+	// HotKeys enables tracking the given number of most read keys,
+	// see HotKeys, PublishHotKeys, and Prewarm. Zero disables it.
+	HotKeys int
+	// HotKeysKey is the Redis key hot keys are published to.
+	// Default is "cache:hotkeys".
+	HotKeysKey string
+	// HotKeysPublishInterval enables publishing hot keys in the
+	// background every interval. Zero disables it.
+	HotKeysPublishInterval time.Duration
+
+	// PredictiveRefresh enables tracking the intervals between reads of up
+	// to the given number of keys and fetching them from Redis into the
+	// local cache shortly before their predicted next read when their
+	// local copies would have expired by then. This spreads the Redis
+	// reads of regularly read keys instead of stalling the reads on
+	// expiry. It requires LocalCacheStoreTTL. Zero disables it.
+	PredictiveRefresh int
+	// PredictiveRefreshInterval is how often keys due for a predictive
+	// refresh are checked. Default is 1 second.
+	PredictiveRefreshInterval time.Duration
+
+	// ExpiryClusterSize enables a guard against values expiring at once:
+	// once more than this many values written to Redis by this process
+	// expire in the same second, further values that would expire in that
+	// second get a random delay of up to ExpiryClusterSpread added to
+	// their TTL, and ExpiryClusterWarning is called. Zero disables it.
+	ExpiryClusterSize int
+	// ExpiryClusterSpread defaults to one minute.
+	ExpiryClusterSpread time.Duration
+	// ExpiryClusterWarning is called once per crowded second with the
+	// second and the number of values expiring in it. Default logs it.
+	ExpiryClusterWarning func(expiresAt time.Time, count int)
+
+	// AccessLog maps key prefixes to how many recent Get, Once, Set, and
+	// Delete calls on keys under them are kept in memory, see AccessLog
+	// and AccessLogHandler. A key is logged under its longest prefix.
+	AccessLog map[string]int
+
+	// WatchInterval is how often Watch fetches watched keys.
+	// Default is 1 second.
+	WatchInterval time.Duration
 }
 
 func (opt *Options) init() {
 	if opt.LocalCacheStoreTTL < 0 { // <=0 不过期
 		opt.LocalCacheStoreTTL = 0
 	}
+	if opt.DeltaSnapshotEvery <= 0 {
+		opt.DeltaSnapshotEvery = 60
+	}
+	if opt.StreamChunkSize <= 0 {
+		opt.StreamChunkSize = 1000
+	}
+	if opt.Now == nil {
+		opt.Now = time.Now
+	}
+	if opt.CompressionThreshold <= 0 {
+		opt.CompressionThreshold = defaultCompressionThreshold
+	}
+	if opt.Compressor == nil {
+		opt.Compressor = S2Compressor
+	}
+	if opt.CompressionLevel != DefaultCompression || opt.CompressionConcurrency > 0 {
+		opt.Compressor = configureCompressor(
+			opt.Compressor, opt.CompressionLevel, opt.CompressionConcurrency)
+	}
+	if opt.HotKeysKey == "" {
+		opt.HotKeysKey = defaultHotKeysKey
+	}
+	if opt.WatchInterval <= 0 {
+		opt.WatchInterval = time.Second
+	}
+	if opt.DegradedWindow <= 0 {
+		opt.DegradedWindow = defaultDegradedWindow
+	}
+	if opt.DegradedLocalTTL <= 0 {
+		opt.DegradedLocalTTL = defaultDegradedLocalTTL
+	}
+	if opt.ReplayInterval <= 0 {
+		opt.ReplayInterval = defaultReplayInterval
+	}
+	if opt.ExpiryClusterSpread <= 0 {
+		opt.ExpiryClusterSpread = defaultExpiryClusterSpread
+	}
+	if opt.ExpiryClusterWarning == nil {
+		opt.ExpiryClusterWarning = logExpiryCluster
+	}
+	if opt.PredictiveRefreshInterval <= 0 {
+		opt.PredictiveRefreshInterval = time.Second
+	}
+	if opt.CriticalStaleness <= 0 {
+		opt.CriticalStaleness = defaultCriticalStaleness
+	}
+	if opt.MemoryPressureHigh <= 0 {
+		opt.MemoryPressureHigh = defaultMemoryPressureHigh
+	}
+	if opt.MemoryPressureLow <= 0 {
+		opt.MemoryPressureLow = defaultMemoryPressureLow
+	}
+	if opt.MemoryPressureTTL <= 0 {
+		opt.MemoryPressureTTL = defaultMemoryPressureTTL
+	}
+	if opt.TrashTTL <= 0 {
+		opt.TrashTTL = defaultTrashTTL
+	}
+	if opt.TrackMetadata && opt.Writer == "" {
+		opt.Writer = defaultWriter()
+	}
+	if opt.WriteLogger == nil {
+		opt.WriteLogger = logWrite
+	}
 }
 
 type Cache struct {
-	opt *Options
-
-	group singleflight.Group
-	locks map[string]*uint32
-
-	hits   uint64
-	misses uint64
-	errs   uint64
+	opt           *Options
+	local         LocalStore
+	ownLocalCache bool
+	started       time.Time
+
+	origin      uint64
+	generations *generationTracker
+
+	group    singleflight.Group
+	many     manyGroup
+	locks    map[string]*uint32
+	keyLocks keyLocks
+	mode     uint32
+	caps     capabilities
+	writes   *writeTracker
+	ttls     *ttlTracker
+	clusters *expiryGuard
+	retries  *retryBudget
+	aeads    aeadCache
+	ages     *ageTracker
+	decoded  *decodedCache
+	loads    chan struct{}
+
+	latencies *latencyTracker
+	sizes     *sizeSketch
+	deps      dependencyIndex
+	critical  criticalClock
+	quotas    map[string]*prefixQuota
+
+	hotKeys     *hotKeySketch
+	prefetching int32
+	accessRings map[string]*accessRing
+	stopHotKeys chan struct{}
+	hotKeysDone chan struct{}
+
+	pressure     uint32
+	degraded     uint32
+	readErrors   errorRateTracker
+	admission    *costAdmission
+	stopPressure chan struct{}
+	pressureDone chan struct{}
+
+	predictor     *accessPredictor
+	stopPredictor chan struct{}
+	predictorDone chan struct{}
+
+	localKeys     *localKeySet
+	stopCompactor chan struct{}
+	compactorDone chan struct{}
+
+	deltaMu      sync.Mutex
+	deltaStates  map[string]*deltaState
+	deltaSweepAt int
+
+	replays      *replayQueue
+	stopReplayer chan struct{}
+	replayerDone chan struct{}
+
+	expiries    *expiryIndex
+	stopSweeper chan struct{}
+	sweeperDone chan struct{}
+	closeOnce   sync.Once
+
+	hits       uint64
+	misses     uint64
+	errs       uint64
+	swept      uint64
+	sweptBytes uint64
+	hedged     uint64
+	compacted  uint64
+
+	quotaRejected uint64
+
+	pressureEvents  uint64
+	pressureSkipped uint64
+	admissionDenied uint64
+
+	unchangedSkipped uint64
+
+	predictiveRefreshes uint64
+	retriesDenied       uint64
+	degradedEvents      uint64
+
+	replayedWrites uint64
+	replayDropped  uint64
 }
 
 func New(opt *Options) *Cache {
 	opt.init()
-	return &Cache{
-		opt:   opt,
-		locks: make(map[string]*uint32),
+
+	var ownLocalCache bool
+	if opt.LocalCache == nil && opt.LocalCacheSize > 0 {
+		o := *opt
+		o.LocalCache = fastcache.New(opt.LocalCacheSize)
+		opt = &o
+		ownLocalCache = true
+	}
+	if opt.Faults != nil && opt.Redis != nil {
+		o := *opt
+		o.Redis = newFaultyRedis(opt.Redis, opt.Faults)
+		opt = &o
+	}
+
+	cd := &Cache{
+		opt:           opt,
+		started:       opt.Now(),
+		local:         newLocalStore(opt),
+		locks:         make(map[string]*uint32),
+		ownLocalCache: ownLocalCache,
+	}
+	if opt.ReadYourWritesWindow > 0 {
+		cd.writes = newWriteTracker(opt.ReadYourWritesWindow, opt.Now)
+	}
+	if opt.StatsEnabled {
+		cd.ttls = newTTLTracker()
+	}
+	if opt.StatsEnabled && opt.StoreCreationTime {
+		cd.ages = newAgeTracker()
+	}
+	if opt.DecodedCacheSize > 0 {
+		cd.decoded = newDecodedCache(opt.DecodedCacheSize)
+	}
+	if opt.AdaptiveCompression {
+		cd.sizes = newSizeSketch(opt)
+	}
+	if opt.HedgePercentile > 0 {
+		cd.latencies = newLatencyTracker(opt.HedgePercentile, opt.HedgeMinDelay)
+	}
+	if len(opt.MaxTotalBytesPerPrefix) > 0 {
+		cd.quotas = newPrefixQuotas(opt.MaxTotalBytesPerPrefix)
 	}
+	if opt.MaxInflightLoads > 0 {
+		cd.loads = make(chan struct{}, opt.MaxInflightLoads)
+	}
+	if opt.Broadcast != nil {
+		if opt.InvalidationDedupWindow > 0 {
+			cd.generations = newGenerationTracker(opt.InvalidationDedupWindow, opt.Now)
+		}
+		cd.origin = newOrigin()
+		if err := opt.Broadcast.Subscribe(cd.onInvalidation); err != nil {
+			atomic.AddUint64(&cd.errs, 1)
+		}
+	}
+	if opt.LocalSweepInterval > 0 && cd.local != nil && opt.LocalCacheStoreTTL > 0 {
+		cd.startSweeper()
+	}
+	if opt.LocalCompactInterval > 0 && cd.local != nil && opt.Redis != nil {
+		cd.startCompactor()
+	}
+	if opt.MemoryPressureInterval > 0 && opt.Redis != nil {
+		if opt.CostAdmission > 0 && opt.CostAdmission < 1 {
+			cd.admission = newCostAdmission(opt.CostAdmission)
+		}
+		cd.startPressureMonitor()
+	}
+	if opt.PredictiveRefresh > 0 && cd.local != nil && opt.Redis != nil &&
+		opt.LocalCacheStoreTTL > 0 {
+		cd.startPredictor()
+	}
+	if opt.RetryBudget > 0 {
+		cd.retries = newRetryBudget(opt.RetryBudget, opt.RetryBudgetBurst)
+	}
+	if opt.ExpiryClusterSize > 0 && opt.Redis != nil {
+		cd.clusters = newExpiryGuard()
+	}
+	if opt.ReplayQueueSize > 0 && opt.Redis != nil {
+		cd.startReplayer()
+	}
+	if len(opt.AccessLog) > 0 {
+		cd.accessRings = newAccessRings(opt.AccessLog)
+	}
+	if opt.HotKeys > 0 {
+		cd.hotKeys = newHotKeySketch(opt.HotKeys)
+		if opt.HotKeysPublishInterval > 0 && opt.Redis != nil {
+			cd.startHotKeysPublisher()
+		}
+	}
+	return cd
 }
 
-// Set caches the item.
-func (cd *Cache) Set(item *Item) error {
-	_, _, err := cd.set(item)
+// Close stops background goroutines started by the cache, closes
+// Options.Broadcast, and releases the local cache created from
+// Options.LocalCacheSize.
+func (cd *Cache) Close() error {
+	var err error
+	cd.closeOnce.Do(func() {
+		if cd.opt.Broadcast != nil {
+			err = cd.opt.Broadcast.Close()
+		}
+		if cd.stopSweeper != nil {
+			close(cd.stopSweeper)
+			<-cd.sweeperDone
+		}
+		if cd.stopHotKeys != nil {
+			close(cd.stopHotKeys)
+			<-cd.hotKeysDone
+		}
+		if cd.stopCompactor != nil {
+			close(cd.stopCompactor)
+			<-cd.compactorDone
+		}
+		if cd.stopPressure != nil {
+			close(cd.stopPressure)
+			<-cd.pressureDone
+		}
+		if cd.stopPredictor != nil {
+			close(cd.stopPredictor)
+			<-cd.predictorDone
+		}
+		if cd.stopReplayer != nil {
+			close(cd.stopReplayer)
+			<-cd.replayerDone
+		}
+		if cd.ownLocalCache {
+			cd.opt.LocalCache.Reset()
+		}
+	})
 	return err
 }
 
+// Set caches the item.
+func (cd *Cache) Set(item *Item) (err error) {
+	if cd.accessRings != nil {
+		defer cd.logAccess("set", item.Key, time.Now(), &err)
+	}
+	if _, _, err := cd.set(item); err != nil {
+		return err
+	}
+	return cd.updateDependencies(item)
+}
+
 func (cd *Cache) set(item *Item) ([]byte, bool, error) {
-	value, err := item.value()
+	if err := cd.checkItem(item); err != nil {
+		return nil, false, err
+	}
+
+	value, admitted, err := cd.loadValue(item)
 	if err != nil {
 		return nil, false, err
 	}
 
-	b, err := cd.Marshal(value)
+	var buf bytes.Buffer
+	b, err := cd.marshalItem(&buf, item, value)
 	if err != nil {
 		return nil, false, err
 	}
 
-	if cd.opt.LocalCache != nil {
-		cd.localSet(item.Key, b)
+	if err := cd.checkEncoded(item, value, b); err != nil {
+		return nil, false, err
+	}
+
+	item, b = cd.staleIfErrorItem(item, value, b)
+
+	if item.Critical {
+		b, err = cd.markCritical(item.Key, value, b)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if cd.opt.ReadOnly {
+		// Once still returns the loaded value.
+		return b, true, ErrReadOnly
+	}
+	cd.maybeLogWrite(item, value, b)
+
+	toRedis := cd.useRedis()
+	var skipped bool
+	if toRedis && cd.stopPressure != nil {
+		item, skipped = cd.pressureItem(item, admitted)
+		toRedis = !skipped
+	}
+	if toRedis {
+		item = cd.spreadExpiry(item)
+	}
+
+	if cd.quotas != nil && toRedis {
+		if err := cd.checkQuota(item.Key, b, item.ttl()); err != nil {
+			// Like ErrReadOnly, Once still returns the loaded value.
+			return b, true, err
+		}
+	}
+
+	if cd.writes != nil {
+		defer cd.writes.touch(item.Key)
+	}
+
+	unlock := cd.keyLocks.lock(item.Key)
+	defer unlock()
+
+	if toRedis && cd.skipUnchanged(item, b) {
+		if cd.useLocalCache() {
+			cd.localSetOnWrite(item.Key, b, item.SkipLocalOnSet)
+		}
+		return b, true, nil
 	}
 
-	if cd.opt.Redis == nil {
-		if cd.opt.LocalCache == nil {
+	// Fenced, immutable, and critical values only reach the local cache
+	// once Redis accepts them.
+	fenced := (item.FencingToken > 0 || item.Immutable || item.Critical) && toRedis
+	if cd.useLocalCache() && !fenced {
+		if item.Immutable {
+			cd.localStore(item.Key, b, true)
+		} else {
+			cd.localSetOnWrite(item.Key, b, item.SkipLocalOnSet)
+		}
+	}
+
+	if skipped {
+		if err := cd.dropStale(item.Key); err != nil {
+			return b, true, err
+		}
+		cd.invalidate(opSet, []string{item.Key}, [][]byte{b})
+		return b, true, nil
+	}
+	if !toRedis {
+		if cd.opt.Redis == nil && cd.local == nil {
 			return b, true, errRedisLocalCacheNil
 		}
 		return b, true, nil
 	}
 
-	if item.IfExists {
-		return b, true, cd.opt.Redis.SetXX(item.Key, b, item.ttl()).Err()
+	err = cd.redisSet(item, b)
+	if err == nil && item.Critical {
+		err = cd.verifyCritical(item.Key, b)
+	}
+	if err != nil {
+		cd.checkOOM(err)
+		cd.queueSet(item, b, err)
+		if (err == ErrTombstoned || item.Critical) && cd.local != nil {
+			cd.local.Del([]byte(item.Key))
+		}
+		return b, true, err
+	}
+	cd.replayed(item.Key)
+	if cd.useLocalCache() && fenced {
+		if item.Immutable {
+			cd.localStore(item.Key, b, true)
+		} else {
+			cd.localSetOnWrite(item.Key, b, item.SkipLocalOnSet && !item.Critical)
+		}
 	}
+	cd.invalidate(opSet, []string{item.Key}, [][]byte{b})
+	return b, true, nil
+}
 
-	if item.IfNotExists {
-		return b, true, cd.opt.Redis.SetNX(item.Key, b, item.ttl()).Err()
+// checkItem rejects items Set doesn't write.
+func (cd *Cache) checkItem(item *Item) error {
+	if item.Do == nil && item.Value == nil && !cd.opt.AllowEmpty {
+		return ErrNilValue
 	}
+	return checkKey(item.Key)
+}
 
-	return b, true, cd.opt.Redis.Set(item.Key, b, item.ttl()).Err()
+// checkEncoded validates a sample of the marshaled values, see
+// Options.ValidateSampleRate.
+func (cd *Cache) checkEncoded(item *Item, value interface{}, b []byte) error {
+	if cd.shouldValidate() && item.Marshal == nil {
+		return cd.validate(item.Key, value, b)
+	}
+	return nil
+}
+
+func (cd *Cache) redisSet(item *Item, b []byte) error {
+	ttl := item.ttl()
+
+	signed, err := cd.sign(item.Key, b)
+	if err != nil {
+		return err
+	}
+
+	prefix, bundled := cd.bundlePrefix(item.Key)
+	var stored bool
+	switch {
+	case bundled:
+		err = cd.bundleSet(item, prefix, signed)
+		stored = err == nil
+	case item.Immutable:
+		stored, err = cd.opt.Redis.SetNX(item.Key, signed, ttl).Result()
+		if err == nil && !stored {
+			err = ErrImmutable
+		}
+	case item.FencingToken > 0:
+		err = cd.fencedSet(item, signed)
+		stored = err == nil
+	case cd.opt.Tombstones && !item.IfNotExists:
+		stored, err = cd.tombstoneAwareSet(item, signed)
+	case item.IfExists:
+		stored, err = cd.opt.Redis.SetXX(item.Key, signed, ttl).Result()
+	case item.IfNotExists:
+		stored, err = cd.opt.Redis.SetNX(item.Key, signed, ttl).Result()
+	default:
+		err = cd.opt.Redis.Set(item.Key, signed, ttl).Err()
+		stored = err == nil
+	}
+	if err != nil || !stored {
+		return err
+	}
+	cd.recordTTL(item)
+	if cd.opt.TrackMetadata {
+		if err := cd.writeMetadata(item); err != nil {
+			return err
+		}
+	}
+	if !cd.opt.ContentHash || bundled {
+		return nil
+	}
+
+	hash, err := cd.sign(hashKey(item.Key), encodeHash(contentHash(b)))
+	if err != nil {
+		return err
+	}
+	return cd.opt.Redis.Set(hashKey(item.Key), hash, ttl).Err()
 }
 
 // Exists reports whether value for the given key exists.
 func (cd *Cache) Exists(ctx context.Context, key string) bool {
-	return cd.Get(ctx, key, nil) == nil
+	if cd.dryRun() {
+		cd.probe(key)
+	}
+	_, err := cd.getBytes(ctx, key, false)
+	return err == nil
 }
 
 // Get gets the value for the given key.
@@ -189,44 +1173,86 @@ func (cd *Cache) get(
 	key string,
 	value interface{},
 	skipLocalCache bool,
-) error {
+) (err error) {
+	if cd.accessRings != nil {
+		defer cd.logAccess("get", key, time.Now(), &err)
+	}
+	if cd.dryRun() {
+		cd.probe(key)
+	}
+
 	b, err := cd.getBytes(ctx, key, skipLocalCache)
 	if err != nil {
 		return err
 	}
-	return cd.Unmarshal(b, value)
+	if (value == nil || len(b) == 0) && !cd.opt.AllowEmpty {
+		return ErrNilValue
+	}
+	if err := cd.unmarshalDecoded(key, b, value); err != nil {
+		return err
+	}
+	cd.rewriteMigrated(ctx, key, b, value)
+	return nil
 }
 
 func (cd *Cache) getBytes(ctx context.Context, key string, skipLocalCache bool) ([]byte, error) {
+	if cd.admission != nil {
+		cd.admission.reads.add(key)
+	}
+	if cd.hotKeys != nil {
+		cd.hotKeys.add(key)
+	}
+	cd.observeRead(key)
+	if cd.recentlyWritten(key) {
+		skipLocalCache = true
+	}
+
 	var local []byte
-	if !skipLocalCache && cd.opt.LocalCache != nil {
+	if !skipLocalCache && cd.useLocalCache() {
 		var ok, expired bool
 		local, ok, expired = cd.localGet(key)
 		if ok && !expired {
+			cd.recordAge(ageLocal, key, local)
 			return local, nil
 		}
 	}
 
 	data, err := cd.getRedisBytes(key, skipLocalCache)
 	if err != nil && cd.opt.ErrUseStale && local != nil {
+		cd.recordAge(ageLocal, key, local)
 		return local, nil
 	}
+	if err == nil {
+		cd.recordAge(ageRedis, key, data)
+	}
 	return data, err
 }
 
 func (cd *Cache) getRedisBytes(key string, skipLocalCache bool) (b []byte, err error) {
-	if cd.opt.Redis == nil {
+	if !cd.useRedis() {
 		return nil, ErrCacheMiss
 	}
+	if prefix, ok := cd.bundlePrefix(key); ok {
+		return cd.bundleGet(prefix, key, skipLocalCache)
+	}
 
+	cd.requested()
 	for i := 0; i <= cd.opt.Retry+1; i++ {
-		b, err = cd.opt.Redis.Get(key).Bytes()
+		if i > 0 && !cd.allowRetry() {
+			break
+		}
+		b, err = cd.redisGet(key)
 		if err == nil || err == redis.Nil {
 			break
 		} else {
 			atomic.AddUint64(&cd.errs, 1)
 		}
 	}
+	if err == redis.Nil {
+		cd.recordRedisRead(nil)
+	} else {
+		cd.recordRedisRead(err)
+	}
 
 	if err != nil {
 		if cd.opt.StatsEnabled {
@@ -238,11 +1264,29 @@ func (cd *Cache) getRedisBytes(key string, skipLocalCache bool) (b []byte, err e
 		return nil, err
 	}
 
+	b, err = cd.verify(key, b)
+	if err != nil {
+		atomic.AddUint64(&cd.errs, 1)
+		return nil, err
+	}
+	if isTombstone(b) {
+		if cd.opt.StatsEnabled {
+			atomic.AddUint64(&cd.misses, 1)
+		}
+		return nil, ErrCacheMiss
+	}
+	if target, ok := decodeRef(b); ok {
+		b, err = cd.deref(target)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if cd.opt.StatsEnabled {
 		atomic.AddUint64(&cd.hits, 1)
 	}
 
-	if !skipLocalCache && cd.opt.LocalCache != nil {
+	if !skipLocalCache && cd.useLocalCache() {
 		cd.localSet(key, b)
 	}
 	return b, nil
@@ -253,8 +1297,15 @@ func (cd *Cache) getRedisBytes(key string, skipLocalCache bool) (b []byte, err e
 // making sure that only one execution is in-flight for a given item.Key
 // at a time. If a duplicate comes in, the duplicate caller waits for the
 // original to complete and receives the same results.
-func (cd *Cache) Once(item *Item) error {
-	b, cached, err := cd.getSetItemBytesOnce(item)
+func (cd *Cache) Once(item *Item) (err error) {
+	if cd.accessRings != nil {
+		defer cd.logAccess("once", item.Key, time.Now(), &err)
+	}
+	if cd.dryRun() {
+		cd.probe(item.Key)
+	}
+
+	b, cached, shared, err := cd.getSetItemBytesOnce(item)
 	if err != nil {
 		return err
 	}
@@ -263,98 +1314,284 @@ func (cd *Cache) Once(item *Item) error {
 		return nil
 	}
 
-	if err := cd.Unmarshal(b, item.Value); err != nil {
-		if cached {
-			_ = cd.Delete(item.Context(), item.Key)
+	if shared != nil && item.Unmarshal == nil {
+		err = shared.unmarshal(cd, item.Key, b, item.Value)
+	} else {
+		err = cd.unmarshalItem(item, b)
+	}
+	if err != nil {
+		if cached && cd.dropUndecodable(item) {
 			return cd.Once(item)
 		}
 		return err
 	}
 
+	if cached && !item.Stale && item.Unmarshal == nil {
+		cd.rewriteMigrated(item.Context(), item.Key, b, item.Value)
+	}
 	return nil
 }
 
-func (cd *Cache) getSetItemBytesOnce(item *Item) (b []byte, cached bool, err error) {
-	var local []byte
-	if cd.opt.LocalCache != nil {
+// dropUndecodable deletes a cached value that failed to decode and reports
+// whether it is gone, so Once can load it again. In ReadOnly or dry run
+// mode, or when Redis fails, the value stays and Once returns the error.
+func (cd *Cache) dropUndecodable(item *Item) bool {
+	if cd.dryRun() {
+		return false
+	}
+	err := cd.Delete(item.Context(), item.Key)
+	return err == nil || err == ErrCacheMiss
+}
+
+func (cd *Cache) getSetItemBytesOnce(
+	item *Item,
+) (b []byte, cached bool, shared *sharedResult, err error) {
+	if cd.admission != nil {
+		cd.admission.reads.add(item.Key)
+	}
+	cd.observeRead(item.Key)
+
+	var local, stale []byte
+	if cd.useLocalCache() && !cd.recentlyWritten(item.Key) {
 		var ok, expired bool
 		local, ok, expired = cd.localGet(item.Key)
 		if ok && !expired {
-			return local, true, nil
+			if !cd.softExpired(item, local) {
+				return local, true, nil, nil
+			}
+			stale = local
 		}
 	}
 
-	v, err := cd.group.Do(item.Key, func() (interface{}, error) {
-		b, err := cd.getBytes(item.Context(), item.Key, item.SkipLocalCache)
+	load := func() (interface{}, error) {
+		cd.prefetchSiblings(item)
+		b, err := cd.getBytesWithin(item, item.SkipLocalCache || stale != nil)
+		if err == nil && cd.softExpired(item, b) {
+			stale, err = b, ErrCacheMiss
+		}
 		if err == nil {
+			if item.Immutable && cd.useLocalCache() {
+				cd.localStore(item.Key, b, true)
+			}
 			cached = true
 			return b, nil
 		}
 
+		if item.Do != nil {
+			release, err := cd.acquireLoad(item)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+		}
+
 		b, ok, err := cd.set(item)
+		if err == nil {
+			err = cd.updateDependencies(item)
+		}
 		if ok {
 			return b, nil
 		}
+		if stale != nil && item.Do != nil {
+			return staleValue(stale), nil
+		}
 		return nil, err
+	}
+
+	v, err := cd.group.Do(item.Key, func() (interface{}, error) {
+		v, err := load()
+		if err == nil && cd.opt.ShareDecoded {
+			v = &sharedResult{v: v}
+		}
+		return v, err
 	})
 	if err != nil {
-		if local != nil && cd.opt.ErrUseStale {
-			return local, true, nil
+		if local != nil && (cd.opt.ErrUseStale || cd.shedStale(err)) {
+			return local, true, nil, nil
 		}
-		return nil, false, err
+		return nil, false, nil, err
 	}
-	return v.([]byte), cached, nil
+	if r, ok := v.(*sharedResult); ok {
+		shared, v = r, r.v
+	}
+	if b, ok := v.(staleValue); ok {
+		item.Stale = true
+		return b, true, shared, nil
+	}
+	return v.([]byte), cached, shared, nil
 }
 
-func (cd *Cache) Delete(ctx context.Context, key string) error {
-	if cd.opt.LocalCache != nil {
-		cd.opt.LocalCache.Del([]byte(key))
+// Delete deletes the key from both tiers. The local cache is cleared even
+// when it is bypassed by the current Mode so it does not serve the deleted
+// value once the tier is back in rotation.
+func (cd *Cache) Delete(ctx context.Context, key string) (err error) {
+	if cd.accessRings != nil {
+		defer cd.logAccess("delete", key, time.Now(), &err)
+	}
+	err = cd.delete(key)
+	if err != nil && err != ErrCacheMiss {
+		return err
+	}
+	if depErr := cd.deleteDependents(ctx, key); depErr != nil {
+		return depErr
+	}
+	return err
+}
+
+func (cd *Cache) delete(key string) error {
+	if cd.opt.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if cd.dryRun() {
+		return nil
+	}
+
+	if cd.writes != nil {
+		defer cd.writes.touch(key)
 	}
 
-	if cd.opt.Redis == nil {
-		if cd.opt.LocalCache == nil {
+	unlock := cd.keyLocks.lock(key)
+	defer unlock()
+
+	cd.forgetDelta(key)
+	cd.forgetDecoded(key)
+
+	if cd.local != nil {
+		cd.local.Del([]byte(key))
+	}
+
+	if !cd.useRedis() {
+		if cd.opt.Redis == nil && cd.local == nil {
 			return errRedisLocalCacheNil
 		}
 		return nil
 	}
 
-	deleted, err := cd.opt.Redis.Del(key).Result()
+	deleted, err := cd.redisDel(key)
 	if err != nil {
+		cd.queueDelete(key, err)
 		return err
 	}
+	cd.replayed(key)
+	cd.invalidate(opDelete, []string{key}, nil)
 	if deleted == 0 {
 		return ErrCacheMiss
 	}
 	return nil
 }
 
+// redisDel deletes the key and the keys stored with it from Redis.
+func (cd *Cache) redisDel(key string) (int64, error) {
+	var deleted int64
+	var err error
+	if prefix, ok := cd.bundlePrefix(key); ok {
+		deleted, err = cd.bundleDel(prefix, key)
+	} else {
+		keys := []string{key}
+		if cd.opt.ContentHash {
+			keys = append(keys, hashKey(key))
+		}
+		deleted, err = cd.opt.Redis.Del(keys...).Result()
+	}
+	if err == nil && cd.opt.TrackMetadata {
+		err = cd.opt.Redis.Del(metaKey(key)).Err()
+	}
+	return deleted, err
+}
+
+func (cd *Cache) now() time.Time {
+	return cd.opt.Now()
+}
+
 func (cd *Cache) localSet(key string, b []byte) {
+	cd.localStore(key, b, false)
+}
+
+// localStore stores the value in the local cache. Immutable values
+// never expire there.
+func (cd *Cache) localStore(key string, b []byte, immutable bool) {
+	if cd.opt.MaxLocalEntryBytes > 0 && len(b) > cd.opt.MaxLocalEntryBytes {
+		// Drop the previous value so it is not served instead of the new one.
+		cd.local.Del([]byte(key))
+		return
+	}
+
+	if isCritical(b) {
+		cd.critical.store(key, cd.now())
+	}
+
 	if cd.opt.LocalCacheStoreTTL > 0 {
 		pos := len(b)
 		b = append(b, make([]byte, 4)...)
-		encodeTime(b[pos:], time.Now())
+		if immutable {
+			copy(b[pos:], neverExpires[:])
+		} else {
+			now := cd.now()
+			encodeTime(b[pos:], now)
+
+			if cd.expiries != nil {
+				cd.expiries.add(key, now.Add(cd.localTTL(now)))
+			}
+		}
 	}
 
-	cd.opt.LocalCache.Set([]byte(key), b)
+	cd.local.Set([]byte(key), b)
+	if cd.localKeys != nil {
+		cd.localKeys.add(key)
+	}
+}
+
+// localSetOnWrite stores a value written by this process in the local
+// cache, or only invalidates the local copy when it is filled on read.
+func (cd *Cache) localSetOnWrite(key string, b []byte, skip bool) {
+	cd.forgetDecoded(key)
+	if (skip || cd.opt.LocalOnRead) && cd.useRedis() {
+		cd.local.Del([]byte(key))
+		return
+	}
+	cd.localSet(key, b)
 }
 
 func (cd *Cache) localGet(key string) ([]byte, bool, bool) {
-	b, ok := cd.opt.LocalCache.HasGet(nil, []byte(key))
+	b, ok, expired := cd.localLookup(key)
+	if ok && isCritical(b) && !cd.critical.fresh(key, cd.now(), cd.opt.CriticalStaleness) {
+		// Stale copies of critical values are not served even on errors.
+		cd.local.Del([]byte(key))
+		return nil, false, false
+	}
+	return b, ok, expired
+}
+
+func (cd *Cache) localLookup(key string) ([]byte, bool, bool) {
+	b, ok := cd.local.HasGet(nil, []byte(key))
 	if !ok {
 		return b, false, false
 	}
+	if cd.opt.Faults != nil {
+		b = cd.opt.Faults.corrupt(b)
+	}
 
 	if len(b) == 0 || cd.opt.LocalCacheStoreTTL == 0 {
 		return b, true, false
 	}
 	if len(b) < 4 {
-		panic("not reached")
+		// A DiskCache entry written without LocalCacheStoreTTL.
+		cd.local.Del([]byte(key))
+		return nil, false, false
 	}
 
 	tm := decodeTime(b[len(b)-4:])
-	lifetime := time.Since(tm)
-	if lifetime > cd.opt.LocalCacheStoreTTL || (!cd.opt.BackgroundUpdate && lifetime > cd.opt.LocalCacheTTL) {
-		cd.opt.LocalCache.Del([]byte(key))
+	lifetime := cd.now().Sub(tm)
+	if lifetime < -maxLocalClockSkew && !bytes.Equal(b[len(b)-4:], neverExpires[:]) {
+		// The entry has no timestamp, like short entries above.
+		cd.local.Del([]byte(key))
+		return nil, false, false
+	}
+	if cd.localExpired(tm, lifetime) {
+		cd.local.Del([]byte(key))
 		return b[:len(b)-4], true, true
 	}
 
@@ -375,48 +1612,172 @@ var encPool = sync.Pool{
 	},
 }
 
+// Marshal marshals the value as it is stored in Redis, with the checksum
+// of Options.Checksum.
 func (cd *Cache) Marshal(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	b, err := cd.marshal(&buf, value, true)
+	if err != nil {
+		return nil, err
+	}
+	return cd.withChecksum(b), nil
+}
+
+// marshal is like Marshal, but encodes the value at the end of buf so
+// batches can share one buffer. Unless the value is compressed the result
+// aliases buf and stays valid as long as buf is not truncated or reset.
+// Values are only compressed when compress is set.
+func (cd *Cache) marshal(buf *bytes.Buffer, value interface{}, compress bool) ([]byte, error) {
+	b, err := cd.marshalPayload(buf, value, compress)
+	if err != nil {
+		return nil, err
+	}
+	return cd.withPayloadHeader(value, b), nil
+}
+
+// marshalPayload marshals the value without the payload header.
+func (cd *Cache) marshalPayload(buf *bytes.Buffer, value interface{}, compress bool) ([]byte, error) {
 	switch value := value.(type) {
 	case nil:
 		return nil, nil
+	case rawPayload:
+		return value, nil
 	case []byte:
 		return value, nil
 	case string:
 		return []byte(value), nil
 	}
 
+	if v := reflect.ValueOf(value); needsScrub(v.Type()) {
+		scrubbed, err := cd.scrub(v)
+		if err != nil {
+			return nil, err
+		}
+		value = scrubbed.Interface()
+	}
+
+	if cd.opt.Marshal != nil {
+		return cd.opt.Marshal(value)
+	}
+
 	enc := encPool.Get().(*msgpack.Encoder)
 
-	var buf bytes.Buffer
-	enc.Reset(&buf)
-	enc.UseCompactEncoding(true)
+	start := buf.Len()
+	enc.Reset(buf)
+	enc.UseCompactEncoding(true).
+		SortMapKeys(cd.opt.SortMapKeys).
+		StructAsArray(cd.opt.StructAsArray).
+		UseJSONTag(cd.opt.UseJSONTag)
 
 	err := enc.Encode(value)
 
 	encPool.Put(enc)
 
 	if err != nil {
+		buf.Truncate(start)
 		return nil, err
 	}
 
-	b := buf.Bytes()
+	b := buf.Bytes()[start:]
+	if cd.opt.DeterministicMarshal {
+		canonical, err := canonicalMsgpack(b)
+		if err != nil {
+			buf.Truncate(start)
+			return nil, err
+		}
+		buf.Truncate(start)
+		buf.Write(canonical)
+		b = buf.Bytes()[start:]
+	}
+	fingerprint := cd.opt.TypeFingerprint || isRegisteredType(reflect.TypeOf(value))
 
-	if len(b) < compressionThreshold {
-		b = append(b, noCompression)
-		return b, nil
+	if cd.sizes != nil {
+		cd.sizes.add(len(b))
+	}
+	var compressed []byte
+	if compress && len(b) >= cd.compressionThreshold() {
+		var err error
+		compressed, err = cd.opt.Compressor.Compress(b)
+		if err != nil {
+			buf.Truncate(start)
+			return nil, err
+		}
+	}
+	if compressed != nil {
+		b = compressed
+		buf.Truncate(start)
+
+		trailer := cd.opt.Compressor.ID()
+		if fingerprint {
+			b = appendTypeFingerprint(b, reflect.TypeOf(value))
+			trailer |= typeFingerprintFlag
+		}
+		if cd.opt.SchemaVersion > 0 {
+			b = appendSchemaVersion(b, cd.opt.SchemaVersion)
+			trailer |= schemaVersionFlag
+		}
+		if cd.opt.StoreCreationTime {
+			b = appendCreatedAt(b, cd.now())
+			trailer |= createdAtFlag
+		}
+		return append(b, trailer), nil
 	}
 
-	b = s2.Encode(nil, b)
-	b = append(b, s2Compression)
+	var trailer byte = noCompression
+	if fingerprint {
+		var fp [typeFingerprintLen]byte
+		buf.Write(appendTypeFingerprint(fp[:0], reflect.TypeOf(value)))
+		trailer |= typeFingerprintFlag
+	}
+	if cd.opt.SchemaVersion > 0 {
+		var version [schemaVersionLen]byte
+		buf.Write(appendSchemaVersion(version[:0], cd.opt.SchemaVersion))
+		trailer |= schemaVersionFlag
+	}
+	if cd.opt.StoreCreationTime {
+		var tm [createdAtLen]byte
+		buf.Write(appendCreatedAt(tm[:0], cd.now()))
+		trailer |= createdAtFlag
+	}
+	buf.WriteByte(trailer)
 
-	return b, nil
+	b = buf.Bytes()[start:]
+	return b[:len(b):len(b)], nil
 }
 
+// Unmarshal decodes a value returned by Marshal, verifying the checksum
+// of Options.Checksum. Bytes returned by the cache, e.g. by GetMap, have
+// no checksum and are decoded with UnmarshalKey.
 func (cd *Cache) Unmarshal(b []byte, value interface{}) error {
+	b, err := cd.checkChecksum(b)
+	if err != nil {
+		return err
+	}
+	return cd.unmarshal(b, value)
+}
+
+// unmarshal decodes a payload without checksum.
+func (cd *Cache) unmarshal(b []byte, value interface{}) error {
 	if len(b) == 0 {
 		return nil
 	}
 
+	// Without Options.PayloadHeader raw values are stored as they are,
+	// so a value starting with the header magic is not a header.
+	var hdr payloadHeader
+	var headed bool
+	var err error
+	body := b
+	if cd.opt.PayloadHeader {
+		hdr, body, headed, err = splitPayloadHeader(b)
+		if err != nil {
+			return err
+		}
+	}
+	if headed && hdr.codec == codecRaw {
+		b = body
+	}
+
 	switch value := value.(type) {
 	case nil:
 		return nil
@@ -427,17 +1788,62 @@ func (cd *Cache) Unmarshal(b []byte, value interface{}) error {
 		reflect.ValueOf(value).Elem().SetString(string(b))
 		return nil
 	}
+	if headed && hdr.codec == codecRaw {
+		return fmt.Errorf("cache: can't decode a raw value into %T", value)
+	}
 
+	b = bytes.TrimSuffix(body, []byte(criticalMarker))
 	if len(b) == 0 {
 		return nil
 	}
 
-	switch c := b[len(b)-1]; c {
+	switch {
+	case headed:
+		if b[len(b)-1]&compressionMethodMask != hdr.compression {
+			return errCorruptHeader
+		}
+	case cd.opt.Unmarshal != nil:
+		return cd.opt.Unmarshal(b, value)
+	}
+
+	c := b[len(b)-1]
+	b = b[:len(b)-1]
+
+	b, _, err = stripCreatedAt(b, c)
+	if err != nil {
+		return err
+	}
+	c &^= createdAtFlag
+
+	b, version, err := stripSchemaVersion(b, c)
+	if err != nil {
+		return err
+	}
+	c &^= schemaVersionFlag
+	// The fingerprint of a payload that needs a migration is of the old
+	// type.
+	migrate := version < cd.opt.SchemaVersion
+
+	var fp uint32
+	if c&typeFingerprintFlag != 0 {
+		var err error
+		if migrate {
+			if len(b) < typeFingerprintLen {
+				return errors.New("cache: payload is too short for type fingerprint")
+			}
+			b = b[:len(b)-typeFingerprintLen]
+		} else {
+			b, fp, err = checkTypeFingerprint(b, value)
+		}
+		if err != nil {
+			return err
+		}
+		c &^= typeFingerprintFlag
+	}
+
+	switch c {
 	case noCompression:
-		b = b[:len(b)-1]
 	case s2Compression:
-		b = b[:len(b)-1]
-
 		n, err := s2.DecodedLen(b)
 		if err != nil {
 			return err
@@ -451,10 +1857,72 @@ func (cd *Cache) Unmarshal(b []byte, value interface{}) error {
 			return err
 		}
 	default:
-		return fmt.Errorf("uknownn compression method: %x", c)
+		comp := cd.compressor(c)
+		if comp == nil {
+			return fmt.Errorf("uknownn compression method: %x", c)
+		}
+		b, err = comp.Decompress(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	if migrate {
+		b, err = cd.migrate(b, version)
+		if err != nil {
+			return err
+		}
 	}
 
-	return msgpack.Unmarshal(b, value)
+	if iface, ok := value.(*interface{}); ok && fp != 0 {
+		if v, ptr, ok := registeredValue(fp); ok {
+			if err := cd.decode(b, ptr); err != nil {
+				return err
+			}
+			*iface = v.Interface()
+			return nil
+		}
+	}
+	return cd.decode(b, value)
+}
+
+func (cd *Cache) decode(b []byte, value interface{}) error {
+	if !cd.opt.UseJSONTag {
+		if cd.opt.StrictDecode {
+			return strictUnmarshal(b, value)
+		}
+		return msgpack.Unmarshal(b, value)
+	}
+
+	dec := msgpack.NewDecoder(bytes.NewReader(b)).UseJSONTag(true)
+	if !cd.opt.StrictDecode {
+		return dec.Decode(value)
+	}
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(value); err != nil {
+		return &DecodeError{Err: err}
+	}
+	return nil
+}
+
+var strictDecPool = sync.Pool{
+	New: func() interface{} {
+		dec := msgpack.NewDecoder(nil)
+		dec.DisallowUnknownFields()
+		return dec
+	},
+}
+
+func strictUnmarshal(b []byte, value interface{}) error {
+	dec := strictDecPool.Get().(*msgpack.Decoder)
+	dec.Reset(bytes.NewReader(b))
+	err := dec.Decode(value)
+	strictDecPool.Put(dec)
+
+	if err != nil {
+		return &DecodeError{Err: err}
+	}
+	return nil
 }
 
 //------------------------------------------------------------------------------
@@ -463,6 +1931,53 @@ type Stats struct {
 	Hits   uint64
 	Misses uint64
 	Errs   uint64
+
+	// Swept is the number of expired local cache entries deleted
+	// by the sweeper and SweptBytes is their total size.
+	Swept      uint64
+	SweptBytes uint64
+
+	// Hedged is the number of Redis GETs sent again by hedging.
+	Hedged uint64
+	// Compacted is the number of local cache entries deleted because
+	// they had expired in Redis.
+	Compacted uint64
+	// QuotaRejected is the number of Sets that failed with
+	// ErrQuotaExceeded.
+	QuotaRejected uint64
+
+	// MemoryPressure is set while Redis is under memory pressure.
+	// PressureEvents is the number of times memory pressure started and
+	// PressureSkipped is the number of LowPriority items not written to
+	// Redis because of it and AdmissionDenied is the number of loaded
+	// values not written to Redis because of Options.CostAdmission.
+	MemoryPressure  bool
+	PressureEvents  uint64
+	PressureSkipped uint64
+	AdmissionDenied uint64
+
+	// UnchangedSkipped is the number of writes that only refreshed the
+	// TTL because of Options.SkipUnchangedWrites.
+	UnchangedSkipped uint64
+	// PredictiveRefreshes is the number of keys fetched into the local
+	// cache ahead of their predicted reads by Options.PredictiveRefresh.
+	PredictiveRefreshes uint64
+	// RetriesDenied is the number of retries Options.RetryBudget didn't
+	// allow.
+	RetriesDenied uint64
+
+	// Degraded is set while local TTLs are extended because of the Redis
+	// error rate and DegradedEvents is the number of times it was set.
+	Degraded       bool
+	DegradedEvents uint64
+
+	// ReplayQueued is the number of failed writes waiting to be replayed
+	// by Options.ReplayQueueSize, Replayed is the number of writes
+	// replayed, and ReplayDropped is the number of writes dropped because
+	// the queue was full or Redis rejected them.
+	ReplayQueued  int
+	Replayed      uint64
+	ReplayDropped uint64
 }
 
 // Stats returns cache statistics.
@@ -474,6 +1989,31 @@ func (cd *Cache) Stats() *Stats {
 		Hits:   atomic.LoadUint64(&cd.hits),
 		Misses: atomic.LoadUint64(&cd.misses),
 		Errs:   atomic.LoadUint64(&cd.errs),
+
+		Swept:      atomic.LoadUint64(&cd.swept),
+		SweptBytes: atomic.LoadUint64(&cd.sweptBytes),
+
+		Hedged:    atomic.LoadUint64(&cd.hedged),
+		Compacted: atomic.LoadUint64(&cd.compacted),
+
+		QuotaRejected: atomic.LoadUint64(&cd.quotaRejected),
+
+		MemoryPressure:  cd.MemoryPressure(),
+		PressureEvents:  atomic.LoadUint64(&cd.pressureEvents),
+		PressureSkipped: atomic.LoadUint64(&cd.pressureSkipped),
+		AdmissionDenied: atomic.LoadUint64(&cd.admissionDenied),
+
+		UnchangedSkipped: atomic.LoadUint64(&cd.unchangedSkipped),
+
+		PredictiveRefreshes: atomic.LoadUint64(&cd.predictiveRefreshes),
+		RetriesDenied:       atomic.LoadUint64(&cd.retriesDenied),
+
+		Degraded:       cd.Degraded(),
+		DegradedEvents: atomic.LoadUint64(&cd.degradedEvents),
+
+		ReplayQueued:  cd.replayQueued(),
+		Replayed:      atomic.LoadUint64(&cd.replayedWrites),
+		ReplayDropped: atomic.LoadUint64(&cd.replayDropped),
 	}
 }
 
@@ -486,6 +2026,15 @@ func encodeTime(b []byte, tm time.Time) {
 	binary.LittleEndian.PutUint32(b, uint32(secs))
 }
 
+// maxLocalClockSkew is how far in the future local cache timestamps may
+// be, e.g. after the clock was adjusted, before they are considered
+// invalid.
+const maxLocalClockSkew = time.Minute
+
+// neverExpires is the local cache timestamp of immutable values.
+// It decodes to a time in the far future.
+var neverExpires = [4]byte{0xff, 0xff, 0xff, 0xff}
+
 func decodeTime(b []byte) time.Time {
 	secs := binary.LittleEndian.Uint32(b)
 	return time.Unix(int64(secs)+epoch, 0)
@@ -1,17 +1,33 @@
 package cache_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"github.com/VictoriaMetrics/fastcache"
+	"github.com/cespare/xxhash/v2"
 	"github.com/go-redis/redis/v7"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"io"
+	"io/ioutil"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/star001007/cache"
+	"github.com/star001007/cache/boltstore"
+	"github.com/star001007/cache/cachetest"
+	"github.com/star001007/cache/gossip"
 )
 
 func TestGinkgo(t *testing.T) {
@@ -349,6 +365,4692 @@ var _ = Describe("Cache", func() {
 
 		testCache()
 	})
+
+	Context("with LocalCache and miniredis", func() {
+		var rc *cachetest.RedisCache
+
+		BeforeEach(func() {
+			rc = cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+				LocalCache: fastcache.New(1 << 20),
+			})
+			mycache = rc.Cache
+		})
+
+		AfterEach(func() {
+			_ = rc.Close()
+		})
+
+		testCache()
+	})
+
+	Context("with LocalCacheSize and without Redis", func() {
+		BeforeEach(func() {
+			mycache = cache.New(&cache.Options{
+				LocalCacheSize: 1 << 20,
+			})
+		})
+
+		AfterEach(func() {
+			_ = mycache.Close()
+		})
+
+		testCache()
+	})
+
+	// Integration matrix for Redis compatible servers,
+	// e.g. CACHE_KEYDB_ADDR=127.0.0.1:6380 go test.
+	for _, backend := range []struct {
+		env    string
+		compat *cache.Compat
+	}{
+		{"CACHE_KEYDB_ADDR", cache.CompatKeyDB},
+		{"CACHE_DRAGONFLY_ADDR", cache.CompatDragonfly},
+	} {
+		backend := backend
+
+		Context("with LocalCache and "+backend.compat.Name, func() {
+			BeforeEach(func() {
+				addr := os.Getenv(backend.env)
+				if addr == "" {
+					Skip(backend.env + " is not set")
+				}
+
+				client := redis.NewClient(&redis.Options{
+					Addr: addr,
+				})
+				Expect(client.FlushDB().Err()).NotTo(HaveOccurred())
+
+				mycache = cache.New(&cache.Options{
+					Redis:      client,
+					LocalCache: fastcache.New(1 << 20),
+					Compat:     backend.compat,
+				})
+			})
+
+			testCache()
+		})
+	}
+})
+
+var _ = Describe("Mode", func() {
+	ctx := context.TODO()
+
+	const key = "mykey"
+
+	var mycache *cache.Cache
+
+	BeforeEach(func() {
+		mycache = cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+		err := mycache.Set(&cache.Item{
+			Ctx:   ctx,
+			Key:   key,
+			Value: "value",
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("bypasses local cache", func() {
+		mycache.SetMode(cache.ModeBypassLocal)
+		Expect(mycache.Mode()).To(Equal(cache.ModeBypassLocal))
+
+		var got string
+		err := mycache.Get(ctx, key, &got)
+		Expect(err).To(Equal(cache.ErrCacheMiss))
+
+		mycache.SetMode(cache.ModeDefault)
+		err = mycache.Get(ctx, key, &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("value"))
+	})
+
+	It("passes through to Do", func() {
+		mycache.SetMode(cache.ModePassThrough)
+
+		var callCount int
+		for i := 0; i < 3; i++ {
+			var got string
+			err := mycache.Once(&cache.Item{
+				Ctx:   ctx,
+				Key:   key,
+				Value: &got,
+				Do: func(*cache.Item) (interface{}, error) {
+					callCount++
+					return "loaded", nil
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal("loaded"))
+		}
+		Expect(callCount).To(Equal(3))
+
+		mycache.SetMode(cache.ModeDefault)
+		var got string
+		err := mycache.Get(ctx, key, &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("value"))
+	})
+
+	It("records stats in dry run", func() {
+		mycache = cache.New(&cache.Options{
+			LocalCache:   fastcache.New(1 << 20),
+			StatsEnabled: true,
+		})
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: key, Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		mycache.SetMode(cache.ModeDryRun)
+
+		for _, k := range []string{key, "missing"} {
+			var got string
+			err := mycache.Once(&cache.Item{
+				Ctx:   ctx,
+				Key:   k,
+				Value: &got,
+				Do: func(*cache.Item) (interface{}, error) {
+					return "loaded", nil
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal("loaded"))
+		}
+
+		stats := mycache.Stats()
+		Expect(stats.Hits).To(Equal(uint64(1)))
+		Expect(stats.Misses).To(Equal(uint64(1)))
+
+		mycache.SetMode(cache.ModeDefault)
+		Expect(mycache.Exists(ctx, "missing")).To(BeFalse())
+	})
+})
+
+var _ = Describe("Watch", func() {
+	ctx := context.TODO()
+
+	It("pushes changes to the subscriber", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache:    fastcache.New(1 << 20),
+			WatchInterval: 10 * time.Millisecond,
+		})
+
+		var mu sync.Mutex
+		var changes []string
+		w := mycache.Watch([]string{"config"}, func(key string, value []byte) {
+			mu.Lock()
+			changes = append(changes, key+"="+string(value))
+			mu.Unlock()
+		})
+		defer w.Close()
+
+		got := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), changes...)
+		}
+
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "config", Value: "v1"})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(got).Should(Equal([]string{"config=v1"}))
+
+		err = mycache.Delete(ctx, "config")
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(got).Should(Equal([]string{"config=v1", "config="}))
+	})
+
+	It("pushes decrypted values", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Encryption: &cache.StaticKeys{
+				Keys:      map[byte][]byte{1: bytes.Repeat([]byte{1}, 32)},
+				CurrentID: 1,
+			},
+			SigningKeys:   map[byte][]byte{1: []byte("secret")},
+			SigningKeyID:  1,
+			ContentHash:   true,
+			WatchInterval: 10 * time.Millisecond,
+		})
+		defer rc.Close()
+
+		obj := &Object{Str: "config", Num: 1}
+		Expect(rc.Set(&cache.Item{Key: "config", Value: obj})).To(Succeed())
+
+		values := make(chan []byte, 10)
+		w := rc.Watch([]string{"config"}, func(key string, value []byte) {
+			values <- value
+		})
+		defer w.Close()
+
+		var got Object
+		Expect(rc.UnmarshalKey("config", <-values, &got)).To(Succeed())
+		Expect(&got).To(Equal(obj))
+
+		obj.Num = 2
+		Expect(rc.Set(&cache.Item{Key: "config", Value: obj})).To(Succeed())
+		Expect(rc.UnmarshalKey("config", <-values, &got)).To(Succeed())
+		Expect(&got).To(Equal(obj))
+		Consistently(values, 50*time.Millisecond).ShouldNot(Receive())
+	})
+})
+
+var _ = Describe("GetIfChanged", func() {
+	ctx := context.TODO()
+
+	It("returns value only when it changed", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "blob", Value: "v1"})
+		Expect(err).NotTo(HaveOccurred())
+
+		b, hash, changed, err := mycache.GetIfChanged(ctx, "blob", 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(string(b)).To(Equal("v1"))
+
+		b, hash2, changed, err := mycache.GetIfChanged(ctx, "blob", hash)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(b).To(BeNil())
+		Expect(hash2).To(Equal(hash))
+
+		err = mycache.Set(&cache.Item{Ctx: ctx, Key: "blob", Value: "v2"})
+		Expect(err).NotTo(HaveOccurred())
+
+		b, hash2, changed, err = mycache.GetIfChanged(ctx, "blob", hash)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(string(b)).To(Equal("v2"))
+		Expect(hash2).NotTo(Equal(hash))
+	})
+})
+
+var _ = Describe("ValidateSampleRate", func() {
+	ctx := context.TODO()
+
+	var mycache *cache.Cache
+
+	BeforeEach(func() {
+		mycache = cache.New(&cache.Options{
+			LocalCache:         fastcache.New(1 << 20),
+			ValidateSampleRate: 1,
+		})
+	})
+
+	It("accepts values that round trip", func() {
+		err := mycache.Set(&cache.Item{
+			Ctx:   ctx,
+			Key:   "mykey",
+			Value: &Object{Str: "mystring", Num: 42},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rejects values that do not round trip", func() {
+		type withUnexported struct {
+			Str string
+			num int
+		}
+
+		err := mycache.Set(&cache.Item{
+			Ctx:   ctx,
+			Key:   "mykey",
+			Value: withUnexported{Str: "mystring", num: 42},
+		})
+		Expect(err).To(BeAssignableToTypeOf(&cache.ValidationError{}))
+		Expect(mycache.Exists(ctx, "mykey")).To(BeFalse())
+	})
+})
+
+var _ = Describe("StrictDecode", func() {
+	ctx := context.TODO()
+
+	It("reports unknown fields", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache:   fastcache.New(1 << 20),
+			StrictDecode: true,
+		})
+
+		err := mycache.Set(&cache.Item{
+			Ctx:   ctx,
+			Key:   "mykey",
+			Value: &Object{Str: "mystring", Num: 42},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		var dst struct {
+			Str string
+		}
+		err = mycache.Get(ctx, "mykey", &dst)
+		Expect(err).To(BeAssignableToTypeOf(&cache.DecodeError{}))
+
+		var obj Object
+		err = mycache.Get(ctx, "mykey", &obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj).To(Equal(Object{Str: "mystring", Num: 42}))
+	})
+})
+
+var _ = Describe("TypeFingerprint", func() {
+	ctx := context.TODO()
+
+	It("detects wrong destination type", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache:      fastcache.New(1 << 20),
+			TypeFingerprint: true,
+		})
+
+		err := mycache.Set(&cache.Item{
+			Ctx:   ctx,
+			Key:   "mykey",
+			Value: &Object{Str: "mystring", Num: 42},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		var other struct {
+			Str string
+			Num int
+		}
+		err = mycache.Get(ctx, "mykey", &other)
+		Expect(err).To(Equal(cache.ErrTypeMismatch))
+
+		var obj Object
+		err = mycache.Get(ctx, "mykey", &obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj).To(Equal(Object{Str: "mystring", Num: 42}))
+	})
+})
+
+var _ = Describe("SetMap and GetMap", func() {
+	ctx := context.TODO()
+
+	It("round trips values", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+
+		err := mycache.SetMap(ctx, "user:", map[string]interface{}{
+			"1": &Object{Str: "one", Num: 1},
+			"2": &Object{Str: "two", Num: 2},
+		}, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+
+		m, err := mycache.GetMap(ctx, "user:", []string{"1", "2", "3"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m).To(HaveLen(2))
+
+		var obj Object
+		err = mycache.Unmarshal(m["2"], &obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj).To(Equal(Object{Str: "two", Num: 2}))
+	})
+})
+
+var _ = Describe("SetStream and GetStream", func() {
+	ctx := context.TODO()
+
+	It("iterates over chunked values", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache:      fastcache.New(1 << 20),
+			StreamChunkSize: 3,
+		})
+
+		var n int
+		err := mycache.SetStream(ctx, "list", func() (interface{}, error) {
+			if n == 10 {
+				return nil, io.EOF
+			}
+			n++
+			return &Object{Num: n}, nil
+		}, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+
+		it, err := mycache.GetStream(ctx, "list")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(it.Len()).To(Equal(10))
+
+		var nums []int
+		for it.Next() {
+			if len(nums)%2 == 1 {
+				nums = append(nums, 0)
+				continue
+			}
+			var obj Object
+			Expect(it.Decode(&obj)).NotTo(HaveOccurred())
+			nums = append(nums, obj.Num)
+		}
+		Expect(it.Err()).NotTo(HaveOccurred())
+		Expect(nums).To(Equal([]int{1, 0, 3, 0, 5, 0, 7, 0, 9, 0}))
+	})
+
+	It("ignores plain formats", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			DefaultFormat:   &cache.PlainFormat{Codec: cache.PlainJSON, Gzip: true},
+			PlainFormats:    map[string]cache.PlainFormat{"gz:": {Gzip: true}},
+			StreamChunkSize: 3,
+		})
+		defer rc.Close()
+
+		for _, key := range []string{"list", "gz:list"} {
+			var n int
+			err := rc.SetStream(ctx, key, func() (interface{}, error) {
+				if n == 5 {
+					return nil, io.EOF
+				}
+				n++
+				return &Object{Num: n}, nil
+			}, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+
+			it, err := rc.GetStream(ctx, key)
+			Expect(err).NotTo(HaveOccurred())
+			var sum int
+			for it.Next() {
+				var obj Object
+				Expect(it.Decode(&obj)).To(Succeed())
+				sum += obj.Num
+			}
+			Expect(it.Err()).NotTo(HaveOccurred())
+			Expect(sum).To(Equal(15))
+		}
+	})
+
+	It("deletes the chunks of the previous stream", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			StreamChunkSize: 2,
+		})
+		defer rc.Close()
+
+		setStream := func(n int) {
+			var i int
+			err := rc.SetStream(ctx, "list", func() (interface{}, error) {
+				if i == n {
+					return nil, io.EOF
+				}
+				i++
+				return &Object{Num: i}, nil
+			}, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		setStream(5)
+		Expect(rc.Miniredis.Keys()).To(HaveLen(4))
+
+		rc.FastForward(time.Second)
+		setStream(2)
+		Expect(rc.Miniredis.Keys()).To(ConsistOf("list", fmt.Sprintf("list:%d:0", rc.Now().UnixNano())))
+
+		it, err := rc.GetStream(ctx, "list")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(it.Len()).To(Equal(2))
+		for it.Next() {
+		}
+		Expect(it.Err()).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("LocalSweepInterval", func() {
+	ctx := context.TODO()
+
+	It("deletes expired local entries", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache:         fastcache.New(1 << 20),
+			LocalCacheTTL:      time.Second,
+			LocalCacheStoreTTL: time.Second,
+			LocalSweepInterval: 100 * time.Millisecond,
+			StatsEnabled:       true,
+		})
+		defer mycache.Close()
+
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() uint64 {
+			return mycache.Stats().Swept
+		}, 5*time.Second).Should(Equal(uint64(1)))
+		Expect(mycache.Stats().SweptBytes).To(BeNumerically(">", 0))
+	})
+})
+
+var _ = Describe("MaxLocalEntryBytes", func() {
+	ctx := context.TODO()
+
+	It("does not cache oversized values locally", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache:         fastcache.New(1 << 20),
+			MaxLocalEntryBytes: 8,
+		})
+
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "small", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mycache.Exists(ctx, "small")).To(BeTrue())
+
+		err = mycache.Set(&cache.Item{Ctx: ctx, Key: "small", Value: "very large value"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mycache.Exists(ctx, "small")).To(BeFalse())
+	})
+})
+
+var _ = Describe("ReadOnly", func() {
+	ctx := context.TODO()
+
+	It("rejects writes but loads values", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+			ReadOnly:   true,
+		})
+
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).To(Equal(cache.ErrReadOnly))
+
+		err = mycache.Delete(ctx, "mykey")
+		Expect(err).To(Equal(cache.ErrReadOnly))
+
+		var got string
+		err = mycache.Once(&cache.Item{
+			Ctx:   ctx,
+			Key:   "mykey",
+			Value: &got,
+			Do: func(*cache.Item) (interface{}, error) {
+				return "loaded", nil
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("loaded"))
+		Expect(mycache.Exists(ctx, "mykey")).To(BeFalse())
+	})
+
+	It("returns the decode error of a corrupt cached value", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			ReadOnly: true,
+		})
+		defer rc.Close()
+		Expect(rc.Miniredis.Set("mykey", "\xde\xad")).To(Succeed())
+
+		var obj Object
+		err := rc.Once(&cache.Item{
+			Ctx:   ctx,
+			Key:   "mykey",
+			Value: &obj,
+			Do: func(*cache.Item) (interface{}, error) {
+				return &Object{Str: "loaded"}, nil
+			},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(rc.Miniredis.Exists("mykey")).To(BeTrue())
+	})
+})
+
+var _ = Describe("MaxInflightLoads", func() {
+	ctx := context.TODO()
+
+	var now time.Time
+	var release, done chan struct{}
+
+	newCache := func(policy cache.ShedPolicy) *cache.Cache {
+		mycache := cache.New(&cache.Options{
+			LocalCache:         fastcache.New(1 << 20),
+			LocalCacheTTL:      time.Minute,
+			LocalCacheStoreTTL: time.Hour,
+			MaxInflightLoads:   1,
+			ShedPolicy:         policy,
+			LoadQueueTimeout:   10 * time.Millisecond,
+			Now: func() time.Time {
+				return now
+			},
+		})
+
+		started := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			err := mycache.Once(&cache.Item{
+				Ctx: ctx,
+				Key: "slow",
+				Do: func(*cache.Item) (interface{}, error) {
+					close(started)
+					<-release
+					return "slow", nil
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}()
+		<-started
+
+		return mycache
+	}
+
+	once := func(mycache *cache.Cache) (string, error) {
+		var got string
+		err := mycache.Once(&cache.Item{
+			Ctx:   ctx,
+			Key:   "mykey",
+			Value: &got,
+			Do: func(*cache.Item) (interface{}, error) {
+				return "loaded", nil
+			},
+		})
+		return got, err
+	}
+
+	BeforeEach(func() {
+		now = time.Now()
+		release = make(chan struct{})
+		done = make(chan struct{})
+	})
+
+	AfterEach(func() {
+		close(release)
+		<-done
+	})
+
+	It("returns ErrOverloaded with ShedError", func() {
+		mycache := newCache(cache.ShedError)
+
+		_, err := once(mycache)
+		Expect(err).To(Equal(cache.ErrOverloaded))
+	})
+
+	It("serves stale values with ShedStale", func() {
+		mycache := newCache(cache.ShedStale)
+
+		_, err := once(mycache)
+		Expect(err).To(Equal(cache.ErrOverloaded))
+
+		err = mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "stale"})
+		Expect(err).NotTo(HaveOccurred())
+		now = now.Add(2 * time.Minute)
+
+		got, err := once(mycache)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("stale"))
+	})
+
+	It("waits for a free slot with ShedQueue", func() {
+		mycache := newCache(cache.ShedQueue)
+
+		_, err := once(mycache)
+		Expect(err).To(Equal(cache.ErrOverloaded))
+
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			release <- struct{}{}
+		}()
+
+		got, err := once(mycache)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("loaded"))
+	})
+})
+
+var _ = Describe("Faults", func() {
+	ctx := context.TODO()
+
+	It("fails Redis commands", func() {
+		mycache := cache.New(&cache.Options{
+			Redis:        newRing(),
+			StatsEnabled: true,
+			Faults: &cache.Faults{
+				RedisErrorRate: 1,
+			},
+		})
+
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).To(Equal(cache.ErrFaultInjected))
+
+		err = mycache.Get(ctx, "mykey", nil)
+		Expect(err).To(Equal(cache.ErrFaultInjected))
+		Expect(mycache.Stats().Errs).To(BeNumerically(">", 0))
+	})
+
+	It("corrupts local cache reads", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+			Faults: &cache.Faults{
+				LocalCorruptionRate: 1,
+			},
+		})
+
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		var got string
+		err = mycache.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).NotTo(Equal("value"))
+	})
+
+	It("keeps optional Redis commands", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			TrackDependencies: true,
+			Faults:            &cache.Faults{},
+		})
+		defer rc.Close()
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "user:1", Value: "user", TTL: time.Hour})
+		Expect(err).NotTo(HaveOccurred())
+		err = rc.Set(&cache.Item{Ctx: ctx, Key: "profile:1", Value: "profile", DependsOn: []string{"user:1"}})
+		Expect(err).NotTo(HaveOccurred())
+
+		var got string
+		ttl, err := rc.GetWithTTL(ctx, "user:1", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ttl).To(Equal(time.Hour))
+
+		Expect(rc.Delete(ctx, "user:1")).NotTo(HaveOccurred())
+		Expect(rc.Miniredis.Exists("profile:1")).To(BeFalse())
+	})
+})
+
+var _ = Describe("cachetest", func() {
+	ctx := context.TODO()
+
+	var rc *cachetest.RedisCache
+
+	AfterEach(func() {
+		_ = rc.Close()
+	})
+
+	It("expires keys on FastForward", func() {
+		rc = cachetest.NewRedisCache(GinkgoT())
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value", TTL: time.Minute})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc.Exists(ctx, "mykey")).To(BeTrue())
+
+		rc.FastForward(time.Minute + time.Second)
+		Expect(rc.Exists(ctx, "mykey")).To(BeFalse())
+	})
+
+	It("honors IfExists and IfNotExists", func() {
+		rc = cachetest.NewRedisCache(GinkgoT())
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v1", IfExists: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc.Exists(ctx, "mykey")).To(BeFalse())
+
+		err = rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v1", IfNotExists: true})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v2", IfNotExists: true})
+		Expect(err).NotTo(HaveOccurred())
+
+		var got string
+		err = rc.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("v1"))
+	})
+
+	It("serves stale local values when Redis fails", func() {
+		rc = cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache:         fastcache.New(1 << 20),
+			LocalCacheTTL:      time.Second,
+			LocalCacheStoreTTL: time.Hour,
+			ErrUseStale:        true,
+		})
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		rc.FastForward(2 * time.Second)
+		rc.Miniredis.Close()
+
+		var got string
+		err = rc.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("value"))
+	})
+})
+
+var _ = Describe("SigningKeys", func() {
+	ctx := context.TODO()
+
+	var rc *cachetest.RedisCache
+
+	AfterEach(func() {
+		_ = rc.Close()
+	})
+
+	newCache := func(id byte) *cachetest.RedisCache {
+		return cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			SigningKeys: map[byte][]byte{
+				1: []byte("secret1"),
+				2: []byte("secret2"),
+			},
+			SigningKeyID: id,
+		})
+	}
+
+	It("rejects tampered values", func() {
+		rc = newCache(1)
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		var got string
+		err = rc.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("value"))
+
+		err = rc.Client.Set("mykey", "forged", 0).Err()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = rc.Get(ctx, "mykey", &got)
+		Expect(err).To(Equal(cache.ErrInvalidSignature))
+	})
+
+	It("rejects values copied to another key", func() {
+		rc = newCache(1)
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		b, err := rc.Client.Get("mykey").Bytes()
+		Expect(err).NotTo(HaveOccurred())
+		err = rc.Client.Set("otherkey", b, 0).Err()
+		Expect(err).NotTo(HaveOccurred())
+
+		var got string
+		err = rc.Get(ctx, "otherkey", &got)
+		Expect(err).To(Equal(cache.ErrInvalidSignature))
+	})
+
+	It("verifies values signed with older keys", func() {
+		rc = newCache(1)
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		rotated := cache.New(&cache.Options{
+			Redis: rc.Client,
+			SigningKeys: map[byte][]byte{
+				1: []byte("secret1"),
+				2: []byte("secret2"),
+			},
+			SigningKeyID: 2,
+		})
+
+		var got string
+		err = rotated.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("value"))
+	})
+
+	It("fails to set values without the signing key", func() {
+		rc = newCache(3)
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("TTLStats", func() {
+	ctx := context.TODO()
+
+	var rc *cachetest.RedisCache
+
+	BeforeEach(func() {
+		rc = cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			StatsEnabled: true,
+		})
+	})
+
+	AfterEach(func() {
+		_ = rc.Close()
+	})
+
+	It("tracks TTL distribution and upcoming expirations", func() {
+		for _, item := range []*cache.Item{
+			{Ctx: ctx, Key: "k1", Value: "v", TTL: 30 * time.Second},
+			{Ctx: ctx, Key: "k2", Value: "v", TTL: 10 * time.Minute},
+			{Ctx: ctx, Key: "k3", Value: "v"},
+			{Ctx: ctx, Key: "k4", Value: "v", TTL: -1},
+		} {
+			err := rc.Set(item)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		st := rc.TTLStats()
+		Expect(st.Defaulted).To(Equal(uint64(1)))
+		Expect(st.Buckets).To(Equal([]cache.TTLBucket{
+			{Max: time.Minute, Count: 1},
+			{Max: 5 * time.Minute},
+			{Max: 15 * time.Minute, Count: 1},
+			{Max: time.Hour, Count: 1},
+			{Max: 6 * time.Hour},
+			{Max: 24 * time.Hour},
+			{Count: 1},
+		}))
+
+		Expect(rc.ExpiringWithin(2 * time.Minute)).To(Equal(uint64(1)))
+		Expect(rc.ExpiringWithin(2 * time.Hour)).To(Equal(uint64(3)))
+
+		rc.FastForward(20 * time.Minute)
+		Expect(rc.ExpiringWithin(2 * time.Hour)).To(Equal(uint64(1)))
+	})
+})
+
+var _ = Describe("Set and Delete ordering", func() {
+	ctx := context.TODO()
+
+	It("keeps tiers consistent under concurrent Set and Delete", func() {
+		local := fastcache.New(1 << 20)
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: local,
+		})
+		defer rc.Close()
+
+		localOnly := cache.New(&cache.Options{
+			LocalCache: local,
+		})
+
+		for i := 0; i < 100; i++ {
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_ = rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+			}()
+			go func() {
+				defer wg.Done()
+				_ = rc.Delete(ctx, "mykey")
+			}()
+			wg.Wait()
+
+			inRedis := rc.Miniredis.Exists("mykey")
+			Expect(localOnly.Exists(ctx, "mykey")).To(Equal(inRedis))
+		}
+	})
+})
+
+var _ = Describe("FencingToken", func() {
+	ctx := context.TODO()
+
+	var rc *cachetest.RedisCache
+
+	BeforeEach(func() {
+		rc = cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+	})
+
+	AfterEach(func() {
+		_ = rc.Close()
+	})
+
+	It("rejects writes with older tokens", func() {
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v2", FencingToken: 2})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v1", FencingToken: 1})
+		Expect(err).To(Equal(cache.ErrStaleFencingToken))
+
+		var got string
+		err = rc.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("v2"))
+
+		err = rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v3", FencingToken: 3})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = rc.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("v3"))
+	})
+
+	It("keeps the token after Delete", func() {
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v2", FencingToken: 2})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = rc.Delete(ctx, "mykey")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v1", FencingToken: 1})
+		Expect(err).To(Equal(cache.ErrStaleFencingToken))
+		Expect(rc.Exists(ctx, "mykey")).To(BeFalse())
+	})
+
+	It("expires the token with the value", func() {
+		err := rc.Set(&cache.Item{
+			Ctx: ctx, Key: "mykey", Value: "v2", TTL: time.Minute, FencingToken: 2,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		rc.FastForward(2 * time.Minute)
+
+		err = rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v1", FencingToken: 1})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("GetWithTTL", func() {
+	ctx := context.TODO()
+
+	var rc *cachetest.RedisCache
+
+	BeforeEach(func() {
+		rc = cachetest.NewRedisCache(GinkgoT())
+	})
+
+	AfterEach(func() {
+		_ = rc.Close()
+	})
+
+	It("returns the value and its TTL", func() {
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value", TTL: time.Minute})
+		Expect(err).NotTo(HaveOccurred())
+
+		rc.FastForward(20 * time.Second)
+
+		var got string
+		ttl, err := rc.GetWithTTL(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("value"))
+		Expect(ttl).To(Equal(40 * time.Second))
+	})
+
+	It("returns a negative TTL for keys without expiration", func() {
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value", TTL: -1})
+		Expect(err).NotTo(HaveOccurred())
+
+		ttl, err := rc.GetWithTTL(ctx, "mykey", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ttl < 0).To(BeTrue())
+	})
+
+	It("returns ErrCacheMiss for missing keys", func() {
+		_, err := rc.GetWithTTL(ctx, "missing", nil)
+		Expect(err).To(Equal(cache.ErrCacheMiss))
+	})
+})
+
+var _ = Describe("GetEx", func() {
+	ctx := context.TODO()
+
+	var rc *cachetest.RedisCache
+
+	BeforeEach(func() {
+		rc = cachetest.NewRedisCache(GinkgoT())
+	})
+
+	AfterEach(func() {
+		_ = rc.Close()
+	})
+
+	It("slides the expiration on read", func() {
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value", TTL: time.Minute})
+		Expect(err).NotTo(HaveOccurred())
+
+		for i := 0; i < 3; i++ {
+			rc.FastForward(50 * time.Second)
+
+			var got string
+			err = rc.GetEx(ctx, "mykey", &got, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal("value"))
+		}
+
+		rc.FastForward(2 * time.Minute)
+		err = rc.GetEx(ctx, "mykey", nil, time.Minute)
+		Expect(err).To(Equal(cache.ErrCacheMiss))
+	})
+
+	It("removes the expiration with a negative TTL", func() {
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value", TTL: time.Minute})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = rc.GetEx(ctx, "mykey", nil, -1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc.Miniredis.TTL("mykey")).To(BeZero())
+	})
+})
+
+var _ = Describe("Capabilities", func() {
+	It("detects missing server features", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		caps, err := rc.Capabilities()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(caps).To(Equal(&cache.Capabilities{}))
+		Expect(rc.Miniredis.Exists("cache:capabilities:probe")).To(BeFalse())
+	})
+
+	It("retries failed probes", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		addr := rc.Miniredis.Addr()
+		rc.Miniredis.Close()
+
+		_, err := rc.Capabilities()
+		Expect(err).To(HaveOccurred())
+
+		err = rc.Miniredis.StartAddr(addr)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = rc.Capabilities()
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("Compat", func() {
+	ctx := context.TODO()
+
+	It("disables fencing tokens for KeyDB", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Compat: cache.CompatKeyDB,
+		})
+		defer rc.Close()
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value", FencingToken: 1})
+		Expect(err).To(HaveOccurred())
+		Expect(rc.Miniredis.Exists("mykey")).To(BeFalse())
+	})
+
+	It("uses static capabilities for Dragonfly", func() {
+		mycache := cache.New(&cache.Options{
+			Redis:  newRing(),
+			Compat: cache.CompatDragonfly,
+		})
+
+		caps, err := mycache.Capabilities()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(caps.GetEx).To(BeTrue())
+	})
+
+	It("uses plain pipelines without transactions", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Compat: &cache.Compat{NoTransactions: true},
+		})
+		defer rc.Close()
+
+		err := rc.SetMap(ctx, "p:", map[string]interface{}{"a": "1", "b": "2"}, time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+
+		m, err := rc.GetMap(ctx, "p:", []string{"a", "b"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("DiskCache", func() {
+	ctx := context.TODO()
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "cache")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	open := func() *boltstore.Store {
+		store, err := boltstore.Open(filepath.Join(dir, "cache.db"))
+		Expect(err).NotTo(HaveOccurred())
+		return store
+	}
+
+	It("keeps values across restarts", func() {
+		store := open()
+		mycache := cache.New(&cache.Options{
+			DiskCache: store,
+		})
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.Close()).NotTo(HaveOccurred())
+
+		store = open()
+		defer store.Close()
+		mycache = cache.New(&cache.Options{
+			DiskCache: store,
+		})
+
+		var got string
+		err = mycache.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("value"))
+
+		err = mycache.Delete(ctx, "mykey")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mycache.Exists(ctx, "mykey")).To(BeFalse())
+	})
+
+	It("drops values written with another LocalCacheStoreTTL", func() {
+		store := open()
+		mycache := cache.New(&cache.Options{
+			DiskCache: store,
+		})
+		for _, value := range []string{"ab", "a long value"} {
+			err := mycache.Set(&cache.Item{Ctx: ctx, Key: value, Value: value})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(store.Close()).NotTo(HaveOccurred())
+
+		store = open()
+		mycache = cache.New(&cache.Options{
+			DiskCache:          store,
+			LocalCacheStoreTTL: time.Hour,
+		})
+		for _, key := range []string{"ab", "a long value"} {
+			var got string
+			Expect(mycache.Get(ctx, key, &got)).To(Equal(cache.ErrCacheMiss))
+		}
+		Expect(store.Close()).NotTo(HaveOccurred())
+
+		// The entries were deleted.
+		store = open()
+		defer store.Close()
+		mycache = cache.New(&cache.Options{
+			DiskCache: store,
+		})
+		Expect(mycache.Exists(ctx, "ab")).To(BeFalse())
+		Expect(mycache.Exists(ctx, "a long value")).To(BeFalse())
+	})
+
+	It("promotes values beneath LocalCache", func() {
+		store := open()
+		defer store.Close()
+
+		err := cache.New(&cache.Options{
+			DiskCache: store,
+		}).Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		local := fastcache.New(1 << 20)
+		mycache := cache.New(&cache.Options{
+			LocalCache: local,
+			DiskCache:  store,
+		})
+
+		var got string
+		err = mycache.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("value"))
+		Expect(local.Has([]byte("mykey"))).To(BeTrue())
+	})
+})
+
+var _ = Describe("Broadcast", func() {
+	ctx := context.TODO()
+
+	var rc *cachetest.RedisCache
+	var other *cache.Cache
+
+	BeforeEach(func() {
+		rc = cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+		other = cache.New(&cache.Options{
+			Redis:      rc.Client,
+			LocalCache: fastcache.New(1 << 20),
+			Broadcast:  cache.NewRedisBroadcast(rc.Client, "invalidations"),
+		})
+	})
+
+	AfterEach(func() {
+		_ = other.Close()
+		_ = rc.Close()
+	})
+
+	It("drops local values changed by other processes", func() {
+		writer := cache.New(&cache.Options{
+			Redis:      rc.Client,
+			LocalCache: fastcache.New(1 << 20),
+			Broadcast:  cache.NewRedisBroadcast(rc.Client, "invalidations"),
+		})
+		defer writer.Close()
+
+		err := writer.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v1"})
+		Expect(err).NotTo(HaveOccurred())
+
+		var got string
+		err = other.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("v1"))
+
+		err = writer.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v2"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() string {
+			_ = other.Get(ctx, "mykey", &got)
+			return got
+		}).Should(Equal("v2"))
+
+		err = writer.Delete(ctx, "mykey")
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() bool {
+			return other.Exists(ctx, "mykey")
+		}).Should(BeFalse())
+
+		// The writer keeps its own values.
+		err = writer.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v3"})
+		Expect(err).NotTo(HaveOccurred())
+		rc.Miniredis.Del("mykey")
+		Consistently(func() bool {
+			return writer.Exists(ctx, "mykey")
+		}, "50ms").Should(BeTrue())
+	})
+})
+
+// funcBroadcast delivers messages passed to deliver.
+type funcBroadcast struct {
+	fn        func(msg []byte)
+	published [][]byte
+}
+
+func (b *funcBroadcast) Publish(msg []byte) error {
+	b.published = append(b.published, msg)
+	return nil
+}
+func (b *funcBroadcast) Subscribe(fn func(msg []byte)) error { b.fn = fn; return nil }
+func (b *funcBroadcast) Close() error                        { return nil }
+
+func (b *funcBroadcast) deliver(msg []byte) { b.fn(msg) }
+
+var _ = Describe("Invalidation wire format", func() {
+	ctx := context.TODO()
+
+	It("decodes messages from other services", func() {
+		// Written by hand from invalidation.proto, with an unknown field
+		// in the entry.
+		msg, err := ioutil.ReadFile(filepath.Join("testdata", "invalidation.bin"))
+		Expect(err).NotTo(HaveOccurred())
+
+		bus := new(funcBroadcast)
+		mycache := cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+			Broadcast:  bus,
+		})
+
+		err = mycache.Set(&cache.Item{Ctx: ctx, Key: "ns:mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		bus.deliver(msg)
+		Expect(mycache.Exists(ctx, "ns:mykey")).To(BeFalse())
+	})
+})
+
+var _ = Describe("InvalidationDedupWindow", func() {
+	ctx := context.TODO()
+
+	It("drops redelivered invalidations", func() {
+		msg, err := ioutil.ReadFile(filepath.Join("testdata", "invalidation.bin"))
+		Expect(err).NotTo(HaveOccurred())
+
+		bus := new(funcBroadcast)
+		mycache := cache.New(&cache.Options{
+			LocalCache:              fastcache.New(1 << 20),
+			Broadcast:               bus,
+			InvalidationDedupWindow: time.Minute,
+		})
+
+		err = mycache.Set(&cache.Item{Ctx: ctx, Key: "ns:mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+		bus.deliver(msg)
+		Expect(mycache.Exists(ctx, "ns:mykey")).To(BeFalse())
+
+		err = mycache.Set(&cache.Item{Ctx: ctx, Key: "ns:mykey", Value: "reloaded"})
+		Expect(err).NotTo(HaveOccurred())
+		bus.deliver(msg)
+		Expect(mycache.Exists(ctx, "ns:mykey")).To(BeTrue())
+	})
+})
+
+var _ = Describe("Invalidation ordering", func() {
+	ctx := context.TODO()
+
+	It("only evicts local copies older than the invalidation", func() {
+		published := new(funcBroadcast)
+		writer := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Broadcast: published,
+		})
+		defer writer.Close()
+
+		for _, value := range []string{"v1", "v2"} {
+			err := writer.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: value})
+			Expect(err).NotTo(HaveOccurred())
+			writer.FastForward(time.Second)
+		}
+		Expect(published.published).To(HaveLen(2))
+		v1, v2 := published.published[0], published.published[1]
+
+		bus := new(funcBroadcast)
+		mycache := cache.New(&cache.Options{
+			LocalCache:              fastcache.New(1 << 20),
+			Broadcast:               bus,
+			InvalidationDedupWindow: time.Minute,
+		})
+
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v0"})
+		Expect(err).NotTo(HaveOccurred())
+		bus.deliver(v1)
+		Expect(mycache.Exists(ctx, "mykey")).To(BeFalse())
+
+		// Already has the value of the invalidation.
+		err = mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v2"})
+		Expect(err).NotTo(HaveOccurred())
+		bus.deliver(v2)
+		Expect(mycache.Exists(ctx, "mykey")).To(BeTrue())
+
+		// Arrives after a newer invalidation.
+		bus.deliver(v1)
+		Expect(mycache.Exists(ctx, "mykey")).To(BeTrue())
+	})
+})
+
+var _ = Describe("HotKeys", func() {
+	ctx := context.TODO()
+
+	It("prewarms the local cache with hot keys of other instances", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			HotKeys: 2,
+		})
+		defer rc.Close()
+
+		for _, key := range []string{"a", "a", "a", "a", "b", "c", "c"} {
+			err := rc.Set(&cache.Item{Ctx: ctx, Key: key, Value: key})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rc.Exists(ctx, key)).To(BeTrue())
+		}
+		Expect(rc.HotKeys()).To(Equal([]string{"a", "c"}))
+		Expect(rc.PublishHotKeys()).NotTo(HaveOccurred())
+
+		standby := cache.New(&cache.Options{
+			Redis:      rc.Client,
+			LocalCache: fastcache.New(1 << 20),
+		})
+		n, err := standby.Prewarm(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(2))
+
+		rc.Miniredis.FlushAll()
+		var value string
+		Expect(standby.Get(ctx, "a", &value)).NotTo(HaveOccurred())
+		Expect(value).To(Equal("a"))
+		Expect(standby.Exists(ctx, "b")).To(BeFalse())
+	})
+})
+
+var _ = Describe("LocalTTLRamp", func() {
+	ctx := context.TODO()
+
+	It("shortens local TTLs after start", func() {
+		now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+		mycache := cache.New(&cache.Options{
+			LocalCache:         fastcache.New(1 << 20),
+			LocalCacheTTL:      time.Hour,
+			LocalCacheStoreTTL: time.Hour,
+			LocalTTLRamp:       10 * time.Minute,
+			Now:                func() time.Time { return now },
+		})
+
+		set := func() {
+			err := mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		set()
+		now = now.Add(2 * time.Second)
+		Expect(mycache.Exists(ctx, "mykey")).To(BeFalse())
+
+		now = now.Add(5*time.Minute - 2*time.Second)
+		set()
+		now = now.Add(29 * time.Minute)
+		Expect(mycache.Exists(ctx, "mykey")).To(BeTrue())
+		now = now.Add(2 * time.Minute)
+		Expect(mycache.Exists(ctx, "mykey")).To(BeFalse())
+
+		set()
+		now = now.Add(59 * time.Minute)
+		Expect(mycache.Exists(ctx, "mykey")).To(BeTrue())
+	})
+})
+
+var _ = Describe("CacheBudget", func() {
+	It("calls the loader when Redis uses up the cache budget", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			CacheBudget: 0.2,
+			Faults:      &cache.Faults{RedisLatency: 500 * time.Millisecond},
+		})
+		defer rc.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		start := time.Now()
+		var loadedAfter time.Duration
+		var value string
+		err := rc.Once(&cache.Item{
+			Ctx:   ctx,
+			Key:   "mykey",
+			Value: &value,
+			Do: func(*cache.Item) (interface{}, error) {
+				loadedAfter = time.Since(start)
+				return "loaded", nil
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal("loaded"))
+		Expect(loadedAfter).To(BeNumerically("<", 400*time.Millisecond))
+	})
+
+	It("measures the budget with the wall clock like the deadline", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		Expect(rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "cached"})).To(Succeed())
+
+		mycache := cache.New(&cache.Options{
+			Redis:       rc.Client,
+			CacheBudget: 0.2,
+			Now: func() time.Time {
+				return time.Now().Add(time.Hour)
+			},
+		})
+
+		var value string
+		err := mycache.Once(&cache.Item{
+			Ctx:   ctx,
+			Key:   "mykey",
+			Value: &value,
+			Do: func(*cache.Item) (interface{}, error) {
+				return "loaded", nil
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal("cached"))
+	})
+})
+
+var _ = Describe("HedgePercentile", func() {
+	ctx := context.TODO()
+
+	It("hedges slow Redis reads", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		mycache := cache.New(&cache.Options{
+			Redis:           rc.Client,
+			HedgeRedis:      rc.Client,
+			HedgePercentile: 0.95,
+			Faults:          &cache.Faults{RedisLatency: 500 * time.Millisecond},
+			StatsEnabled:    true,
+		})
+
+		start := time.Now()
+		var value string
+		Expect(mycache.Get(ctx, "mykey", &value)).NotTo(HaveOccurred())
+		Expect(value).To(Equal("value"))
+		Expect(time.Since(start)).To(BeNumerically("<", 400*time.Millisecond))
+		Expect(mycache.Stats().Hedged).To(Equal(uint64(1)))
+	})
+})
+
+var _ = Describe("BundlePrefixes", func() {
+	ctx := context.TODO()
+
+	It("stores keys under a prefix in one hash", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			BundlePrefixes: []string{"flags:"},
+		})
+		defer rc.Close()
+
+		for _, key := range []string{"flags:a", "flags:b"} {
+			err := rc.Set(&cache.Item{Ctx: ctx, Key: key, Value: true})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		fields, err := rc.Miniredis.HKeys("flags:")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fields).To(ConsistOf("a", "b"))
+
+		mycache := cache.New(&cache.Options{
+			Redis:          rc.Client,
+			LocalCache:     fastcache.New(1 << 20),
+			BundlePrefixes: []string{"flags:"},
+			StatsEnabled:   true,
+		})
+		var flag bool
+		Expect(mycache.Get(ctx, "flags:a", &flag)).NotTo(HaveOccurred())
+		Expect(flag).To(BeTrue())
+		Expect(mycache.Get(ctx, "flags:b", &flag)).NotTo(HaveOccurred())
+		Expect(mycache.Stats().Hits).To(Equal(uint64(1)))
+
+		Expect(rc.Delete(ctx, "flags:a")).NotTo(HaveOccurred())
+		Expect(rc.Exists(ctx, "flags:a")).To(BeFalse())
+		Expect(rc.Exists(ctx, "flags:b")).To(BeTrue())
+	})
+
+	It("stores and reads bundled keys with SetMap and GetMap", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			BundlePrefixes: []string{"flags:"},
+			ContentHash:    true,
+		})
+		defer rc.Close()
+
+		err := rc.SetMap(ctx, "flags:", map[string]interface{}{"a": "on"}, time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc.Set(&cache.Item{Ctx: ctx, Key: "flags:b", Value: "off"})).To(Succeed())
+		Expect(rc.Miniredis.Exists("flags:a")).To(BeFalse())
+
+		var flag string
+		Expect(rc.Get(ctx, "flags:a", &flag)).To(Succeed())
+		Expect(flag).To(Equal("on"))
+
+		m, err := rc.GetMap(ctx, "flags:", []string{"a", "b", "missing"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m).To(HaveLen(2))
+		Expect(rc.UnmarshalKey("flags:b", m["b"], &flag)).To(Succeed())
+		Expect(flag).To(Equal("off"))
+	})
+})
+
+var _ = Describe("GetView", func() {
+	ctx := context.TODO()
+
+	It("rebuilds the view when an input changes", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			TrackDependencies: true,
+		})
+		defer rc.Close()
+
+		set := func(c *cache.Cache, key string, n int) {
+			err := c.Set(&cache.Item{Ctx: ctx, Key: key, Value: n})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		var builds int
+		view := &cache.View{
+			Key:    "sum",
+			Inputs: []string{"a", "b"},
+			Build: func(ctx context.Context) (interface{}, error) {
+				builds++
+				var sum int
+				for _, key := range []string{"a", "b"} {
+					var n int
+					if err := rc.Get(ctx, key, &n); err != nil && err != cache.ErrCacheMiss {
+						return nil, err
+					}
+					sum += n
+				}
+				return sum, nil
+			},
+		}
+		sum := func() int {
+			var n int
+			Expect(rc.GetView(ctx, view, &n)).NotTo(HaveOccurred())
+			return n
+		}
+
+		set(rc.Cache, "a", 1)
+		set(rc.Cache, "b", 2)
+		Expect(sum()).To(Equal(3))
+		Expect(sum()).To(Equal(3))
+		Expect(builds).To(Equal(1))
+		Expect(rc.Miniredis.Members("cache:dependents:a")).To(Equal([]string{"sum"}))
+
+		set(rc.Cache, "a", 10)
+		Expect(sum()).To(Equal(12))
+		Expect(builds).To(Equal(2))
+
+		other := cache.New(&cache.Options{
+			Redis:             rc.Client,
+			TrackDependencies: true,
+		})
+		Expect(other.Delete(ctx, "b")).NotTo(HaveOccurred())
+		Expect(sum()).To(Equal(10))
+		Expect(builds).To(Equal(3))
+	})
+})
+
+var _ = Describe("DependsOn", func() {
+	ctx := context.TODO()
+
+	It("cascades deletes to dependents in both tiers", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache:        fastcache.New(1 << 20),
+			TrackDependencies: true,
+		})
+		defer rc.Close()
+
+		items := []*cache.Item{
+			{Ctx: ctx, Key: "user:1", Value: "user"},
+			{Ctx: ctx, Key: "profile:1", Value: "profile", DependsOn: []string{"user:1"}},
+			{Ctx: ctx, Key: "page:1", Value: "page", DependsOn: []string{"profile:1"}},
+		}
+		for _, item := range items {
+			Expect(rc.Set(item)).NotTo(HaveOccurred())
+		}
+
+		Expect(rc.Delete(ctx, "user:1")).NotTo(HaveOccurred())
+		for _, item := range items {
+			Expect(rc.Miniredis.Exists(item.Key)).To(BeFalse())
+			Expect(rc.Exists(ctx, item.Key)).To(BeFalse())
+		}
+	})
+})
+
+var _ = Describe("TimeKey", func() {
+	ctx := context.TODO()
+
+	It("generates window aligned keys", func() {
+		k := cache.TimeKey("stats", 5*time.Minute)
+		tm := time.Date(2024, time.January, 1, 12, 7, 30, 0, time.UTC)
+		Expect(k.Key(tm)).To(Equal("stats:20240101T120500Z"))
+		Expect(k.Previous(tm)).To(Equal("stats:20240101T120000Z"))
+		Expect(k.TTL(tm)).To(Equal(3 * time.Minute))
+	})
+
+	It("falls back to the previous window during the transition", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		k := cache.TimeKey("stats", 5*time.Minute)
+		Expect(rc.SetTimed(ctx, k, 42)).NotTo(HaveOccurred())
+
+		next := k.Start(rc.Now()).Add(5 * time.Minute)
+		rc.FastForward(next.Sub(rc.Now()) + time.Second)
+
+		var n int
+		Expect(rc.GetTimed(ctx, k, &n)).NotTo(HaveOccurred())
+		Expect(n).To(Equal(42))
+
+		rc.FastForward(k.Grace)
+		Expect(rc.GetTimed(ctx, k, &n)).To(Equal(cache.ErrCacheMiss))
+	})
+})
+
+var _ = Describe("FanoutSet", func() {
+	ctx := context.TODO()
+
+	It("sets the value under every key", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		ids := []string{"1", "2", "3"}
+		err := rc.FanoutSet(ctx, "user:{id}:theme", ids, "dark", time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, id := range ids {
+			key := "user:" + id + ":theme"
+			var theme string
+			Expect(rc.Get(ctx, key, &theme)).NotTo(HaveOccurred())
+			Expect(theme).To(Equal("dark"))
+			Expect(rc.Miniredis.TTL(key)).To(Equal(time.Minute))
+		}
+	})
+	It("writes bundled keys and keeps the invariants of Set", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			BundlePrefixes:         []string{"flags:"},
+			TrackMetadata:          true,
+			MaxTotalBytesPerPrefix: map[string]int64{"quota:": 1},
+		})
+		defer rc.Close()
+
+		err := rc.FanoutSet(ctx, "flags:{id}", []string{"a", "b"}, "on", time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		var flag string
+		Expect(rc.Get(ctx, "flags:b", &flag)).To(Succeed())
+		Expect(flag).To(Equal("on"))
+
+		err = rc.FanoutSet(ctx, "user:{id}", []string{"1"}, "v", time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		meta, err := rc.Describe(ctx, "user:1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.Version).To(Equal(int64(1)))
+
+		err = rc.FanoutSet(ctx, "quota:{id}", []string{"1"}, "value", time.Minute)
+		Expect(err).To(Equal(cache.ErrQuotaExceeded))
+		Expect(rc.Miniredis.Exists("quota:1")).To(BeFalse())
+	})
+})
+
+var _ = Describe("AdaptiveCompression", func() {
+	It("learns the compression threshold from value sizes", func() {
+		mycache := cache.New(&cache.Options{
+			AdaptiveCompression: true,
+		})
+		Expect(mycache.CompressionThreshold()).To(Equal(64))
+
+		for i := 0; i < 1024; i++ {
+			value := []string{string(bytes.Repeat([]byte("x"), 300+i%20))}
+			_, err := mycache.Marshal(value)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(mycache.CompressionThreshold()).To(Equal(256))
+	})
+})
+
+var _ = Describe("LocalCompactInterval", func() {
+	ctx := context.TODO()
+
+	It("drops local entries expired in Redis", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache:           fastcache.New(1 << 20),
+			LocalCompactInterval: 10 * time.Millisecond,
+			StatsEnabled:         true,
+		})
+		defer rc.Close()
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "short", Value: "value", TTL: time.Second})
+		Expect(err).NotTo(HaveOccurred())
+		err = rc.Set(&cache.Item{Ctx: ctx, Key: "long", Value: "value", TTL: time.Hour})
+		Expect(err).NotTo(HaveOccurred())
+
+		rc.FastForward(2 * time.Second)
+		Eventually(func() uint64 {
+			return rc.Stats().Compacted
+		}).Should(Equal(uint64(1)))
+		Expect(rc.Exists(ctx, "short")).To(BeFalse())
+		Expect(rc.Exists(ctx, "long")).To(BeTrue())
+	})
+})
+
+var _ = Describe("Tombstone", func() {
+	ctx := context.TODO()
+
+	It("makes late writers discard stale values", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+			Tombstones: true,
+		})
+		defer rc.Close()
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc.Tombstone(ctx, "mykey", time.Minute)).NotTo(HaveOccurred())
+		Expect(rc.Get(ctx, "mykey", nil)).To(Equal(cache.ErrCacheMiss))
+
+		err = rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v2"})
+		Expect(err).To(Equal(cache.ErrTombstoned))
+		Expect(rc.Exists(ctx, "mykey")).To(BeFalse())
+
+		var value string
+		err = rc.Once(&cache.Item{
+			Ctx:   ctx,
+			Key:   "mykey",
+			Value: &value,
+			Do: func(*cache.Item) (interface{}, error) {
+				return "v3", nil
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal("v3"))
+		Expect(rc.Exists(ctx, "mykey")).To(BeFalse())
+
+		rc.FastForward(2 * time.Minute)
+		err = rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v4"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc.Get(ctx, "mykey", &value)).NotTo(HaveOccurred())
+		Expect(value).To(Equal("v4"))
+	})
+})
+
+var _ = Describe("Immutable", func() {
+	ctx := context.TODO()
+
+	It("refuses to overwrite and keeps local copies", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache:         fastcache.New(1 << 20),
+			LocalCacheTTL:      time.Minute,
+			LocalCacheStoreTTL: time.Minute,
+		})
+		defer rc.Close()
+
+		item := &cache.Item{Ctx: ctx, Key: "blob:1", Value: "content", Immutable: true}
+		Expect(rc.Set(item)).NotTo(HaveOccurred())
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "blob:1", Value: "other", Immutable: true})
+		Expect(err).To(Equal(cache.ErrImmutable))
+
+		rc.FastForward(time.Hour)
+		rc.Miniredis.FlushAll()
+
+		var value string
+		Expect(rc.Get(ctx, "blob:1", &value)).NotTo(HaveOccurred())
+		Expect(value).To(Equal("content"))
+	})
+})
+
+var _ = Describe("SetCAS", func() {
+	ctx := context.TODO()
+
+	It("stores identical values once", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		obj := &Object{Str: "mystring", Num: 42}
+		key, err := rc.SetCAS(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key).To(HavePrefix("cas:"))
+
+		again, err := rc.SetCAS(ctx, &Object{Str: "mystring", Num: 42})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(Equal(key))
+
+		other, err := rc.SetCAS(ctx, &Object{Str: "other"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other).NotTo(Equal(key))
+
+		var got Object
+		Expect(rc.GetCAS(ctx, strings.TrimPrefix(key, "cas:"), &got)).NotTo(HaveOccurred())
+		Expect(&got).To(Equal(obj))
+	})
+})
+
+var _ = Describe("SetRef", func() {
+	ctx := context.TODO()
+
+	It("reads values through references", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+		defer rc.Close()
+
+		v1, err := rc.SetCAS(ctx, &Object{Str: "v1"})
+		Expect(err).NotTo(HaveOccurred())
+		v2, err := rc.SetCAS(ctx, &Object{Str: "v2"})
+		Expect(err).NotTo(HaveOccurred())
+
+		var obj Object
+		Expect(rc.SetRef(ctx, "latest", v1, time.Hour)).NotTo(HaveOccurred())
+		Expect(rc.Get(ctx, "latest", &obj)).NotTo(HaveOccurred())
+		Expect(obj.Str).To(Equal("v1"))
+
+		Expect(rc.SetRef(ctx, "latest", v2, time.Hour)).NotTo(HaveOccurred())
+		Expect(rc.Get(ctx, "latest", &obj)).NotTo(HaveOccurred())
+		Expect(obj.Str).To(Equal("v2"))
+	})
+})
+
+var _ = Describe("AllowEmpty", func() {
+	ctx := context.TODO()
+
+	It("rejects empty values by default", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		err := rc.Set(&cache.Item{Ctx: ctx, Key: "mykey"})
+		Expect(err).To(Equal(cache.ErrNilValue))
+
+		Expect(rc.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "x"})).NotTo(HaveOccurred())
+		Expect(rc.Get(ctx, "mykey", nil)).To(Equal(cache.ErrNilValue))
+		Expect(rc.Get(ctx, "missing", nil)).To(Equal(cache.ErrCacheMiss))
+	})
+
+	It("accepts empty values when enabled", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			AllowEmpty: true,
+		})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Ctx: ctx, Key: "mykey"})).NotTo(HaveOccurred())
+		Expect(rc.Get(ctx, "mykey", nil)).NotTo(HaveOccurred())
+	})
+})
+
+type Order struct {
+	ID    int
+	Total float64
+}
+
+type Customer struct {
+	Name string
+}
+
+var _ = Describe("RegisterType", func() {
+	ctx := context.TODO()
+
+	cache.RegisterType[Order]("order")
+	cache.RegisterType[*Customer]("customer")
+
+	It("decodes registered types into interface{}", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+
+		Expect(mycache.Set(&cache.Item{
+			Ctx:   ctx,
+			Key:   "order",
+			Value: Order{ID: 1, Total: 9.5},
+		})).NotTo(HaveOccurred())
+		Expect(mycache.Set(&cache.Item{
+			Ctx:   ctx,
+			Key:   "customer",
+			Value: &Customer{Name: "Ann"},
+		})).NotTo(HaveOccurred())
+
+		var v interface{}
+		Expect(mycache.Get(ctx, "order", &v)).NotTo(HaveOccurred())
+		Expect(v).To(Equal(Order{ID: 1, Total: 9.5}))
+
+		Expect(mycache.Get(ctx, "customer", &v)).NotTo(HaveOccurred())
+		Expect(v).To(Equal(&Customer{Name: "Ann"}))
+
+		var order Order
+		Expect(mycache.Get(ctx, "order", &order)).NotTo(HaveOccurred())
+		Expect(order.ID).To(Equal(1))
+
+		var customer Customer
+		Expect(mycache.Get(ctx, "order", &customer)).To(Equal(cache.ErrTypeMismatch))
+	})
+
+	It("panics on duplicate names", func() {
+		Expect(func() {
+			cache.RegisterType[int]("order")
+		}).To(Panic())
+	})
+})
+
+var _ = Describe("Memoize", func() {
+	ctx := context.TODO()
+
+	It("caches results per key", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+
+		var calls int64
+		square := cache.Memoize(mycache, func(ctx context.Context, n int) (int, error) {
+			atomic.AddInt64(&calls, 1)
+			return n * n, nil
+		}, func(n int) string {
+			return fmt.Sprintf("square:%d", n)
+		}, time.Hour)
+
+		for i := 0; i < 3; i++ {
+			res, err := square(ctx, 7)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res).To(Equal(49))
+		}
+		res, err := square(ctx, 3)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal(9))
+		Expect(atomic.LoadInt64(&calls)).To(Equal(int64(2)))
+	})
+
+	It("does not cache errors", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+
+		fail := true
+		load := cache.Memoize(mycache, func(ctx context.Context, id string) (*Object, error) {
+			if fail {
+				return nil, io.EOF
+			}
+			return &Object{Str: id}, nil
+		}, func(id string) string {
+			return "object:" + id
+		}, time.Hour)
+
+		_, err := load(ctx, "a")
+		Expect(err).To(Equal(io.EOF))
+
+		fail = false
+		obj, err := load(ctx, "a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj.Str).To(Equal("a"))
+	})
+})
+
+func cube(ctx context.Context, n int) (int, error) {
+	return n * n * n, nil
+}
+
+var _ = Describe("FuncKey", func() {
+	It("derives keys from the function, version, and arguments", func() {
+		key, err := cache.FuncKey(cube, "v1", 3)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key).To(HavePrefix("fn:github.com/star001007/cache_test.cube:v1:"))
+
+		same, err := cache.FuncKey(cube, "v1", 3)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(same).To(Equal(key))
+
+		other, err := cache.FuncKey(cube, "v1", 4)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other).NotTo(Equal(key))
+
+		other, err = cache.FuncKey(cube, "v2", 3)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other).NotTo(Equal(key))
+
+		a, err := cache.FuncKey(cube, "", map[string]interface{}{"a": 1, "b": 2, "c": 3})
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 10; i++ {
+			b, err := cache.FuncKey(cube, "", map[string]interface{}{"c": 3, "b": 2, "a": 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b).To(Equal(a))
+		}
+	})
+
+	It("is used by Memoize without a key function", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+
+		memoCube := cache.Memoize(mycache, cube, nil, time.Hour)
+		res, err := memoCube(context.TODO(), 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res).To(Equal(8))
+
+		key, err := cache.FuncKey(cube, "", 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mycache.Exists(context.TODO(), key)).To(BeTrue())
+	})
+})
+
+var _ = Describe("InvalidateTag", func() {
+	ctx := context.TODO()
+
+	set := func(c *cache.Cache, key string, tags ...string) {
+		Expect(c.Set(&cache.Item{
+			Ctx:   ctx,
+			Key:   key,
+			Value: key,
+			Tags:  tags,
+		})).NotTo(HaveOccurred())
+	}
+
+	It("deletes tagged keys from Redis in batches", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+		defer rc.Close()
+
+		for i := 0; i < 10; i++ {
+			set(rc.Cache, fmt.Sprintf("user:%d", i), "users")
+		}
+		set(rc.Cache, "other", "others")
+
+		var reports []int
+		err := rc.InvalidateTag(ctx, "users", &cache.InvalidateTagOptions{
+			BatchSize: 3,
+			Progress: func(deleted int, cursor uint64) {
+				reports = append(reports, deleted)
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reports).NotTo(BeEmpty())
+		Expect(reports[len(reports)-1]).To(Equal(10))
+
+		for i := 0; i < 10; i++ {
+			Expect(rc.Exists(ctx, fmt.Sprintf("user:%d", i))).To(BeFalse())
+		}
+		Expect(rc.Exists(ctx, "other")).To(BeTrue())
+
+		Expect(rc.InvalidateTag(ctx, "users", nil)).NotTo(HaveOccurred())
+	})
+
+	It("stops when the context is canceled", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		set(rc.Cache, "mykey", "mytag")
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		Expect(rc.InvalidateTag(canceled, "mytag", nil)).To(Equal(context.Canceled))
+		Expect(rc.Exists(ctx, "mykey")).To(BeTrue())
+
+		Expect(rc.InvalidateTag(ctx, "mytag", nil)).NotTo(HaveOccurred())
+		Expect(rc.Exists(ctx, "mykey")).To(BeFalse())
+	})
+
+	It("works without Redis", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+
+		set(mycache, "a", "mytag")
+		set(mycache, "b", "mytag")
+
+		Expect(mycache.InvalidateTag(ctx, "mytag", nil)).NotTo(HaveOccurred())
+		Expect(mycache.Exists(ctx, "a")).To(BeFalse())
+		Expect(mycache.Exists(ctx, "b")).To(BeFalse())
+	})
+})
+
+var _ = Describe("MaxTotalBytesPerPrefix", func() {
+	ctx := context.TODO()
+
+	It("rejects Sets over the quota until values expire", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			StatsEnabled: true,
+			MaxTotalBytesPerPrefix: map[string]int64{
+				"big:": 100,
+			},
+		})
+		defer rc.Close()
+
+		value := strings.Repeat("x", 40)
+		set := func(key string) error {
+			return rc.Set(&cache.Item{
+				Ctx:   ctx,
+				Key:   key,
+				Value: value,
+				TTL:   time.Minute,
+			})
+		}
+
+		Expect(set("big:1")).NotTo(HaveOccurred())
+		Expect(set("big:2")).NotTo(HaveOccurred())
+		Expect(set("big:3")).To(Equal(cache.ErrQuotaExceeded))
+		Expect(rc.Exists(ctx, "big:3")).To(BeFalse())
+		Expect(rc.Stats().QuotaRejected).To(Equal(uint64(1)))
+
+		Expect(set("small:1")).NotTo(HaveOccurred())
+
+		rc.FastForward(3 * time.Minute)
+		Expect(set("big:3")).NotTo(HaveOccurred())
+	})
+})
+
+// infoRedis replies to INFO with the given info.
+type infoRedis struct {
+	*redis.Client
+
+	mu   sync.Mutex
+	info string
+}
+
+func (r *infoRedis) setInfo(info string) {
+	r.mu.Lock()
+	r.info = info
+	r.mu.Unlock()
+}
+
+func (r *infoRedis) Do(args ...interface{}) *redis.Cmd {
+	if args[0] == "info" {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return redis.NewCmdResult(r.info, nil)
+	}
+	return r.Client.Do(args...)
+}
+
+var _ = Describe("MemoryPressure", func() {
+	ctx := context.TODO()
+
+	It("shortens TTLs and skips low priority items under pressure", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		redisInfo := &infoRedis{
+			Client: rc.Client,
+			info:   "# Memory\r\nused_memory:950\r\nmaxmemory:1000\r\n",
+		}
+		mycache := cache.New(&cache.Options{
+			Redis:                  redisInfo,
+			StatsEnabled:           true,
+			MemoryPressureInterval: 10 * time.Millisecond,
+			MemoryPressureTTL:      time.Minute,
+		})
+		defer mycache.Close()
+
+		Eventually(mycache.MemoryPressure).Should(BeTrue())
+
+		Expect(rc.Client.Set("low", "stale", 0).Err()).NotTo(HaveOccurred())
+		Expect(mycache.Set(&cache.Item{
+			Ctx:         ctx,
+			Key:         "low",
+			Value:       "value",
+			LowPriority: true,
+		})).NotTo(HaveOccurred())
+		Expect(rc.Miniredis.Exists("low")).To(BeFalse())
+
+		Expect(mycache.Set(&cache.Item{
+			Ctx:   ctx,
+			Key:   "high",
+			Value: "value",
+			TTL:   time.Hour,
+		})).NotTo(HaveOccurred())
+		Expect(rc.Miniredis.TTL("high")).To(Equal(time.Minute))
+
+		stats := mycache.Stats()
+		Expect(stats.PressureEvents).To(Equal(uint64(1)))
+		Expect(stats.PressureSkipped).To(Equal(uint64(1)))
+
+		// Pressure lasts until usage drops below the low watermark.
+		redisInfo.setInfo("used_memory:850\r\nmaxmemory:1000\r\n")
+		Consistently(mycache.MemoryPressure, 50*time.Millisecond).Should(BeTrue())
+
+		redisInfo.setInfo("used_memory:700\r\nmaxmemory:1000\r\n")
+		Eventually(mycache.MemoryPressure).Should(BeFalse())
+
+		Expect(mycache.Set(&cache.Item{
+			Ctx:   ctx,
+			Key:   "high",
+			Value: "value",
+			TTL:   time.Hour,
+		})).NotTo(HaveOccurred())
+		Expect(rc.Miniredis.TTL("high")).To(Equal(time.Hour))
+	})
+})
+
+var _ = Describe("CostAdmission", func() {
+	ctx := context.TODO()
+
+	It("keeps expensive values in Redis under memory pressure", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		mycache := cache.New(&cache.Options{
+			Redis: &infoRedis{
+				Client: rc.Client,
+				info:   "used_memory:950\r\nmaxmemory:1000\r\n",
+			},
+			StatsEnabled:           true,
+			MemoryPressureInterval: 10 * time.Millisecond,
+			CostAdmission:          0.5,
+		})
+		defer mycache.Close()
+
+		Eventually(mycache.MemoryPressure).Should(BeTrue())
+
+		load := func(key string, cost time.Duration) {
+			var s string
+			Expect(mycache.Once(&cache.Item{
+				Ctx:   ctx,
+				Key:   key,
+				Value: &s,
+				Do: func(*cache.Item) (interface{}, error) {
+					time.Sleep(cost)
+					return key, nil
+				},
+			})).NotTo(HaveOccurred())
+		}
+
+		for i := 0; i < 400; i++ {
+			if i%2 == 0 {
+				load(fmt.Sprintf("cheap:%d", i), 0)
+			} else {
+				load(fmt.Sprintf("slow:%d", i), time.Millisecond)
+			}
+		}
+		Expect(mycache.Stats().AdmissionDenied).NotTo(BeZero())
+
+		for i := 0; i < 10; i++ {
+			_ = mycache.Get(ctx, "expensive", nil)
+		}
+		load("expensive", 10*time.Millisecond)
+		Expect(rc.Miniredis.Exists("expensive")).To(BeTrue())
+	})
+})
+
+var _ = Describe("Item.Critical", func() {
+	ctx := context.TODO()
+
+	It("bounds the age of local copies in every process", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+		defer rc.Close()
+
+		reader := cache.New(&cache.Options{
+			Redis:             rc.Client,
+			LocalCache:        fastcache.New(1 << 20),
+			CriticalStaleness: time.Second,
+			Now:               rc.Now,
+		})
+
+		Expect(rc.Set(&cache.Item{
+			Ctx:      ctx,
+			Key:      "killswitch",
+			Value:    &Object{Str: "off"},
+			Critical: true,
+		})).NotTo(HaveOccurred())
+
+		var obj Object
+		Expect(reader.Get(ctx, "killswitch", &obj)).NotTo(HaveOccurred())
+		Expect(obj.Str).To(Equal("off"))
+
+		// Written by a process that doesn't share invalidations.
+		other := cache.New(&cache.Options{Redis: rc.Client})
+		Expect(other.Set(&cache.Item{
+			Ctx:      ctx,
+			Key:      "killswitch",
+			Value:    &Object{Str: "on"},
+			Critical: true,
+		})).NotTo(HaveOccurred())
+
+		Expect(reader.Get(ctx, "killswitch", &obj)).NotTo(HaveOccurred())
+		Expect(obj.Str).To(Equal("off"))
+
+		rc.FastForward(time.Second)
+		Expect(reader.Get(ctx, "killswitch", &obj)).NotTo(HaveOccurred())
+		Expect(obj.Str).To(Equal("on"))
+	})
+
+	It("rejects values without a trailer", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		err := rc.Set(&cache.Item{
+			Ctx:      ctx,
+			Key:      "killswitch",
+			Value:    "off",
+			Critical: true,
+		})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Encoding options", func() {
+	It("sorts map keys", func() {
+		mycache := cache.New(&cache.Options{
+			SortMapKeys: true,
+		})
+
+		m := make(map[string]interface{})
+		for i := 0; i < 20; i++ {
+			m[fmt.Sprint(i)] = i
+		}
+		b1, err := mycache.Marshal(m)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 10; i++ {
+			b2, err := mycache.Marshal(m)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b2).To(Equal(b1))
+		}
+	})
+
+	It("encodes structs as arrays", func() {
+		mycache := cache.New(&cache.Options{
+			StructAsArray: true,
+		})
+
+		obj := &Object{Str: "mystring", Num: 42}
+		b, err := mycache.Marshal(obj)
+		Expect(err).NotTo(HaveOccurred())
+
+		plain, err := cache.New(&cache.Options{}).Marshal(obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(b)).To(BeNumerically("<", len(plain)))
+
+		var got Object
+		Expect(mycache.Unmarshal(b, &got)).NotTo(HaveOccurred())
+		Expect(got).To(Equal(*obj))
+	})
+
+	It("uses json tags", func() {
+		type tagged struct {
+			Name string `json:"name"`
+		}
+
+		mycache := cache.New(&cache.Options{
+			UseJSONTag:   true,
+			StrictDecode: true,
+		})
+
+		b, err := mycache.Marshal(&tagged{Name: "ann"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bytes.Contains(b, []byte("name"))).To(BeTrue())
+
+		var got tagged
+		Expect(mycache.Unmarshal(b, &got)).NotTo(HaveOccurred())
+		Expect(got.Name).To(Equal("ann"))
+	})
+})
+
+var _ = Describe("DeterministicMarshal", func() {
+	It("marshals equal values to identical bytes", func() {
+		mycache := cache.New(&cache.Options{
+			DeterministicMarshal: true,
+		})
+
+		type value struct {
+			IDs    map[int]string
+			Nested []map[string]float64
+			At     time.Time
+		}
+		v := &value{
+			IDs:    make(map[int]string),
+			Nested: []map[string]float64{{}},
+			At:     time.Unix(1600000000, 42),
+		}
+		for i := 0; i < 50; i++ {
+			v.IDs[i] = fmt.Sprint(i)
+			v.Nested[0][fmt.Sprint(i)] = float64(i) / 3
+		}
+
+		b1, err := mycache.Marshal(v)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 10; i++ {
+			b2, err := mycache.Marshal(v)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b2).To(Equal(b1))
+		}
+
+		var got value
+		Expect(mycache.Unmarshal(b1, &got)).NotTo(HaveOccurred())
+		Expect(got.IDs).To(Equal(v.IDs))
+		Expect(got.Nested).To(Equal(v.Nested))
+		Expect(got.At.Equal(v.At)).To(BeTrue())
+	})
+
+	It("encodes zero and NaN floats canonically", func() {
+		mycache := cache.New(&cache.Options{
+			DeterministicMarshal: true,
+		})
+
+		zero, err := mycache.Marshal([]float64{0, math.NaN()})
+		Expect(err).NotTo(HaveOccurred())
+		negZero, err := mycache.Marshal([]float64{math.Copysign(0, -1), -math.NaN()})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(negZero).To(Equal(zero))
+	})
+})
+
+var _ = Describe("SkipUnchangedWrites", func() {
+	ctx := context.TODO()
+
+	test := func(opt *cache.Options) {
+		opt.SkipUnchangedWrites = true
+		opt.StatsEnabled = true
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), opt)
+		defer rc.Close()
+
+		set := func(value string) {
+			Expect(rc.Set(&cache.Item{
+				Ctx:   ctx,
+				Key:   "mykey",
+				Value: &Object{Str: value},
+				TTL:   time.Hour,
+			})).NotTo(HaveOccurred())
+		}
+
+		set("v1")
+		rc.FastForward(time.Minute)
+		Expect(rc.Miniredis.TTL("mykey")).To(Equal(59 * time.Minute))
+
+		set("v1")
+		Expect(rc.Stats().UnchangedSkipped).To(Equal(uint64(1)))
+		Expect(rc.Miniredis.TTL("mykey")).To(Equal(time.Hour))
+
+		set("v2")
+		Expect(rc.Stats().UnchangedSkipped).To(Equal(uint64(1)))
+		var obj Object
+		Expect(rc.Get(ctx, "mykey", &obj)).NotTo(HaveOccurred())
+		Expect(obj.Str).To(Equal("v2"))
+
+		// A deleted value is written again.
+		rc.Miniredis.Del("mykey")
+		set("v2")
+		Expect(rc.Stats().UnchangedSkipped).To(Equal(uint64(1)))
+		Expect(rc.Miniredis.Exists("mykey")).To(BeTrue())
+	}
+
+	It("compares content hashes", func() {
+		test(&cache.Options{ContentHash: true})
+	})
+
+	It("compares local copies", func() {
+		test(&cache.Options{LocalCache: fastcache.New(1 << 20)})
+	})
+})
+
+var _ = Describe("Batch", func() {
+	ctx := context.TODO()
+
+	It("validates items like Set", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			ValidateSampleRate: 1,
+		})
+		defer rc.Close()
+
+		type withUnexported struct {
+			Str string
+			num int
+		}
+
+		b := rc.NewBatch()
+		Expect(b.Set(&cache.Item{Key: "nil"})).To(Equal(cache.ErrNilValue))
+		Expect(b.Set(&cache.Item{Key: "cache:hash:a", Value: "v"})).To(Equal(cache.ErrReservedKey))
+		err := b.Set(&cache.Item{Key: "invalid", Value: withUnexported{Str: "s", num: 1}})
+		Expect(err).To(BeAssignableToTypeOf(&cache.ValidationError{}))
+		Expect(b.Flush(ctx)).To(Succeed())
+		Expect(rc.Miniredis.Keys()).To(BeEmpty())
+	})
+
+	It("writes items in pipelines and reports failed items", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{BatchSize: 2})
+		defer rc.Close()
+
+		b := rc.NewBatch()
+		for i := 0; i < 3; i++ {
+			Expect(b.Set(&cache.Item{
+				Ctx:   ctx,
+				Key:   fmt.Sprintf("key%d", i),
+				Value: &Object{Num: i},
+				TTL:   time.Hour,
+			})).NotTo(HaveOccurred())
+		}
+
+		// The first two items are flushed once BatchSize is reached.
+		Expect(rc.Miniredis.Exists("key0")).To(BeTrue())
+		Expect(rc.Miniredis.Exists("key1")).To(BeTrue())
+		Expect(rc.Miniredis.Exists("key2")).To(BeFalse())
+
+		Expect(b.Set(&cache.Item{Key: "immutable", Value: "v", Immutable: true})).
+			NotTo(Succeed())
+
+		Expect(b.Flush(ctx)).NotTo(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			var obj Object
+			Expect(rc.Get(ctx, fmt.Sprintf("key%d", i), &obj)).NotTo(HaveOccurred())
+			Expect(obj.Num).To(Equal(i))
+		}
+		Expect(rc.Miniredis.TTL("key2")).To(Equal(time.Hour))
+
+		Expect(b.Set(&cache.Item{Key: "key0", Value: "v", IfNotExists: true})).
+			NotTo(HaveOccurred())
+		Expect(b.Set(&cache.Item{Key: "key3", Value: "v"})).NotTo(HaveOccurred())
+		Expect(b.Flush(ctx)).NotTo(HaveOccurred())
+		var obj Object
+		Expect(rc.Get(ctx, "key0", &obj)).NotTo(HaveOccurred())
+		Expect(obj.Num).To(Equal(0))
+		Expect(rc.Exists(ctx, "key3")).To(BeTrue())
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		Expect(b.Set(&cache.Item{Key: "key4", Value: "v"})).NotTo(HaveOccurred())
+		err := b.Flush(canceled)
+		Expect(err).To(HaveOccurred())
+		multiErr, ok := err.(*cache.MultiError)
+		Expect(ok).To(BeTrue())
+		Expect(multiErr.Total).To(Equal(1))
+		Expect(multiErr.Errors).To(HaveLen(1))
+		Expect(multiErr.Err("key4")).To(Equal(context.Canceled))
+		Expect(rc.Miniredis.Exists("key4")).To(BeFalse())
+	})
+
+	It("keeps the content hash of values IfNotExists didn't replace", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			ContentHash: true,
+		})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Ctx: ctx, Key: "key", Value: "v1"})).To(Succeed())
+		hash, err := rc.Miniredis.Get("cache:hash:key")
+		Expect(err).NotTo(HaveOccurred())
+
+		b := rc.NewBatch()
+		Expect(b.Set(&cache.Item{Key: "key", Value: "v2", IfNotExists: true})).To(Succeed())
+		Expect(b.Set(&cache.Item{Key: "other", Value: "v2", IfNotExists: true})).To(Succeed())
+		Expect(b.Flush(ctx)).To(Succeed())
+
+		Expect(rc.Miniredis.Get("cache:hash:key")).To(Equal(hash))
+		Expect(rc.Miniredis.Exists("cache:hash:other")).To(BeTrue())
+	})
+})
+
+var _ = Describe("MSet and DeleteMany", func() {
+	ctx := context.TODO()
+
+	It("reports the errors of individual keys", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		err := rc.MSet(ctx, []*cache.Item{
+			{Key: "key1", Value: &Object{Num: 1}},
+			{Key: "bad", Value: make(chan int)},
+			{Key: "key2", Value: &Object{Num: 2}, Immutable: true},
+		})
+		Expect(err).To(HaveOccurred())
+		multiErr, ok := err.(*cache.MultiError)
+		Expect(ok).To(BeTrue())
+		Expect(multiErr.Total).To(Equal(3))
+		Expect(multiErr.Errors).To(HaveLen(1))
+		Expect(multiErr.Err("bad")).To(HaveOccurred())
+		Expect(multiErr.Err("key1")).NotTo(HaveOccurred())
+
+		for _, key := range []string{"key1", "key2"} {
+			Expect(rc.Exists(ctx, key)).To(BeTrue())
+		}
+
+		Expect(rc.DeleteMany(ctx, []string{"key1", "missing", "key2"})).To(Succeed())
+		for _, key := range []string{"key1", "key2"} {
+			Expect(rc.Miniredis.Exists(key)).To(BeFalse())
+		}
+	})
+
+	It("writes items in one pipeline with their own TTLs", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+		defer rc.Close()
+
+		items := make([]*cache.Item, 300)
+		for i := range items {
+			items[i] = &cache.Item{
+				Key:   fmt.Sprintf("key%d", i),
+				Value: &Object{Num: i},
+				TTL:   time.Duration(i+1) * time.Minute,
+			}
+		}
+		Expect(rc.MSet(ctx, items)).To(Succeed())
+
+		for i, item := range items {
+			Expect(rc.Miniredis.TTL(item.Key)).To(Equal(item.TTL))
+
+			var obj Object
+			Expect(rc.Get(ctx, item.Key, &obj)).To(Succeed())
+			Expect(obj.Num).To(Equal(i))
+		}
+
+		// Every key is served from the local cache.
+		rc.Miniredis.FlushAll()
+		var obj Object
+		Expect(rc.Get(ctx, "key299", &obj)).To(Succeed())
+		Expect(obj.Num).To(Equal(299))
+	})
+
+	It("reports progress and stops on cancellation", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{BatchSize: 2})
+		defer rc.Close()
+
+		items := make([]*cache.Item, 5)
+		keys := make([]string, len(items))
+		for i := range items {
+			keys[i] = fmt.Sprintf("key%d", i)
+			items[i] = &cache.Item{Key: keys[i], Value: "v"}
+		}
+
+		var processed []int
+		progressCtx := cache.WithProgress(ctx, func(n, failed int) {
+			Expect(failed).To(Equal(0))
+			processed = append(processed, n)
+		})
+		Expect(rc.MSet(progressCtx, items)).To(Succeed())
+		Expect(processed).To(Equal([]int{2, 4, 5}))
+
+		// Canceling after the first batch leaves the other keys alone.
+		cancelCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		var failed int
+		err := rc.DeleteMany(cache.WithProgress(cancelCtx, func(n, f int) {
+			failed = f
+			cancel()
+		}), keys)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*cache.MultiError).Errors).To(HaveLen(3))
+		Expect(failed).To(Equal(3))
+		Expect(rc.Miniredis.Exists("key0")).To(BeFalse())
+		Expect(rc.Miniredis.Exists("key1")).To(BeFalse())
+		Expect(rc.Miniredis.Exists("key2")).To(BeTrue())
+	})
+})
+
+var _ = Describe("SoftDelete", func() {
+	ctx := context.TODO()
+
+	It("moves values to the trash and restores them", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{
+			Key:   "mykey",
+			Value: &Object{Str: "expensive"},
+			TTL:   time.Hour,
+		})).To(Succeed())
+		rc.FastForward(10 * time.Minute)
+
+		Expect(rc.SoftDelete(ctx, "mykey")).To(Succeed())
+		Expect(rc.Exists(ctx, "mykey")).To(BeFalse())
+		Expect(rc.Miniredis.TTL("trash:mykey")).To(Equal(15 * time.Minute))
+
+		rc.FastForward(5 * time.Minute)
+		Expect(rc.Restore(ctx, "mykey")).To(Succeed())
+		var obj Object
+		Expect(rc.Get(ctx, "mykey", &obj)).To(Succeed())
+		Expect(obj.Str).To(Equal("expensive"))
+		Expect(rc.Miniredis.TTL("mykey")).To(BeNumerically("~", 45*time.Minute, time.Second))
+		Expect(rc.Miniredis.Exists("trash:mykey")).To(BeFalse())
+
+		Expect(rc.Restore(ctx, "mykey")).To(Equal(cache.ErrCacheMiss))
+		Expect(rc.SoftDelete(ctx, "missing")).To(Equal(cache.ErrCacheMiss))
+	})
+
+	It("restores values in a plain format", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache:    fastcache.New(1 << 20),
+			DefaultFormat: &cache.PlainFormat{Codec: cache.PlainJSON, Gzip: true},
+		})
+		defer rc.Close()
+
+		obj := &Object{Str: "expensive", Num: 42}
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: obj})).To(Succeed())
+		stored, err := rc.Miniredis.Get("mykey")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(rc.SoftDelete(ctx, "mykey")).To(Succeed())
+		Expect(rc.Restore(ctx, "mykey")).To(Succeed())
+		Expect(rc.Miniredis.Get("mykey")).To(Equal(stored))
+
+		var got Object
+		Expect(rc.Get(ctx, "mykey", &got)).To(Succeed())
+		Expect(&got).To(Equal(obj))
+		Expect(rc.GetSkippingLocalCache(ctx, "mykey", &got)).To(Succeed())
+		Expect(&got).To(Equal(obj))
+	})
+
+	It("doesn't restore values that would have expired", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: "v", TTL: time.Minute})).To(Succeed())
+		Expect(rc.SoftDelete(ctx, "mykey")).To(Succeed())
+		rc.FastForward(2 * time.Minute)
+		Expect(rc.Restore(ctx, "mykey")).To(Equal(cache.ErrCacheMiss))
+	})
+})
+
+var _ = Describe("TrackMetadata", func() {
+	ctx := context.TODO()
+
+	It("records who wrote a key and when", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			TrackMetadata: true,
+			Writer:        "billing/pod-7",
+		})
+		defer rc.Close()
+
+		_, err := rc.Describe(ctx, "mykey")
+		Expect(err).To(Equal(cache.ErrCacheMiss))
+
+		created := rc.Now()
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: "v1", TTL: time.Hour})).To(Succeed())
+		rc.FastForward(time.Minute)
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: "v2", TTL: time.Hour})).To(Succeed())
+
+		meta, err := rc.Describe(ctx, "mykey")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.Writer).To(Equal("billing/pod-7"))
+		Expect(meta.Version).To(Equal(int64(2)))
+		Expect(meta.CreatedAt).To(BeTemporally("~", created, time.Millisecond))
+		Expect(meta.UpdatedAt).To(BeTemporally("~", created.Add(time.Minute), time.Millisecond))
+		Expect(rc.Miniredis.TTL("cache:meta:mykey")).To(Equal(time.Hour))
+
+		Expect(rc.Delete(ctx, "mykey")).To(Succeed())
+		_, err = rc.Describe(ctx, "mykey")
+		Expect(err).To(Equal(cache.ErrCacheMiss))
+	})
+})
+
+var _ = Describe("WriteLogSampleRate", func() {
+	It("logs writes with redacted payloads", func() {
+		var entries []cache.WriteLogEntry
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			WriteLogSampleRate: 1,
+			WriteLogger: func(e cache.WriteLogEntry) {
+				entries = append(entries, e)
+			},
+			RedactPayload: func(key string, value interface{}) interface{} {
+				return value.(*Object).Num
+			},
+		})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{
+			Key:   "user:1",
+			Value: &Object{Str: "secret@example.com", Num: 42},
+			TTL:   time.Minute,
+		})).To(Succeed())
+
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Key).To(Equal("user:1"))
+		Expect(entries[0].Size).To(BeNumerically(">", 0))
+		Expect(entries[0].TTL).To(Equal(time.Minute))
+		Expect(entries[0].Payload).To(Equal(42))
+	})
+})
+
+var _ = Describe("field scrubbing", func() {
+	ctx := context.TODO()
+
+	type Account struct {
+		Name     string
+		Email    string `cache:"hash"`
+		Password string `cache:"omit"`
+	}
+	type Team struct {
+		Owner   *Account
+		Members []Account
+	}
+
+	It("omits and hashes tagged fields", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			ValidateSampleRate: 1,
+		})
+		defer rc.Close()
+
+		owner := &Account{Name: "ann", Email: "ann@example.com", Password: "hunter2"}
+		team := &Team{
+			Owner:   owner,
+			Members: []Account{*owner, {Name: "bob"}},
+		}
+		Expect(rc.Set(&cache.Item{Key: "team", Value: team})).To(Succeed())
+
+		// The caller's value is not modified.
+		Expect(owner.Password).To(Equal("hunter2"))
+		Expect(owner.Email).To(Equal("ann@example.com"))
+
+		b, err := rc.Miniredis.Get("team")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b).NotTo(ContainSubstring("hunter2"))
+		Expect(b).NotTo(ContainSubstring("ann@example.com"))
+
+		var got Team
+		Expect(rc.Get(ctx, "team", &got)).To(Succeed())
+		Expect(got.Owner.Name).To(Equal("ann"))
+		Expect(got.Owner.Password).To(BeEmpty())
+		Expect(got.Owner.Email).To(HaveLen(64))
+		Expect(got.Members[0].Email).To(Equal(got.Owner.Email))
+		Expect(got.Members[1].Email).To(BeEmpty())
+	})
+
+	It("rejects hashing other types", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		type Bad struct {
+			Age int `cache:"hash"`
+		}
+		Expect(rc.Set(&cache.Item{Key: "bad", Value: &Bad{Age: 42}})).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("GetFresh", func() {
+	ctx := context.TODO()
+
+	It("treats old values as misses", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache:        fastcache.New(1 << 20),
+			StoreCreationTime: true,
+		})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: &Object{Num: 1}, TTL: time.Hour})).To(Succeed())
+		rc.FastForward(10 * time.Minute)
+
+		var obj Object
+		Expect(rc.GetFresh(ctx, "mykey", &obj, 15*time.Minute)).To(Succeed())
+		Expect(obj.Num).To(Equal(1))
+		Expect(rc.GetFresh(ctx, "mykey", &obj, 5*time.Minute)).To(Equal(cache.ErrCacheMiss))
+
+		// The TTL still applies to Get.
+		Expect(rc.Get(ctx, "mykey", &obj)).To(Succeed())
+
+		var s string
+		Expect(rc.Set(&cache.Item{Key: "raw", Value: "v"})).To(Succeed())
+		Expect(rc.GetFresh(ctx, "raw", &s, time.Hour)).To(Equal(cache.ErrCacheMiss))
+	})
+
+	It("treats values without creation time as misses", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: &Object{Num: 1}})).To(Succeed())
+		var obj Object
+		Expect(rc.GetFresh(ctx, "mykey", &obj, time.Hour)).To(Equal(cache.ErrCacheMiss))
+	})
+})
+
+var _ = Describe("GetWithAge", func() {
+	ctx := context.TODO()
+
+	It("reports the same age in both tiers", func() {
+		opt := &cache.Options{
+			LocalCache:        fastcache.New(1 << 20),
+			StoreCreationTime: true,
+			StatsEnabled:      true,
+		}
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), opt)
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: &Object{Num: 1}, TTL: time.Hour})).To(Succeed())
+		rc.FastForward(2 * time.Minute)
+
+		var obj Object
+		age, err := rc.GetWithAge(ctx, "mykey", &obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(age).To(BeNumerically("~", 2*time.Minute, time.Millisecond))
+
+		age, err = rc.GetWithAge(ctx, "missing", &obj)
+		Expect(err).To(Equal(cache.ErrCacheMiss))
+
+		// Another process without a local copy reads from Redis.
+		other := cache.New(&cache.Options{
+			Redis:             rc.Client,
+			StoreCreationTime: true,
+			StatsEnabled:      true,
+			Now:               rc.Now,
+		})
+		defer other.Close()
+		age, err = other.GetWithAge(ctx, "mykey", &obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(age).To(BeNumerically("~", 2*time.Minute, time.Millisecond))
+
+		count := func(buckets []cache.AgeBucket) (n uint64) {
+			for _, b := range buckets {
+				if b.Max == 5*time.Minute {
+					n += b.Count
+				}
+			}
+			return n
+		}
+		Expect(count(rc.AgeStats().Local)).To(Equal(uint64(1)))
+		Expect(count(other.AgeStats().Redis)).To(Equal(uint64(1)))
+
+		var s string
+		Expect(rc.Set(&cache.Item{Key: "raw", Value: "some text"})).To(Succeed())
+		age, err = rc.GetWithAge(ctx, "raw", &s)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(age).To(BeNumerically("<", 0))
+	})
+})
+
+var _ = Describe("StaleIfError", func() {
+	test := func(opt *cache.Options) {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), opt)
+		defer rc.Close()
+
+		load := func(do func(*cache.Item) (interface{}, error)) (*cache.Item, *Object, error) {
+			var obj Object
+			item := &cache.Item{
+				Key:          "mykey",
+				Value:        &obj,
+				TTL:          time.Minute,
+				StaleIfError: time.Hour,
+				Do:           do,
+			}
+			err := rc.Once(item)
+			return item, &obj, err
+		}
+		failing := func(*cache.Item) (interface{}, error) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		item, obj, err := load(func(*cache.Item) (interface{}, error) {
+			return &Object{Num: 1}, nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj.Num).To(Equal(1))
+		Expect(item.Stale).To(BeFalse())
+		Expect(rc.Miniredis.TTL("mykey")).To(Equal(time.Minute + time.Hour))
+
+		// Within the TTL the loader is not called.
+		item, obj, err = load(failing)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj.Num).To(Equal(1))
+		Expect(item.Stale).To(BeFalse())
+
+		rc.FastForward(2 * time.Minute)
+
+		item, obj, err = load(failing)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj.Num).To(Equal(1))
+		Expect(item.Stale).To(BeTrue())
+
+		item, obj, err = load(func(*cache.Item) (interface{}, error) {
+			return &Object{Num: 2}, nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj.Num).To(Equal(2))
+		Expect(item.Stale).To(BeFalse())
+	}
+
+	It("serves values past TTL from Redis when Do fails", func() {
+		test(&cache.Options{})
+	})
+
+	It("serves values past TTL from the local cache when Do fails", func() {
+		test(&cache.Options{LocalCache: fastcache.New(1 << 20)})
+	})
+})
+
+var _ = Describe("ShareDecoded", func() {
+	It("shares the decoded value among concurrent Once callers", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{ShareDecoded: true})
+		defer rc.Close()
+
+		type Catalog struct {
+			Items []string
+		}
+
+		release := make(chan struct{})
+		var calls int32
+		const n = 5
+		results := make([]Catalog, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(rc.Once(&cache.Item{
+					Key:   "catalog",
+					Value: &results[i],
+					Do: func(*cache.Item) (interface{}, error) {
+						atomic.AddInt32(&calls, 1)
+						<-release
+						return &Catalog{Items: []string{"a", "b"}}, nil
+					},
+				})).To(Succeed())
+			}(i)
+		}
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+		for i := range results {
+			Expect(results[i].Items).To(Equal([]string{"a", "b"}))
+			Expect(&results[i].Items[0]).To(BeIdenticalTo(&results[0].Items[0]))
+		}
+	})
+})
+
+var _ = Describe("MGet", func() {
+	ctx := context.TODO()
+
+	It("gets values from both tiers", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+		defer rc.Close()
+
+		for i := 0; i < 3; i++ {
+			Expect(rc.Set(&cache.Item{
+				Key:            fmt.Sprintf("key%d", i),
+				Value:          &Object{Num: i},
+				SkipLocalOnSet: i > 0,
+			})).To(Succeed())
+		}
+		Expect(rc.Set(&cache.Item{Key: "bad", Value: "not msgpack"})).To(Succeed())
+
+		got := make(map[string]*Object)
+		err := rc.MGet(ctx, []string{"key0", "key1", "key2", "missing", "bad"}, func(key string) interface{} {
+			got[key] = new(Object)
+			return got[key]
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*cache.MultiError).Errors).To(HaveKey("bad"))
+		Expect(got).NotTo(HaveKey("missing"))
+		for i := 0; i < 3; i++ {
+			Expect(got[fmt.Sprintf("key%d", i)].Num).To(Equal(i))
+		}
+
+		// The local cache is back-filled.
+		rc.Miniredis.FlushAll()
+		var obj Object
+		Expect(rc.Get(ctx, "key2", &obj)).To(Succeed())
+		Expect(obj.Num).To(Equal(2))
+	})
+
+	It("follows references, reads bundles, and reports failed keys", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			BundlePrefixes: []string{"flags:"},
+			SigningKeys:    map[byte][]byte{1: []byte("secret")},
+			SigningKeyID:   1,
+		})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Key: "target", Value: "value"})).To(Succeed())
+		Expect(rc.SetRef(ctx, "ref", "target", time.Minute)).To(Succeed())
+		Expect(rc.Set(&cache.Item{Key: "flags:a", Value: "on"})).To(Succeed())
+		Expect(rc.Miniredis.Set("forged", "unsigned")).To(Succeed())
+
+		got := make(map[string]*string)
+		err := rc.MGet(ctx, []string{"ref", "flags:a", "forged"}, func(key string) interface{} {
+			got[key] = new(string)
+			return got[key]
+		})
+		Expect(err).To(HaveOccurred())
+		multiErr := err.(*cache.MultiError)
+		Expect(multiErr.Errors).To(HaveLen(1))
+		Expect(multiErr.Err("forged")).To(HaveOccurred())
+		Expect(*got["ref"]).To(Equal("value"))
+		Expect(*got["flags:a"]).To(Equal("on"))
+	})
+})
+
+var _ = Describe("DecodedCacheSize", func() {
+	ctx := context.TODO()
+
+	type Catalog struct {
+		Items []string
+	}
+
+	It("serves decoded values while the bytes are unchanged", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache:       fastcache.New(1 << 20),
+			DecodedCacheSize: 1,
+		})
+		defer rc.Close()
+
+		set := func(key string, items ...string) {
+			Expect(rc.Set(&cache.Item{Key: key, Value: &Catalog{Items: items}})).To(Succeed())
+		}
+		get := func(key string) *Catalog {
+			var c Catalog
+			Expect(rc.Get(ctx, key, &c)).To(Succeed())
+			return &c
+		}
+
+		set("a", "x")
+		c1, c2 := get("a"), get("a")
+		Expect(c2.Items).To(Equal([]string{"x"}))
+		Expect(&c2.Items[0]).To(BeIdenticalTo(&c1.Items[0]))
+
+		set("a", "y")
+		c3 := get("a")
+		Expect(c3.Items).To(Equal([]string{"y"}))
+
+		// Another process changes the value in Redis only.
+		rc.Miniredis.FlushAll()
+		other := cache.New(&cache.Options{Redis: rc.Client})
+		Expect(other.Set(&cache.Item{Key: "a", Value: &Catalog{Items: []string{"z"}}})).To(Succeed())
+		Expect(get("a").Items).To(Equal([]string{"y"}))
+		var c Catalog
+		Expect(rc.GetSkippingLocalCache(ctx, "a", &c)).To(Succeed())
+		Expect(c.Items).To(Equal([]string{"z"}))
+
+		// The least recently used value is evicted.
+		set("b", "w")
+		c4 := get("a")
+		get("b")
+		Expect(&get("a").Items[0]).NotTo(BeIdenticalTo(&c4.Items[0]))
+	})
+
+	It("hands out deep copies with CopyOnRead", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			DecodedCacheSize: 10,
+			CopyOnRead:       true,
+		})
+		defer rc.Close()
+
+		type Nested struct {
+			Catalog *Catalog
+			Tags    map[string][]string
+		}
+		Expect(rc.Set(&cache.Item{Key: "a", Value: &Nested{
+			Catalog: &Catalog{Items: []string{"x"}},
+			Tags:    map[string][]string{"t": {"1"}},
+		}})).To(Succeed())
+
+		var n1 Nested
+		Expect(rc.Get(ctx, "a", &n1)).To(Succeed())
+		n1.Catalog.Items[0] = "changed"
+		n1.Tags["t"][0] = "changed"
+
+		var n2 Nested
+		Expect(rc.Get(ctx, "a", &n2)).To(Succeed())
+		Expect(n2.Catalog.Items).To(Equal([]string{"x"}))
+		Expect(n2.Tags).To(Equal(map[string][]string{"t": {"1"}}))
+	})
+})
+
+var _ = Describe("OnceMany", func() {
+	items := func(keys ...string) []*cache.Item {
+		items := make([]*cache.Item, len(keys))
+		for i, key := range keys {
+			items[i] = &cache.Item{Key: key, Value: new(Object)}
+		}
+		return items
+	}
+
+	It("loads only the missing keys with one loader call", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Key: "key0", Value: &Object{Str: "cached"}})).To(Succeed())
+
+		var calls int
+		var loaded []string
+		loader := func(missing []*cache.Item) error {
+			calls++
+			for _, item := range missing {
+				loaded = append(loaded, item.Key)
+				if item.Key == "key3" {
+					item.Value = nil
+					continue
+				}
+				item.Value.(*Object).Str = "loaded " + item.Key
+			}
+			return nil
+		}
+
+		page := items("key0", "key1", "key2", "key3")
+		err := rc.OnceMany(page, loader)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*cache.MultiError).Errors).To(Equal(map[string]error{"key3": cache.ErrCacheMiss}))
+		Expect(calls).To(Equal(1))
+		Expect(loaded).To(Equal([]string{"key1", "key2", "key3"}))
+		Expect(page[0].Value.(*Object).Str).To(Equal("cached"))
+		Expect(page[1].Value.(*Object).Str).To(Equal("loaded key1"))
+
+		loaded = nil
+		page = items("key1", "key2")
+		Expect(rc.OnceMany(page, loader)).To(Succeed())
+		Expect(calls).To(Equal(1))
+		Expect(page[1].Value.(*Object).Str).To(Equal("loaded key2"))
+	})
+
+	It("waits for keys that another call is loading", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		started := make(chan struct{})
+		unblock := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			defer GinkgoRecover()
+			done <- rc.OnceMany(items("a"), func(missing []*cache.Item) error {
+				close(started)
+				<-unblock
+				missing[0].Value.(*Object).Num = 1
+				return nil
+			})
+		}()
+		<-started
+
+		var loaded []string
+		page := items("a", "b")
+		go func() {
+			defer GinkgoRecover()
+			done <- rc.OnceMany(page, func(missing []*cache.Item) error {
+				for _, item := range missing {
+					loaded = append(loaded, item.Key)
+					item.Value.(*Object).Num = 2
+				}
+				close(unblock)
+				return nil
+			})
+		}()
+
+		Expect(<-done).To(Succeed())
+		Expect(<-done).To(Succeed())
+		Expect(loaded).To(Equal([]string{"b"}))
+		Expect(page[0].Value.(*Object).Num).To(Equal(1))
+		Expect(page[1].Value.(*Object).Num).To(Equal(2))
+	})
+})
+
+var _ = Describe("Migrations", func() {
+	ctx := context.TODO()
+
+	type UserV1 struct {
+		Name string
+	}
+	type UserV2 struct {
+		FullName string
+	}
+
+	It("upgrades old values on read and rewrites them", func() {
+		var migrated int
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			SchemaVersion: 1,
+			Migrations: map[int]func([]byte) ([]byte, error){
+				0: func(b []byte) ([]byte, error) {
+					migrated++
+					return bytes.Replace(b, []byte("\xa4Name"), []byte("\xa8FullName"), 1), nil
+				},
+			},
+			RewriteMigrated: true,
+		})
+		defer rc.Close()
+
+		old := cache.New(&cache.Options{Redis: rc.Client})
+		Expect(old.Set(&cache.Item{
+			Key:   "user",
+			Value: &UserV1{Name: "Ann"},
+			TTL:   10 * time.Minute,
+		})).To(Succeed())
+		rc.FastForward(time.Minute)
+
+		var user UserV2
+		Expect(rc.Get(ctx, "user", &user)).To(Succeed())
+		Expect(user.FullName).To(Equal("Ann"))
+		Expect(migrated).To(Equal(1))
+
+		// The value was rewritten with the current version and TTL.
+		user = UserV2{}
+		Expect(rc.Get(ctx, "user", &user)).To(Succeed())
+		Expect(user.FullName).To(Equal("Ann"))
+		Expect(migrated).To(Equal(1))
+		Expect(rc.Miniredis.TTL("user")).To(Equal(9 * time.Minute))
+	})
+
+	It("loads values that can't be upgraded again", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{SchemaVersion: 2})
+		defer rc.Close()
+
+		old := cache.New(&cache.Options{Redis: rc.Client, SchemaVersion: 1})
+		Expect(old.Set(&cache.Item{Key: "user", Value: &UserV1{Name: "Ann"}})).To(Succeed())
+
+		var user UserV2
+		err := rc.Get(ctx, "user", &user)
+		Expect(err).To(MatchError("cache: no migration from schema version 1"))
+
+		Expect(rc.Once(&cache.Item{
+			Key:   "user",
+			Value: &user,
+			Do: func(*cache.Item) (interface{}, error) {
+				return &UserV2{FullName: "Ann"}, nil
+			},
+		})).To(Succeed())
+		Expect(user.FullName).To(Equal("Ann"))
+		Expect(rc.Get(ctx, "user", &user)).To(Succeed())
+	})
+})
+
+var _ = Describe("generic helpers", func() {
+	ctx := context.TODO()
+
+	It("returns values of the type parameter", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		_, err := cache.Get[Object](rc.Cache, ctx, "key")
+		Expect(err).To(Equal(cache.ErrCacheMiss))
+
+		var calls int
+		item := &cache.Item{
+			Key: "key",
+			Do: func(*cache.Item) (interface{}, error) {
+				calls++
+				return &Object{Str: "mystring", Num: 42}, nil
+			},
+		}
+		for i := 0; i < 2; i++ {
+			obj, err := cache.Once[Object](rc.Cache, item)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(obj).To(Equal(Object{Str: "mystring", Num: 42}))
+		}
+		Expect(calls).To(Equal(1))
+		Expect(item.Value).To(BeNil())
+
+		obj, err := cache.Get[*Object](rc.Cache, ctx, "key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(obj).To(Equal(&Object{Str: "mystring", Num: 42}))
+	})
+})
+
+var _ = Describe("FallbackFormats", func() {
+	ctx := context.TODO()
+
+	It("decodes values written by other libraries", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			FallbackFormats: []cache.PlainFormat{
+				{Codec: cache.PlainMsgpack},
+				{Codec: cache.PlainJSON},
+			},
+		})
+		defer rc.Close()
+
+		Expect(rc.Miniredis.Set("legacy", `{"Str":"mystring","Num":42}`)).To(Succeed())
+
+		var obj Object
+		Expect(rc.Get(ctx, "legacy", &obj)).To(Succeed())
+		Expect(obj).To(Equal(Object{Str: "mystring", Num: 42}))
+
+		Expect(rc.Miniredis.Set("garbage", "not a value")).To(Succeed())
+		err := rc.Get(ctx, "garbage", &obj)
+		Expect(err).To(MatchError(ContainSubstring("compression method")))
+
+		strict := cache.New(&cache.Options{Redis: rc.Client})
+		Expect(strict.Get(ctx, "legacy", &obj)).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("Migrate", func() {
+	ctx := context.TODO()
+
+	It("renames keys and re-encodes values in batches", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+			BatchSize:  2,
+		})
+		defer rc.Close()
+
+		for i := 0; i < 5; i++ {
+			Expect(rc.Set(&cache.Item{
+				Key:   fmt.Sprintf("user:%d", i),
+				Value: &Object{Num: i},
+			})).To(Succeed())
+		}
+		Expect(rc.Set(&cache.Item{Key: "other", Value: "v"})).To(Succeed())
+
+		var pages int
+		progressCtx := cache.WithProgress(ctx, func(n, failed int) {
+			pages++
+		})
+		err := rc.Migrate(progressCtx, "user:*", func(key string, b []byte) (string, []byte, time.Duration, error) {
+			var obj Object
+			if err := rc.UnmarshalKey(key, b, &obj); err != nil {
+				return "", nil, 0, err
+			}
+			if obj.Num == 3 {
+				return "", nil, 0, fmt.Errorf("can't migrate %d", obj.Num)
+			}
+			obj.Str = "migrated"
+			newB, err := rc.Marshal(&obj)
+			return "users:" + strings.TrimPrefix(key, "user:"), newB, time.Minute, err
+		})
+		Expect(err).To(HaveOccurred())
+		multiErr := err.(*cache.MultiError)
+		Expect(multiErr.Total).To(Equal(5))
+		Expect(multiErr.Errors).To(HaveKey("user:3"))
+		Expect(pages).To(BeNumerically(">", 0))
+
+		for i := 0; i < 5; i++ {
+			var obj Object
+			if i == 3 {
+				Expect(rc.Exists(ctx, "user:3")).To(BeTrue())
+				continue
+			}
+			Expect(rc.Exists(ctx, fmt.Sprintf("user:%d", i))).To(BeFalse())
+			Expect(rc.Get(ctx, fmt.Sprintf("users:%d", i), &obj)).To(Succeed())
+			Expect(obj).To(Equal(Object{Str: "migrated", Num: i}))
+			Expect(rc.Miniredis.TTL(fmt.Sprintf("users:%d", i))).To(Equal(time.Minute))
+		}
+		Expect(rc.Exists(ctx, "other")).To(BeTrue())
+	})
+})
+
+var _ = Describe("Item.Marshal and Item.Unmarshal", func() {
+	ctx := context.TODO()
+
+	It("replace the codec for one item", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		upstream := []byte(`{"Str":"mystring","Num":42}`)
+		var calls int
+		item := func(dst *Object) *cache.Item {
+			return &cache.Item{
+				Key:   "key",
+				Value: dst,
+				Do: func(*cache.Item) (interface{}, error) {
+					calls++
+					return upstream, nil
+				},
+				Marshal: func(v interface{}) ([]byte, error) {
+					return v.([]byte), nil
+				},
+				Unmarshal: json.Unmarshal,
+			}
+		}
+
+		for i := 0; i < 2; i++ {
+			var obj Object
+			Expect(rc.Once(item(&obj))).To(Succeed())
+			Expect(obj).To(Equal(Object{Str: "mystring", Num: 42}))
+		}
+		Expect(calls).To(Equal(1))
+
+		var b []byte
+		Expect(rc.Get(ctx, "key", &b)).To(Succeed())
+		Expect(b).To(Equal(upstream))
+	})
+})
+
+var _ = Describe("custom Marshal and Unmarshal", func() {
+	ctx := context.TODO()
+
+	It("stores values exactly as Marshal returns them", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Marshal: func(v interface{}) ([]byte, error) {
+				obj := v.(*Object)
+				return []byte(fmt.Sprintf("%s|%d", obj.Str, obj.Num)), nil
+			},
+			Unmarshal: func(b []byte, v interface{}) error {
+				obj := v.(*Object)
+				_, err := fmt.Sscanf(strings.Replace(string(b), "|", " ", 1), "%s %d", &obj.Str, &obj.Num)
+				return err
+			},
+			StoreCreationTime: true,
+		})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Key: "key", Value: &Object{Str: "mystring", Num: 42}})).To(Succeed())
+		Expect(rc.Miniredis.Get("key")).To(Equal("mystring|42"))
+
+		var obj Object
+		Expect(rc.Get(ctx, "key", &obj)).To(Succeed())
+		Expect(obj).To(Equal(Object{Str: "mystring", Num: 42}))
+
+		Expect(rc.Set(&cache.Item{Key: "str", Value: "value"})).To(Succeed())
+		var s string
+		Expect(rc.Get(ctx, "str", &s)).To(Succeed())
+		Expect(s).To(Equal("value"))
+	})
+})
+
+var _ = Describe("Explain", func() {
+	ctx := context.TODO()
+
+	It("reports what every tier holds", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache:         fastcache.New(1 << 20),
+			LocalCacheTTL:      time.Minute,
+			LocalCacheStoreTTL: time.Minute,
+			StoreCreationTime:  true,
+		})
+		defer rc.Close()
+
+		e := rc.Explain(ctx, "key")
+		Expect(e.Served).To(BeEmpty())
+		Expect(e.Steps).To(HaveLen(2))
+		Expect(e.Steps[0].Outcome).To(Equal("missing"))
+		Expect(e.Steps[1].Outcome).To(Equal("missing"))
+
+		Expect(rc.Set(&cache.Item{Key: "key", Value: &Object{Num: 42}, TTL: time.Hour})).To(Succeed())
+		rc.FastForward(2 * time.Minute)
+
+		e = rc.Explain(ctx, "key")
+		Expect(e.Served).To(Equal("redis"))
+		Expect(e.Steps[0].Outcome).To(Equal("expired"))
+		Expect(e.Steps[1].Outcome).To(Equal("found"))
+		Expect(e.Steps[1].Age).To(BeNumerically("~", 2*time.Minute, time.Second))
+		Expect(e.Steps[1].TTL).To(Equal(58 * time.Minute))
+		Expect(e.Steps[1].Size).To(BeNumerically(">", 0))
+
+		// Explain doesn't drop the expired local copy.
+		Expect(rc.Explain(ctx, "key").Steps[0].Outcome).To(Equal("expired"))
+
+		var obj Object
+		Expect(rc.Get(ctx, "key", &obj)).To(Succeed())
+		e = rc.Explain(ctx, "key")
+		Expect(e.Served).To(Equal("local"))
+		Expect(e.Steps).To(HaveLen(1))
+		Expect(e.Steps[0].String()).To(Equal("local: found"))
+	})
+})
+
+var _ = Describe("AccessLog", func() {
+	ctx := context.TODO()
+
+	It("keeps the recent operations per prefix", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			AccessLog: map[string]int{
+				"user:":       2,
+				"user:admin:": 10,
+			},
+		})
+		defer rc.Close()
+
+		var obj Object
+		Expect(rc.Get(ctx, "user:1", &obj)).To(Equal(cache.ErrCacheMiss))
+		Expect(rc.Set(&cache.Item{Key: "user:1", Value: &obj})).To(Succeed())
+		Expect(rc.Get(ctx, "user:1", &obj)).To(Succeed())
+		Expect(rc.Set(&cache.Item{Key: "user:admin:1", Value: make(chan int)})).NotTo(Succeed())
+		Expect(rc.Set(&cache.Item{Key: "other", Value: &obj})).To(Succeed())
+
+		log := rc.AccessLog("user:")
+		Expect(log).To(HaveLen(2))
+		Expect(log[0].Op).To(Equal("set"))
+		Expect(log[1].Op).To(Equal("get"))
+		Expect(log[1].Outcome).To(Equal("ok"))
+
+		admin := rc.AccessLog("user:admin:")
+		Expect(admin).To(HaveLen(1))
+		Expect(admin[0].Outcome).To(Equal("error"))
+		Expect(admin[0].Err).NotTo(BeEmpty())
+
+		w := httptest.NewRecorder()
+		rc.AccessLogHandler().ServeHTTP(w, httptest.NewRequest("GET", "/?prefix=user:", nil))
+		var logs map[string][]cache.AccessLogEntry
+		Expect(json.Unmarshal(w.Body.Bytes(), &logs)).To(Succeed())
+		Expect(logs).To(HaveLen(1))
+		Expect(logs["user:"]).To(HaveLen(2))
+		Expect(logs["user:"][1].Key).To(Equal("user:1"))
+	})
+})
+
+var _ = Describe("Item.SiblingKeys", func() {
+	ctx := context.TODO()
+
+	It("prefetches sibling keys into the local cache on a miss", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+		})
+		defer rc.Close()
+
+		other := cache.New(&cache.Options{Redis: rc.Client})
+		for _, key := range []string{"page:2", "page:3"} {
+			Expect(other.Set(&cache.Item{Key: key, Value: &Object{Str: key}})).To(Succeed())
+		}
+
+		var obj Object
+		Expect(rc.Once(&cache.Item{
+			Key:   "page:1",
+			Value: &obj,
+			Do: func(*cache.Item) (interface{}, error) {
+				return &Object{Str: "page:1"}, nil
+			},
+			SiblingKeys: func(key string) []string {
+				return []string{"page:2", "page:3", "page:4"}
+			},
+		})).To(Succeed())
+
+		for _, key := range []string{"page:2", "page:3"} {
+			key := key
+			Eventually(func() string {
+				return rc.Explain(ctx, key).Served
+			}).Should(Equal("local"))
+		}
+		Expect(rc.Explain(ctx, "page:4").Served).To(BeEmpty())
+	})
+})
+
+type reverseCompressor struct{}
+
+func (reverseCompressor) ID() byte { return 4 }
+
+func (reverseCompressor) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, len(src))
+	for i, c := range src {
+		dst[len(src)-1-i] = c
+	}
+	return dst, nil
+}
+
+func (c reverseCompressor) Decompress(src []byte) ([]byte, error) {
+	return c.Compress(src)
+}
+
+var _ = Describe("Compressor", func() {
+	ctx := context.TODO()
+
+	It("reads values compressed by every built-in compressor", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		large := &Object{Str: strings.Repeat("my very large string", 10), Num: 42}
+		for _, c := range []cache.Compressor{cache.S2Compressor, cache.ZstdCompressor, cache.LZ4Compressor} {
+			writer := cache.New(&cache.Options{Redis: rc.Client, Compressor: c})
+			Expect(writer.Set(&cache.Item{Key: "key", Value: large})).To(Succeed())
+
+			b, err := rc.Miniredis.Get("key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b[len(b)-1]).To(Equal(c.ID()))
+
+			var obj Object
+			Expect(rc.Get(ctx, "key", &obj)).To(Succeed())
+			Expect(&obj).To(Equal(large))
+		}
+	})
+
+	It("reads values compressed at every level", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		large := &Object{Str: strings.Repeat("my very large string", 10), Num: 42}
+		levels := []cache.CompressionLevel{cache.FastestCompression, cache.BetterCompression}
+		for _, c := range []cache.Compressor{cache.S2Compressor, cache.ZstdCompressor, cache.LZ4Compressor} {
+			for _, level := range levels {
+				writer := cache.New(&cache.Options{
+					Redis:                  rc.Client,
+					Compressor:             c,
+					CompressionLevel:       level,
+					CompressionConcurrency: 2,
+				})
+				Expect(writer.Set(&cache.Item{Key: "key", Value: large})).To(Succeed())
+
+				b, err := rc.Miniredis.Get("key")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(b[len(b)-1]).To(Equal(c.ID()))
+
+				var obj Object
+				Expect(rc.Get(ctx, "key", &obj)).To(Succeed())
+				Expect(&obj).To(Equal(large))
+			}
+		}
+	})
+
+	It("compresses values from CompressionThreshold", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			CompressionThreshold: 1 << 10,
+		})
+		defer rc.Close()
+		Expect(rc.CompressionThreshold()).To(Equal(1 << 10))
+
+		large := &Object{Str: strings.Repeat("my very large string", 10), Num: 42}
+		Expect(rc.Set(&cache.Item{Key: "key", Value: large})).To(Succeed())
+		b, err := rc.Miniredis.Get("key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b[len(b)-1]).To(Equal(byte(0)))
+
+		large.Str = strings.Repeat("my very large string", 100)
+		Expect(rc.Set(&cache.Item{Key: "key", Value: large})).To(Succeed())
+		b, err = rc.Miniredis.Get("key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b[len(b)-1]).To(Equal(cache.S2Compressor.ID()))
+	})
+
+	It("stores items with NoCompression uncompressed", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		large := &Object{Str: strings.Repeat("my very large string", 10), Num: 42}
+		Expect(rc.Set(&cache.Item{Key: "key", Value: large, NoCompression: true})).To(Succeed())
+
+		b, err := rc.Miniredis.Get("key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b[len(b)-1]).To(Equal(byte(0)))
+		Expect(b).To(ContainSubstring(large.Str))
+
+		var obj Object
+		Expect(rc.Get(ctx, "key", &obj)).To(Succeed())
+		Expect(&obj).To(Equal(large))
+
+		err = rc.Once(&cache.Item{
+			Key:           "once",
+			Value:         &obj,
+			NoCompression: true,
+			Do: func(*cache.Item) (interface{}, error) {
+				return large, nil
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		b, err = rc.Miniredis.Get("once")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b[len(b)-1]).To(Equal(byte(0)))
+	})
+
+	It("uses custom compressors", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Compressor: reverseCompressor{},
+		})
+		defer rc.Close()
+
+		large := &Object{Str: strings.Repeat("my very large string", 10), Num: 42}
+		Expect(rc.Set(&cache.Item{Key: "key", Value: large})).To(Succeed())
+
+		var obj Object
+		Expect(rc.Get(ctx, "key", &obj)).To(Succeed())
+		Expect(&obj).To(Equal(large))
+
+		other := cache.New(&cache.Options{Redis: rc.Client})
+		Expect(other.Get(ctx, "key", &obj)).To(MatchError(ContainSubstring("compression method: 4")))
+	})
+})
+
+var _ = Describe("PredictiveRefresh", func() {
+	ctx := context.TODO()
+
+	It("refreshes local copies before their predicted reads", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache:                fastcache.New(1 << 20),
+			LocalCacheTTL:             100 * time.Second,
+			LocalCacheStoreTTL:        100 * time.Second,
+			PredictiveRefresh:         10,
+			PredictiveRefreshInterval: 10 * time.Millisecond,
+			StatsEnabled:              true,
+		})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: "v1"})).To(Succeed())
+
+		// Read every minute, so the copy stored at 2m would expire at
+		// 3m40s just before the read predicted at 4m.
+		var s string
+		for i := 0; i < 4; i++ {
+			if i > 0 {
+				rc.FastForward(time.Minute)
+			}
+			Expect(rc.Get(ctx, "mykey", &s)).To(Succeed())
+		}
+
+		other := cache.New(&cache.Options{Redis: rc.Client, Now: rc.Now})
+		defer other.Close()
+		Expect(other.Set(&cache.Item{Key: "mykey", Value: "v2"})).To(Succeed())
+
+		refreshes := rc.Stats().PredictiveRefreshes
+		rc.FastForward(59 * time.Second)
+		Eventually(func() uint64 {
+			return rc.Stats().PredictiveRefreshes
+		}, 5*time.Second).Should(Equal(refreshes + 1))
+
+		Expect(rc.Explain(ctx, "mykey").Served).To(Equal("local"))
+		Expect(rc.Get(ctx, "mykey", &s)).To(Succeed())
+		Expect(s).To(Equal("v2"))
+	})
+})
+
+var _ = Describe("SpreadTTL", func() {
+	ctx := context.TODO()
+
+	It("spreads TTLs of batch writes over the window", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		write := func() map[string]time.Duration {
+			batch := rc.NewBatch()
+			batch.SpreadTTL(time.Hour, 10*time.Minute)
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key%d", i)
+				Expect(batch.Set(&cache.Item{Key: key, Value: "value"})).To(Succeed())
+			}
+			Expect(batch.Set(&cache.Item{Key: "fixed", Value: "value", TTL: time.Minute})).To(Succeed())
+			Expect(batch.Flush(ctx)).To(Succeed())
+
+			ttls := make(map[string]time.Duration)
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key%d", i)
+				ttl := rc.Miniredis.TTL(key)
+				Expect(ttl).To(BeNumerically(">=", time.Hour))
+				Expect(ttl).To(BeNumerically("<", time.Hour+10*time.Minute))
+				ttls[key] = ttl
+			}
+			Expect(rc.Miniredis.TTL("fixed")).To(Equal(time.Minute))
+			return ttls
+		}
+
+		ttls := write()
+		distinct := make(map[time.Duration]bool)
+		for _, ttl := range ttls {
+			distinct[ttl] = true
+		}
+		Expect(len(distinct)).To(BeNumerically(">", 50))
+
+		// Keys keep their TTLs across runs.
+		Expect(write()).To(Equal(ttls))
+	})
+})
+
+var _ = Describe("ExpiryClusterSize", func() {
+	It("spreads values expiring in a crowded second", func() {
+		var warnings []int
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			ExpiryClusterSize: 3,
+			ExpiryClusterWarning: func(expiresAt time.Time, count int) {
+				warnings = append(warnings, count)
+			},
+		})
+		defer rc.Close()
+
+		var spread int
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key%d", i)
+			Expect(rc.Set(&cache.Item{Key: key, Value: "value", TTL: time.Hour})).To(Succeed())
+
+			ttl := rc.Miniredis.TTL(key)
+			Expect(ttl).To(BeNumerically(">=", time.Hour))
+			Expect(ttl).To(BeNumerically("<", time.Hour+time.Minute))
+			if ttl > time.Hour {
+				spread++
+			}
+			if i < 3 {
+				Expect(ttl).To(Equal(time.Hour))
+			}
+		}
+		Expect(spread).To(BeNumerically(">", 0))
+		Expect(warnings).To(Equal([]int{4}))
+	})
+})
+
+var _ = Describe("RetryBudget", func() {
+	ctx := context.TODO()
+
+	It("limits retries across operations", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Retry:            2,
+			RetryBudget:      0.1,
+			RetryBudgetBurst: 2,
+			StatsEnabled:     true,
+			Faults:           &cache.Faults{RedisErrorRate: 1},
+		})
+		defer rc.Close()
+
+		// Without the budget every Get would try four times.
+		for i := 0; i < 20; i++ {
+			Expect(rc.Get(ctx, "mykey", nil)).To(Equal(cache.ErrFaultInjected))
+		}
+		st := rc.Stats()
+		Expect(st.Errs).To(BeNumerically(">=", 22))
+		Expect(st.Errs).To(BeNumerically("<=", 24))
+		Expect(st.RetriesDenied).To(Equal(uint64(20)))
+	})
+})
+
+var _ = Describe("DegradedErrorRate", func() {
+	ctx := context.TODO()
+
+	It("extends local TTLs while Redis reads fail", func() {
+		faults := &cache.Faults{}
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			LocalCache:         fastcache.New(1 << 20),
+			LocalCacheTTL:      time.Minute,
+			LocalCacheStoreTTL: time.Minute,
+			DegradedErrorRate:  0.5,
+			DegradedWindow:     10 * time.Second,
+			DegradedLocalTTL:   10 * time.Minute,
+			StatsEnabled:       true,
+			Faults:             faults,
+		})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: "value"})).To(Succeed())
+
+		readMissing := func() {
+			for i := 0; i < 10; i++ {
+				_ = rc.Get(ctx, "missing", nil)
+			}
+			rc.FastForward(10 * time.Second)
+			_ = rc.Get(ctx, "missing", nil)
+		}
+
+		faults.RedisErrorRate = 1
+		readMissing()
+		Expect(rc.Degraded()).To(BeTrue())
+
+		rc.FastForward(2 * time.Minute)
+		var s string
+		Expect(rc.Get(ctx, "mykey", &s)).To(Succeed())
+		Expect(s).To(Equal("value"))
+
+		faults.RedisErrorRate = 0
+		readMissing()
+		Expect(rc.Degraded()).To(BeFalse())
+		Expect(rc.Explain(ctx, "mykey").Served).To(Equal("redis"))
+		Expect(rc.Stats().DegradedEvents).To(Equal(uint64(1)))
+	})
+})
+
+var _ = Describe("PayloadHeader", func() {
+	ctx := context.TODO()
+
+	It("writes self-describing values and reads values written without it", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			PayloadHeader: true,
+		})
+		defer rc.Close()
+		legacy := cache.New(&cache.Options{Redis: rc.Client})
+		defer legacy.Close()
+
+		large := &Object{Str: strings.Repeat("my very large string", 10), Num: 42}
+		Expect(rc.Set(&cache.Item{Key: "obj", Value: large})).To(Succeed())
+		Expect(rc.Set(&cache.Item{Key: "str", Value: "mystring"})).To(Succeed())
+		Expect(legacy.Set(&cache.Item{Key: "old", Value: large})).To(Succeed())
+
+		b, err := rc.Miniredis.Get("str")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b).To(Equal("\xc1\xca\x01\x00\x00mystring"))
+
+		var obj Object
+		Expect(rc.Get(ctx, "obj", &obj)).To(Succeed())
+		Expect(&obj).To(Equal(large))
+		Expect(rc.Get(ctx, "old", &obj)).To(Succeed())
+		Expect(&obj).To(Equal(large))
+
+		var s string
+		Expect(rc.Get(ctx, "str", &s)).To(Succeed())
+		Expect(s).To(Equal("mystring"))
+		Expect(rc.Get(ctx, "str", &obj)).To(HaveOccurred())
+
+		// Readers without it read the header as part of raw values.
+		Expect(legacy.Get(ctx, "str", &s)).To(Succeed())
+		Expect(s).To(Equal("\xc1\xca\x01\x00\x00mystring"))
+	})
+
+	It("keeps streams, references, and deltas working", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			PayloadHeader:   true,
+			StreamChunkSize: 3,
+		})
+		defer rc.Close()
+
+		var n int
+		err := rc.SetStream(ctx, "list", func() (interface{}, error) {
+			if n == 5 {
+				return nil, io.EOF
+			}
+			n++
+			return &Object{Num: n}, nil
+		}, time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		it, err := rc.GetStream(ctx, "list")
+		Expect(err).NotTo(HaveOccurred())
+		var sum int
+		for it.Next() {
+			var obj Object
+			Expect(it.Decode(&obj)).To(Succeed())
+			sum += obj.Num
+		}
+		Expect(it.Err()).NotTo(HaveOccurred())
+		Expect(sum).To(Equal(15))
+
+		large := &Object{Str: strings.Repeat("my very large string", 10), Num: 42}
+		target, err := rc.SetCAS(ctx, large)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rc.SetRef(ctx, "ref", target, time.Hour)).To(Succeed())
+		var obj Object
+		Expect(rc.Get(ctx, "ref", &obj)).To(Succeed())
+		Expect(&obj).To(Equal(large))
+
+		for i := 0; i < 3; i++ {
+			large.Num = i
+			Expect(rc.SetDelta(&cache.Item{Key: "delta", Value: large})).To(Succeed())
+		}
+		Expect(rc.GetDelta(ctx, "delta", &obj)).To(Succeed())
+		Expect(&obj).To(Equal(large))
+	})
+
+	It("rejects unknown format versions", func() {
+		mycache := cache.New(&cache.Options{PayloadHeader: true})
+		var s string
+		err := mycache.Unmarshal([]byte("\xc1\xca\x09\x00\x00mystring"), &s)
+		Expect(err).To(MatchError("cache: unknown payload format version 9"))
+	})
+
+	It("stores raw values that look like a header as is without it", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{})
+		defer rc.Close()
+
+		for _, value := range []string{"\xc1\xca\x01\x00\x00xy", "\xc1\xca\x09"} {
+			Expect(rc.Set(&cache.Item{Key: "mykey", Value: []byte(value)})).To(Succeed())
+			var b []byte
+			Expect(rc.GetSkippingLocalCache(ctx, "mykey", &b)).To(Succeed())
+			Expect(string(b)).To(Equal(value))
+		}
+	})
+})
+
+var _ = Describe("Encryption", func() {
+	ctx := context.TODO()
+
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 16)
+
+	It("encrypts values in Redis and rotates keys", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Encryption: &cache.StaticKeys{
+				Keys:      map[byte][]byte{1: key1},
+				CurrentID: 1,
+			},
+			SigningKeys:  map[byte][]byte{1: []byte("secret")},
+			SigningKeyID: 1,
+		})
+		defer rc.Close()
+
+		obj := &Object{Str: "my secret string", Num: 42}
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: obj})).To(Succeed())
+
+		b, err := rc.Miniredis.Get("mykey")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b[:3]).To(Equal("\xc1\xce\x01"))
+		Expect(b).NotTo(ContainSubstring("my secret string"))
+
+		var got Object
+		Expect(rc.Get(ctx, "mykey", &got)).To(Succeed())
+		Expect(&got).To(Equal(obj))
+
+		// Values can't be moved to another key.
+		Expect(rc.Miniredis.Set("other", b)).To(Succeed())
+		Expect(rc.Get(ctx, "other", &got)).To(Equal(cache.ErrInvalidSignature))
+
+		rotated := cache.New(&cache.Options{
+			Redis: rc.Client,
+			Encryption: &cache.StaticKeys{
+				Keys:      map[byte][]byte{1: key1, 2: key2},
+				CurrentID: 2,
+			},
+			SigningKeys:  map[byte][]byte{1: []byte("secret")},
+			SigningKeyID: 1,
+		})
+		defer rotated.Close()
+		Expect(rotated.Get(ctx, "mykey", &got)).To(Succeed())
+		Expect(&got).To(Equal(obj))
+
+		Expect(rotated.Set(&cache.Item{Key: "mykey", Value: obj})).To(Succeed())
+		b, err = rc.Miniredis.Get("mykey")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b[:3]).To(Equal("\xc1\xce\x02"))
+		Expect(rc.Get(ctx, "mykey", &got)).To(Equal(cache.ErrDecryption))
+	})
+
+	It("encrypts values written with SetMap", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Encryption: &cache.StaticKeys{
+				Keys:      map[byte][]byte{1: key1},
+				CurrentID: 1,
+			},
+			ContentHash: true,
+		})
+		defer rc.Close()
+
+		err := rc.SetMap(ctx, "m:", map[string]interface{}{"x": "secret-map"}, time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+
+		b, err := rc.Miniredis.Get("m:x")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b[:3]).To(Equal("\xc1\xce\x01"))
+		Expect(b).NotTo(ContainSubstring("secret-map"))
+
+		var s string
+		Expect(rc.GetSkippingLocalCache(ctx, "m:x", &s)).To(Succeed())
+		Expect(s).To(Equal("secret-map"))
+	})
+
+	It("reads values written without encryption", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Encryption: &cache.StaticKeys{
+				Keys:      map[byte][]byte{1: key1},
+				CurrentID: 1,
+			},
+		})
+		defer rc.Close()
+
+		plain := cache.New(&cache.Options{Redis: rc.Client})
+		defer plain.Close()
+		Expect(plain.Set(&cache.Item{Key: "mykey", Value: "value"})).To(Succeed())
+
+		var s string
+		Expect(rc.Get(ctx, "mykey", &s)).To(Succeed())
+		Expect(s).To(Equal("value"))
+	})
+})
+
+var _ = Describe("ReplayQueueSize", func() {
+	ctx := context.TODO()
+
+	It("replays writes that failed during an outage", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			ReplayQueueSize: 10,
+			ReplayInterval:  10 * time.Millisecond,
+			StatsEnabled:    true,
+		})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Key: "deleted", Value: "old"})).To(Succeed())
+
+		rc.Miniredis.Close()
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: "first"})).NotTo(Succeed())
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: "second"})).NotTo(Succeed())
+		Expect(rc.Delete(ctx, "deleted")).NotTo(Succeed())
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: "once", IfNotExists: true})).NotTo(Succeed())
+		Expect(rc.Stats().ReplayQueued).To(Equal(3))
+
+		Expect(rc.Miniredis.Restart()).To(Succeed())
+		Eventually(func() int {
+			return rc.Stats().ReplayQueued
+		}).Should(Equal(0))
+
+		var s string
+		Expect(rc.Get(ctx, "mykey", &s)).To(Succeed())
+		Expect(s).To(Equal("second"))
+		Expect(rc.Miniredis.Exists("deleted")).To(BeFalse())
+		Expect(rc.Stats().Replayed).To(Equal(uint64(3)))
+	})
+
+	It("drops queued writes superseded by a successful write", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			ReplayQueueSize: 10,
+			ReplayInterval:  time.Hour,
+			StatsEnabled:    true,
+		})
+		defer rc.Close()
+
+		rc.Miniredis.Close()
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: "stale"})).NotTo(Succeed())
+		Expect(rc.Set(&cache.Item{Key: "other", Value: "value"})).NotTo(Succeed())
+		Expect(rc.Stats().ReplayQueued).To(Equal(2))
+
+		Expect(rc.Miniredis.Restart()).To(Succeed())
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: "fresh"})).To(Succeed())
+		Expect(rc.Stats().ReplayQueued).To(Equal(1))
+	})
+
+	It("drops the newest writes when the queue is full", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			ReplayQueueSize: 2,
+			ReplayOverflow:  cache.ReplayDropNewest,
+			ReplayInterval:  time.Hour,
+			StatsEnabled:    true,
+		})
+		defer rc.Close()
+
+		rc.Miniredis.Close()
+		for i := 0; i < 5; i++ {
+			Expect(rc.Set(&cache.Item{Key: fmt.Sprint("key", i), Value: "value"})).NotTo(Succeed())
+		}
+		stats := rc.Stats()
+		Expect(stats.ReplayQueued).To(Equal(2))
+		Expect(stats.ReplayDropped).To(Equal(uint64(3)))
+	})
+})
+
+var _ = Describe("Checksum", func() {
+	ctx := context.TODO()
+
+	It("detects truncated values", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Checksum: true,
+		})
+		defer rc.Close()
+
+		obj := &Object{Str: strings.Repeat("my very large string", 10), Num: 42}
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: obj})).To(Succeed())
+
+		b, err := rc.Miniredis.Get("mykey")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b[:2]).To(Equal("\xc1\xcc"))
+
+		var got Object
+		Expect(rc.Get(ctx, "mykey", &got)).To(Succeed())
+		Expect(&got).To(Equal(obj))
+
+		Expect(rc.Miniredis.Set("mykey", b[:len(b)-3])).To(Succeed())
+		err = rc.Get(ctx, "mykey", &got)
+		Expect(err).To(BeAssignableToTypeOf(&cache.ChecksumError{}))
+	})
+
+	It("returns ChecksumError from Unmarshal", func() {
+		mycache := cache.New(&cache.Options{Checksum: true})
+
+		b, err := mycache.Marshal(&Object{Str: "mystring", Num: 42})
+		Expect(err).NotTo(HaveOccurred())
+
+		var got Object
+		err = mycache.Unmarshal(b[:len(b)-1], &got)
+		Expect(err).To(BeAssignableToTypeOf(&cache.ChecksumError{}))
+	})
+
+	It("stores raw values that look like a checksum", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Checksum: true,
+		})
+		defer rc.Close()
+
+		value := []byte("\xc1\xcc\x00\x00\x00\x00xy")
+		Expect(rc.Set(&cache.Item{Key: "mykey", Value: value})).To(Succeed())
+
+		var b []byte
+		Expect(rc.Get(ctx, "mykey", &b)).To(Succeed())
+		Expect(b).To(Equal(value))
+		Expect(rc.GetSkippingLocalCache(ctx, "mykey", &b)).To(Succeed())
+		Expect(b).To(Equal(value))
+	})
+
+	It("reads values written without checksum", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			Checksum: true,
+		})
+		defer rc.Close()
+
+		plain := cache.New(&cache.Options{Redis: rc.Client})
+		defer plain.Close()
+		Expect(plain.Set(&cache.Item{Key: "mykey", Value: "value"})).To(Succeed())
+
+		var s string
+		Expect(rc.Get(ctx, "mykey", &s)).To(Succeed())
+		Expect(s).To(Equal("value"))
+	})
+})
+
+var _ = Describe("ReadYourWritesWindow", func() {
+	ctx := context.TODO()
+
+	It("reads writes back from a local-only cache", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache:           fastcache.New(1 << 20),
+			ReadYourWritesWindow: time.Minute,
+		})
+
+		Expect(mycache.Set(&cache.Item{Key: "mykey", Value: "value"})).To(Succeed())
+
+		var s string
+		Expect(mycache.Get(ctx, "mykey", &s)).To(Succeed())
+		Expect(s).To(Equal("value"))
+
+		err := mycache.Once(&cache.Item{
+			Key:   "mykey",
+			Value: &s,
+			Do: func(*cache.Item) (interface{}, error) {
+				return "loaded", nil
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s).To(Equal("value"))
+	})
+})
+
+var _ = Describe("reserved keys", func() {
+	ctx := context.TODO()
+
+	It("rejects keys of sidecar keys", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			ContentHash: true,
+		})
+		defer rc.Close()
+
+		Expect(rc.Set(&cache.Item{Key: "a", Value: "value"})).To(Succeed())
+		hash, err := rc.Miniredis.Get("cache:hash:a")
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, kind := range []string{"hash", "token", "meta", "delta", "dependents", "tag"} {
+			key := "cache:" + kind + ":a"
+			Expect(rc.Set(&cache.Item{Key: key, Value: "value"})).To(Equal(cache.ErrReservedKey))
+			Expect(rc.Delete(ctx, key)).To(Equal(cache.ErrReservedKey))
+		}
+		err = rc.SetMap(ctx, "cache:hash:", map[string]interface{}{"a": "value"}, time.Minute)
+		Expect(err).To(Equal(cache.ErrReservedKey))
+		Expect(rc.MSet(ctx, []*cache.Item{{Key: "cache:hash:a", Value: "value"}})).To(HaveOccurred())
+
+		Expect(rc.Miniredis.Get("cache:hash:a")).To(Equal(hash))
+	})
+
+	It("accepts keys that end like sidecar keys", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			ContentHash:       true,
+			TrackMetadata:     true,
+			TrackDependencies: true,
+		})
+		defer rc.Close()
+
+		for _, key := range []string{"x:hash", "user:token", "config:meta", "a:delta", "a:dependents"} {
+			Expect(rc.Set(&cache.Item{Key: key, Value: "value"})).To(Succeed())
+			var got string
+			Expect(rc.Get(ctx, key, &got)).To(Succeed())
+			Expect(got).To(Equal("value"))
+			Expect(rc.Delete(ctx, key)).To(Succeed())
+		}
+	})
+})
+
+var _ = Describe("SetDelta", func() {
+	ctx := context.TODO()
+
+	value := func(i int) string {
+		return strings.Repeat("my very large string", 50) + fmt.Sprint(i)
+	}
+
+	It("writes deltas until the next snapshot", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			DeltaSnapshotEvery: 2,
+		})
+		defer rc.Close()
+
+		for i, hasDelta := range []bool{false, true, true, false, true} {
+			Expect(rc.SetDelta(&cache.Item{Key: "mykey", Value: value(i)})).To(Succeed())
+			Expect(rc.Miniredis.Exists("cache:delta:mykey")).To(Equal(hasDelta))
+			Expect(rc.Miniredis.Get("mykey")).To(Equal(value(i - i%3)))
+
+			var got string
+			Expect(rc.GetDelta(ctx, "mykey", &got)).To(Succeed())
+			Expect(got).To(Equal(value(i)))
+		}
+	})
+
+	It("ignores deltas of another snapshot", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		Expect(rc.SetDelta(&cache.Item{Key: "mykey", Value: value(0)})).To(Succeed())
+		Expect(rc.SetDelta(&cache.Item{Key: "mykey", Value: value(1)})).To(Succeed())
+		Expect(rc.Miniredis.Exists("cache:delta:mykey")).To(BeTrue())
+
+		// Another writer replaces the snapshot.
+		Expect(rc.Miniredis.Set("mykey", value(2))).To(Succeed())
+
+		var got string
+		Expect(rc.GetDelta(ctx, "mykey", &got)).To(Succeed())
+		Expect(got).To(Equal(value(2)))
+	})
+
+	It("rejects corrupt deltas", func() {
+		rc := cachetest.NewRedisCache(GinkgoT())
+		defer rc.Close()
+
+		Expect(rc.SetDelta(&cache.Item{Key: "mykey", Value: value(0)})).To(Succeed())
+
+		delta := make([]byte, 9)
+		binary.LittleEndian.PutUint64(delta, xxhash.Sum64String(value(0)))
+		// Copy 2^64-1 bytes from offset 1, which overflows the bounds.
+		delta = append(delta, 0x00, 0x01)
+		delta = append(delta, bytes.Repeat([]byte{0xff}, 9)...)
+		delta = append(delta, 0x01)
+		Expect(rc.Miniredis.Set("cache:delta:mykey", string(delta))).To(Succeed())
+
+		var got string
+		err := rc.GetDelta(ctx, "mykey", &got)
+		Expect(err).To(MatchError("cache: corrupt delta"))
+	})
+})
+
+var _ = Describe("gossip", func() {
+	var nodes []*gossip.Gossip
+	var history time.Duration
+
+	start := func(peers ...*gossip.Gossip) *gossip.Gossip {
+		opt := &gossip.Options{
+			Addr:                "127.0.0.1:0",
+			Fanout:              1,
+			AntiEntropyInterval: 10 * time.Millisecond,
+			History:             history,
+		}
+		for _, peer := range peers {
+			opt.Peers = append(opt.Peers, peer.Addr().String())
+		}
+
+		node, err := gossip.New(opt)
+		Expect(err).NotTo(HaveOccurred())
+		nodes = append(nodes, node)
+		return node
+	}
+
+	received := func(node *gossip.Gossip) func() []string {
+		var mu sync.Mutex
+		var msgs []string
+		err := node.Subscribe(func(msg []byte) {
+			mu.Lock()
+			msgs = append(msgs, string(msg))
+			mu.Unlock()
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		return func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), msgs...)
+		}
+	}
+
+	BeforeEach(func() {
+		nodes = nil
+		history = 0
+	})
+
+	AfterEach(func() {
+		for _, node := range nodes {
+			_ = node.Close()
+		}
+	})
+
+	It("forwards messages between peers", func() {
+		a := start()
+		b := start(a)
+		c := start(b)
+
+		gotA := received(a)
+		gotC := received(c)
+
+		// Let b and c introduce themselves with digests.
+		time.Sleep(50 * time.Millisecond)
+
+		err := c.Publish([]byte("hello"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(gotA).Should(Equal([]string{"hello"}))
+		Expect(gotC()).To(Equal([]string{"hello"}))
+	})
+
+	It("repairs late peers with digests", func() {
+		a := start()
+		received(a)
+
+		err := a.Publish([]byte("hello"))
+		Expect(err).NotTo(HaveOccurred())
+
+		b := start(a)
+		gotB := received(b)
+
+		Eventually(gotB).Should(Equal([]string{"hello"}))
+		Consistently(gotB, "50ms").Should(HaveLen(1))
+	})
+
+	It("doesn't deliver messages again after History", func() {
+		history = 50 * time.Millisecond
+		a := start()
+		gotA := received(a)
+
+		err := a.Publish([]byte("hello"))
+		Expect(err).NotTo(HaveOccurred())
+
+		// b receives the message later, but forgets it when a does.
+		time.Sleep(20 * time.Millisecond)
+		b := start(a)
+		gotB := received(b)
+		Eventually(gotB).Should(Equal([]string{"hello"}))
+
+		Consistently(gotA, "300ms").Should(HaveLen(1))
+		Expect(gotB()).To(HaveLen(1))
+
+		c := start(a, b)
+		gotC := received(c)
+		Consistently(gotC, "100ms").Should(BeEmpty())
+	})
+})
+
+var _ = Describe("PlainFormats", func() {
+	ctx := context.TODO()
+
+	It("stores gzipped JSON under the prefix", func() {
+		mycache := cache.New(&cache.Options{
+			LocalCache: fastcache.New(1 << 20),
+			PlainFormats: map[string]cache.PlainFormat{
+				"ext:": {Codec: cache.PlainJSON, Gzip: true},
+			},
+		})
+
+		obj := &Object{Str: "mystring", Num: 42}
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "ext:1", Value: obj})
+		Expect(err).NotTo(HaveOccurred())
+
+		m, err := mycache.GetMap(ctx, "ext:", []string{"1"})
+		Expect(err).NotTo(HaveOccurred())
+
+		zr, err := gzip.NewReader(bytes.NewReader(m["1"]))
+		Expect(err).NotTo(HaveOccurred())
+		b, err := ioutil.ReadAll(zr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(b)).To(Equal(`{"Str":"mystring","Num":42}`))
+
+		got := new(Object)
+		err = mycache.Get(ctx, "ext:1", got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal(obj))
+	})
+
+	It("stores every other key in DefaultFormat", func() {
+		rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &cache.Options{
+			PlainFormats: map[string]cache.PlainFormat{
+				"ext:": {Codec: cache.PlainMsgpack},
+			},
+			DefaultFormat: &cache.PlainFormat{Codec: cache.PlainJSON},
+		})
+		defer rc.Close()
+
+		obj := &Object{Str: "mystring", Num: 42}
+		Expect(rc.Set(&cache.Item{Key: "key", Value: obj})).To(Succeed())
+		Expect(rc.Miniredis.Get("key")).To(Equal(`{"Str":"mystring","Num":42}`))
+
+		Expect(rc.Set(&cache.Item{Key: "ext:1", Value: obj})).To(Succeed())
+		b, err := rc.Miniredis.Get("ext:1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b).NotTo(HavePrefix("{"))
+
+		got := new(Object)
+		Expect(rc.Get(ctx, "key", got)).To(Succeed())
+		Expect(got).To(Equal(obj))
+	})
 })
 
 func newRing() *redis.Client {
@@ -0,0 +1,105 @@
+// Package cachetest provides helpers for testing code that uses the cache
+// against an in-memory Redis server.
+package cachetest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+
+	"github.com/star001007/cache"
+)
+
+// RedisCache is a cache backed by miniredis. Its clock only moves with
+// FastForward, which advances miniredis and the cache in lockstep so TTL
+// and staleness behaviors can be tested without sleeping.
+type RedisCache struct {
+	*cache.Cache
+
+	Miniredis *miniredis.Miniredis
+	Client    *redis.Client
+
+	mu  sync.Mutex
+	now time.Time
+}
+
+// TB is the part of testing.TB used by this package.
+// It is also implemented by GinkgoT().
+type TB interface {
+	Fatalf(format string, args ...interface{})
+}
+
+var _ TB = (testing.TB)(nil)
+
+// NewRedisCache starts miniredis and returns a cache using it.
+// Call Close when done.
+func NewRedisCache(t TB) *RedisCache {
+	helper(t)
+	return NewRedisCacheWithOptions(t, nil)
+}
+
+// NewRedisCacheWithOptions is like NewRedisCache, but creates the cache
+// with a copy of opt, replacing opt.Redis and opt.Now.
+func NewRedisCacheWithOptions(t TB, opt *cache.Options) *RedisCache {
+	helper(t)
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("cachetest: can't start miniredis: %s", err)
+	}
+
+	c := &RedisCache{
+		Miniredis: mr,
+		Client: redis.NewClient(&redis.Options{
+			Addr: mr.Addr(),
+		}),
+		now: time.Now(),
+	}
+	mr.SetTime(c.now)
+
+	var o cache.Options
+	if opt != nil {
+		o = *opt
+	}
+	o.Redis = c.Client
+	o.Now = c.Now
+	c.Cache = cache.New(&o)
+
+	return c
+}
+
+// Now returns the current time of the cache clock.
+func (c *RedisCache) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// FastForward moves the cache clock forward and expires Redis keys
+// whose TTL has passed.
+func (c *RedisCache) FastForward(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	c.mu.Unlock()
+
+	c.Miniredis.SetTime(now)
+	c.Miniredis.FastForward(d)
+}
+
+func helper(t TB) {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+}
+
+// Close closes the cache, the Redis client, and miniredis.
+func (c *RedisCache) Close() error {
+	_ = c.Cache.Close()
+	err := c.Client.Close()
+	c.Miniredis.Close()
+	return err
+}
@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+var errCanonicalTruncated = errors.New("cache: truncated msgpack value")
+
+// canonicalMsgpack re-encodes a msgpack value so equal values have
+// identical bytes: map entries are sorted by their encoded keys, negative
+// zero floats become zero, and NaNs use a single bit pattern. Everything
+// else is copied as is, which is canonical already with compact encoding.
+func canonicalMsgpack(b []byte) ([]byte, error) {
+	dst := make([]byte, 0, len(b))
+	var err error
+	for len(b) > 0 {
+		dst, b, err = appendCanonical(dst, b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// appendCanonical appends the canonical encoding of the first value of src
+// to dst and returns the rest of src.
+func appendCanonical(dst, src []byte) ([]byte, []byte, error) {
+	if len(src) == 0 {
+		return nil, nil, errCanonicalTruncated
+	}
+
+	c := src[0]
+	switch {
+	case c <= 0x7f || c >= 0xe0: // fixint
+		return append(dst, c), src[1:], nil
+	case c <= 0x8f: // fixmap
+		return appendCanonicalMap(dst, src[:1], src[1:], int(c&0x0f))
+	case c <= 0x9f: // fixarray
+		return appendCanonicalArray(dst, src[:1], src[1:], int(c&0x0f))
+	case c <= 0xbf: // fixstr
+		return appendRaw(dst, src, 1+int(c&0x1f))
+	}
+
+	switch c {
+	case 0xc0, 0xc2, 0xc3: // nil, false, true
+		return append(dst, c), src[1:], nil
+	case 0xc4, 0xd9: // bin8, str8
+		n, err := readLen(src, 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		return appendRaw(dst, src, 2+n)
+	case 0xc5, 0xda: // bin16, str16
+		n, err := readLen(src, 2)
+		if err != nil {
+			return nil, nil, err
+		}
+		return appendRaw(dst, src, 3+n)
+	case 0xc6, 0xdb: // bin32, str32
+		n, err := readLen(src, 4)
+		if err != nil {
+			return nil, nil, err
+		}
+		return appendRaw(dst, src, 5+n)
+	case 0xc7: // ext8
+		n, err := readLen(src, 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		return appendRaw(dst, src, 3+n)
+	case 0xc8: // ext16
+		n, err := readLen(src, 2)
+		if err != nil {
+			return nil, nil, err
+		}
+		return appendRaw(dst, src, 4+n)
+	case 0xc9: // ext32
+		n, err := readLen(src, 4)
+		if err != nil {
+			return nil, nil, err
+		}
+		return appendRaw(dst, src, 6+n)
+	case 0xca: // float32
+		if len(src) < 5 {
+			return nil, nil, errCanonicalTruncated
+		}
+		f := math.Float32frombits(binary.BigEndian.Uint32(src[1:]))
+		switch {
+		case f != f:
+			f = float32(math.NaN())
+		case f == 0:
+			f = 0
+		}
+		dst = append(dst, c)
+		dst = appendUint32(dst, math.Float32bits(f))
+		return dst, src[5:], nil
+	case 0xcb: // float64
+		if len(src) < 9 {
+			return nil, nil, errCanonicalTruncated
+		}
+		f := math.Float64frombits(binary.BigEndian.Uint64(src[1:]))
+		switch {
+		case math.IsNaN(f):
+			f = math.NaN()
+		case f == 0:
+			f = 0
+		}
+		dst = append(dst, c)
+		dst = appendUint64(dst, math.Float64bits(f))
+		return dst, src[9:], nil
+	case 0xcc, 0xd0: // uint8, int8
+		return appendRaw(dst, src, 2)
+	case 0xcd, 0xd1: // uint16, int16
+		return appendRaw(dst, src, 3)
+	case 0xce, 0xd2: // uint32, int32
+		return appendRaw(dst, src, 5)
+	case 0xcf, 0xd3: // uint64, int64
+		return appendRaw(dst, src, 9)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext1-16
+		return appendRaw(dst, src, 2+1<<(c-0xd4))
+	case 0xdc, 0xde: // array16, map16
+		n, err := readLen(src, 2)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c == 0xdc {
+			return appendCanonicalArray(dst, src[:3], src[3:], n)
+		}
+		return appendCanonicalMap(dst, src[:3], src[3:], n)
+	case 0xdd, 0xdf: // array32, map32
+		n, err := readLen(src, 4)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c == 0xdd {
+			return appendCanonicalArray(dst, src[:5], src[5:], n)
+		}
+		return appendCanonicalMap(dst, src[:5], src[5:], n)
+	}
+	return nil, nil, errors.New("cache: unknown msgpack code")
+}
+
+func appendCanonicalArray(dst, header, src []byte, n int) ([]byte, []byte, error) {
+	dst = append(dst, header...)
+	var err error
+	for i := 0; i < n; i++ {
+		dst, src, err = appendCanonical(dst, src)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return dst, src, nil
+}
+
+func appendCanonicalMap(dst, header, src []byte, n int) ([]byte, []byte, error) {
+	type entry struct {
+		key, value []byte
+	}
+
+	entries := make([]entry, n)
+	var err error
+	for i := range entries {
+		var key, value []byte
+		key, src, err = appendCanonical(nil, src)
+		if err != nil {
+			return nil, nil, err
+		}
+		value, src, err = appendCanonical(nil, src)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries[i] = entry{key: key, value: value}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+
+	dst = append(dst, header...)
+	for _, e := range entries {
+		dst = append(dst, e.key...)
+		dst = append(dst, e.value...)
+	}
+	return dst, src, nil
+}
+
+// readLen reads the big endian length of size bytes following the code.
+func readLen(src []byte, size int) (int, error) {
+	if len(src) < 1+size {
+		return 0, errCanonicalTruncated
+	}
+	switch size {
+	case 1:
+		return int(src[1]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(src[1:])), nil
+	default:
+		return int(binary.BigEndian.Uint32(src[1:])), nil
+	}
+}
+
+func appendRaw(dst, src []byte, n int) ([]byte, []byte, error) {
+	if n < 0 || len(src) < n {
+		return nil, nil, errCanonicalTruncated
+	}
+	return append(dst, src[:n]...), src[n:], nil
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(dst, buf[:]...)
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(dst, buf[:]...)
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// Capabilities are the optional Redis server features detected by the
+// cache. Features that depend on them are enabled automatically.
+type Capabilities struct {
+	// Version is the server version reported by INFO,
+	// empty when the server doesn't support INFO.
+	Version string
+
+	// GetEx is set when the server supports GETEX (Redis 6.2).
+	// Otherwise GetEx uses GET and PEXPIRE.
+	GetEx bool
+	// KeepTTL is set when SET supports KEEPTTL (Redis 6.0).
+	KeepTTL bool
+	// JSON is set when the RedisJSON module is loaded.
+	JSON bool
+	// KeyspaceEvents is the notify-keyspace-events setting,
+	// empty when keyspace notifications are disabled or CONFIG is not
+	// available.
+	KeyspaceEvents string
+}
+
+var errProbeUnsupported = errors.New("cache: Redis client does not support arbitrary commands")
+
+// probeKey is a key that is only read or conditionally written
+// while probing commands.
+const probeKey = "cache:capabilities:probe"
+
+type capabilities struct {
+	mu   sync.Mutex
+	caps atomic.Value // *Capabilities
+}
+
+// Capabilities probes the Redis server on first use and returns the
+// detected capabilities. Failed probes are retried on the next call.
+// Options.Compat can provide the capabilities instead.
+func (cd *Cache) Capabilities() (*Capabilities, error) {
+	if cd.opt.Compat != nil && cd.opt.Compat.Capabilities != nil {
+		c := *cd.opt.Compat.Capabilities
+		return &c, nil
+	}
+	if caps, ok := cd.caps.caps.Load().(*Capabilities); ok {
+		c := *caps
+		return &c, nil
+	}
+
+	cd.caps.mu.Lock()
+	defer cd.caps.mu.Unlock()
+
+	if caps, ok := cd.caps.caps.Load().(*Capabilities); ok {
+		c := *caps
+		return &c, nil
+	}
+
+	caps, err := cd.probeCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	cd.caps.caps.Store(caps)
+
+	c := *caps
+	return &c, nil
+}
+
+func (cd *Cache) probeCapabilities() (*Capabilities, error) {
+	if cd.opt.Redis == nil {
+		return nil, errRedisLocalCacheNil
+	}
+	d, ok := cd.opt.Redis.(doer)
+	if !ok {
+		return nil, errProbeUnsupported
+	}
+
+	caps := new(Capabilities)
+
+	info, err := d.Do("info", "server").Text()
+	if err != nil && !isServerError(err) {
+		return nil, err
+	}
+	caps.Version = infoField(info, "redis_version")
+
+	if caps.GetEx, err = probeCommand(d, "getex", probeKey); err != nil {
+		return nil, err
+	}
+	if caps.KeepTTL, err = probeCommand(d, "set", probeKey, "", "xx", "keepttl"); err != nil {
+		return nil, err
+	}
+	if caps.JSON, err = probeCommand(d, "json.get", probeKey); err != nil {
+		return nil, err
+	}
+
+	cfg, err := d.Do("config", "get", "notify-keyspace-events").Result()
+	if err != nil && !isServerError(err) {
+		return nil, err
+	}
+	if vals, ok := cfg.([]interface{}); ok && len(vals) == 2 {
+		caps.KeyspaceEvents, _ = vals[1].(string)
+	}
+
+	return caps, nil
+}
+
+// probeCommand reports whether the server accepts the command.
+func probeCommand(d doer, args ...interface{}) (bool, error) {
+	err := d.Do(args...).Err()
+	switch {
+	case err == nil || err == redis.Nil:
+		return true, nil
+	case isServerError(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// isServerError reports whether err is an error reply rather than
+// a network failure.
+func isServerError(err error) bool {
+	if err == nil {
+		return false
+	}
+	s := err.Error()
+	return strings.HasPrefix(s, "ERR ") || strings.HasPrefix(s, "NOPERM ")
+}
+
+func infoField(info, name string) string {
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, name+":") {
+			return strings.TrimSpace(line[len(name)+1:])
+		}
+	}
+	return ""
+}
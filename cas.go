@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+const casPrefix = "cas:"
+
+// SetCAS caches the value under a key derived from the SHA-256 of its
+// marshaled bytes, "cas:<hex hash>", and returns the key. Identical values
+// cached under many logical keys are stored once: save the returned key
+// and read the value with GetCAS. Setting an existing value refreshes
+// its TTL, which is the default Item TTL.
+func (cd *Cache) SetCAS(ctx context.Context, value interface{}) (string, error) {
+	var buf bytes.Buffer
+	b, err := cd.marshal(&buf, value, true)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+	key := casPrefix + hash
+
+	item := &Item{
+		Ctx:       ctx,
+		Key:       key,
+		Value:     rawPayload(b),
+		Immutable: true,
+	}
+	err = cd.Set(item)
+	if err == ErrImmutable {
+		err = cd.refreshTTL(key, item.ttl())
+	}
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// GetCAS gets the value stored by SetCAS for the given hash, the key
+// returned by SetCAS without the "cas:" prefix.
+func (cd *Cache) GetCAS(ctx context.Context, hash string, value interface{}) error {
+	b, err := cd.getBytes(ctx, casPrefix+hash, false)
+	if err != nil {
+		return err
+	}
+	return cd.unmarshal(b, value)
+}
+
+func (cd *Cache) refreshTTL(key string, ttl time.Duration) error {
+	e, ok := cd.opt.Redis.(expirer)
+	if !ok {
+		return errExpireUnsupported
+	}
+	return e.PExpire(key, ttl).Err()
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// checksumMagic starts values written with Options.Checksum. Like the
+// payload header, it starts with 0xc1, which never starts msgpack or
+// UTF-8 values.
+var checksumMagic = []byte{0xc1, 0xcc}
+
+// checksumHeaderLen is the size of the magic and the CRC-32C in front of
+// the payload.
+const checksumHeaderLen = 2 + 4
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumError is returned when the checksum of a value doesn't match
+// its payload, e.g. because the value was truncated.
+type ChecksumError struct {
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("cache: payload checksum mismatch: expected %08x, got %08x",
+		e.Expected, e.Actual)
+}
+
+// withChecksum prefixes the payload with its CRC-32C when
+// Options.Checksum is set. Tombstones stay as they are, so scripts can
+// recognize them.
+func (cd *Cache) withChecksum(b []byte) []byte {
+	if !cd.opt.Checksum || b == nil || isTombstone(b) {
+		return b
+	}
+
+	out := make([]byte, checksumHeaderLen, checksumHeaderLen+len(b))
+	copy(out, checksumMagic)
+	binary.BigEndian.PutUint32(out[2:], crc32.Checksum(b, crc32c))
+	return append(out, b...)
+}
+
+// checkChecksum verifies the checksum of the payload and returns the
+// payload without it. Values written before Options.Checksum was set are
+// returned as they are.
+func (cd *Cache) checkChecksum(b []byte) ([]byte, error) {
+	if !cd.opt.Checksum || !bytes.HasPrefix(b, checksumMagic) {
+		return b, nil
+	}
+	if len(b) < checksumHeaderLen {
+		return nil, &ChecksumError{}
+	}
+
+	payload := b[checksumHeaderLen:]
+	expected := binary.BigEndian.Uint32(b[2:checksumHeaderLen])
+	if actual := crc32.Checksum(payload, crc32c); actual != expected {
+		return nil, &ChecksumError{Expected: expected, Actual: actual}
+	}
+	return payload, nil
+}
@@ -0,0 +1,74 @@
+package cache
+
+import "reflect"
+
+// setDecoded stores a value decoded by the decoded cache or ShareDecoded
+// in dst, a copy of it with Options.CopyOnRead.
+func (cd *Cache) setDecoded(dst, src reflect.Value) {
+	if cd.opt.CopyOnRead {
+		deepCopy(dst, src)
+		return
+	}
+	dst.Set(src)
+}
+
+// deepCopy sets dst to a copy of src that shares no pointers, slices, or
+// maps with it. Unexported struct fields are copied shallowly. Decoded
+// values have no cycles, so none are detected.
+func deepCopy(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		v := reflect.New(src.Type().Elem())
+		deepCopy(v.Elem(), src.Elem())
+		dst.Set(v)
+	case reflect.Interface:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		elem := src.Elem()
+		v := reflect.New(elem.Type()).Elem()
+		deepCopy(v, elem)
+		dst.Set(v)
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		v := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			deepCopy(v.Index(i), src.Index(i))
+		}
+		dst.Set(v)
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			deepCopy(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			dst.Set(src)
+			return
+		}
+		v := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			elem := reflect.New(src.Type().Elem()).Elem()
+			deepCopy(elem, iter.Value())
+			v.SetMapIndex(iter.Key(), elem)
+		}
+		dst.Set(v)
+	case reflect.Struct:
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if f := dst.Field(i); f.CanSet() {
+				deepCopy(f, src.Field(i))
+			}
+		}
+	default:
+		dst.Set(src)
+	}
+}
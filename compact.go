@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const defaultCompactSample = 100
+
+// localKeySet tracks keys stored in the local cache, which fastcache
+// can't enumerate.
+type localKeySet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func (s *localKeySet) add(key string) {
+	s.mu.Lock()
+	s.keys[key] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *localKeySet) remove(key string) {
+	s.mu.Lock()
+	delete(s.keys, key)
+	s.mu.Unlock()
+}
+
+// sample returns up to n keys in random order.
+func (s *localKeySet) sample(n int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, n)
+	for key := range s.keys {
+		if len(keys) == n {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (cd *Cache) startCompactor() {
+	cd.localKeys = &localKeySet{
+		keys: make(map[string]struct{}),
+	}
+	cd.stopCompactor = make(chan struct{})
+	cd.compactorDone = make(chan struct{})
+
+	go func() {
+		defer close(cd.compactorDone)
+
+		ticker := time.NewTicker(cd.opt.LocalCompactInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-cd.stopCompactor:
+				return
+			case <-ticker.C:
+				cd.compactLocal()
+			}
+		}
+	}()
+}
+
+// compactLocal checks a sample of local cache keys with a pipeline of
+// PTTL commands and deletes the local copies of keys that have expired
+// in Redis, so fastcache can reuse their space.
+func (cd *Cache) compactLocal() {
+	if !cd.useRedis() {
+		return
+	}
+
+	n := cd.opt.LocalCompactSample
+	if n <= 0 {
+		n = defaultCompactSample
+	}
+
+	var keys []string
+	for _, key := range cd.localKeys.sample(n) {
+		if _, ok := cd.local.HasGet(nil, []byte(key)); !ok {
+			cd.localKeys.remove(key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	cmds := make([]*redis.DurationCmd, len(keys))
+	var unsupported bool
+	cd.pipelined(func(pipe RemoteStore) {
+		p, ok := pipe.(pttler)
+		if !ok {
+			unsupported = true
+			return
+		}
+		for i, key := range keys {
+			redisKey := key
+			if prefix, ok := cd.bundlePrefix(key); ok {
+				redisKey = prefix
+			}
+			cmds[i] = p.PTTL(redisKey)
+		}
+	})
+	if unsupported {
+		atomic.AddUint64(&cd.errs, 1)
+		return
+	}
+
+	for i, cmd := range cmds {
+		ttl, err := cmd.Result()
+		if err != nil {
+			atomic.AddUint64(&cd.errs, 1)
+			continue
+		}
+		// PTTL replies -2 for missing keys.
+		if ttl != -2 {
+			continue
+		}
+		cd.local.Del([]byte(keys[i]))
+		cd.localKeys.remove(keys[i])
+		atomic.AddUint64(&cd.compacted, 1)
+	}
+}
@@ -0,0 +1,50 @@
+package cache
+
+import "errors"
+
+var errScriptsDisabled = errors.New("cache: Lua scripts are disabled by Options.Compat")
+
+// Compat adjusts the cache to a Redis compatible server.
+type Compat struct {
+	// Name of the server, used in errors.
+	Name string
+
+	// NoTransactions sends batches that are wrapped in MULTI/EXEC on
+	// Redis, like SetMap, as plain pipelines.
+	NoTransactions bool
+
+	// NoScripts disables features implemented with Lua scripts, which then
+	// return an error. FencingToken depends on scripts.
+	NoScripts bool
+
+	// Capabilities are used instead of probing the server.
+	Capabilities *Capabilities
+}
+
+// CompatKeyDB is the profile for KeyDB. KeyDB with active replication
+// accepts writes on every replica, so a compare-and-set script can't order
+// writes and fencing tokens are disabled.
+var CompatKeyDB = &Compat{
+	Name:      "KeyDB",
+	NoScripts: true,
+}
+
+// CompatDragonfly is the profile for Dragonfly. Dragonfly supports GETEX,
+// KEEPTTL and JSON commands natively but doesn't implement INFO and CONFIG
+// the way Redis does, so the capabilities are not probed.
+var CompatDragonfly = &Compat{
+	Name: "Dragonfly",
+	Capabilities: &Capabilities{
+		GetEx:   true,
+		KeepTTL: true,
+		JSON:    true,
+	},
+}
+
+func (cd *Cache) useTransactions() bool {
+	return cd.opt.Compat == nil || !cd.opt.Compat.NoTransactions
+}
+
+func (cd *Cache) useScripts() bool {
+	return cd.opt.Compat == nil || !cd.opt.Compat.NoScripts
+}
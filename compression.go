@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultCompressionQuantile = 0.5
+	defaultMinCompression      = 16
+	defaultMaxCompression      = 64 << 10
+
+	// sizeSketchDecayAt is the number of samples after which the counts
+	// are halved, so the sketch follows recent values.
+	sizeSketchDecayAt = 10000
+	// sizeSketchRecalcEvery is how often the threshold is recomputed.
+	sizeSketchRecalcEvery = 256
+)
+
+// sizeSketch is a quantile sketch of marshaled value sizes. Sizes are
+// counted in log-scale buckets, four per power of two, so quantiles are
+// accurate to within 25%.
+type sizeSketch struct {
+	quantile float64
+	min, max int
+
+	mu     sync.Mutex
+	counts [64 * 4]uint64
+	total  uint64
+	added  uint64
+
+	threshold int64 // atomic
+}
+
+func newSizeSketch(opt *Options) *sizeSketch {
+	quantile := opt.CompressionQuantile
+	if quantile <= 0 || quantile > 1 {
+		quantile = defaultCompressionQuantile
+	}
+	min := opt.MinCompressionThreshold
+	if min <= 0 {
+		min = defaultMinCompression
+	}
+	max := opt.MaxCompressionThreshold
+	if max <= 0 {
+		max = defaultMaxCompression
+	}
+	return newQuantileSketch(quantile, min, max, opt.CompressionThreshold)
+}
+
+// newQuantileSketch returns a sketch whose threshold follows the quantile
+// of the added values, clamped to [min, max] and starting at initial.
+func newQuantileSketch(quantile float64, min, max, initial int) *sizeSketch {
+	s := &sizeSketch{
+		quantile: quantile,
+		min:      min,
+		max:      max,
+	}
+	s.threshold = int64(s.clamp(initial))
+	return s
+}
+
+func sizeBucket(n int) int {
+	if n < 4 {
+		return n
+	}
+	exp := bits.Len(uint(n)) - 1
+	sub := (n >> uint(exp-2)) & 3
+	return exp*4 + sub
+}
+
+// bucketSize returns the smallest size counted in the bucket.
+func bucketSize(i int) int {
+	if i < 4 {
+		return i
+	}
+	exp, sub := i/4, i%4
+	return (4 + sub) << uint(exp-2)
+}
+
+func (s *sizeSketch) add(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts[sizeBucket(n)]++
+	s.total++
+	s.added++
+
+	if s.total >= sizeSketchDecayAt {
+		s.total = 0
+		for i := range s.counts {
+			s.counts[i] /= 2
+			s.total += s.counts[i]
+		}
+	}
+
+	if s.added%sizeSketchRecalcEvery == 0 {
+		atomic.StoreInt64(&s.threshold, int64(s.clamp(s.quantileSize())))
+	}
+}
+
+func (s *sizeSketch) quantileSize() int {
+	rank := uint64(float64(s.total) * s.quantile)
+	var seen uint64
+	for i, count := range s.counts {
+		seen += count
+		if seen > rank {
+			return bucketSize(i)
+		}
+	}
+	return s.max
+}
+
+func (s *sizeSketch) clamp(n int) int {
+	if n < s.min {
+		return s.min
+	}
+	if n > s.max {
+		return s.max
+	}
+	return n
+}
+
+// compressionThreshold returns the size from which marshaled values
+// are compressed.
+func (cd *Cache) compressionThreshold() int {
+	if cd.sizes == nil {
+		return cd.opt.CompressionThreshold
+	}
+	return int(atomic.LoadInt64(&cd.sizes.threshold))
+}
+
+// CompressionThreshold returns the size from which marshaled values are
+// compressed, which changes over time with Options.AdaptiveCompression.
+func (cd *Cache) CompressionThreshold() int {
+	return cd.compressionThreshold()
+}
@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// compressionMethodMask selects the compression method in the payload
+// trailer byte.
+const compressionMethodMask = 0x1f
+
+// Compressor compresses values larger than the compression threshold, see
+// Options.Compressor.
+type Compressor interface {
+	// ID is stored in the payload trailer so readers can pick the
+	// decompressor. IDs 1 to 3 are used by the built-in compressors;
+	// others must use 4 to 31.
+	ID() byte
+	// Compress returns the compressed src or nil when src doesn't
+	// compress, in which case the value is stored uncompressed.
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// CompressionLevel selects how hard the built-in compressors try, see
+// Options.CompressionLevel.
+type CompressionLevel int
+
+const (
+	DefaultCompression CompressionLevel = iota
+	// FastestCompression uses the fastest zstd level.
+	FastestCompression
+	// BetterCompression uses the better s2 and LZ4 HC encoders.
+	BetterCompression
+)
+
+var (
+	// S2Compressor is the default compressor.
+	S2Compressor Compressor = s2Compressor{}
+	// ZstdCompressor is slower than S2Compressor, but compresses better.
+	ZstdCompressor Compressor = &zstdCompressor{}
+	// LZ4Compressor stores LZ4 blocks.
+	LZ4Compressor Compressor = lz4Compressor{}
+)
+
+var builtinCompressors = map[byte]Compressor{
+	s2Compression:   S2Compressor,
+	zstdCompression: ZstdCompressor,
+	lz4Compression:  LZ4Compressor,
+}
+
+// compressor returns the compressor of the method or nil when it is
+// unknown.
+func (cd *Cache) compressor(method byte) Compressor {
+	if c := cd.opt.Compressor; c != nil && c.ID() == method {
+		return c
+	}
+	return builtinCompressors[method]
+}
+
+// configureCompressor returns the built-in compressor c with the level and
+// concurrency applied, or c itself when it is a custom compressor.
+func configureCompressor(c Compressor, level CompressionLevel, concurrency int) Compressor {
+	switch c.(type) {
+	case s2Compressor:
+		return s2Compressor{level: level}
+	case *zstdCompressor:
+		return &zstdCompressor{level: level, concurrency: concurrency}
+	case lz4Compressor:
+		return lz4Compressor{level: level}
+	}
+	return c
+}
+
+type s2Compressor struct {
+	level CompressionLevel
+}
+
+func (s2Compressor) ID() byte { return s2Compression }
+
+func (c s2Compressor) Compress(src []byte) ([]byte, error) {
+	if c.level == BetterCompression {
+		return s2.EncodeBetter(nil, src), nil
+	}
+	return s2.Encode(nil, src), nil
+}
+
+func (s2Compressor) Decompress(src []byte) ([]byte, error) {
+	return s2.Decode(nil, src)
+}
+
+// zstdCompressor lazily creates one encoder and decoder shared by all
+// callers; both are safe for concurrent use.
+type zstdCompressor struct {
+	level       CompressionLevel
+	concurrency int
+
+	once sync.Once
+	enc  *zstd.Encoder
+	dec  *zstd.Decoder
+	err  error
+}
+
+func (c *zstdCompressor) init() error {
+	c.once.Do(func() {
+		opts := []zstd.EOption{zstd.WithEncoderLevel(zstd.SpeedDefault)}
+		if c.level == FastestCompression {
+			opts[0] = zstd.WithEncoderLevel(zstd.SpeedFastest)
+		}
+		if c.concurrency > 0 {
+			opts = append(opts, zstd.WithEncoderConcurrency(c.concurrency))
+		}
+		c.enc, c.err = zstd.NewWriter(nil, opts...)
+		if c.err == nil {
+			c.dec, c.err = zstd.NewReader(nil)
+		}
+	})
+	return c.err
+}
+
+func (c *zstdCompressor) ID() byte { return zstdCompression }
+
+func (c *zstdCompressor) Compress(src []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.enc.EncodeAll(src, nil), nil
+}
+
+func (c *zstdCompressor) Decompress(src []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.dec.DecodeAll(src, nil)
+}
+
+var errCorruptLZ4 = errors.New("cache: corrupt LZ4 block")
+
+// lz4Compressor stores the uvarint decompressed length followed by an
+// LZ4 block.
+type lz4Compressor struct {
+	level CompressionLevel
+}
+
+func (lz4Compressor) ID() byte { return lz4Compression }
+
+var lz4HashTables = sync.Pool{
+	New: func() interface{} {
+		return make([]int, 1<<16)
+	},
+}
+
+func (c lz4Compressor) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, binary.MaxVarintLen64+lz4.CompressBlockBound(len(src)))
+	n := binary.PutUvarint(dst, uint64(len(src)))
+
+	var m int
+	var err error
+	if c.level == BetterCompression {
+		m, err = lz4.CompressBlockHC(src, dst[n:], 0)
+	} else {
+		ht := lz4HashTables.Get().([]int)
+		for i := range ht {
+			ht[i] = 0
+		}
+		m, err = lz4.CompressBlock(src, dst[n:], ht)
+		lz4HashTables.Put(ht)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m == 0 {
+		return nil, nil
+	}
+	return dst[:n+m], nil
+}
+
+func (lz4Compressor) Decompress(src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 || size > uint64(len(src))*255 {
+		return nil, errCorruptLZ4
+	}
+	dst := make([]byte, size)
+	m, err := lz4.UncompressBlock(src[n:], dst)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(m) != size {
+		return nil, errCorruptLZ4
+	}
+	return dst, nil
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// writeTracker remembers keys recently written by this process so reads
+// can skip the local cache for them until the window passes.
+type writeTracker struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]time.Time
+	nextPrune time.Time
+}
+
+func newWriteTracker(window time.Duration, now func() time.Time) *writeTracker {
+	return &writeTracker{
+		window: window,
+		now:    now,
+		keys:   make(map[string]time.Time),
+	}
+}
+
+func (t *writeTracker) touch(key string) {
+	now := t.now()
+
+	t.mu.Lock()
+	t.keys[key] = now.Add(t.window)
+	if now.After(t.nextPrune) {
+		for k, deadline := range t.keys {
+			if now.After(deadline) {
+				delete(t.keys, k)
+			}
+		}
+		t.nextPrune = now.Add(t.window)
+	}
+	t.mu.Unlock()
+}
+
+func (t *writeTracker) recent(key string) bool {
+	t.mu.Lock()
+	deadline, ok := t.keys[key]
+	if ok && t.now().After(deadline) {
+		delete(t.keys, key)
+		ok = false
+	}
+	t.mu.Unlock()
+	return ok
+}
+
+// recentlyWritten reports whether the key was set or deleted by this
+// process within Options.ReadYourWritesWindow.
+func (cd *Cache) recentlyWritten(key string) bool {
+	// Without Redis the local cache is the only copy of the write.
+	if cd.writes == nil || !cd.useRedis() {
+		return false
+	}
+	return cd.writes.recent(key)
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// createdAtFlag is set in the payload trailer byte when the payload
+// carries the creation time right before the trailer.
+const createdAtFlag = 0x40
+
+const createdAtLen = 8
+
+var errCreatedAtTooShort = errors.New("cache: payload is too short for creation time")
+
+func appendCreatedAt(b []byte, tm time.Time) []byte {
+	var buf [createdAtLen]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(tm.UnixNano()/int64(time.Millisecond)))
+	return append(b, buf[:]...)
+}
+
+// stripCreatedAt removes the creation time from the payload body before
+// the trailer when the trailer flags it.
+func stripCreatedAt(body []byte, trailer byte) ([]byte, time.Time, error) {
+	if trailer&createdAtFlag == 0 {
+		return body, time.Time{}, nil
+	}
+	if len(body) < createdAtLen {
+		return nil, time.Time{}, errCreatedAtTooShort
+	}
+	pos := len(body) - createdAtLen
+	ms := int64(binary.LittleEndian.Uint64(body[pos:]))
+	return body[:pos], time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+// createdAt returns the creation time stored in the payload by
+// Options.StoreCreationTime. b must not be a string, []byte, or plain
+// format value, which have no trailer.
+func createdAt(b []byte) (time.Time, bool) {
+	if len(b) == 0 || isTombstone(b) {
+		return time.Time{}, false
+	}
+	b = bytes.TrimSuffix(b, []byte(criticalMarker))
+	if len(b) == 0 {
+		return time.Time{}, false
+	}
+	_, tm, err := stripCreatedAt(b[:len(b)-1], b[len(b)-1])
+	if err != nil || tm.IsZero() {
+		return time.Time{}, false
+	}
+	return tm, true
+}
+
+// GetFresh is like Get, but treats values created more than maxAge ago as
+// misses, even when their TTL has not expired yet. A stale local copy is
+// skipped in favor of the value in Redis. Values without a creation time,
+// e.g. written without Options.StoreCreationTime, string and []byte
+// values, PlainFormats, or values encoded by Options.Marshal, are never
+// fresh.
+func (cd *Cache) GetFresh(ctx context.Context, key string, value interface{}, maxAge time.Duration) error {
+	switch value.(type) {
+	case *string, *[]byte:
+		return ErrCacheMiss
+	}
+	if !cd.hasTrailer(key) {
+		return ErrCacheMiss
+	}
+
+	b, err := cd.getBytes(ctx, key, false)
+	if err != nil {
+		return err
+	}
+
+	if !cd.isFresh(key, b, maxAge) {
+		if !cd.useLocalCache() {
+			return ErrCacheMiss
+		}
+		b, err = cd.getBytes(ctx, key, true)
+		if err != nil {
+			return err
+		}
+		if !cd.isFresh(key, b, maxAge) {
+			return ErrCacheMiss
+		}
+	}
+	return cd.UnmarshalKey(key, b, value)
+}
+
+func (cd *Cache) isFresh(key string, b []byte, maxAge time.Duration) bool {
+	age, ok := cd.age(key, b)
+	return ok && age <= maxAge
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+)
+
+const defaultCriticalStaleness = time.Second
+
+// criticalMarker is appended to payloads of Item.Critical values, so
+// every process reading them bounds the age of its local copy.
+const criticalMarker = "\xc1critical"
+
+// ErrCriticalWriteLost is returned by Set when the value of an
+// Item.Critical item could not be read back from Redis after the write.
+var ErrCriticalWriteLost = errors.New("cache: critical write was not read back from Redis")
+
+var errCriticalUnsupported = errors.New("cache: Critical requires values encoded with a trailer, not string, []byte, or PlainFormats")
+
+func isCritical(b []byte) bool {
+	return len(b) > len(criticalMarker) && string(b[len(b)-len(criticalMarker):]) == criticalMarker
+}
+
+// markCritical appends the critical marker to the marshaled value.
+func (cd *Cache) markCritical(key string, value interface{}, b []byte) ([]byte, error) {
+	if _, ok := cd.plainFormat(key); ok || isRawValue(value) {
+		return nil, errCriticalUnsupported
+	}
+	marked := make([]byte, 0, len(b)+len(criticalMarker))
+	marked = append(marked, b...)
+	return append(marked, criticalMarker...), nil
+}
+
+// verifyCritical reads the value back from Redis and compares it with the
+// written one.
+func (cd *Cache) verifyCritical(key string, b []byte) error {
+	got, err := cd.opt.Redis.Get(key).Bytes()
+	if err != nil {
+		return err
+	}
+	got, err = cd.verify(key, got)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, b) {
+		return ErrCriticalWriteLost
+	}
+	return nil
+}
+
+// criticalClock remembers when the local copies of critical values were
+// stored. Entries are removed when the copy is found too old; keys that
+// are never read again stay, which is fine for the few critical keys.
+type criticalClock struct {
+	stored sync.Map // map[string]time.Time
+}
+
+func (c *criticalClock) store(key string, now time.Time) {
+	c.stored.Store(key, now)
+}
+
+// fresh reports whether the local copy of the key is younger than the
+// staleness bound.
+func (c *criticalClock) fresh(key string, now time.Time, staleness time.Duration) bool {
+	v, ok := c.stored.Load(key)
+	if ok && now.Sub(v.(time.Time)) < staleness {
+		return true
+	}
+	c.stored.Delete(key)
+	return false
+}
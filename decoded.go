@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+)
+
+// decodedCache is a bounded LRU of decoded values keyed by key and
+// destination type, see Options.DecodedCacheSize. Every entry remembers
+// the hash of the bytes it was decoded from, so it is only used while the
+// byte tiers hold the same bytes.
+type decodedCache struct {
+	mu    sync.Mutex
+	size  int
+	lru   *list.List
+	items map[string]map[reflect.Type]*list.Element
+}
+
+type decodedEntry struct {
+	key   string
+	typ   reflect.Type
+	hash  uint64
+	value reflect.Value
+}
+
+func newDecodedCache(size int) *decodedCache {
+	return &decodedCache{
+		size:  size,
+		lru:   list.New(),
+		items: make(map[string]map[reflect.Type]*list.Element),
+	}
+}
+
+func (c *decodedCache) get(key string, typ reflect.Type, hash uint64) (reflect.Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key][typ]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	e := el.Value.(*decodedEntry)
+	if e.hash != hash {
+		c.removeElement(el)
+		return reflect.Value{}, false
+	}
+	c.lru.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *decodedCache) add(key string, typ reflect.Type, hash uint64, value reflect.Value) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key][typ]; ok {
+		c.removeElement(el)
+	}
+
+	byType := c.items[key]
+	if byType == nil {
+		byType = make(map[reflect.Type]*list.Element)
+		c.items[key] = byType
+	}
+	byType[typ] = c.lru.PushFront(&decodedEntry{
+		key:   key,
+		typ:   typ,
+		hash:  hash,
+		value: value,
+	})
+
+	for c.lru.Len() > c.size {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// remove drops the values of the key for all types.
+func (c *decodedCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, el := range c.items[key] {
+		c.removeElement(el)
+	}
+}
+
+func (c *decodedCache) removeElement(el *list.Element) {
+	e := c.lru.Remove(el).(*decodedEntry)
+	byType := c.items[e.key]
+	delete(byType, e.typ)
+	if len(byType) == 0 {
+		delete(c.items, e.key)
+	}
+}
+
+// unmarshalDecoded is like UnmarshalKey, but serves and fills the decoded
+// cache.
+func (cd *Cache) unmarshalDecoded(key string, b []byte, value interface{}) error {
+	typ := reflect.TypeOf(value)
+	if cd.decoded == nil || typ == nil || typ.Kind() != reflect.Ptr ||
+		reflect.ValueOf(value).IsNil() {
+		return cd.UnmarshalKey(key, b, value)
+	}
+	switch value.(type) {
+	case *string, *[]byte:
+		return cd.UnmarshalKey(key, b, value)
+	}
+
+	hash := contentHash(b)
+	v, ok := cd.decoded.get(key, typ, hash)
+	if !ok {
+		v = reflect.New(typ.Elem())
+		if err := cd.UnmarshalKey(key, b, v.Interface()); err != nil {
+			return err
+		}
+		cd.decoded.add(key, typ, hash, v)
+	}
+	cd.setDecoded(reflect.ValueOf(value).Elem(), v.Elem())
+	return nil
+}
+
+func (cd *Cache) forgetDecoded(key string) {
+	if cd.decoded != nil {
+		cd.decoded.remove(key)
+	}
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDegradedWindow   = 10 * time.Second
+	defaultDegradedLocalTTL = 10 * time.Minute
+	// degradedMinReads is the number of Redis reads a window needs to
+	// change the state, so a few errors under low traffic don't flip it.
+	degradedMinReads = 10
+)
+
+// errorRateTracker counts Redis read errors in fixed windows, see
+// Options.DegradedErrorRate.
+type errorRateTracker struct {
+	mu     sync.Mutex
+	start  time.Time
+	reads  uint64
+	failed uint64
+}
+
+// add records a read and returns the error rate of the window that just
+// ended, or false while the window is still open or had too few reads.
+func (t *errorRateTracker) add(now time.Time, window time.Duration, failed bool) (float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var rate float64
+	var ended bool
+	if now.Sub(t.start) >= window {
+		if t.reads >= degradedMinReads {
+			rate = float64(t.failed) / float64(t.reads)
+			ended = true
+		}
+		t.start = now
+		t.reads, t.failed = 0, 0
+	}
+	t.reads++
+	if failed {
+		t.failed++
+	}
+	return rate, ended
+}
+
+// Degraded reports whether the Redis error rate is above
+// Options.DegradedErrorRate, so local TTLs are extended.
+func (cd *Cache) Degraded() bool {
+	return atomic.LoadUint32(&cd.degraded) == 1
+}
+
+// recordRedisRead updates the degraded state with the outcome of a Redis
+// read.
+func (cd *Cache) recordRedisRead(err error) {
+	if cd.opt.DegradedErrorRate <= 0 {
+		return
+	}
+	rate, ended := cd.readErrors.add(cd.now(), cd.opt.DegradedWindow, err != nil)
+	if !ended {
+		return
+	}
+
+	var v uint32
+	if rate > cd.opt.DegradedErrorRate {
+		v = 1
+	}
+	if atomic.SwapUint32(&cd.degraded, v) != v && v == 1 {
+		atomic.AddUint64(&cd.degradedEvents, 1)
+	}
+}
+
+// degradedTTL extends the local TTL to Options.DegradedLocalTTL while the
+// cache is degraded.
+func (cd *Cache) degradedTTL(ttl time.Duration) time.Duration {
+	if cd.Degraded() && ttl < cd.opt.DegradedLocalTTL {
+		return cd.opt.DegradedLocalTTL
+	}
+	return ttl
+}
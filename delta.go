@@ -0,0 +1,435 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const deltaBlockSize = 32
+
+const (
+	deltaOpCopy   = 0x0
+	deltaOpInsert = 0x1
+)
+
+// deltaCompressedBase marks deltas computed against the decompressed
+// snapshot.
+const deltaCompressedBase = 0x1
+
+var errCorruptDelta = errors.New("cache: corrupt delta")
+
+// deltaSweepMin is the number of delta states kept before the ones of
+// expired snapshots are dropped.
+const deltaSweepMin = 1024
+
+// deltaState is the last snapshot written by SetDelta for a key.
+type deltaState struct {
+	mu         sync.Mutex
+	base       []byte
+	snapHash   uint64
+	compressed bool
+	snapAt     time.Time
+	deltas     int
+
+	// expiresAt is when the snapshot expires in Unix ns, 0 if never.
+	// It is read without mu when dropping expired states.
+	expiresAt int64
+}
+
+// SetDelta caches the item as a full snapshot plus a binary delta against
+// that snapshot, cutting Redis write bandwidth for large values that change
+// often but only a little. A new snapshot is written every
+// Options.DeltaSnapshotEvery updates, when the delta grows larger than half
+// of the value, or when half of the TTL has passed since the last snapshot.
+//
+// Values written with SetDelta must be read with GetDelta. Each key is
+// expected to have a single writing process; readers detect a delta that
+// does not match the snapshot and fall back to the snapshot.
+func (cd *Cache) SetDelta(item *Item) error {
+	if cd.opt.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := checkKey(item.Key); err != nil {
+		return err
+	}
+
+	value, err := item.value()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	b, err := cd.marshal(&buf, value, true)
+	if err != nil {
+		return err
+	}
+
+	full := b
+	compressed := !isRawValue(value) && cd.opt.Marshal == nil && len(b) > 0 &&
+		b[len(b)-1]&compressionMethodMask != noCompression
+	if compressed {
+		full, err = cd.decompressPayload(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cd.writes != nil {
+		defer cd.writes.touch(item.Key)
+	}
+
+	unlock := cd.keyLocks.lock(item.Key)
+	defer unlock()
+
+	if cd.useLocalCache() {
+		cd.localSetOnWrite(item.Key, full, item.SkipLocalOnSet)
+	}
+
+	if !cd.useRedis() {
+		if cd.opt.Redis == nil && cd.local == nil {
+			return errRedisLocalCacheNil
+		}
+		return nil
+	}
+
+	st := cd.deltaState(item.Key)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	ttl := item.ttl()
+	if st.base != nil &&
+		st.deltas < cd.opt.DeltaSnapshotEvery &&
+		(ttl == 0 || cd.now().Sub(st.snapAt) < ttl/2) {
+		delta := make([]byte, 9, 64)
+		binary.LittleEndian.PutUint64(delta, st.snapHash)
+		if st.compressed {
+			delta[8] = deltaCompressedBase
+		}
+		delta = appendDelta(delta, st.base, full)
+
+		if len(delta) < len(full)/2 {
+			delta, err = cd.sign(deltaKey(item.Key), delta)
+			if err != nil {
+				return err
+			}
+			if err := cd.opt.Redis.Set(deltaKey(item.Key), delta, ttl).Err(); err != nil {
+				return err
+			}
+			st.deltas++
+			cd.invalidate(opSet, []string{item.Key}, [][]byte{full})
+			return nil
+		}
+	}
+
+	signed, err := cd.sign(item.Key, b)
+	if err != nil {
+		return err
+	}
+	if err := cd.opt.Redis.Set(item.Key, signed, ttl).Err(); err != nil {
+		st.base = nil
+		return err
+	}
+
+	st.base = full
+	st.snapHash = contentHash(b)
+	st.compressed = compressed
+	st.snapAt = cd.now()
+	st.deltas = 0
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = st.snapAt.Add(ttl).UnixNano()
+	}
+	atomic.StoreInt64(&st.expiresAt, expiresAt)
+
+	cd.invalidate(opSet, []string{item.Key}, [][]byte{full})
+	return cd.opt.Redis.Del(deltaKey(item.Key)).Err()
+}
+
+// GetDelta gets the value written with SetDelta for the given key,
+// reassembling it from the snapshot and the delta.
+func (cd *Cache) GetDelta(ctx context.Context, key string, value interface{}) error {
+	skipLocalCache := cd.recentlyWritten(key)
+	if !skipLocalCache && cd.useLocalCache() {
+		b, ok, expired := cd.localGet(key)
+		if ok && !expired {
+			return cd.unmarshal(b, value)
+		}
+	}
+
+	snap, err := cd.getRedisBytes(key, true)
+	if err != nil {
+		return err
+	}
+
+	delta, err := cd.opt.Redis.Get(deltaKey(key)).Bytes()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if err == nil {
+		delta, err = cd.verify(deltaKey(key), delta)
+		if err != nil {
+			return err
+		}
+	}
+
+	b := snap
+	if len(delta) > 9 && binary.LittleEndian.Uint64(delta) == contentHash(snap) {
+		base := snap
+		if delta[8]&deltaCompressedBase != 0 {
+			base, err = cd.decompressPayload(snap)
+			if err != nil {
+				return err
+			}
+		}
+
+		b, err = applyDelta(base, delta[9:])
+		if err != nil {
+			return err
+		}
+	}
+
+	if !skipLocalCache && cd.useLocalCache() {
+		cd.localSet(key, b)
+	}
+	return cd.unmarshal(b, value)
+}
+
+func (cd *Cache) deltaState(key string) *deltaState {
+	cd.deltaMu.Lock()
+	defer cd.deltaMu.Unlock()
+
+	if cd.deltaStates == nil {
+		cd.deltaStates = make(map[string]*deltaState)
+	}
+	st, ok := cd.deltaStates[key]
+	if !ok {
+		if len(cd.deltaStates) >= cd.deltaSweepAt {
+			cd.dropExpiredDeltas()
+			cd.deltaSweepAt = 2*len(cd.deltaStates) + deltaSweepMin
+		}
+		st = new(deltaState)
+		cd.deltaStates[key] = st
+	}
+	return st
+}
+
+// dropExpiredDeltas forgets the states of expired snapshots, e.g. of keys
+// written only once. It must be called with deltaMu held.
+func (cd *Cache) dropExpiredDeltas() {
+	now := cd.now().UnixNano()
+	for key, st := range cd.deltaStates {
+		if at := atomic.LoadInt64(&st.expiresAt); at != 0 && at <= now {
+			delete(cd.deltaStates, key)
+		}
+	}
+}
+
+func (cd *Cache) forgetDelta(key string) {
+	cd.deltaMu.Lock()
+	delete(cd.deltaStates, key)
+	cd.deltaMu.Unlock()
+}
+
+func deltaKey(key string) string {
+	return deltaPrefix + key
+}
+
+func isRawValue(value interface{}) bool {
+	switch value.(type) {
+	case nil, rawPayload, []byte, string:
+		return true
+	}
+	return false
+}
+
+// decompressPayload converts a compressed payload to
+// the equivalent uncompressed payload.
+func (cd *Cache) decompressPayload(b []byte) ([]byte, error) {
+	if cd.opt.PayloadHeader {
+		_, body, headed, err := splitPayloadHeader(b)
+		if err != nil {
+			return nil, err
+		}
+		if headed {
+			raw, err := cd.decompressPayload(body)
+			if err != nil {
+				return nil, err
+			}
+			return append(appendPayloadHeader(nil, codecMsgpack, noCompression), raw...), nil
+		}
+	}
+
+	trailer := b[len(b)-1]
+	body := b[:len(b)-1]
+
+	var created []byte
+	if trailer&createdAtFlag != 0 {
+		if len(body) < createdAtLen {
+			return nil, errCorruptDelta
+		}
+		pos := len(body) - createdAtLen
+		body, created = body[:pos], body[pos:]
+	}
+
+	var version []byte
+	if trailer&schemaVersionFlag != 0 {
+		if len(body) < schemaVersionLen {
+			return nil, errCorruptDelta
+		}
+		pos := len(body) - schemaVersionLen
+		body, version = body[:pos], body[pos:]
+	}
+
+	var fp []byte
+	if trailer&typeFingerprintFlag != 0 {
+		if len(body) < typeFingerprintLen {
+			return nil, errCorruptDelta
+		}
+		pos := len(body) - typeFingerprintLen
+		body, fp = body[:pos], body[pos:]
+	}
+
+	comp := cd.compressor(trailer & compressionMethodMask)
+	if comp == nil {
+		return nil, errCorruptDelta
+	}
+	raw, err := comp.Decompress(body)
+	if err != nil {
+		return nil, err
+	}
+	raw = append(raw, fp...)
+	raw = append(raw, version...)
+	raw = append(raw, created...)
+	return append(raw, trailer&^compressionMethodMask|noCompression), nil
+}
+
+// appendDelta appends to dst the operations that rebuild target from base.
+// Blocks of base are matched in target with a rolling checksum and encoded
+// as copies; everything else is inserted literally.
+func appendDelta(dst, base, target []byte) []byte {
+	const n = deltaBlockSize
+
+	index := make(map[uint32]int, len(base)/n)
+	for off := 0; off+n <= len(base); off += n {
+		h := weakHash(base[off : off+n])
+		if _, ok := index[h]; !ok {
+			index[h] = off
+		}
+	}
+
+	var a, b uint32
+	var rolling bool
+	lit := 0
+	for i := 0; i+n <= len(target); {
+		if !rolling {
+			a, b = weakSums(target[i : i+n])
+			rolling = true
+		}
+
+		if off, ok := index[b<<16|a&0xffff]; ok && bytes.Equal(base[off:off+n], target[i:i+n]) {
+			size := n
+			for off+size < len(base) && i+size < len(target) && base[off+size] == target[i+size] {
+				size++
+			}
+
+			dst = appendInsertOp(dst, target[lit:i])
+			dst = append(dst, deltaOpCopy)
+			dst = appendUvarint(dst, uint64(off))
+			dst = appendUvarint(dst, uint64(size))
+
+			i += size
+			lit = i
+			rolling = false
+			continue
+		}
+
+		if i+n < len(target) {
+			out, in := uint32(target[i]), uint32(target[i+n])
+			a = a - out + in
+			b = b - n*out + a
+		}
+		i++
+	}
+
+	return appendInsertOp(dst, target[lit:])
+}
+
+func appendInsertOp(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	dst = append(dst, deltaOpInsert)
+	dst = appendUvarint(dst, uint64(len(lit)))
+	return append(dst, lit...)
+}
+
+func applyDelta(base, delta []byte) ([]byte, error) {
+	var out []byte
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		switch op {
+		case deltaOpCopy:
+			off, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errCorruptDelta
+			}
+			delta = delta[n:]
+
+			size, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errCorruptDelta
+			}
+			delta = delta[n:]
+
+			if off > uint64(len(base)) || size > uint64(len(base))-off {
+				return nil, errCorruptDelta
+			}
+			out = append(out, base[off:off+size]...)
+		case deltaOpInsert:
+			size, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errCorruptDelta
+			}
+			delta = delta[n:]
+
+			if size > uint64(len(delta)) {
+				return nil, errCorruptDelta
+			}
+			out = append(out, delta[:size]...)
+			delta = delta[size:]
+		default:
+			return nil, errCorruptDelta
+		}
+	}
+	return out, nil
+}
+
+func weakHash(block []byte) uint32 {
+	a, b := weakSums(block)
+	return b<<16 | a&0xffff
+}
+
+// weakSums returns the Adler-like rolling checksum of the block.
+func weakSums(block []byte) (a, b uint32) {
+	n := uint32(len(block))
+	for i, c := range block {
+		a += uint32(c)
+		b += (n - uint32(i)) * uint32(c)
+	}
+	return a, b
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(dst, buf[:n]...)
+}
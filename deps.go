@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var errDependenciesUnsupported = errors.New("cache: Redis client does not support sets")
+
+type setser interface {
+	SAdd(key string, members ...interface{}) *redis.IntCmd
+	SMembers(key string) *redis.StringSliceCmd
+}
+
+// addDependentScript adds a dependent and extends the TTL of the set so
+// it outlives every dependent. KEYS: dependents or tag key. ARGV:
+// dependent, TTL in ms.
+const addDependentScript = `
+local cur = redis.call("PTTL", KEYS[1])
+redis.call("SADD", KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl == 0 then
+	redis.call("PERSIST", KEYS[1])
+elseif cur == -2 or (cur >= 0 and cur < ttl) then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1
+`
+
+func dependentsKey(key string) string {
+	return dependentsPrefix + key
+}
+
+// dependencyIndex maps keys to the keys derived from them
+// in this process.
+type dependencyIndex struct {
+	mu   sync.Mutex
+	keys map[string]map[string]struct{}
+}
+
+func (idx *dependencyIndex) add(parent, child string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.keys == nil {
+		idx.keys = make(map[string]map[string]struct{})
+	}
+	children, ok := idx.keys[parent]
+	if !ok {
+		children = make(map[string]struct{})
+		idx.keys[parent] = children
+	}
+	children[child] = struct{}{}
+}
+
+// take removes and returns the dependents of the key.
+func (idx *dependencyIndex) take(parent string) []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	children := idx.keys[parent]
+	delete(idx.keys, parent)
+
+	keys := make([]string, 0, len(children))
+	for child := range children {
+		keys = append(keys, child)
+	}
+	return keys
+}
+
+// addDependents registers child as derived from parents, so writing any
+// of the parents deletes child. The registration in Redis lives at least
+// as long as ttl.
+func (cd *Cache) addDependents(parents []string, child string, ttl time.Duration) error {
+	if !cd.opt.TrackDependencies {
+		return nil
+	}
+
+	sets := make([]string, len(parents))
+	for i, parent := range parents {
+		cd.deps.add(parent, child)
+		sets[i] = dependentsKey(parent)
+	}
+	if !cd.useRedis() {
+		return nil
+	}
+	return cd.addMember(sets, child, ttl)
+}
+
+// addMember adds the member to the Redis sets and extends their TTL to
+// at least ttl.
+func (cd *Cache) addMember(sets []string, member string, ttl time.Duration) error {
+	if s, ok := cd.opt.Redis.(scripter); ok && cd.useScripts() {
+		for _, set := range sets {
+			err := s.Eval(addDependentScript,
+				[]string{set}, member, ttl.Milliseconds()).Err()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Without scripts the TTL of the last registration wins.
+	var cmds []redis.Cmder
+	var err error
+	cd.pipelined(func(pipe RemoteStore) {
+		s, ok := pipe.(setser)
+		e, ok2 := pipe.(expirer)
+		if !ok || !ok2 {
+			err = errDependenciesUnsupported
+			return
+		}
+		for _, set := range sets {
+			cmds = append(cmds, s.SAdd(set, member))
+			if ttl > 0 {
+				cmds = append(cmds, e.PExpire(set, ttl))
+			} else {
+				cmds = append(cmds, e.Persist(set))
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateDependencies registers the set item as a dependent of
+// Item.DependsOn and as a member of Item.Tags, and deletes the values
+// derived from its previous value.
+func (cd *Cache) updateDependencies(item *Item) error {
+	if len(item.Tags) > 0 {
+		if err := cd.addTags(item.Tags, item.Key, item.ttl()); err != nil {
+			return err
+		}
+	}
+	if len(item.DependsOn) > 0 {
+		if err := cd.addDependents(item.DependsOn, item.Key, item.ttl()); err != nil {
+			return err
+		}
+	}
+	return cd.deleteDependents(item.Context(), item.Key)
+}
+
+// deleteDependents deletes the keys derived from the key in both tiers,
+// following dependents of dependents. It must be called without holding
+// key locks.
+func (cd *Cache) deleteDependents(ctx context.Context, key string) error {
+	if !cd.opt.TrackDependencies || cd.dryRun() {
+		return nil
+	}
+
+	children := cd.deps.take(key)
+	if cd.useRedis() {
+		redisChildren, err := cd.takeRedisDependents(key)
+		if err != nil {
+			return err
+		}
+		children = append(children, redisChildren...)
+	}
+
+	var firstErr error
+	seen := make(map[string]struct{}, len(children))
+	for _, child := range children {
+		if _, ok := seen[child]; ok {
+			continue
+		}
+		seen[child] = struct{}{}
+		if err := cd.Delete(ctx, child); err != nil && err != ErrCacheMiss && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// takeRedisDependents atomically reads and deletes the dependents of the
+// key stored in Redis.
+func (cd *Cache) takeRedisDependents(key string) ([]string, error) {
+	var members *redis.StringSliceCmd
+	var err error
+	cd.txPipelined(func(pipe RemoteStore) {
+		s, ok := pipe.(setser)
+		if !ok {
+			err = errDependenciesUnsupported
+			return
+		}
+		members = s.SMembers(dependentsKey(key))
+		pipe.Del(dependentsKey(key))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members.Result()
+}
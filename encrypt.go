@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// encryptedMagic starts values encrypted with Options.Encryption. Like
+// the payload header, it starts with 0xc1, which never starts msgpack or
+// UTF-8 values.
+var encryptedMagic = []byte{0xc1, 0xce}
+
+// encryptedHeaderLen is the size of the magic, the key ID, and the nonce
+// in front of the ciphertext.
+const encryptedHeaderLen = 2 + 1 + 12
+
+// ErrDecryption is returned when a value read from Redis can't be
+// decrypted, e.g. because its key is no longer provided.
+var ErrDecryption = errors.New("cache: can't decrypt value")
+
+// KeyProvider supplies the AES-128, AES-192, or AES-256 keys of
+// Options.Encryption. Keys are identified by a byte stored with every
+// encrypted value, so keys can be rotated: start encrypting with a new
+// key and keep providing the old one until values encrypted with it have
+// expired.
+type KeyProvider interface {
+	// CurrentKey returns the key new values are encrypted with.
+	CurrentKey() (id byte, key []byte, err error)
+	// Key returns the key with the ID.
+	Key(id byte) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider with fixed keys.
+type StaticKeys struct {
+	Keys      map[byte][]byte
+	CurrentID byte
+}
+
+var _ KeyProvider = (*StaticKeys)(nil)
+
+func (k *StaticKeys) CurrentKey() (byte, []byte, error) {
+	key, err := k.Key(k.CurrentID)
+	return k.CurrentID, key, err
+}
+
+func (k *StaticKeys) Key(id byte) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("cache: encryption key %d is missing", id)
+	}
+	return key, nil
+}
+
+// aeadCache keeps the AES-GCM ciphers of the keys, which are costly to set
+// up for every value.
+type aeadCache struct {
+	mu    sync.Mutex
+	aeads map[string]cipher.AEAD
+}
+
+func (c *aeadCache) get(key []byte) (cipher.AEAD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if aead, ok := c.aeads[string(key)]; ok {
+		return aead, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if c.aeads == nil {
+		c.aeads = make(map[string]cipher.AEAD)
+	}
+	c.aeads[string(key)] = aead
+	return aead, nil
+}
+
+// encrypt encrypts the value with the current key of Options.Encryption.
+// The Redis key is authenticated with the value, so an encrypted value
+// can't be replayed under another key. Tombstones stay in clear text, so
+// scripts can recognize them.
+func (cd *Cache) encrypt(key string, b []byte) ([]byte, error) {
+	if cd.opt.Encryption == nil || isTombstone(b) {
+		return b, nil
+	}
+
+	id, secret, err := cd.opt.Encryption.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cd.aeads.get(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, encryptedHeaderLen, encryptedHeaderLen+len(b)+aead.Overhead())
+	copy(out, encryptedMagic)
+	out[2] = id
+	nonce := out[3:encryptedHeaderLen]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, b, []byte(key)), nil
+}
+
+// decrypt decrypts a value read from Redis. Values written before
+// Options.Encryption was set are returned as they are.
+func (cd *Cache) decrypt(key string, b []byte) ([]byte, error) {
+	if cd.opt.Encryption == nil || !bytes.HasPrefix(b, encryptedMagic) {
+		return b, nil
+	}
+	if len(b) < encryptedHeaderLen {
+		return nil, ErrDecryption
+	}
+
+	secret, err := cd.opt.Encryption.Key(b[2])
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	aead, err := cd.aeads.get(secret)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	plain, err := aead.Open(nil, b[3:encryptedHeaderLen], b[encryptedHeaderLen:], []byte(key))
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return plain, nil
+}
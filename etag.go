@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// GetIfChanged returns the stored bytes for the key along with their
+// content hash, but only if the hash differs from lastHash. Otherwise it
+// returns a nil value, lastHash, and changed set to false. Pass 0 as
+// lastHash to always get the value. The value can be decoded with
+// Unmarshal.
+//
+// With Options.ContentHash enabled the hash is checked in Redis first, so
+// pollers of large values only pay for the payload when it has changed.
+func (cd *Cache) GetIfChanged(
+	ctx context.Context, key string, lastHash uint64,
+) (value []byte, newHash uint64, changed bool, err error) {
+	if lastHash != 0 && cd.opt.ContentHash && cd.useRedis() {
+		hash, err := cd.redisHash(key)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if hash == lastHash {
+			return nil, lastHash, false, nil
+		}
+	}
+
+	b, err := cd.getBytes(ctx, key, cd.useRedis())
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	newHash = contentHash(b)
+	if newHash == lastHash {
+		return nil, lastHash, false, nil
+	}
+	return b, newHash, true, nil
+}
+
+// redisHash returns the stored content hash for the key
+// or 0 if there is none.
+func (cd *Cache) redisHash(key string) (uint64, error) {
+	b, err := cd.opt.Redis.Get(hashKey(key)).Bytes()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	b, err = cd.verify(hashKey(key), b)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 8 {
+		return 0, nil
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func hashKey(key string) string {
+	return hashPrefix + key
+}
+
+func encodeHash(hash uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, hash)
+	return b
+}
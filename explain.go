@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// Explanation is a trace of how Get would serve a key, returned by
+// Explain.
+type Explanation struct {
+	Key   string
+	Steps []ExplainStep
+	// Served is the tier Get would serve the value from: "local",
+	// "redis", or empty for a miss.
+	Served string
+}
+
+// ExplainStep describes what one tier holds for the key.
+type ExplainStep struct {
+	// Tier is "local" or "redis".
+	Tier string
+	// Outcome is "found", "expired", "missing", "skipped", or "error".
+	Outcome string
+	// Note explains skipped tiers and unusual outcomes.
+	Note string
+	// Size is the size of the value in bytes.
+	Size int
+	// Age is how long ago the value was created, or -1 when unknown,
+	// see Options.StoreCreationTime.
+	Age time.Duration
+	// TTL is the remaining Redis TTL, or -1 when the key has none.
+	TTL      time.Duration
+	Duration time.Duration
+	Err      error
+}
+
+func (s ExplainStep) String() string {
+	str := fmt.Sprintf("%s: %s", s.Tier, s.Outcome)
+	if s.Note != "" {
+		str += " (" + s.Note + ")"
+	}
+	if s.Err != nil {
+		str += ": " + s.Err.Error()
+	}
+	return str
+}
+
+// Explain looks the key up in every tier like Get, without changing them
+// or the stats, and reports what each tier holds, e.g. for a debug
+// endpoint that answers why a value was stale.
+func (cd *Cache) Explain(ctx context.Context, key string) *Explanation {
+	e := &Explanation{Key: key}
+
+	local := cd.explainLocal(key)
+	e.Steps = append(e.Steps, local)
+	if local.Outcome == "found" {
+		e.Served = "local"
+		return e
+	}
+
+	remote := cd.explainRedis(key)
+	e.Steps = append(e.Steps, remote)
+	switch {
+	case remote.Outcome == "found":
+		e.Served = "redis"
+	case remote.Outcome == "error" && local.Outcome == "expired" && cd.opt.ErrUseStale:
+		e.Served = "local"
+	}
+	return e
+}
+
+func (cd *Cache) explainLocal(key string) ExplainStep {
+	step := ExplainStep{Tier: "local", Age: -1, TTL: -1}
+	switch {
+	case cd.local == nil:
+		step.Outcome, step.Note = "skipped", "no local cache"
+		return step
+	case !cd.useLocalCache():
+		step.Outcome, step.Note = "skipped", "bypassed by Mode"
+		return step
+	case cd.recentlyWritten(key):
+		step.Outcome, step.Note = "skipped", "written within ReadYourWritesWindow"
+		return step
+	}
+
+	start := time.Now()
+	b, ok := cd.local.HasGet(nil, []byte(key))
+	step.Duration = time.Since(start)
+	if !ok {
+		step.Outcome = "missing"
+		return step
+	}
+
+	step.Outcome = "found"
+	if cd.opt.LocalCacheStoreTTL > 0 && len(b) >= 4 {
+		tm := decodeTime(b[len(b)-4:])
+		b = b[:len(b)-4]
+		if cd.localExpired(tm, cd.now().Sub(tm)) {
+			step.Outcome, step.Note = "expired", "stored "+tm.Format(time.RFC3339)
+		}
+	}
+	if step.Outcome == "found" && isCritical(b) {
+		stored, ok := cd.critical.stored.Load(key)
+		if !ok || cd.now().Sub(stored.(time.Time)) >= cd.opt.CriticalStaleness {
+			step.Outcome, step.Note = "expired", "critical value past CriticalStaleness"
+		}
+	}
+	step.Size = len(b)
+	step.Age = cd.explainAge(key, b)
+	return step
+}
+
+func (cd *Cache) explainRedis(key string) ExplainStep {
+	step := ExplainStep{Tier: "redis", Age: -1, TTL: -1}
+	switch {
+	case cd.opt.Redis == nil:
+		step.Outcome, step.Note = "skipped", "no Redis"
+		return step
+	case !cd.useRedis():
+		step.Outcome, step.Note = "skipped", "bypassed by Mode"
+		return step
+	}
+	if prefix, ok := cd.bundlePrefix(key); ok {
+		step.Outcome, step.Note = "skipped", "bundled in hash "+prefix
+		return step
+	}
+
+	start := time.Now()
+	b, err := cd.opt.Redis.Get(key).Bytes()
+	var ttl time.Duration
+	var ttlErr error
+	if p, ok := cd.opt.Redis.(pttler); ok && err == nil {
+		ttl, ttlErr = p.PTTL(key).Result()
+	}
+	step.Duration = time.Since(start)
+
+	if err == nil {
+		b, err = cd.verify(key, b)
+	}
+	switch {
+	case err == redis.Nil:
+		step.Outcome = "missing"
+		return step
+	case err != nil:
+		step.Outcome, step.Err = "error", err
+		return step
+	case isTombstone(b):
+		step.Outcome, step.Note = "missing", "tombstone"
+		return step
+	}
+
+	step.Outcome = "found"
+	if target, ok := decodeRef(b); ok {
+		step.Note = "reference to " + target
+	}
+	if ttlErr == nil && ttl != 0 {
+		step.TTL = ttl
+	}
+	step.Size = len(b)
+	step.Age = cd.explainAge(key, b)
+	return step
+}
+
+func (cd *Cache) explainAge(key string, b []byte) time.Duration {
+	if age, ok := cd.age(key, b); ok {
+		return age
+	}
+	return -1
+}
@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// ErrFaultInjected is returned by Redis commands failed by Options.Faults.
+var ErrFaultInjected = errors.New("cache: injected fault")
+
+// Faults injects artificial failures into the cache tiers to test
+// ErrUseStale, retries, and other failure handling. It is meant for tests
+// only.
+type Faults struct {
+	// RedisLatency is added to every Redis command.
+	RedisLatency time.Duration
+	// RedisErrorRate is the fraction of Redis commands that fail
+	// with ErrFaultInjected without reaching Redis.
+	RedisErrorRate float64
+	// LocalCorruptionRate is the fraction of local cache reads that
+	// return corrupted bytes.
+	LocalCorruptionRate float64
+}
+
+func (f *Faults) redisFault() error {
+	if f.RedisLatency > 0 {
+		time.Sleep(f.RedisLatency)
+	}
+	if sample(f.RedisErrorRate) {
+		return ErrFaultInjected
+	}
+	return nil
+}
+
+// corrupt returns a copy of b with one flipped byte.
+func (f *Faults) corrupt(b []byte) []byte {
+	if len(b) == 0 || !sample(f.LocalCorruptionRate) {
+		return b
+	}
+	b = append([]byte(nil), b...)
+	b[rand.Intn(len(b))] ^= 0xff
+	return b
+}
+
+func sample(rate float64) bool {
+	return rate > 0 && (rate >= 1 || rand.Float64() < rate)
+}
+
+// redisClient is a RemoteStore with the optional commands of Redis
+// clients. Pipelines are left out, see faultyClient.
+type redisClient interface {
+	RemoteStore
+	doer
+	scripter
+	expirer
+	pttler
+	setser
+	setScanner
+	keyScanner
+	hasher
+	metaHasher
+}
+
+// newFaultyRedis wraps the store to inject Faults into its commands. The
+// wrapper supports the same optional commands as the store, so features
+// that depend on them keep working.
+func newFaultyRedis(store RemoteStore, faults *Faults) RemoteStore {
+	r := &faultyRedis{
+		RemoteStore: store,
+		faults:      faults,
+	}
+	if c, ok := store.(redisClient); ok {
+		return &faultyClient{
+			faultyRedis: r,
+			client:      c,
+		}
+	}
+	return r
+}
+
+// faultyRedis is a RemoteStore that injects Faults into every command.
+type faultyRedis struct {
+	RemoteStore
+	faults *Faults
+}
+
+var _ RemoteStore = (*faultyRedis)(nil)
+
+func (r *faultyRedis) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewStatusResult("", err)
+	}
+	return r.RemoteStore.Set(key, value, expiration)
+}
+
+func (r *faultyRedis) SetXX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	return r.RemoteStore.SetXX(key, value, expiration)
+}
+
+func (r *faultyRedis) SetNX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	return r.RemoteStore.SetNX(key, value, expiration)
+}
+
+func (r *faultyRedis) Get(key string) *redis.StringCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewStringResult("", err)
+	}
+	return r.RemoteStore.Get(key)
+}
+
+func (r *faultyRedis) Del(keys ...string) *redis.IntCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	return r.RemoteStore.Del(keys...)
+}
+
+// faultyClient is a faultyRedis for Redis clients. It doesn't support
+// pipelines, so the commands that would be pipelined are sent one by one
+// and each of them can fail.
+type faultyClient struct {
+	*faultyRedis
+	client redisClient
+}
+
+var _ redisClient = (*faultyClient)(nil)
+
+func (r *faultyClient) Do(args ...interface{}) *redis.Cmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewCmdResult(nil, err)
+	}
+	return r.client.Do(args...)
+}
+
+func (r *faultyClient) Eval(script string, keys []string, args ...interface{}) *redis.Cmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewCmdResult(nil, err)
+	}
+	return r.client.Eval(script, keys, args...)
+}
+
+func (r *faultyClient) PExpire(key string, expiration time.Duration) *redis.BoolCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	return r.client.PExpire(key, expiration)
+}
+
+func (r *faultyClient) Persist(key string) *redis.BoolCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	return r.client.Persist(key)
+}
+
+func (r *faultyClient) PTTL(key string) *redis.DurationCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewDurationResult(0, err)
+	}
+	return r.client.PTTL(key)
+}
+
+func (r *faultyClient) SAdd(key string, members ...interface{}) *redis.IntCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	return r.client.SAdd(key, members...)
+}
+
+func (r *faultyClient) SMembers(key string) *redis.StringSliceCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewStringSliceResult(nil, err)
+	}
+	return r.client.SMembers(key)
+}
+
+func (r *faultyClient) SScan(key string, cursor uint64, match string, count int64) *redis.ScanCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewScanCmdResult(nil, 0, err)
+	}
+	return r.client.SScan(key, cursor, match, count)
+}
+
+func (r *faultyClient) SRem(key string, members ...interface{}) *redis.IntCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	return r.client.SRem(key, members...)
+}
+
+func (r *faultyClient) Scan(cursor uint64, match string, count int64) *redis.ScanCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewScanCmdResult(nil, 0, err)
+	}
+	return r.client.Scan(cursor, match, count)
+}
+
+func (r *faultyClient) HSet(key string, values ...interface{}) *redis.IntCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	return r.client.HSet(key, values...)
+}
+
+func (r *faultyClient) HSetNX(key, field string, value interface{}) *redis.BoolCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	return r.client.HSetNX(key, field, value)
+}
+
+func (r *faultyClient) HIncrBy(key, field string, incr int64) *redis.IntCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	return r.client.HIncrBy(key, field, incr)
+}
+
+func (r *faultyClient) HGetAll(key string) *redis.StringStringMapCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewStringStringMapResult(nil, err)
+	}
+	return r.client.HGetAll(key)
+}
+
+func (r *faultyClient) HDel(key string, fields ...string) *redis.IntCmd {
+	if err := r.faults.redisFault(); err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	return r.client.HDel(key, fields...)
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// ErrStaleFencingToken is returned when a value is set with a fencing
+// token older than the token of the stored value.
+var ErrStaleFencingToken = errors.New("cache: fencing token is older than the stored one")
+
+var errFencingUnsupported = errors.New("cache: Redis client does not support fencing tokens")
+
+type scripter interface {
+	Eval(script string, keys []string, args ...interface{}) *redis.Cmd
+}
+
+// fencedSetScript sets the value and its token unless the stored token
+// is newer. KEYS: value key, token key. ARGV: value, token, TTL in ms.
+const fencedSetScript = `
+local cur = redis.call("GET", KEYS[2])
+if cur and tonumber(cur) > tonumber(ARGV[2]) then
+	return 0
+end
+local ttl = tonumber(ARGV[3])
+if ttl > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ttl)
+	redis.call("SET", KEYS[2], ARGV[2], "PX", ttl)
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+	redis.call("SET", KEYS[2], ARGV[2])
+end
+return 1
+`
+
+func tokenKey(key string) string {
+	return tokenPrefix + key
+}
+
+// fencedSet atomically stores the value unless Redis holds a value
+// written with a newer Item.FencingToken.
+func (cd *Cache) fencedSet(item *Item, b []byte) error {
+	if item.IfExists || item.IfNotExists {
+		return errors.New("cache: FencingToken can't be used with IfExists or IfNotExists")
+	}
+
+	if !cd.useScripts() {
+		return errScriptsDisabled
+	}
+
+	s, ok := cd.opt.Redis.(scripter)
+	if !ok {
+		return errFencingUnsupported
+	}
+
+	keys := []string{item.Key, tokenKey(item.Key)}
+	token := strconv.FormatUint(item.FencingToken, 10)
+	ttl := item.ttl().Milliseconds()
+
+	stored, err := s.Eval(fencedSetScript, keys, b, token, ttl).Int64()
+	if err != nil {
+		return err
+	}
+	if stored == 0 {
+		return ErrStaleFencingToken
+	}
+	return nil
+}
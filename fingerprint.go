@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// typeFingerprintFlag is set in the payload trailer byte when the payload
+// carries a type fingerprint right before the trailer.
+const typeFingerprintFlag = 0x80
+
+const typeFingerprintLen = 4
+
+// ErrTypeMismatch is returned when a value stored with a type fingerprint
+// is decoded into a destination of a different type.
+var ErrTypeMismatch = errors.New("cache: value type does not match destination")
+
+var typeFingerprints sync.Map // map[reflect.Type]uint32
+
+func appendTypeFingerprint(b []byte, typ reflect.Type) []byte {
+	var buf [typeFingerprintLen]byte
+	binary.LittleEndian.PutUint32(buf[:], typeFingerprint(typ))
+	return append(b, buf[:]...)
+}
+
+// checkTypeFingerprint strips the fingerprint from the payload body and
+// verifies it against the destination type.
+func checkTypeFingerprint(b []byte, value interface{}) ([]byte, uint32, error) {
+	if len(b) < typeFingerprintLen {
+		return nil, 0, errors.New("cache: payload is too short for type fingerprint")
+	}
+	pos := len(b) - typeFingerprintLen
+	fp := binary.LittleEndian.Uint32(b[pos:])
+
+	typ := reflect.TypeOf(value)
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+		if typ.Kind() != reflect.Interface && typeFingerprint(typ) != fp {
+			return nil, 0, ErrTypeMismatch
+		}
+	}
+	return b[:pos], fp, nil
+}
+
+// typeFingerprint returns a short hash of the package qualified type name
+// and, for structs, the names and types of its fields. Pointers are
+// ignored so *T and T share a fingerprint. Types registered with
+// RegisterType use the tag of their name instead.
+func typeFingerprint(typ reflect.Type) uint32 {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	if fp, ok := typeFingerprints.Load(typ); ok {
+		return fp.(uint32)
+	}
+
+	var sb strings.Builder
+	writeTypeSignature(&sb, typ)
+	fp := uint32(xxhash.Sum64String(sb.String()))
+
+	typeFingerprints.Store(typ, fp)
+	return fp
+}
+
+func writeTypeSignature(sb *strings.Builder, typ reflect.Type) {
+	if typ.PkgPath() != "" {
+		sb.WriteString(typ.PkgPath())
+		sb.WriteByte('.')
+	}
+	sb.WriteString(typ.String())
+
+	if typ.Kind() != reflect.Struct {
+		return
+	}
+
+	sb.WriteByte('{')
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		sb.WriteString(f.Name)
+		sb.WriteByte(' ')
+		sb.WriteString(f.Type.String())
+		sb.WriteByte(';')
+	}
+	sb.WriteByte('}')
+}
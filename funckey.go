@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// FuncKey derives a cache key from the identity of fn, its code version,
+// and the arguments it is called with:
+//
+//	fn:<package path>.<function name>:<version>:<hash of args>
+//
+// Arguments are encoded with msgpack and hashed with SHA-256, so equal
+// arguments produce the same key. msgpack only sorts the keys of
+// map[string]string and map[string]interface{}; other maps don't produce
+// stable keys and should not be used as arguments. Bump version
+// whenever the results of fn change for the same arguments; keys of the
+// previous version are then never read again and expire with their TTL.
+//
+// Anonymous functions are named after the enclosing function and their
+// position in it, e.g. pkg.Load.func1, which changes when closures are
+// added before them, so prefer named functions.
+func FuncKey(fn interface{}, version string, args ...interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf).SortMapKeys(true).UseCompactEncoding(true)
+	if err := enc.Encode(version); err != nil {
+		return "", err
+	}
+	for _, arg := range args {
+		if err := enc.Encode(arg); err != nil {
+			return "", err
+		}
+	}
+	sum := sha256.Sum256(buf.Bytes())
+
+	var sb strings.Builder
+	sb.WriteString("fn:")
+	sb.WriteString(funcName(fn))
+	sb.WriteByte(':')
+	sb.WriteString(version)
+	sb.WriteByte(':')
+	sb.WriteString(hex.EncodeToString(sum[:16]))
+	return sb.String(), nil
+}
+
+// KeyFunc returns a key function for Memoize that derives keys with
+// FuncKey. The returned function panics when the argument can't be
+// encoded with msgpack, e.g. when it contains channels or functions.
+func KeyFunc[A any](fn interface{}, version string) func(A) string {
+	return func(arg A) string {
+		key, err := FuncKey(fn, version, arg)
+		if err != nil {
+			panic(err)
+		}
+		return key
+	}
+}
+
+func funcName(fn interface{}) string {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		panic("cache: FuncKey expects a function, got " + v.Type().String())
+	}
+	if f := runtime.FuncForPC(v.Pointer()); f != nil {
+		return f.Name()
+	}
+	return v.Type().String()
+}
@@ -0,0 +1,23 @@
+package cache
+
+import "context"
+
+// Get is like Cache.Get, but decodes the value into a new T and returns
+// it, so the destination always has the expected type.
+func Get[T any](cd *Cache, ctx context.Context, key string) (T, error) {
+	var v T
+	err := cd.Get(ctx, key, &v)
+	return v, err
+}
+
+// Once is like Cache.Once, but decodes the value into a new T and returns
+// it. The Value of the item is ignored; Item.Stale is set like in
+// Cache.Once.
+func Once[T any](cd *Cache, item *Item) (T, error) {
+	var v T
+	typed := *item
+	typed.Value = &v
+	err := cd.Once(&typed)
+	item.Stale = typed.Stale
+	return v, err
+}
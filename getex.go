@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+type doer interface {
+	Do(args ...interface{}) *redis.Cmd
+}
+
+type expirer interface {
+	PExpire(key string, expiration time.Duration) *redis.BoolCmd
+	Persist(key string) *redis.BoolCmd
+}
+
+var errExpireUnsupported = errors.New("cache: Redis client does not support PEXPIRE")
+
+// GetEx gets the value for the given key and resets its TTL in Redis,
+// which gives keys read this way a sliding expiration. TTL is handled
+// like Item.TTL: negative removes the expiration and values below one
+// second mean one hour. GETEX is used when Capabilities reports it,
+// other servers get GET and PEXPIRE in one pipeline. The local cache is
+// skipped for the read because it would not refresh the TTL.
+func (cd *Cache) GetEx(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	if !cd.useRedis() {
+		return ErrCacheMiss
+	}
+
+	b, err := cd.getExBytes(key, (&Item{TTL: ttl}).ttl())
+	if err != nil {
+		if err == redis.Nil {
+			err = ErrCacheMiss
+		} else {
+			atomic.AddUint64(&cd.errs, 1)
+		}
+		if cd.opt.StatsEnabled {
+			atomic.AddUint64(&cd.misses, 1)
+		}
+		return err
+	}
+
+	b, err = cd.verify(key, b)
+	if err != nil {
+		atomic.AddUint64(&cd.errs, 1)
+		return err
+	}
+
+	if cd.opt.StatsEnabled {
+		atomic.AddUint64(&cd.hits, 1)
+	}
+	if cd.useLocalCache() {
+		cd.localSet(key, b)
+	}
+
+	if value == nil || len(b) == 0 {
+		return nil
+	}
+	return cd.UnmarshalKey(key, b, value)
+}
+
+func (cd *Cache) getExBytes(key string, ttl time.Duration) ([]byte, error) {
+	d, ok := cd.opt.Redis.(doer)
+	if caps, err := cd.Capabilities(); ok && err == nil && caps.GetEx {
+		args := []interface{}{"getex", key, "persist"}
+		if ttl > 0 {
+			args = []interface{}{"getex", key, "px", ttl.Milliseconds()}
+		}
+
+		s, err := d.Do(args...).Text()
+		return []byte(s), err
+	}
+
+	var get *redis.StringCmd
+	var expire *redis.BoolCmd
+	cd.pipelined(func(pipe RemoteStore) {
+		get = pipe.Get(key)
+		if e, ok := pipe.(expirer); ok {
+			if ttl > 0 {
+				expire = e.PExpire(key, ttl)
+			} else {
+				expire = e.Persist(key)
+			}
+		}
+	})
+
+	b, err := get.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if expire == nil {
+		return nil, errExpireUnsupported
+	}
+	if err := expire.Err(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
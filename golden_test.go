@@ -0,0 +1,229 @@
+package cache_test
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/star001007/cache"
+	"github.com/star001007/cache/cachetest"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "write missing payloads to testdata/payloads")
+
+var (
+	goldenSigningKey    = []byte("golden signing key")
+	goldenEncryptionKey = []byte("golden encryption key, 32 bytes!")
+)
+
+// goldenPayloads are values serialized by earlier versions of the package.
+// They must stay readable as long as such values may still be in Redis, so
+// existing files are never regenerated; add new files for new formats.
+// Payloads with a key are the bytes Set stored in Redis under the key,
+// e.g. signed or encrypted, and are read back with Get.
+var goldenPayloads = []struct {
+	name  string
+	opt   cache.Options
+	key   string
+	value interface{}
+	dst   func() interface{}
+}{{
+	name:  "string",
+	value: "mystring",
+	dst:   func() interface{} { return new(string) },
+}, {
+	name:  "bytes",
+	value: []byte("mybytes"),
+	dst:   func() interface{} { return new([]byte) },
+}, {
+	name:  "msgpack",
+	value: &Object{Str: "mystring", Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "msgpack_s2",
+	value: &Object{Str: strings.Repeat("my very large string", 10), Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "msgpack_fingerprint",
+	opt:   cache.Options{TypeFingerprint: true},
+	value: &Object{Str: "mystring", Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "msgpack_s2_fingerprint",
+	opt:   cache.Options{TypeFingerprint: true},
+	value: &Object{Str: strings.Repeat("my very large string", 10), Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "msgpack_created",
+	opt:   cache.Options{StoreCreationTime: true},
+	value: &Object{Str: "mystring", Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "msgpack_s2_fingerprint_created",
+	opt:   cache.Options{TypeFingerprint: true, StoreCreationTime: true},
+	value: &Object{Str: strings.Repeat("my very large string", 10), Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "msgpack_zstd",
+	opt:   cache.Options{Compressor: cache.ZstdCompressor},
+	value: &Object{Str: strings.Repeat("my very large string", 10), Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "msgpack_lz4",
+	opt:   cache.Options{Compressor: cache.LZ4Compressor},
+	value: &Object{Str: strings.Repeat("my very large string", 10), Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "msgpack_schema_version",
+	opt:   cache.Options{SchemaVersion: 3},
+	value: &Object{Str: "mystring", Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name: "msgpack_s2_fingerprint_schema_version_created",
+	opt: cache.Options{
+		TypeFingerprint:   true,
+		SchemaVersion:     3,
+		StoreCreationTime: true,
+	},
+	value: &Object{Str: strings.Repeat("my very large string", 10), Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "string_header",
+	opt:   cache.Options{PayloadHeader: true},
+	value: "mystring",
+	dst:   func() interface{} { return new(string) },
+}, {
+	name:  "bytes_header",
+	opt:   cache.Options{PayloadHeader: true},
+	value: []byte("mybytes"),
+	dst:   func() interface{} { return new([]byte) },
+}, {
+	name:  "msgpack_header",
+	opt:   cache.Options{PayloadHeader: true},
+	value: &Object{Str: "mystring", Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "msgpack_s2_fingerprint_created_header",
+	opt:   cache.Options{PayloadHeader: true, TypeFingerprint: true, StoreCreationTime: true},
+	value: &Object{Str: strings.Repeat("my very large string", 10), Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "string_checksum",
+	opt:   cache.Options{Checksum: true},
+	value: "mystring",
+	dst:   func() interface{} { return new(string) },
+}, {
+	name:  "msgpack_s2_header_checksum",
+	opt:   cache.Options{PayloadHeader: true, Checksum: true},
+	value: &Object{Str: strings.Repeat("my very large string", 10), Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name:  "plain_json",
+	opt:   cache.Options{DefaultFormat: &cache.PlainFormat{Codec: cache.PlainJSON}},
+	key:   "mykey",
+	value: &Object{Str: "mystring", Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name: "plain_msgpack_gzip",
+	opt: cache.Options{
+		PlainFormats: map[string]cache.PlainFormat{"gz:": {Codec: cache.PlainMsgpack, Gzip: true}},
+	},
+	key:   "gz:mykey",
+	value: &Object{Str: "mystring", Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name: "msgpack_signed",
+	opt: cache.Options{
+		SigningKeys:  map[byte][]byte{1: goldenSigningKey},
+		SigningKeyID: 1,
+	},
+	key:   "mykey",
+	value: &Object{Str: "mystring", Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name: "msgpack_encrypted",
+	opt: cache.Options{
+		Encryption: &cache.StaticKeys{Keys: map[byte][]byte{1: goldenEncryptionKey}, CurrentID: 1},
+	},
+	key:   "mykey",
+	value: &Object{Str: "mystring", Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}, {
+	name: "msgpack_s2_checksum_encrypted_signed",
+	opt: cache.Options{
+		Checksum:     true,
+		Encryption:   &cache.StaticKeys{Keys: map[byte][]byte{1: goldenEncryptionKey}, CurrentID: 1},
+		SigningKeys:  map[byte][]byte{1: goldenSigningKey},
+		SigningKeyID: 1,
+	},
+	key:   "mykey",
+	value: &Object{Str: strings.Repeat("my very large string", 10), Num: 42},
+	dst:   func() interface{} { return new(Object) },
+}}
+
+var _ = Describe("Golden payloads", func() {
+	ctx := context.TODO()
+
+	for _, tt := range goldenPayloads {
+		tt := tt
+
+		It("decodes "+tt.name, func() {
+			opt := tt.opt
+			path := filepath.Join("testdata", "payloads", tt.name+".bin")
+
+			var marshal func(value interface{}) ([]byte, error)
+			var unmarshal func(b []byte, value interface{}) error
+			if tt.key == "" {
+				mycache := cache.New(&opt)
+				marshal, unmarshal = mycache.Marshal, mycache.Unmarshal
+			} else {
+				rc := cachetest.NewRedisCacheWithOptions(GinkgoT(), &opt)
+				defer rc.Close()
+
+				marshal = func(value interface{}) ([]byte, error) {
+					if err := rc.Set(&cache.Item{Ctx: ctx, Key: tt.key, Value: value}); err != nil {
+						return nil, err
+					}
+					s, err := rc.Miniredis.Get(tt.key)
+					return []byte(s), err
+				}
+				unmarshal = func(b []byte, value interface{}) error {
+					if err := rc.Miniredis.Set(tt.key, string(b)); err != nil {
+						return err
+					}
+					return rc.Get(ctx, tt.key, value)
+				}
+			}
+
+			// Existing payloads are never rewritten, they are what older
+			// versions wrote.
+			if _, err := os.Stat(path); *updateGolden && os.IsNotExist(err) {
+				b, err := marshal(tt.value)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ioutil.WriteFile(path, b, 0644)).To(Succeed())
+			}
+
+			b, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+
+			dst := tt.dst()
+			err = unmarshal(b, dst)
+			Expect(err).NotTo(HaveOccurred())
+
+			switch v := tt.value.(type) {
+			case string:
+				Expect(*dst.(*string)).To(Equal(v))
+			case []byte:
+				Expect(*dst.(*[]byte)).To(Equal(v))
+			default:
+				Expect(dst).To(Equal(v))
+			}
+		})
+	}
+})
@@ -0,0 +1,431 @@
+// Package gossip provides a cache.Broadcast that propagates invalidations
+// between peer processes over UDP without a broker.
+//
+// Every message is sent to Options.Fanout random peers, which forward it
+// the same way until Options.MaxHops is reached. Peers periodically
+// exchange digests of the messages they have seen in the last
+// Options.History and send each other the ones that are missing, so
+// messages lost by UDP or sent before a peer joined are still delivered.
+// Messages carry the time they were published and are dropped once they
+// are older than Options.History, so clocks of the processes must be
+// synchronized well within it.
+package gossip
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	mathrand "math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/star001007/cache"
+)
+
+const (
+	msgData byte = iota
+	msgDigest
+	msgRequest
+)
+
+// maxPacket keeps packets below the UDP datagram limit.
+const maxPacket = 60000
+
+const dataHeaderLen = 1 + 8 + 1 + 8
+
+var errTooLarge = errors.New("gossip: message is too large")
+
+type Options struct {
+	// Addr is the UDP address to listen on, e.g. ":7946".
+	Addr string
+
+	// Peers are the addresses of other processes. Processes that send
+	// packets to this one are added automatically, so seeding every
+	// process with a few peers is enough.
+	Peers []string
+
+	// Fanout is the number of random peers every message is sent to.
+	// Default is 3.
+	Fanout int
+
+	// MaxHops is how many times a message is forwarded. Default is 3.
+	MaxHops int
+
+	// AntiEntropyInterval is how often a digest of recent messages is
+	// exchanged with a random peer. Default is 10 seconds.
+	AntiEntropyInterval time.Duration
+
+	// History is how long after publishing messages are remembered to
+	// drop duplicates and to repair peers with digests. Older messages
+	// are dropped. Default is one minute.
+	History time.Duration
+}
+
+func (opt *Options) init() {
+	if opt.Fanout <= 0 {
+		opt.Fanout = 3
+	}
+	if opt.MaxHops <= 0 {
+		opt.MaxHops = 3
+	}
+	if opt.AntiEntropyInterval <= 0 {
+		opt.AntiEntropyInterval = 10 * time.Second
+	}
+	if opt.History <= 0 {
+		opt.History = time.Minute
+	}
+}
+
+type message struct {
+	payload []byte
+	at      time.Time
+}
+
+// Gossip is a cache.Broadcast over UDP gossip.
+type Gossip struct {
+	opt  Options
+	conn *net.UDPConn
+
+	mu       sync.Mutex
+	peers    map[string]*net.UDPAddr
+	messages map[uint64]*message
+	fn       func(msg []byte)
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+var _ cache.Broadcast = (*Gossip)(nil)
+
+// New starts listening on Options.Addr. Call Close when done.
+func New(opt *Options) (*Gossip, error) {
+	o := *opt
+	o.init()
+
+	laddr, err := net.ResolveUDPAddr("udp", o.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make(map[string]*net.UDPAddr, len(o.Peers))
+	for _, peer := range o.Peers {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return nil, err
+		}
+		peers[addr.String()] = addr
+	}
+
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Gossip{
+		opt:      o,
+		conn:     conn,
+		peers:    peers,
+		messages: make(map[uint64]*message),
+		stop:     make(chan struct{}),
+	}
+
+	g.wg.Add(2)
+	go g.readLoop()
+	go g.antiEntropyLoop()
+
+	return g, nil
+}
+
+// Addr returns the address the process listens on.
+func (g *Gossip) Addr() net.Addr {
+	return g.conn.LocalAddr()
+}
+
+// Publish sends the message to Options.Fanout random peers.
+func (g *Gossip) Publish(msg []byte) error {
+	if dataHeaderLen+len(msg) > maxPacket {
+		return errTooLarge
+	}
+
+	id := newID()
+	payload := append([]byte(nil), msg...)
+	at := time.Now()
+	fn := g.remember(id, payload, at)
+	if fn != nil {
+		fn(payload)
+	}
+	g.forward(id, g.opt.MaxHops, payload, at, nil)
+	return nil
+}
+
+// Subscribe calls fn with every message, including messages published
+// by this process.
+func (g *Gossip) Subscribe(fn func(msg []byte)) error {
+	g.mu.Lock()
+	g.fn = fn
+	g.mu.Unlock()
+	return nil
+}
+
+// Close stops listening.
+func (g *Gossip) Close() error {
+	var err error
+	g.closeOnce.Do(func() {
+		close(g.stop)
+		err = g.conn.Close()
+		g.wg.Wait()
+	})
+	return err
+}
+
+// remember stores a new message published at the given time and returns
+// the subscriber, or nil when the message was seen before or has expired.
+// Expired messages may have been forgotten already, so they would be
+// delivered again.
+func (g *Gossip) remember(id uint64, payload []byte, at time.Time) func([]byte) {
+	if g.expired(at) {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.messages[id]; ok {
+		return nil
+	}
+	g.messages[id] = &message{
+		payload: payload,
+		at:      at,
+	}
+	if g.fn == nil {
+		return func([]byte) {}
+	}
+	return g.fn
+}
+
+func (g *Gossip) expired(at time.Time) bool {
+	return time.Since(at) > g.opt.History
+}
+
+func (g *Gossip) forward(id uint64, hops int, payload []byte, at time.Time, exclude *net.UDPAddr) {
+	pkt := dataPacket(id, hops, payload, at)
+	for _, peer := range g.randomPeers(g.opt.Fanout, exclude) {
+		g.send(peer, pkt)
+	}
+}
+
+func (g *Gossip) readLoop() {
+	defer g.wg.Done()
+
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-g.stop:
+				return
+			default:
+				continue
+			}
+		}
+		g.handle(append([]byte(nil), buf[:n]...), addr)
+	}
+}
+
+func (g *Gossip) handle(pkt []byte, from *net.UDPAddr) {
+	if len(pkt) == 0 {
+		return
+	}
+	g.addPeer(from)
+
+	switch pkt[0] {
+	case msgData:
+		if len(pkt) < dataHeaderLen {
+			return
+		}
+		id := binary.LittleEndian.Uint64(pkt[1:])
+		hops := int(pkt[9])
+		at := time.Unix(0, int64(binary.LittleEndian.Uint64(pkt[10:])))
+		payload := pkt[dataHeaderLen:]
+
+		fn := g.remember(id, payload, at)
+		if fn == nil {
+			return
+		}
+		fn(payload)
+		if hops > 0 {
+			g.forward(id, hops-1, payload, at, from)
+		}
+	case msgDigest:
+		g.handleDigest(decodeIDs(pkt[1:]), from)
+	case msgRequest:
+		for _, id := range decodeIDs(pkt[1:]) {
+			g.mu.Lock()
+			m, ok := g.messages[id]
+			g.mu.Unlock()
+			if ok && !g.expired(m.at) {
+				g.send(from, dataPacket(id, 0, m.payload, m.at))
+			}
+		}
+	}
+}
+
+// handleDigest pushes messages the peer is missing
+// and requests messages this process is missing.
+func (g *Gossip) handleDigest(theirs []uint64, from *net.UDPAddr) {
+	seen := make(map[uint64]struct{}, len(theirs))
+	var missing []uint64
+
+	g.mu.Lock()
+	for _, id := range theirs {
+		seen[id] = struct{}{}
+		if _, ok := g.messages[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	var push []uint64
+	for id := range g.messages {
+		if _, ok := seen[id]; !ok {
+			push = append(push, id)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, id := range push {
+		g.mu.Lock()
+		m, ok := g.messages[id]
+		g.mu.Unlock()
+		if ok && !g.expired(m.at) {
+			g.send(from, dataPacket(id, 0, m.payload, m.at))
+		}
+	}
+	for _, pkt := range idPackets(msgRequest, missing) {
+		g.send(from, pkt)
+	}
+}
+
+func (g *Gossip) antiEntropyLoop() {
+	defer g.wg.Done()
+
+	ticker := time.NewTicker(g.opt.AntiEntropyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			g.antiEntropy()
+		case <-g.stop:
+			return
+		}
+	}
+}
+
+func (g *Gossip) antiEntropy() {
+	expired := time.Now().Add(-g.opt.History)
+
+	g.mu.Lock()
+	ids := make([]uint64, 0, len(g.messages))
+	for id, m := range g.messages {
+		if m.at.Before(expired) {
+			delete(g.messages, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	g.mu.Unlock()
+
+	peers := g.randomPeers(1, nil)
+	if len(peers) == 0 {
+		return
+	}
+
+	pkts := idPackets(msgDigest, ids)
+	if len(pkts) == 0 {
+		// An empty digest still pulls the messages of the peer.
+		pkts = [][]byte{{msgDigest}}
+	}
+	for _, pkt := range pkts {
+		g.send(peers[0], pkt)
+	}
+}
+
+func (g *Gossip) addPeer(addr *net.UDPAddr) {
+	key := addr.String()
+
+	g.mu.Lock()
+	if _, ok := g.peers[key]; !ok {
+		g.peers[key] = addr
+	}
+	g.mu.Unlock()
+}
+
+func (g *Gossip) randomPeers(n int, exclude *net.UDPAddr) []*net.UDPAddr {
+	g.mu.Lock()
+	peers := make([]*net.UDPAddr, 0, len(g.peers))
+	for key, addr := range g.peers {
+		if exclude != nil && key == exclude.String() {
+			continue
+		}
+		peers = append(peers, addr)
+	}
+	g.mu.Unlock()
+
+	mathrand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	if len(peers) > n {
+		peers = peers[:n]
+	}
+	return peers
+}
+
+func (g *Gossip) send(addr *net.UDPAddr, pkt []byte) {
+	_, _ = g.conn.WriteToUDP(pkt, addr)
+}
+
+func dataPacket(id uint64, hops int, payload []byte, at time.Time) []byte {
+	pkt := make([]byte, dataHeaderLen, dataHeaderLen+len(payload))
+	pkt[0] = msgData
+	binary.LittleEndian.PutUint64(pkt[1:], id)
+	pkt[9] = byte(hops)
+	binary.LittleEndian.PutUint64(pkt[10:], uint64(at.UnixNano()))
+	return append(pkt, payload...)
+}
+
+// idPackets splits ids into packets of the given type.
+func idPackets(typ byte, ids []uint64) [][]byte {
+	const perPacket = (maxPacket - 1) / 8
+
+	var pkts [][]byte
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > perPacket {
+			n = perPacket
+		}
+
+		pkt := make([]byte, 1+8*n)
+		pkt[0] = typ
+		for i, id := range ids[:n] {
+			binary.LittleEndian.PutUint64(pkt[1+8*i:], id)
+		}
+		pkts = append(pkts, pkt)
+		ids = ids[n:]
+	}
+	return pkts
+}
+
+func decodeIDs(b []byte) []uint64 {
+	ids := make([]uint64, 0, len(b)/8)
+	for ; len(b) >= 8; b = b[8:] {
+		ids = append(ids, binary.LittleEndian.Uint64(b))
+	}
+	return ids
+}
+
+func newID() uint64 {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return binary.LittleEndian.Uint64(b[:])
+}
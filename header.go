@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// payloadMagic starts payloads written with Options.PayloadHeader. 0xc1
+// is never used by msgpack and never starts valid UTF-8, so msgpack
+// payloads and raw strings written without a header are not mistaken for
+// one.
+var payloadMagic = []byte{0xc1, 0xca}
+
+const (
+	payloadHeaderLen = 5
+	// payloadFormatVersion is the version of the header and of the
+	// payload that follows it.
+	payloadFormatVersion = 1
+)
+
+// Codecs recorded in the payload header.
+const (
+	codecRaw     = 0 // string or []byte stored as is, without trailer
+	codecMsgpack = 1 // msgpack payload with trailer
+)
+
+var errCorruptHeader = errors.New("cache: corrupt payload header")
+
+// rawPayload is a value that is already a stored payload, e.g. a stream
+// chunk or a reference, and is written as is without a header.
+type rawPayload []byte
+
+// payloadHeader is the header of payloads written with
+// Options.PayloadHeader:
+//
+//	magic (2 bytes) | format version | codec | compression method
+type payloadHeader struct {
+	version     byte
+	codec       byte
+	compression byte
+}
+
+func appendPayloadHeader(dst []byte, codec, compression byte) []byte {
+	dst = append(dst, payloadMagic...)
+	return append(dst, payloadFormatVersion, codec, compression)
+}
+
+// splitPayloadHeader returns the header and the rest of the payload, or
+// false for payloads written without a header.
+func splitPayloadHeader(b []byte) (payloadHeader, []byte, bool, error) {
+	if !bytes.HasPrefix(b, payloadMagic) {
+		return payloadHeader{}, b, false, nil
+	}
+	if len(b) < payloadHeaderLen {
+		return payloadHeader{}, nil, false, errCorruptHeader
+	}
+
+	hdr := payloadHeader{
+		version:     b[2],
+		codec:       b[3],
+		compression: b[4],
+	}
+	if hdr.version > payloadFormatVersion {
+		return payloadHeader{}, nil, false,
+			fmt.Errorf("cache: unknown payload format version %d", hdr.version)
+	}
+	if hdr.codec > codecMsgpack {
+		return payloadHeader{}, nil, false, fmt.Errorf("cache: unknown payload codec %d", hdr.codec)
+	}
+	return hdr, b[payloadHeaderLen:], true, nil
+}
+
+// withPayloadHeader prefixes the marshaled value with the payload header
+// when Options.PayloadHeader is set. Values marshaled with
+// Options.Marshal and stored payloads are left as they are.
+func (cd *Cache) withPayloadHeader(value interface{}, b []byte) []byte {
+	if !cd.opt.PayloadHeader || b == nil {
+		return b
+	}
+
+	switch value.(type) {
+	case rawPayload:
+		return b
+	case []byte, string:
+		return append(appendPayloadHeader(make([]byte, 0, payloadHeaderLen+len(b)), codecRaw, noCompression), b...)
+	}
+	if cd.opt.Marshal != nil {
+		return b
+	}
+	compression := b[len(b)-1] & compressionMethodMask
+	return append(appendPayloadHeader(make([]byte, 0, payloadHeaderLen+len(b)), codecMsgpack, compression), b...)
+}
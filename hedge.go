@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	hedgeSamples      = 1000
+	hedgeRecalcEvery  = 100
+	defaultHedgeDelay = 10 * time.Millisecond
+)
+
+// latencyTracker keeps recent Redis GET latencies and the delay at
+// Options.HedgePercentile of them.
+type latencyTracker struct {
+	percentile float64
+	minDelay   time.Duration
+
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	added   int
+
+	delay int64 // atomic time.Duration
+}
+
+func newLatencyTracker(percentile float64, minDelay time.Duration) *latencyTracker {
+	if percentile > 1 {
+		percentile = 1
+	}
+	t := &latencyTracker{
+		percentile: percentile,
+		minDelay:   minDelay,
+		samples:    make([]time.Duration, 0, hedgeSamples),
+	}
+	if minDelay < defaultHedgeDelay {
+		t.delay = int64(defaultHedgeDelay)
+	} else {
+		t.delay = int64(minDelay)
+	}
+	return t
+}
+
+func (t *latencyTracker) add(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) < hedgeSamples {
+		t.samples = append(t.samples, d)
+	} else {
+		t.samples[t.next] = d
+		t.next = (t.next + 1) % hedgeSamples
+	}
+
+	t.added++
+	if t.added%hedgeRecalcEvery != 0 {
+		return
+	}
+
+	sorted := append([]time.Duration(nil), t.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	delay := sorted[int(float64(len(sorted)-1)*t.percentile)]
+	if delay < t.minDelay {
+		delay = t.minDelay
+	}
+	atomic.StoreInt64(&t.delay, int64(delay))
+}
+
+// hedgeDelay returns how long a GET may take before it is hedged.
+func (t *latencyTracker) hedgeDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.delay))
+}
+
+// redisGet gets the key from Redis. With Options.HedgePercentile it sends
+// a second GET, to Options.HedgeRedis if set, when the first one is
+// slower than the percentile of recent GETs and returns the first reply.
+func (cd *Cache) redisGet(key string) ([]byte, error) {
+	if cd.latencies == nil {
+		return cd.opt.Redis.Get(key).Bytes()
+	}
+
+	type result struct {
+		b   []byte
+		err error
+	}
+	ch := make(chan result, 2)
+	get := func(r RemoteStore, primary bool) {
+		start := time.Now()
+		b, err := r.Get(key).Bytes()
+		if primary && (err == nil || err == redis.Nil) {
+			cd.latencies.add(time.Since(start))
+		}
+		ch <- result{b, err}
+	}
+
+	go get(cd.opt.Redis, true)
+
+	timer := time.NewTimer(cd.latencies.hedgeDelay())
+	defer timer.Stop()
+
+	pending := 1
+	select {
+	case res := <-ch:
+		return res.b, res.err
+	case <-timer.C:
+	}
+
+	hedge := cd.opt.HedgeRedis
+	if hedge == nil {
+		hedge = cd.opt.Redis
+	}
+	go get(hedge, false)
+	pending++
+	atomic.AddUint64(&cd.hedged, 1)
+
+	var res result
+	for ; pending > 0; pending-- {
+		res = <-ch
+		if res.err == nil || res.err == redis.Nil {
+			return res.b, res.err
+		}
+	}
+	return res.b, res.err
+}
@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+const defaultHotKeysKey = "cache:hotkeys"
+
+// hotKeySketch estimates the most read keys with the Space-Saving
+// algorithm: it keeps a fixed number of counters and, when a new key
+// arrives, takes over the counter of the least read key.
+type hotKeySketch struct {
+	mu     sync.Mutex
+	size   int
+	counts map[string]*hotKey
+	heap   hotKeyHeap
+}
+
+type hotKey struct {
+	key   string
+	count uint64
+	index int
+}
+
+func newHotKeySketch(size int) *hotKeySketch {
+	return &hotKeySketch{
+		size:   size,
+		counts: make(map[string]*hotKey, size),
+	}
+}
+
+func (s *hotKeySketch) add(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.counts[key]; ok {
+		k.count++
+		heap.Fix(&s.heap, k.index)
+		return
+	}
+
+	if len(s.heap) < s.size {
+		k := &hotKey{key: key, count: 1}
+		heap.Push(&s.heap, k)
+		s.counts[key] = k
+		return
+	}
+
+	k := s.heap[0]
+	delete(s.counts, k.key)
+	k.key = key
+	k.count++
+	s.counts[key] = k
+	heap.Fix(&s.heap, 0)
+}
+
+// top returns the keys ordered from the most read.
+func (s *hotKeySketch) top() []string {
+	s.mu.Lock()
+	hot := append(hotKeyHeap(nil), s.heap...)
+	s.mu.Unlock()
+
+	sort.Slice(hot, func(i, j int) bool {
+		return hot[i].count > hot[j].count
+	})
+	keys := make([]string, len(hot))
+	for i, k := range hot {
+		keys[i] = k.key
+	}
+	return keys
+}
+
+// decay halves the counts so the sketch follows recent reads.
+func (s *hotKeySketch) decay() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hot := s.heap[:0]
+	for _, k := range s.heap {
+		k.count /= 2
+		if k.count == 0 {
+			delete(s.counts, k.key)
+			continue
+		}
+		hot = append(hot, k)
+	}
+	s.heap = hot
+	heap.Init(&s.heap)
+}
+
+type hotKeyHeap []*hotKey
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotKeyHeap) Push(x interface{}) {
+	k := x.(*hotKey)
+	k.index = len(*h)
+	*h = append(*h, k)
+}
+
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	k := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return k
+}
+
+//------------------------------------------------------------------------------
+
+// HotKeys returns the most read keys of this process ordered from the
+// hottest, or nil when Options.HotKeys is zero.
+func (cd *Cache) HotKeys() []string {
+	if cd.hotKeys == nil {
+		return nil
+	}
+	return cd.hotKeys.top()
+}
+
+// PublishHotKeys stores the hot keys of this process in Redis under
+// Options.HotKeysKey, so instances that start later can Prewarm them.
+// Counts are halved after every publish so the list follows recent reads.
+func (cd *Cache) PublishHotKeys() error {
+	if cd.hotKeys == nil || !cd.useRedis() {
+		return nil
+	}
+	if cd.opt.ReadOnly {
+		return ErrReadOnly
+	}
+
+	b, err := msgpack.Marshal(cd.hotKeys.top())
+	if err != nil {
+		return err
+	}
+	b, err = cd.sign(cd.opt.HotKeysKey, b)
+	if err != nil {
+		return err
+	}
+	if err := cd.opt.Redis.Set(cd.opt.HotKeysKey, b, cd.hotKeysTTL()).Err(); err != nil {
+		return err
+	}
+
+	cd.hotKeys.decay()
+	return nil
+}
+
+// hotKeysTTL keeps the published list around for a few missed publishes.
+func (cd *Cache) hotKeysTTL() time.Duration {
+	if cd.opt.HotKeysPublishInterval > 0 {
+		return 3 * cd.opt.HotKeysPublishInterval
+	}
+	return time.Hour
+}
+
+// Prewarm loads the hot keys published by other instances from Redis into
+// the local cache and returns the number of loaded keys. Call it after
+// New and before the instance receives traffic, e.g. during rolling
+// deploys. Keys missing in Redis are skipped.
+func (cd *Cache) Prewarm(ctx context.Context) (int, error) {
+	if !cd.useRedis() || !cd.useLocalCache() {
+		return 0, nil
+	}
+
+	b, err := cd.opt.Redis.Get(cd.opt.HotKeysKey).Bytes()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	b, err = cd.verify(cd.opt.HotKeysKey, b)
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	if err := msgpack.Unmarshal(b, &keys); err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		_, err := cd.getRedisBytes(key, false)
+		if err == ErrCacheMiss {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (cd *Cache) startHotKeysPublisher() {
+	cd.stopHotKeys = make(chan struct{})
+	cd.hotKeysDone = make(chan struct{})
+
+	go func() {
+		defer close(cd.hotKeysDone)
+
+		ticker := time.NewTicker(cd.opt.HotKeysPublishInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-cd.stopHotKeys:
+				return
+			case <-ticker.C:
+				if err := cd.PublishHotKeys(); err != nil {
+					atomic.AddUint64(&cd.errs, 1)
+				}
+			}
+		}
+	}()
+}
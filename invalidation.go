@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// invalidationVersion is the version of invalidation.proto.
+const invalidationVersion = 1
+
+var errInvalidBroadcast = errors.New("cache: invalid broadcast message")
+
+type invalidationOp uint64
+
+const (
+	opUnspecified invalidationOp = iota
+	opSet
+	opDelete
+)
+
+// invalidation mirrors the Invalidation message of invalidation.proto.
+// It is encoded by hand to avoid depending on a protobuf runtime.
+type invalidation struct {
+	Version uint32
+	Origin  uint64
+	Entries []invalidationEntry
+}
+
+type invalidationEntry struct {
+	Key        string
+	Namespace  string
+	Generation uint64
+	Op         invalidationOp
+	Hash       uint64
+}
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendTag(b []byte, field int, wire int) []byte {
+	return appendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func (m *invalidation) marshal() []byte {
+	b := make([]byte, 0, 64)
+	if m.Version != 0 {
+		b = appendTag(b, 1, wireVarint)
+		b = appendUvarint(b, uint64(m.Version))
+	}
+	if m.Origin != 0 {
+		b = appendTag(b, 2, wireFixed64)
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], m.Origin)
+		b = append(b, buf[:]...)
+	}
+	for i := range m.Entries {
+		b = appendProtoBytes(b, 3, m.Entries[i].marshal())
+	}
+	return b
+}
+
+func (e *invalidationEntry) marshal() []byte {
+	b := make([]byte, 0, len(e.Key)+len(e.Namespace)+16)
+	if e.Key != "" {
+		b = appendProtoBytes(b, 1, []byte(e.Key))
+	}
+	if e.Namespace != "" {
+		b = appendProtoBytes(b, 2, []byte(e.Namespace))
+	}
+	if e.Generation != 0 {
+		b = appendTag(b, 3, wireVarint)
+		b = appendUvarint(b, e.Generation)
+	}
+	if e.Op != opUnspecified {
+		b = appendTag(b, 4, wireVarint)
+		b = appendUvarint(b, uint64(e.Op))
+	}
+	if e.Hash != 0 {
+		b = appendTag(b, 5, wireFixed64)
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], e.Hash)
+		b = append(b, buf[:]...)
+	}
+	return b
+}
+
+func (m *invalidation) unmarshal(b []byte) error {
+	return walkProto(b, func(field int, wire int, v uint64, data []byte) error {
+		switch {
+		case field == 1 && wire == wireVarint:
+			m.Version = uint32(v)
+		case field == 2 && wire == wireFixed64:
+			m.Origin = v
+		case field == 3 && wire == wireBytes:
+			var e invalidationEntry
+			if err := e.unmarshal(data); err != nil {
+				return err
+			}
+			m.Entries = append(m.Entries, e)
+		}
+		return nil
+	})
+}
+
+func (e *invalidationEntry) unmarshal(b []byte) error {
+	return walkProto(b, func(field int, wire int, v uint64, data []byte) error {
+		switch {
+		case field == 1 && wire == wireBytes:
+			e.Key = string(data)
+		case field == 2 && wire == wireBytes:
+			e.Namespace = string(data)
+		case field == 3 && wire == wireVarint:
+			e.Generation = v
+		case field == 4 && wire == wireVarint:
+			e.Op = invalidationOp(v)
+		case field == 5 && wire == wireFixed64:
+			e.Hash = v
+		}
+		return nil
+	})
+}
+
+// walkProto calls fn for every field of a protobuf message. Varint and
+// fixed fields are passed in v, length-delimited fields in data.
+// Unknown fields are skipped by the callers.
+func walkProto(b []byte, fn func(field int, wire int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errInvalidBroadcast
+		}
+		b = b[n:]
+
+		field, wire := int(tag>>3), int(tag&7)
+		var v uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errInvalidBroadcast
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errInvalidBroadcast
+			}
+			v = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errInvalidBroadcast
+			}
+			v = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return errInvalidBroadcast
+			}
+			data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return errInvalidBroadcast
+		}
+
+		if err := fn(field, wire, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// Package kafkabroadcast provides a cache.Broadcast over Kafka, so fleets
+// that already run Kafka can propagate local cache invalidations without
+// Redis pub/sub.
+package kafkabroadcast
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/star001007/cache"
+)
+
+const (
+	// queueSize is the number of messages Publish queues while the
+	// writer is busy.
+	queueSize = 1024
+	// maxBatch is the number of queued messages written at once.
+	maxBatch = 100
+	// closeTimeout bounds how long Close waits for queued messages.
+	closeTimeout = time.Second
+)
+
+// ErrQueueFull is returned by Publish when the messages aren't written
+// as fast as they are published, e.g. while the brokers are unreachable.
+var ErrQueueFull = errors.New("kafkabroadcast: publish queue is full")
+
+// Broadcast publishes invalidations to a Kafka topic. Every process reads
+// the topic without a consumer group, so the topic must have a single
+// partition.
+type Broadcast struct {
+	brokers []string
+	topic   string
+	writer  *kafka.Writer
+
+	queue     chan []byte
+	closing   chan struct{}
+	closeOnce sync.Once
+	stop      context.CancelFunc
+	stopped   chan struct{}
+
+	mu     sync.Mutex
+	reader *kafka.Reader
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var _ cache.Broadcast = (*Broadcast)(nil)
+
+// New returns a Broadcast on the topic.
+func New(brokers []string, topic string) *Broadcast {
+	ctx, stop := context.WithCancel(context.Background())
+	b := &Broadcast{
+		brokers: brokers,
+		topic:   topic,
+		writer: kafka.NewWriter(kafka.WriterConfig{
+			Brokers: brokers,
+			Topic:   topic,
+			// Invalidations are small and latency matters more than
+			// batching.
+			BatchTimeout: 10 * time.Millisecond,
+		}),
+		queue:   make(chan []byte, queueSize),
+		closing: make(chan struct{}),
+		stop:    stop,
+		stopped: make(chan struct{}),
+	}
+	go b.publish(ctx)
+	return b
+}
+
+// Publish queues the message and returns without waiting for Kafka, so
+// cache writes don't wait for the brokers. Messages that fail to be
+// written after the retries of the writer are dropped.
+func (b *Broadcast) Publish(msg []byte) error {
+	select {
+	case <-b.closing:
+		return context.Canceled
+	default:
+	}
+	select {
+	case b.queue <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (b *Broadcast) publish(ctx context.Context) {
+	defer close(b.stopped)
+	for {
+		select {
+		case msg := <-b.queue:
+			b.write(ctx, msg)
+		case <-b.closing:
+			// Write the messages published before Close.
+			for {
+				select {
+				case msg := <-b.queue:
+					b.write(ctx, msg)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write writes msg together with the messages queued meanwhile.
+func (b *Broadcast) write(ctx context.Context, msg []byte) {
+	msgs := []kafka.Message{{Value: msg}}
+	for len(msgs) < maxBatch {
+		select {
+		case msg := <-b.queue:
+			msgs = append(msgs, kafka.Message{Value: msg})
+		default:
+			_ = b.writer.WriteMessages(ctx, msgs...)
+			return
+		}
+	}
+	_ = b.writer.WriteMessages(ctx, msgs...)
+}
+
+// Subscribe reads messages published after it is called.
+func (b *Broadcast) Subscribe(fn func(msg []byte)) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: b.brokers,
+		Topic:   b.topic,
+		MaxWait: 100 * time.Millisecond,
+	})
+	if err := reader.SetOffset(kafka.LastOffset); err != nil {
+		_ = reader.Close()
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			m, err := reader.ReadMessage(ctx)
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+					continue
+				}
+			}
+			fn(m.Value)
+		}
+	}()
+
+	b.mu.Lock()
+	b.reader = reader
+	b.cancel = cancel
+	b.done = done
+	b.mu.Unlock()
+	return nil
+}
+
+// Close waits up to a second for the queued messages to be written.
+func (b *Broadcast) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closing)
+		timer := time.AfterFunc(closeTimeout, b.stop)
+		<-b.stopped
+		timer.Stop()
+		b.stop()
+	})
+
+	b.mu.Lock()
+	reader, cancel, done := b.reader, b.cancel, b.done
+	b.reader = nil
+	b.mu.Unlock()
+
+	if reader != nil {
+		cancel()
+		<-done
+		_ = reader.Close()
+	}
+	return b.writer.Close()
+}
@@ -0,0 +1,56 @@
+package kafkabroadcast_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/star001007/cache/kafkabroadcast"
+)
+
+func TestGinkgo(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "kafkabroadcast")
+}
+
+var _ = Describe("kafkabroadcast", func() {
+	It("doesn't wait for the brokers in Publish", func() {
+		// Nothing listens on port 1.
+		b := kafkabroadcast.New([]string{"127.0.0.1:1"}, "invalidations")
+
+		start := time.Now()
+		var err error
+		for i := 0; i < 10000 && err == nil; i++ {
+			err = b.Publish([]byte("msg"))
+		}
+		Expect(err).To(Equal(kafkabroadcast.ErrQueueFull))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+		start = time.Now()
+		_ = b.Close()
+		Expect(time.Since(start)).To(BeNumerically("<", 3*time.Second))
+		Expect(b.Publish([]byte("msg"))).To(HaveOccurred())
+	})
+
+	It("delivers published messages", func() {
+		brokers := os.Getenv("KAFKA_BROKERS")
+		if brokers == "" {
+			Skip("KAFKA_BROKERS is not set")
+		}
+
+		b := kafkabroadcast.New(strings.Split(brokers, ","), "cache-invalidations-test")
+		defer b.Close()
+
+		got := make(chan string, 10)
+		Expect(b.Subscribe(func(msg []byte) {
+			got <- string(msg)
+		})).To(Succeed())
+
+		Expect(b.Publish([]byte("msg"))).To(Succeed())
+		Eventually(got, 10*time.Second).Should(Receive(Equal("msg")))
+	})
+})
@@ -0,0 +1,56 @@
+package cache
+
+// LocalStore is a local cache tier. *fastcache.Cache implements it,
+// and boltstore provides one persisted on disk.
+type LocalStore interface {
+	// HasGet appends the value of the key to dst
+	// and reports whether the key exists.
+	HasGet(dst, k []byte) ([]byte, bool)
+	Set(k, v []byte)
+	Del(k []byte)
+}
+
+// newLocalStore combines Options.LocalCache and Options.DiskCache
+// into the local tier used by the cache.
+func newLocalStore(opt *Options) LocalStore {
+	switch {
+	case opt.LocalCache != nil && opt.DiskCache != nil:
+		return &tieredStore{
+			mem:  opt.LocalCache,
+			disk: opt.DiskCache,
+		}
+	case opt.LocalCache != nil:
+		return opt.LocalCache
+	default:
+		return opt.DiskCache
+	}
+}
+
+// tieredStore keeps values both in memory and on disk
+// and promotes values found only on disk to memory.
+type tieredStore struct {
+	mem  LocalStore
+	disk LocalStore
+}
+
+func (s *tieredStore) HasGet(dst, k []byte) ([]byte, bool) {
+	if b, ok := s.mem.HasGet(dst, k); ok {
+		return b, true
+	}
+
+	b, ok := s.disk.HasGet(dst, k)
+	if ok {
+		s.mem.Set(k, b[len(dst):])
+	}
+	return b, ok
+}
+
+func (s *tieredStore) Set(k, v []byte) {
+	s.mem.Set(k, v)
+	s.disk.Set(k, v)
+}
+
+func (s *tieredStore) Del(k []byte) {
+	s.mem.Del(k)
+	s.disk.Del(k)
+}
@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// SetMap caches every value of m under prefix + map key with the given TTL
+// using a single Redis transaction. The values are written like Set,
+// except that keys a Batch doesn't support, e.g. bundled keys, are set
+// one by one after the transaction. Nothing is written when a value can't
+// be marshaled.
+func (cd *Cache) SetMap(
+	ctx context.Context, prefix string, m map[string]interface{}, ttl time.Duration,
+) error {
+	if cd.opt.ReadOnly {
+		return ErrReadOnly
+	}
+	if cd.opt.Redis == nil && cd.local == nil {
+		return errRedisLocalCacheNil
+	}
+
+	items := make([]*Item, 0, len(m))
+	for k, v := range m {
+		items = append(items, &Item{Ctx: ctx, Key: prefix + k, Value: v, TTL: ttl})
+	}
+
+	writes := make([]batchWrite, 0, len(items))
+	var unsupported []*Item
+	for _, item := range items {
+		w, err := cd.prepareWrite(item)
+		if err == errBatchUnsupported {
+			unsupported = append(unsupported, item)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		writes = append(writes, w)
+	}
+	return cd.setMany(writes, unsupported)
+}
+
+// FanoutSet caches the same value under keyTemplate with "{id}" replaced
+// by every id like SetMap, e.g. to push a config change to per-user
+// entries. The value is marshaled once.
+func (cd *Cache) FanoutSet(
+	ctx context.Context, keyTemplate string, ids []string, value interface{}, ttl time.Duration,
+) error {
+	if cd.opt.ReadOnly {
+		return ErrReadOnly
+	}
+	if cd.opt.Redis == nil && cd.local == nil {
+		return errRedisLocalCacheNil
+	}
+
+	writes := make([]batchWrite, 0, len(ids))
+	var unsupported []*Item
+	var shared []byte
+	for _, id := range ids {
+		item := &Item{
+			Ctx:   ctx,
+			Key:   strings.Replace(keyTemplate, "{id}", id, -1),
+			Value: value,
+			TTL:   ttl,
+		}
+
+		var w batchWrite
+		// Keys with a plain format may use different formats.
+		_, plain := cd.plainFormat(item.Key)
+		err := cd.checkBatchItem(item)
+		switch {
+		case err == errBatchUnsupported:
+			unsupported = append(unsupported, item)
+			continue
+		case err != nil:
+			return err
+		case shared != nil && !plain:
+			w, err = cd.prepareEncoded(item, value, shared)
+		default:
+			w, err = cd.prepareWrite(item)
+			if err == nil && !plain {
+				shared = w.b
+			}
+		}
+		if err != nil {
+			return err
+		}
+		writes = append(writes, w)
+	}
+	return cd.setMany(writes, unsupported)
+}
+
+// setMany sends the prepared writes in a single transaction and then sets
+// the unsupported items one by one. It returns the first error.
+func (cd *Cache) setMany(writes []batchWrite, unsupported []*Item) error {
+	var firstErr error
+	fail := func(_ batchWrite, err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	stored := cd.writeBatch(writes, true, fail)
+	for _, w := range stored {
+		if err := cd.updateDependencies(w.item); err != nil {
+			fail(w, err)
+		}
+	}
+
+	for _, item := range unsupported {
+		if err := cd.Set(item); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// GetMap gets the stored bytes for prefix + key for every key, checking the
+// local cache first and fetching the rest with a single Redis pipeline.
+// Keys under Options.BundlePrefixes are read from their bundles instead,
+// and references set with SetRef are followed. Missing keys are absent
+// from the result, as are keys that failed; the first error is returned
+// with the other values. Values can be decoded with UnmarshalKey.
+func (cd *Cache) GetMap(ctx context.Context, prefix string, keys []string) (map[string][]byte, error) {
+	m, errs := cd.getMany(prefix, keys)
+	for _, k := range keys {
+		if err, ok := errs[k]; ok {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// getMany is GetMap returning the errors of the failed keys.
+func (cd *Cache) getMany(prefix string, keys []string) (map[string][]byte, map[string]error) {
+	m := make(map[string][]byte, len(keys))
+	errs := make(map[string]error)
+
+	missing := keys
+	if cd.useLocalCache() {
+		missing = nil
+		for _, k := range keys {
+			key := prefix + k
+			if !cd.recentlyWritten(key) {
+				if b, ok, expired := cd.localGet(key); ok && !expired {
+					m[k] = b
+					continue
+				}
+			}
+			missing = append(missing, k)
+		}
+	}
+
+	if len(missing) == 0 || !cd.useRedis() {
+		return m, errs
+	}
+
+	// Bundled keys are read with their whole bundle.
+	unbundled := missing[:0:0]
+	for _, k := range missing {
+		bundle, ok := cd.bundlePrefix(prefix + k)
+		if !ok {
+			unbundled = append(unbundled, k)
+			continue
+		}
+		b, err := cd.bundleGet(bundle, prefix+k, false)
+		if err == nil {
+			m[k] = b
+		} else if err != ErrCacheMiss {
+			errs[k] = err
+		}
+	}
+	missing = unbundled
+
+	cmds := make([]*redis.StringCmd, len(missing))
+	cd.pipelined(func(pipe RemoteStore) {
+		for i, k := range missing {
+			cmds[i] = pipe.Get(prefix + k)
+		}
+	})
+
+	for i, cmd := range cmds {
+		k := missing[i]
+		b, err := cmd.Bytes()
+		if err == nil {
+			b, err = cd.verify(prefix+k, b)
+		}
+		if err == nil && isTombstone(b) {
+			err = redis.Nil
+		}
+		if target, ok := decodeRef(b); ok && err == nil {
+			b, err = cd.deref(target)
+			if err != nil && err != ErrCacheMiss {
+				// deref counts the error.
+				errs[k] = err
+				continue
+			}
+			if err == ErrCacheMiss {
+				err = redis.Nil
+			}
+		}
+		if err != nil {
+			if err != redis.Nil {
+				atomic.AddUint64(&cd.errs, 1)
+				errs[k] = err
+			}
+			if cd.opt.StatsEnabled {
+				atomic.AddUint64(&cd.misses, 1)
+			}
+			continue
+		}
+
+		if cd.opt.StatsEnabled {
+			atomic.AddUint64(&cd.hits, 1)
+		}
+
+		m[k] = b
+		if cd.useLocalCache() {
+			cd.localSet(prefix+k, b)
+		}
+	}
+	return m, errs
+}
+
+// MGet gets the values of the keys like GetMap, checking the local cache
+// first, fetching the rest with a single Redis pipeline, and back-filling
+// the local cache. Every found value is decoded into dst(key); missing
+// keys are skipped. Keys that fail to be read or decoded are reported in
+// a *MultiError without stopping the others.
+func (cd *Cache) MGet(ctx context.Context, keys []string, dst func(key string) interface{}) error {
+	m, errs := cd.getMany("", keys)
+	for key, b := range m {
+		if err := cd.UnmarshalKey(key, b, dst(key)); err != nil {
+			errs[key] = err
+		}
+	}
+	return multiError(errs, len(keys))
+}
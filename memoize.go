@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Memoize returns fn wrapped to cache its results with Once under the key
+// returned by keyFn, so concurrent calls with the same key run fn once.
+// fn should be pure: the result must depend only on the argument, and
+// keyFn must return distinct keys for arguments with distinct results.
+// A nil keyFn derives keys from fn and the argument with KeyFunc.
+// Errors returned by fn are not cached.
+func Memoize[A, R any](
+	cd *Cache,
+	fn func(context.Context, A) (R, error),
+	keyFn func(A) string,
+	ttl time.Duration,
+) func(context.Context, A) (R, error) {
+	if keyFn == nil {
+		keyFn = KeyFunc[A](fn, "")
+	}
+	return func(ctx context.Context, arg A) (R, error) {
+		var res R
+		err := cd.Once(&Item{
+			Ctx:   ctx,
+			Key:   keyFn(arg),
+			Value: &res,
+			TTL:   ttl,
+			Do: func(item *Item) (interface{}, error) {
+				return fn(item.Context(), arg)
+			},
+		})
+		return res, err
+	}
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var errMetadataUnsupported = errors.New("cache: Redis client does not support HSETNX and HINCRBY")
+
+type metaHasher interface {
+	HSet(key string, values ...interface{}) *redis.IntCmd
+	HSetNX(key, field string, value interface{}) *redis.BoolCmd
+	HIncrBy(key, field string, incr int64) *redis.IntCmd
+	HGetAll(key string) *redis.StringStringMapCmd
+}
+
+func metaKey(key string) string {
+	return metaPrefix + key
+}
+
+func defaultWriter() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
+
+// KeyMetadata describes who wrote a key and when, see
+// Options.TrackMetadata.
+type KeyMetadata struct {
+	// CreatedAt is when the key was first written since it was last
+	// deleted or expired.
+	CreatedAt time.Time
+	// UpdatedAt is when the key was last written.
+	UpdatedAt time.Time
+	// Writer is Options.Writer of the process that last wrote the key.
+	Writer string
+	// Version is the number of writes since CreatedAt.
+	Version int64
+}
+
+// writeMetadata records the write of the item in its metadata hash.
+func (cd *Cache) writeMetadata(item *Item) error {
+	if _, ok := cd.opt.Redis.(metaHasher); !ok {
+		return errMetadataUnsupported
+	}
+
+	key := metaKey(item.Key)
+	now := cd.now().UnixNano() / int64(time.Millisecond)
+	ttl := item.ttl()
+
+	var cmds []redis.Cmder
+	cd.txPipelined(func(pipe RemoteStore) {
+		h := pipe.(metaHasher)
+		cmds = append(cmds,
+			h.HSetNX(key, "created", now),
+			h.HSet(key, "updated", now, "writer", cd.opt.Writer),
+			h.HIncrBy(key, "version", 1),
+		)
+		if e, ok := pipe.(expirer); ok {
+			if ttl > 0 {
+				cmds = append(cmds, e.PExpire(key, ttl))
+			} else {
+				cmds = append(cmds, e.Persist(key))
+			}
+		}
+	})
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Describe returns the metadata of the key recorded with
+// Options.TrackMetadata. It returns ErrCacheMiss when the key has none,
+// e.g. because it was written without TrackMetadata.
+func (cd *Cache) Describe(ctx context.Context, key string) (*KeyMetadata, error) {
+	if !cd.useRedis() {
+		return nil, ErrCacheMiss
+	}
+	h, ok := cd.opt.Redis.(metaHasher)
+	if !ok {
+		return nil, errMetadataUnsupported
+	}
+
+	m, err := h.HGetAll(metaKey(key)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return nil, ErrCacheMiss
+	}
+
+	created, _ := strconv.ParseInt(m["created"], 10, 64)
+	updated, _ := strconv.ParseInt(m["updated"], 10, 64)
+	version, _ := strconv.ParseInt(m["version"], 10, 64)
+	return &KeyMetadata{
+		CreatedAt: time.Unix(0, created*int64(time.Millisecond)),
+		UpdatedAt: time.Unix(0, updated*int64(time.Millisecond)),
+		Writer:    m["writer"],
+		Version:   version,
+	}, nil
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// schemaVersionFlag is set in the payload trailer byte when the payload
+// carries the schema version before the creation time.
+const schemaVersionFlag = 0x20
+
+const schemaVersionLen = 2
+
+var errSchemaVersionTooShort = errors.New("cache: payload is too short for schema version")
+
+func appendSchemaVersion(b []byte, version int) []byte {
+	var buf [schemaVersionLen]byte
+	binary.LittleEndian.PutUint16(buf[:], uint16(version))
+	return append(b, buf[:]...)
+}
+
+// stripSchemaVersion removes the schema version from the payload body
+// when the trailer flags it. Payloads without one are version 0.
+func stripSchemaVersion(body []byte, trailer byte) ([]byte, int, error) {
+	if trailer&schemaVersionFlag == 0 {
+		return body, 0, nil
+	}
+	if len(body) < schemaVersionLen {
+		return nil, 0, errSchemaVersionTooShort
+	}
+	pos := len(body) - schemaVersionLen
+	return body[:pos], int(binary.LittleEndian.Uint16(body[pos:])), nil
+}
+
+// schemaVersion returns the schema version of the payload. b must not be a
+// string, []byte, or plain format value, which have no trailer.
+func schemaVersion(b []byte) (int, bool) {
+	if len(b) == 0 || isTombstone(b) {
+		return 0, false
+	}
+	b = bytes.TrimSuffix(b, []byte(criticalMarker))
+	if len(b) == 0 {
+		return 0, false
+	}
+	trailer := b[len(b)-1]
+	body, _, err := stripCreatedAt(b[:len(b)-1], trailer)
+	if err != nil {
+		return 0, false
+	}
+	_, version, err := stripSchemaVersion(body, trailer)
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
+// migrate upgrades the decompressed msgpack body from the version to
+// Options.SchemaVersion with Options.Migrations.
+func (cd *Cache) migrate(b []byte, version int) ([]byte, error) {
+	for v := version; v < cd.opt.SchemaVersion; v++ {
+		m := cd.opt.Migrations[v]
+		if m == nil {
+			return nil, fmt.Errorf("cache: no migration from schema version %d", v)
+		}
+		var err error
+		b, err = m(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// rewriteMigrated writes the value decoded from a migrated payload back to
+// Redis with the remaining TTL of the key, see Options.RewriteMigrated.
+// Failures are ignored; the next reader migrates the payload again.
+func (cd *Cache) rewriteMigrated(ctx context.Context, key string, b []byte, value interface{}) {
+	if !cd.opt.RewriteMigrated || cd.opt.ReadOnly || !cd.useRedis() {
+		return
+	}
+	switch value.(type) {
+	case nil, *string, *[]byte, *interface{}:
+		return
+	}
+	if !cd.hasTrailer(key) {
+		return
+	}
+	if _, bundled := cd.bundlePrefix(key); bundled {
+		return
+	}
+	if version, ok := schemaVersion(b); !ok || version >= cd.opt.SchemaVersion {
+		return
+	}
+
+	p, ok := cd.opt.Redis.(pttler)
+	if !ok {
+		return
+	}
+	ttl, err := p.PTTL(key).Result()
+	if err != nil {
+		return
+	}
+	switch {
+	case ttl == -1:
+		// The key has no TTL.
+	case ttl < time.Second:
+		return
+	}
+
+	// Keep values stored with Item.NoCompression uncompressed.
+	b = bytes.TrimSuffix(b, []byte(criticalMarker))
+	_ = cd.Set(&Item{
+		Ctx:           ctx,
+		Key:           key,
+		Value:         value,
+		TTL:           ttl,
+		IfExists:      true,
+		NoCompression: b[len(b)-1]&compressionMethodMask == noCompression,
+	})
+}
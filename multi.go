@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// MultiError is returned by batch operations such as MSet, DeleteMany,
+// OnceMany, and Batch.Flush when some of the keys failed. The other keys
+// were processed.
+type MultiError struct {
+	// Errors maps the failed keys to their errors.
+	Errors map[string]error
+	// Total is the number of keys in the operation.
+	Total int
+}
+
+func (e *MultiError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("cache: %d of %d keys failed, key %q: %s",
+		len(e.Errors), e.Total, keys[0], e.Errors[keys[0]])
+}
+
+// Err returns the error of the key or nil when the key didn't fail.
+func (e *MultiError) Err(key string) error {
+	return e.Errors[key]
+}
+
+// multiError returns a *MultiError with the errors or nil when there are
+// none.
+func multiError(errs map[string]error, total int) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &MultiError{Errors: errs, Total: total}
+}
+
+// MSet sets all items, sending them to Redis in pipelines of
+// Options.BatchSize writes and honoring the TTL of every item. Items are
+// marshaled concurrently before each pipeline is sent. Items that a Batch
+// can't write, e.g. fenced or immutable ones, are set one by one. A failed
+// item doesn't stop the others; their errors are returned in a
+// *MultiError. Once ctx is canceled, the remaining items fail with the
+// context error.
+func (cd *Cache) MSet(ctx context.Context, items []*Item) error {
+	b := cd.NewBatch()
+	size := cd.batchSize()
+	for len(items) > 0 {
+		chunk := items
+		if len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		items = items[len(chunk):]
+
+		var writes []batchWrite
+		var errs []error
+		if ctx.Err() == nil {
+			writes, errs = cd.prepareWrites(chunk)
+		}
+		for i, item := range chunk {
+			err := ctx.Err()
+			if err == nil {
+				err = errs[i]
+				if err == nil {
+					b.addPrepared(writes[i])
+					continue
+				}
+				if err == errBatchUnsupported {
+					err = cd.Set(item)
+				}
+			}
+
+			b.total++
+			b.done++
+			if err != nil {
+				b.failKey(item.Key, err)
+			}
+		}
+		b.flushPending(ctx)
+	}
+	return b.Flush(ctx)
+}
+
+// prepareWrites marshals the items with up to GOMAXPROCS goroutines.
+func (cd *Cache) prepareWrites(items []*Item) ([]batchWrite, []error) {
+	writes := make([]batchWrite, len(items))
+	errs := make([]error, len(items))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(items) {
+		workers = len(items)
+	}
+	var next int64 = -1
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(items) {
+					return
+				}
+				writes[i], errs[i] = cd.prepareWrite(items[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return writes, errs
+}
+
+// DeleteMany deletes the keys from both tiers like Delete. Missing keys
+// are not errors. A failed key doesn't stop the others; their errors are
+// returned in a *MultiError. Once ctx is canceled, the remaining keys
+// fail with the context error.
+func (cd *Cache) DeleteMany(ctx context.Context, keys []string) error {
+	errs := make(map[string]error)
+	every := cd.batchSize()
+	for i, key := range keys {
+		err := ctx.Err()
+		if err == nil {
+			err = cd.Delete(ctx, key)
+		}
+		if err != nil && err != ErrCacheMiss {
+			errs[key] = err
+		}
+		if (i+1)%every == 0 || i == len(keys)-1 {
+			reportProgress(ctx, i+1, len(errs))
+		}
+	}
+	return multiError(errs, len(keys))
+}
@@ -0,0 +1,66 @@
+// Package natsbroadcast provides a cache.Broadcast over NATS, so fleets
+// that already run NATS can propagate local cache invalidations without
+// Redis pub/sub.
+package natsbroadcast
+
+import (
+	"sync"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/star001007/cache"
+)
+
+// Broadcast publishes invalidations to a NATS subject.
+type Broadcast struct {
+	conn    *nats.Conn
+	subject string
+
+	mu  sync.Mutex
+	sub *nats.Subscription
+}
+
+var _ cache.Broadcast = (*Broadcast)(nil)
+
+// New returns a Broadcast on the subject. Close doesn't close conn.
+func New(conn *nats.Conn, subject string) *Broadcast {
+	return &Broadcast{
+		conn:    conn,
+		subject: subject,
+	}
+}
+
+func (b *Broadcast) Publish(msg []byte) error {
+	return b.conn.Publish(b.subject, msg)
+}
+
+func (b *Broadcast) Subscribe(fn func(msg []byte)) error {
+	sub, err := b.conn.Subscribe(b.subject, func(m *nats.Msg) {
+		fn(m.Data)
+	})
+	if err != nil {
+		return err
+	}
+	// Make sure the server knows about the subscription.
+	if err := b.conn.Flush(); err != nil {
+		_ = sub.Unsubscribe()
+		return err
+	}
+
+	b.mu.Lock()
+	b.sub = sub
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *Broadcast) Close() error {
+	b.mu.Lock()
+	sub := b.sub
+	b.sub = nil
+	b.mu.Unlock()
+
+	if sub == nil {
+		return nil
+	}
+	return sub.Unsubscribe()
+}
@@ -0,0 +1,190 @@
+package natsbroadcast_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/star001007/cache/natsbroadcast"
+)
+
+func TestGinkgo(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "natsbroadcast")
+}
+
+var _ = Describe("natsbroadcast", func() {
+	var server *fakeServer
+	var conn1, conn2 *nats.Conn
+
+	BeforeEach(func() {
+		var err error
+		server, err = startFakeServer()
+		Expect(err).NotTo(HaveOccurred())
+
+		conn1, err = nats.Connect(server.url())
+		Expect(err).NotTo(HaveOccurred())
+		conn2, err = nats.Connect(server.url())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		conn1.Close()
+		conn2.Close()
+		server.close()
+	})
+
+	It("delivers messages to subscribers until Close", func() {
+		b1 := natsbroadcast.New(conn1, "invalidations")
+		b2 := natsbroadcast.New(conn2, "invalidations")
+		other := natsbroadcast.New(conn2, "other")
+
+		got := make(chan string, 10)
+		Expect(b1.Subscribe(func(msg []byte) {
+			got <- string(msg)
+		})).To(Succeed())
+
+		Expect(b2.Publish([]byte("msg1"))).To(Succeed())
+		Expect(other.Publish([]byte("other"))).To(Succeed())
+		Eventually(got).Should(Receive(Equal("msg1")))
+		Expect(b1.Publish([]byte("msg2"))).To(Succeed())
+		Eventually(got).Should(Receive(Equal("msg2")))
+
+		Expect(b1.Close()).To(Succeed())
+		Expect(conn1.Flush()).To(Succeed())
+		Expect(b2.Publish([]byte("msg3"))).To(Succeed())
+		Expect(conn2.Flush()).To(Succeed())
+		Consistently(got, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("doesn't close the connection", func() {
+		b := natsbroadcast.New(conn1, "invalidations")
+		Expect(b.Close()).To(Succeed())
+		Expect(b.Subscribe(func([]byte) {})).To(Succeed())
+		Expect(b.Close()).To(Succeed())
+		Expect(conn1.IsClosed()).To(BeFalse())
+	})
+})
+
+// fakeServer speaks enough of the NATS protocol for the tests: it
+// delivers every PUB to the SUBs of the same subject.
+type fakeServer struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	subs map[string]map[*fakeSub]struct{}
+}
+
+type fakeSub struct {
+	sid string
+	w   *fakeConn
+}
+
+type fakeConn struct {
+	mu sync.Mutex
+	net.Conn
+}
+
+func (c *fakeConn) send(format string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, _ = fmt.Fprintf(c.Conn, format, args...)
+}
+
+func startFakeServer() (*fakeServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &fakeServer{ln: ln, subs: make(map[string]map[*fakeSub]struct{})}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(&fakeConn{Conn: conn})
+		}
+	}()
+	return s, nil
+}
+
+func (s *fakeServer) url() string {
+	return "nats://" + s.ln.Addr().String()
+}
+
+func (s *fakeServer) close() {
+	_ = s.ln.Close()
+}
+
+func (s *fakeServer) serve(c *fakeConn) {
+	defer c.Close()
+
+	sids := make(map[string]*fakeSub)
+	subjects := make(map[string]string)
+	defer func() {
+		s.mu.Lock()
+		for sid, sub := range sids {
+			delete(s.subs[subjects[sid]], sub)
+		}
+		s.mu.Unlock()
+	}()
+
+	c.send("INFO {\"server_id\":\"fake\",\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			c.send("PONG\r\n")
+		case "SUB":
+			subject, sid := args[1], args[len(args)-1]
+			sub := &fakeSub{sid: sid, w: c}
+			s.mu.Lock()
+			if s.subs[subject] == nil {
+				s.subs[subject] = make(map[*fakeSub]struct{})
+			}
+			s.subs[subject][sub] = struct{}{}
+			s.mu.Unlock()
+			sids[sid], subjects[sid] = sub, subject
+		case "UNSUB":
+			sid := args[1]
+			s.mu.Lock()
+			delete(s.subs[subjects[sid]], sids[sid])
+			s.mu.Unlock()
+			delete(sids, sid)
+		case "PUB":
+			subject := args[1]
+			n, err := strconv.Atoi(args[len(args)-1])
+			if err != nil {
+				return
+			}
+			payload := make([]byte, n+2) // with CRLF
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.mu.Lock()
+			for sub := range s.subs[subject] {
+				sub.w.send("MSG %s %s %d\r\n%s", subject, sub.sid, n, payload)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package cache
+
+import "sync"
+
+// manyGroup dedupes the keys that OnceMany calls are loading.
+type manyGroup struct {
+	mu    sync.Mutex
+	calls map[string]*manyCall
+}
+
+type manyCall struct {
+	done chan struct{}
+	b    []byte
+	err  error
+}
+
+type manyWait struct {
+	item *Item
+	call *manyCall
+}
+
+// claim registers calls for the keys of the items that no other OnceMany is
+// loading. The other items wait for the calls loading their keys.
+func (g *manyGroup) claim(items []*Item) (owned []*Item, calls []*manyCall, waits []manyWait) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*manyCall)
+	}
+	for _, item := range items {
+		if c, ok := g.calls[item.Key]; ok {
+			waits = append(waits, manyWait{item: item, call: c})
+			continue
+		}
+		c := &manyCall{done: make(chan struct{})}
+		g.calls[item.Key] = c
+		owned = append(owned, item)
+		calls = append(calls, c)
+	}
+	return owned, calls, waits
+}
+
+func (g *manyGroup) release(items []*Item, calls []*manyCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i, item := range items {
+		delete(g.calls, item.Key)
+		close(calls[i].done)
+	}
+}
+
+// OnceMany is like Once for many items. It gets the cached values in bulk
+// like MGet and calls loader once with only the missing items. The loader
+// fills their Value, or sets it to nil when a value doesn't exist, which
+// fails the key with ErrCacheMiss. Loaded values are cached with one
+// Batch. Keys that a concurrent OnceMany is already loading are not passed
+// to the loader; their values are decoded from that call's result.
+//
+// A failed key doesn't stop the others; their errors are returned in a
+// *MultiError. A loader error fails every key passed to it. All items use
+// the context of the first one.
+func (cd *Cache) OnceMany(items []*Item, loader func(missing []*Item) error) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ctx := items[0].Context()
+
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Key
+	}
+	// Like Once, keys that Redis fails to return are loaded.
+	found, _ := cd.GetMap(ctx, "", keys)
+
+	var missing []*Item
+	for _, item := range items {
+		if b, ok := found[item.Key]; ok {
+			if item.Value == nil || cd.unmarshalItem(item, b) == nil {
+				continue
+			}
+		}
+		missing = append(missing, item)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	owned, calls, waits := cd.many.claim(missing)
+	if len(owned) > 0 {
+		cd.loadMany(owned, calls, loader)
+	}
+
+	errs := make(map[string]error)
+	for i, item := range owned {
+		if err := calls[i].err; err != nil {
+			errs[item.Key] = err
+		}
+	}
+	for _, w := range waits {
+		select {
+		case <-w.call.done:
+		case <-ctx.Done():
+			errs[w.item.Key] = ctx.Err()
+			continue
+		}
+
+		err := w.call.err
+		if err == nil && w.item.Value != nil {
+			err = cd.unmarshalItem(w.item, w.call.b)
+		}
+		if err != nil {
+			errs[w.item.Key] = err
+		}
+	}
+	return multiError(errs, len(items))
+}
+
+// loadMany calls the loader with the items and caches the loaded values.
+// Like Once, values are returned even when writing them to Redis fails.
+func (cd *Cache) loadMany(items []*Item, calls []*manyCall, loader func([]*Item) error) {
+	defer cd.many.release(items, calls)
+
+	if err := loader(items); err != nil {
+		for _, c := range calls {
+			c.err = err
+		}
+		return
+	}
+
+	var loaded []*Item
+	var loadedCalls []*manyCall
+	for i, item := range items {
+		if item.Value == nil {
+			calls[i].err = ErrCacheMiss
+			continue
+		}
+		loaded = append(loaded, item)
+		loadedCalls = append(loadedCalls, calls[i])
+	}
+	if len(loaded) == 0 {
+		return
+	}
+
+	batch := cd.NewBatch()
+	writes, errs := cd.prepareWrites(loaded)
+	for i, item := range loaded {
+		c := loadedCalls[i]
+		if errs[i] == nil {
+			c.b = writes[i].b
+			batch.addPrepared(writes[i])
+			continue
+		}
+
+		// Set writes what a Batch can't and, like in Once, returns values
+		// it failed to write, e.g. with ErrReadOnly.
+		b, ok, err := cd.set(item)
+		if err == nil {
+			err = cd.updateDependencies(item)
+		}
+		if ok {
+			c.b = b
+		} else {
+			c.err = err
+		}
+	}
+	_ = batch.Flush(loaded[0].Context())
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// keyLockShards is the number of mutexes that order writes to the same key.
+const keyLockShards = 256
+
+// keyLocks serializes Set and Delete calls for the same key, so both tiers
+// see them in the same order. Without it a Delete racing with a Set could
+// clear the local cache before the Set writes it and Redis after, leaving
+// the deleted value alive locally.
+type keyLocks [keyLockShards]sync.Mutex
+
+func keyShard(key string) int {
+	return int(xxhash.Sum64String(key) % keyLockShards)
+}
+
+// lock locks the shard of the key and returns the func that unlocks it.
+func (l *keyLocks) lock(key string) func() {
+	mu := &l[keyShard(key)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockAll locks the shards of all keys in ascending order
+// and returns the func that unlocks them.
+func (l *keyLocks) lockAll(keys []string) func() {
+	shards := make([]int, 0, len(keys))
+	seen := make(map[int]struct{}, len(keys))
+	for _, key := range keys {
+		i := keyShard(key)
+		if _, ok := seen[i]; !ok {
+			seen[i] = struct{}{}
+			shards = append(shards, i)
+		}
+	}
+	sort.Ints(shards)
+
+	for _, i := range shards {
+		l[i].Lock()
+	}
+	return func() {
+		for _, i := range shards {
+			l[i].Unlock()
+		}
+	}
+}
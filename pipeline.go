@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+type pipeliner interface {
+	Pipeline() redis.Pipeliner
+}
+
+type txPipeliner interface {
+	TxPipeline() redis.Pipeliner
+}
+
+type pttler interface {
+	PTTL(key string) *redis.DurationCmd
+}
+
+var errTTLUnsupported = errors.New("cache: Redis client does not support PTTL")
+
+// pipelined queues the commands issued by fn in a single Redis pipeline
+// when the client supports pipelining. Otherwise the commands are sent to
+// the client one by one. Callers check errors of the individual commands.
+func (cd *Cache) pipelined(fn func(RemoteStore)) {
+	p, ok := cd.opt.Redis.(pipeliner)
+	if !ok {
+		fn(cd.opt.Redis)
+		return
+	}
+
+	pipe := p.Pipeline()
+	fn(pipe)
+	_, _ = pipe.Exec()
+}
+
+// txPipelined is like pipelined, but wraps the commands in MULTI/EXEC
+// when the client and Options.Compat allow transactions, so they are
+// applied together. Otherwise it falls back to pipelined.
+func (cd *Cache) txPipelined(fn func(RemoteStore)) {
+	p, ok := cd.opt.Redis.(txPipeliner)
+	if !ok || !cd.useTransactions() {
+		cd.pipelined(fn)
+		return
+	}
+
+	pipe := p.TxPipeline()
+	fn(pipe)
+	_, _ = pipe.Exec()
+}
+
+// GetWithTTL gets the value for the given key from Redis together with
+// its remaining TTL in a single round trip when the client supports
+// pipelining. The TTL is negative when the key has no expiration.
+// The local cache is not used because it doesn't know Redis TTLs.
+func (cd *Cache) GetWithTTL(ctx context.Context, key string, value interface{}) (time.Duration, error) {
+	if !cd.useRedis() {
+		return 0, ErrCacheMiss
+	}
+
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	var ttlErr error
+	cd.pipelined(func(pipe RemoteStore) {
+		get = pipe.Get(key)
+		if p, ok := pipe.(pttler); ok {
+			pttl = p.PTTL(key)
+		} else {
+			ttlErr = errTTLUnsupported
+		}
+	})
+
+	b, err := get.Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			err = ErrCacheMiss
+		} else {
+			atomic.AddUint64(&cd.errs, 1)
+		}
+		if cd.opt.StatsEnabled {
+			atomic.AddUint64(&cd.misses, 1)
+		}
+		return 0, err
+	}
+	if ttlErr != nil {
+		return 0, ttlErr
+	}
+
+	ttl, err := pttl.Result()
+	if err != nil {
+		atomic.AddUint64(&cd.errs, 1)
+		return 0, err
+	}
+
+	b, err = cd.verify(key, b)
+	if err != nil {
+		atomic.AddUint64(&cd.errs, 1)
+		return 0, err
+	}
+
+	if cd.opt.StatsEnabled {
+		atomic.AddUint64(&cd.hits, 1)
+	}
+
+	if value == nil || len(b) == 0 {
+		return ttl, nil
+	}
+	return ttl, cd.UnmarshalKey(key, b, value)
+}
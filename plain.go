@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// PlainCodec is the encoding of values stored in a PlainFormat.
+type PlainCodec int
+
+const (
+	PlainMsgpack PlainCodec = iota
+	PlainJSON
+)
+
+// PlainFormat stores values without the package specific trailer byte so
+// they can be read and written by services in other languages. string and
+// []byte values are stored as is; other values are encoded with Codec.
+// With Gzip set, every value is gzip compressed.
+type PlainFormat struct {
+	Codec PlainCodec
+	Gzip  bool
+}
+
+// plainFormat returns the format for the longest
+// Options.PlainFormats prefix of the key or Options.DefaultFormat.
+func (cd *Cache) plainFormat(key string) (PlainFormat, bool) {
+	var format PlainFormat
+	prefixLen := -1
+	for prefix, f := range cd.opt.PlainFormats {
+		if len(prefix) > prefixLen && strings.HasPrefix(key, prefix) {
+			format = f
+			prefixLen = len(prefix)
+		}
+	}
+	if prefixLen < 0 && cd.opt.DefaultFormat != nil {
+		return *cd.opt.DefaultFormat, true
+	}
+	return format, prefixLen >= 0
+}
+
+// hasTrailer reports whether values of the key are encoded with the
+// trailer byte, i.e. neither in a PlainFormat nor by Options.Marshal.
+func (cd *Cache) hasTrailer(key string) bool {
+	if cd.opt.Marshal != nil {
+		return false
+	}
+	_, plain := cd.plainFormat(key)
+	return !plain
+}
+
+// marshalKey marshals the value in the storage format of the key.
+func (cd *Cache) marshalKey(buf *bytes.Buffer, key string, value interface{}) ([]byte, error) {
+	return cd.marshalKeyCompress(buf, key, value, true)
+}
+
+func (cd *Cache) marshalKeyCompress(
+	buf *bytes.Buffer, key string, value interface{}, compress bool,
+) ([]byte, error) {
+	// Internal entries such as stream chunks are already stored payloads.
+	if _, raw := value.(rawPayload); raw {
+		return cd.marshal(buf, value, compress)
+	}
+	if format, ok := cd.plainFormat(key); ok {
+		return format.marshal(value)
+	}
+	return cd.marshal(buf, value, compress)
+}
+
+// marshalItem marshals the value of the item with Item.Marshal or in the
+// storage format of the key.
+func (cd *Cache) marshalItem(buf *bytes.Buffer, item *Item, value interface{}) ([]byte, error) {
+	if item.Marshal != nil {
+		return item.Marshal(value)
+	}
+	return cd.marshalKeyCompress(buf, item.Key, value, !item.NoCompression)
+}
+
+// unmarshalItem decodes the value of the item into item.Value with
+// Item.Unmarshal or like UnmarshalKey.
+func (cd *Cache) unmarshalItem(item *Item, b []byte) error {
+	if item.Unmarshal != nil {
+		return item.Unmarshal(bytes.TrimSuffix(b, []byte(criticalMarker)), item.Value)
+	}
+	return cd.unmarshalDecoded(item.Key, b, item.Value)
+}
+
+// UnmarshalKey is like Unmarshal, but decodes bytes returned by the
+// cache, which have no checksum, in the storage format of the key, e.g.
+// one of Options.PlainFormats. Use it to decode bytes returned by GetMap,
+// GetIfChanged, or Watch.
+func (cd *Cache) UnmarshalKey(key string, b []byte, value interface{}) error {
+	var err error
+	if format, ok := cd.plainFormat(key); ok {
+		err = format.unmarshal(b, value, cd.opt.StrictDecode)
+	} else {
+		err = cd.unmarshal(b, value)
+	}
+	if err == nil || len(cd.opt.FallbackFormats) == 0 {
+		return err
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return err
+	}
+	for _, f := range cd.opt.FallbackFormats {
+		// Drop whatever the failed attempt decoded.
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+		if f.unmarshal(b, value, cd.opt.StrictDecode) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (f PlainFormat) marshal(value interface{}) ([]byte, error) {
+	var b []byte
+	switch value := value.(type) {
+	case nil:
+		return nil, nil
+	case rawPayload:
+		b = value
+	case []byte:
+		b = value
+	case string:
+		b = []byte(value)
+	default:
+		var err error
+		if f.Codec == PlainJSON {
+			b, err = json.Marshal(value)
+		} else {
+			b, err = msgpack.Marshal(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !f.Gzip {
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f PlainFormat) unmarshal(b []byte, value interface{}, strict bool) error {
+	if len(b) == 0 || value == nil {
+		return nil
+	}
+
+	if f.Gzip {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		b, err = ioutil.ReadAll(zr)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch value := value.(type) {
+	case *[]byte:
+		reflect.ValueOf(value).Elem().SetBytes(b)
+		return nil
+	case *string:
+		reflect.ValueOf(value).Elem().SetString(string(b))
+		return nil
+	}
+
+	if f.Codec != PlainJSON {
+		if strict {
+			return strictUnmarshal(b, value)
+		}
+		return msgpack.Unmarshal(b, value)
+	}
+
+	if !strict {
+		return json.Unmarshal(b, value)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(value); err != nil {
+		return &DecodeError{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// accessPattern is the read history of a key tracked by Options.PredictiveRefresh.
+type accessPattern struct {
+	last     time.Time
+	interval time.Duration // smoothed time between reads, zero until the second read
+	// refreshed is the predicted read the local copy was last refreshed for.
+	refreshed time.Time
+}
+
+// accessPredictor tracks the intervals between reads of up to size keys.
+type accessPredictor struct {
+	mu   sync.Mutex
+	size int
+	keys map[string]*accessPattern
+}
+
+func newAccessPredictor(size int) *accessPredictor {
+	return &accessPredictor{
+		size: size,
+		keys: make(map[string]*accessPattern),
+	}
+}
+
+func (p *accessPredictor) observe(key string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a, ok := p.keys[key]
+	if !ok {
+		if len(p.keys) >= p.size {
+			return
+		}
+		p.keys[key] = &accessPattern{last: now}
+		return
+	}
+
+	d := now.Sub(a.last)
+	if d <= 0 {
+		return
+	}
+	if a.interval == 0 {
+		a.interval = d
+	} else {
+		a.interval = (3*a.interval + d) / 4
+	}
+	a.last = now
+}
+
+// due returns the keys whose next read is predicted within a tenth of
+// their read interval from now, along with the predicted read times. Keys
+// that have not been read for several intervals are forgotten.
+func (p *accessPredictor) due(now time.Time, idle time.Duration) ([]string, []time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var keys []string
+	var next []time.Time
+	for key, a := range p.keys {
+		if a.interval == 0 {
+			if now.Sub(a.last) > idle {
+				delete(p.keys, key)
+			}
+			continue
+		}
+		if now.Sub(a.last) > 3*a.interval {
+			delete(p.keys, key)
+			continue
+		}
+
+		at := a.last.Add(a.interval)
+		if at.Equal(a.refreshed) || now.Before(at.Add(-a.interval/10)) {
+			continue
+		}
+		a.refreshed = at
+		keys = append(keys, key)
+		next = append(next, at)
+	}
+	return keys, next
+}
+
+func (cd *Cache) startPredictor() {
+	cd.predictor = newAccessPredictor(cd.opt.PredictiveRefresh)
+	cd.stopPredictor = make(chan struct{})
+	cd.predictorDone = make(chan struct{})
+
+	go func() {
+		defer close(cd.predictorDone)
+
+		ticker := time.NewTicker(cd.opt.PredictiveRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-cd.stopPredictor:
+				return
+			case <-ticker.C:
+				cd.refreshPredicted()
+			}
+		}
+	}()
+}
+
+func (cd *Cache) observeRead(key string) {
+	if cd.predictor != nil {
+		cd.predictor.observe(key, cd.now())
+	}
+}
+
+// refreshPredicted fetches the keys about to be read from Redis into the
+// local cache when their local copies would have expired by the time they
+// are read, so the read is served locally instead of waiting for Redis.
+func (cd *Cache) refreshPredicted() {
+	keys, next := cd.predictor.due(cd.now(), cd.opt.LocalCacheStoreTTL)
+	for i, key := range keys {
+		if cd.recentlyWritten(key) {
+			continue
+		}
+		if b, ok := cd.local.HasGet(nil, []byte(key)); ok && len(b) >= 4 {
+			tm := decodeTime(b[len(b)-4:])
+			if !cd.localExpired(tm, next[i].Sub(tm)) {
+				continue
+			}
+		}
+		if _, err := cd.getRedisBytes(key, false); err == nil {
+			atomic.AddUint64(&cd.predictiveRefreshes, 1)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// maxPrefetches limits concurrent sibling prefetches. Prefetches beyond
+// it are dropped, so they never compete with reads for connections.
+const maxPrefetches = 4
+
+// prefetchSiblings loads Item.SiblingKeys into the local cache in the
+// background.
+func (cd *Cache) prefetchSiblings(item *Item) {
+	if item.SiblingKeys == nil || !cd.useLocalCache() || !cd.useRedis() {
+		return
+	}
+
+	var keys []string
+	for _, key := range item.SiblingKeys(item.Key) {
+		if key != item.Key {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	if atomic.AddInt32(&cd.prefetching, 1) > maxPrefetches {
+		atomic.AddInt32(&cd.prefetching, -1)
+		return
+	}
+	go func() {
+		defer atomic.AddInt32(&cd.prefetching, -1)
+		// GetMap skips keys in the local cache and back-fills it.
+		_, _ = cd.GetMap(context.Background(), "", keys)
+	}()
+}
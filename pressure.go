@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMemoryPressureHigh = 0.9
+	defaultMemoryPressureLow  = 0.8
+	defaultMemoryPressureTTL  = 5 * time.Minute
+)
+
+// MemoryPressure reports whether Redis is under memory pressure,
+// see Options.MemoryPressureInterval.
+func (cd *Cache) MemoryPressure() bool {
+	return atomic.LoadUint32(&cd.pressure) == 1
+}
+
+func (cd *Cache) setMemoryPressure(on bool) {
+	var v uint32
+	if on {
+		v = 1
+	}
+	if atomic.SwapUint32(&cd.pressure, v) != v && on {
+		atomic.AddUint64(&cd.pressureEvents, 1)
+	}
+}
+
+// pressureItem adapts the item to memory pressure: it returns a copy of
+// the item with the TTL capped, or true when the item should not be
+// written to Redis at all because it has LowPriority or was not admitted
+// by Options.CostAdmission.
+func (cd *Cache) pressureItem(item *Item, admitted bool) (*Item, bool) {
+	if !cd.MemoryPressure() {
+		return item, false
+	}
+	if item.LowPriority {
+		atomic.AddUint64(&cd.pressureSkipped, 1)
+		return item, true
+	}
+	if !admitted {
+		atomic.AddUint64(&cd.admissionDenied, 1)
+		return item, true
+	}
+	if ttl := cd.opt.MemoryPressureTTL; item.ttl() == 0 || item.ttl() > ttl {
+		capped := *item
+		capped.TTL = ttl
+		return &capped, false
+	}
+	return item, false
+}
+
+// dropStale deletes the previous value of an item that is not written to
+// Redis under memory pressure, so other processes don't read it.
+func (cd *Cache) dropStale(key string) error {
+	if prefix, ok := cd.bundlePrefix(key); ok {
+		_, err := cd.bundleDel(prefix, key)
+		return err
+	}
+	return cd.opt.Redis.Del(key).Err()
+}
+
+// checkOOM starts memory pressure when Redis refused a write because it
+// reached maxmemory.
+func (cd *Cache) checkOOM(err error) {
+	if cd.stopPressure != nil && err != nil && strings.HasPrefix(err.Error(), "OOM ") {
+		cd.setMemoryPressure(true)
+	}
+}
+
+// updateMemoryPressure reads INFO memory and starts memory pressure above
+// MemoryPressureHigh of maxmemory and ends it below MemoryPressureLow.
+// Without maxmemory only OOM errors start memory pressure.
+func (cd *Cache) updateMemoryPressure() error {
+	d, ok := cd.opt.Redis.(doer)
+	if !ok {
+		return errProbeUnsupported
+	}
+
+	info, err := d.Do("info", "memory").Text()
+	if err != nil {
+		return err
+	}
+
+	used, _ := strconv.ParseFloat(infoField(info, "used_memory"), 64)
+	max, _ := strconv.ParseFloat(infoField(info, "maxmemory"), 64)
+	if max <= 0 {
+		cd.setMemoryPressure(false)
+		return nil
+	}
+
+	switch usage := used / max; {
+	case usage >= cd.opt.MemoryPressureHigh:
+		cd.setMemoryPressure(true)
+	case usage < cd.opt.MemoryPressureLow:
+		cd.setMemoryPressure(false)
+	}
+	return nil
+}
+
+func (cd *Cache) startPressureMonitor() {
+	cd.stopPressure = make(chan struct{})
+	cd.pressureDone = make(chan struct{})
+
+	go func() {
+		defer close(cd.pressureDone)
+
+		ticker := time.NewTicker(cd.opt.MemoryPressureInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-cd.stopPressure:
+				return
+			case <-ticker.C:
+				if err := cd.updateMemoryPressure(); err != nil {
+					atomic.AddUint64(&cd.errs, 1)
+				}
+			}
+		}
+	}()
+}
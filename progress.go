@@ -0,0 +1,26 @@
+package cache
+
+import "context"
+
+// ProgressFunc is called by batch operations with the number of keys
+// processed so far and how many of them failed.
+type ProgressFunc func(processed, failed int)
+
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx that makes batch operations started
+// with it, e.g. MSet, DeleteMany, Batch.Flush, and InvalidateTag, report
+// their progress to fn after every batch of keys. Canceling ctx stops the
+// operation before the next batch.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func reportProgress(ctx context.Context, processed, failed int) {
+	if ctx == nil {
+		return
+	}
+	if fn, _ := ctx.Value(progressKey{}).(ProgressFunc); fn != nil {
+		fn(processed, failed)
+	}
+}
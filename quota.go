@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQuotaExceeded is returned by Set when the key prefix has used up its
+// Options.MaxTotalBytesPerPrefix.
+var ErrQuotaExceeded = errors.New("cache: prefix quota exceeded")
+
+// quotaBucket is the resolution at which written bytes expire.
+const quotaBucket = time.Minute
+
+// prefixQuota estimates the bytes a prefix holds in Redis from the bytes
+// written by this process and their TTLs. Overwritten and deleted values
+// are counted until they would have expired.
+type prefixQuota struct {
+	mu      sync.Mutex
+	limit   int64
+	total   int64
+	expires map[int64]int64 // bucket -> bytes expiring after it
+	swept   int64
+}
+
+func newPrefixQuota(limit int64) *prefixQuota {
+	return &prefixQuota{
+		limit:   limit,
+		expires: make(map[int64]int64),
+	}
+}
+
+// add accounts n bytes written with the ttl, or reports false when they
+// don't fit into the quota.
+func (q *prefixQuota) add(n int64, ttl time.Duration, now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	bucket := now.UnixNano() / int64(quotaBucket)
+	if bucket > q.swept {
+		for b, size := range q.expires {
+			if b < bucket {
+				q.total -= size
+				delete(q.expires, b)
+			}
+		}
+		q.swept = bucket
+	}
+
+	if q.total+n > q.limit {
+		return false
+	}
+	q.total += n
+	if ttl > 0 {
+		// Bytes are released once the bucket of their expiry has passed.
+		q.expires[now.Add(ttl).UnixNano()/int64(quotaBucket)] += n
+	}
+	return true
+}
+
+func newPrefixQuotas(limits map[string]int64) map[string]*prefixQuota {
+	quotas := make(map[string]*prefixQuota, len(limits))
+	for prefix, limit := range limits {
+		quotas[prefix] = newPrefixQuota(limit)
+	}
+	return quotas
+}
+
+// checkQuota accounts the value written to Redis against the quota of the
+// longest matching prefix.
+func (cd *Cache) checkQuota(key string, b []byte, ttl time.Duration) error {
+	var quota *prefixQuota
+	var longest int
+	for prefix, q := range cd.quotas {
+		if len(prefix) >= longest && strings.HasPrefix(key, prefix) {
+			quota, longest = q, len(prefix)
+		}
+	}
+	if quota == nil {
+		return nil
+	}
+
+	if !quota.add(int64(len(key)+len(b)), ttl, cd.now()) {
+		atomic.AddUint64(&cd.quotaRejected, 1)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
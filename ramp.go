@@ -0,0 +1,29 @@
+package cache
+
+import "time"
+
+// rampTTL shortens the local TTL of entries stored within
+// Options.LocalTTLRamp after the cache was created. The TTL grows
+// linearly with the time the entry was stored, so values fetched while
+// the fleet warms up don't outlive the warmup for long.
+func (cd *Cache) rampTTL(ttl time.Duration, storedAt time.Time) time.Duration {
+	ramp := cd.opt.LocalTTLRamp
+	if ramp <= 0 {
+		return ttl
+	}
+
+	elapsed := storedAt.Sub(cd.started)
+	if elapsed >= ramp {
+		return ttl
+	}
+
+	ramped := time.Duration(float64(ttl) * float64(elapsed) / float64(ramp))
+	// Local cache entries store time with one second precision.
+	if ramped < time.Second {
+		ramped = time.Second
+	}
+	if ramped > ttl {
+		ramped = ttl
+	}
+	return ramped
+}
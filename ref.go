@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var (
+	errRefChain   = errors.New("cache: reference points to another reference")
+	errRefNoRedis = errors.New("cache: references require Redis")
+)
+
+// refPrefix and refSuffix enclose the target key of a reference entry.
+// Like tombstones, they can't be mistaken for a marshaled value.
+var (
+	refPrefix = []byte("\xc1cache:ref:")
+	refSuffix = []byte{0xff}
+)
+
+func encodeRef(target string) []byte {
+	b := make([]byte, 0, len(refPrefix)+len(target)+len(refSuffix))
+	b = append(b, refPrefix...)
+	b = append(b, target...)
+	return append(b, refSuffix...)
+}
+
+func decodeRef(b []byte) (string, bool) {
+	if len(b) < len(refPrefix)+len(refSuffix) ||
+		!bytes.HasPrefix(b, refPrefix) || !bytes.HasSuffix(b, refSuffix) {
+		return "", false
+	}
+	return string(b[len(refPrefix) : len(b)-len(refSuffix)]), true
+}
+
+// SetRef caches a small reference from key to target, usually a key
+// returned by SetCAS, so republishing a large value under key is a cheap
+// pointer swap. Get and Once read the target value through the reference.
+// References to references are not followed.
+func (cd *Cache) SetRef(ctx context.Context, key, target string, ttl time.Duration) error {
+	if cd.opt.Redis == nil {
+		return errRefNoRedis
+	}
+	return cd.Set(&Item{
+		Ctx:   ctx,
+		Key:   key,
+		Value: rawPayload(encodeRef(target)),
+		TTL:   ttl,
+		// The local cache holds the target value, filled on read.
+		SkipLocalOnSet: true,
+	})
+}
+
+// deref gets the value referenced by a reference entry from Redis.
+func (cd *Cache) deref(target string) ([]byte, error) {
+	b, err := cd.redisGet(target)
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		atomic.AddUint64(&cd.errs, 1)
+		return nil, err
+	}
+
+	b, err = cd.verify(target, b)
+	if err != nil {
+		atomic.AddUint64(&cd.errs, 1)
+		return nil, err
+	}
+	if isTombstone(b) {
+		return nil, ErrCacheMiss
+	}
+	if _, ok := decodeRef(b); ok {
+		return nil, errRefChain
+	}
+	return b, nil
+}
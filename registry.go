@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+var typeRegistry struct {
+	mu     sync.RWMutex
+	names  map[string]reflect.Type
+	byType map[reflect.Type]uint32
+	byTag  map[uint32]reflect.Type
+}
+
+// RegisterType registers T under the given name. Values of registered
+// types are always stored with a type tag, so Get into a *interface{}
+// reconstructs a value of type T instead of a generic map. The tag is
+// derived from the name, so renaming the Go type or changing its fields
+// keeps existing values readable.
+//
+// Register types from init functions; RegisterType panics when the name
+// or the type is already registered.
+func RegisterType[T any](name string) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	base := typ
+	for base.Kind() == reflect.Ptr {
+		base = base.Elem()
+	}
+	if base.Kind() == reflect.Interface {
+		panic(fmt.Sprintf("cache: can't register interface type %s", typ))
+	}
+
+	typeRegistry.mu.Lock()
+	defer typeRegistry.mu.Unlock()
+
+	if typeRegistry.names == nil {
+		typeRegistry.names = make(map[string]reflect.Type)
+		typeRegistry.byType = make(map[reflect.Type]uint32)
+		typeRegistry.byTag = make(map[uint32]reflect.Type)
+	}
+
+	if other, ok := typeRegistry.names[name]; ok {
+		panic(fmt.Sprintf("cache: name %q is already registered for %s", name, other))
+	}
+	if _, ok := typeRegistry.byType[base]; ok {
+		panic(fmt.Sprintf("cache: type %s is already registered", typ))
+	}
+	tag := typeTag(name)
+	if other, ok := typeRegistry.byTag[tag]; ok {
+		panic(fmt.Sprintf("cache: name %q collides with the name of %s", name, other))
+	}
+
+	typeRegistry.names[name] = typ
+	typeRegistry.byType[base] = tag
+	typeRegistry.byTag[tag] = typ
+	typeFingerprints.Store(base, tag)
+}
+
+// typeTag is the fingerprint stored for values of registered types.
+func typeTag(name string) uint32 {
+	return uint32(xxhash.Sum64String("cache.RegisterType:" + name))
+}
+
+// isRegisteredType reports whether values of the type carry a type tag.
+func isRegisteredType(typ reflect.Type) bool {
+	if typ == nil {
+		return false
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	typeRegistry.mu.RLock()
+	_, ok := typeRegistry.byType[typ]
+	typeRegistry.mu.RUnlock()
+	return ok
+}
+
+// registeredValue returns a new value of the type registered with the
+// tag and the pointer to decode into, or false when the tag is unknown.
+func registeredValue(tag uint32) (reflect.Value, interface{}, bool) {
+	typeRegistry.mu.RLock()
+	typ, ok := typeRegistry.byTag[tag]
+	typeRegistry.mu.RUnlock()
+	if !ok {
+		return reflect.Value{}, nil, false
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		v := reflect.New(typ.Elem())
+		return v, v.Interface(), true
+	}
+	v := reflect.New(typ)
+	return v.Elem(), v.Interface(), true
+}
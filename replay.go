@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultReplayInterval = time.Second
+
+// ReplayOverflow decides which write is dropped when the replay queue of
+// Options.ReplayQueueSize is full.
+type ReplayOverflow int
+
+const (
+	// ReplayDropOldest drops the oldest queued write.
+	ReplayDropOldest ReplayOverflow = iota
+	// ReplayDropNewest drops the write that doesn't fit.
+	ReplayDropNewest
+)
+
+// replayOp is a Set or Delete that failed because Redis was unavailable.
+type replayOp struct {
+	seq      uint64
+	key      string
+	value    []byte
+	ttl      time.Duration
+	queuedAt time.Time
+	del      bool
+}
+
+// replayQueue keeps failed writes in the order they were made, see
+// Options.ReplayQueueSize.
+type replayQueue struct {
+	mu       sync.Mutex
+	ops      []replayOp
+	size     int
+	overflow ReplayOverflow
+	seq      uint64
+}
+
+func newReplayQueue(size int, overflow ReplayOverflow) *replayQueue {
+	return &replayQueue{
+		size:     size,
+		overflow: overflow,
+	}
+}
+
+// push queues the write and reports whether a write was dropped to make
+// room for it or instead of it.
+func (q *replayQueue) push(op replayOp) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.ops) >= q.size {
+		if q.overflow == ReplayDropNewest {
+			return true
+		}
+		q.ops = q.ops[1:]
+		q.seq++
+		op.seq = q.seq
+		q.ops = append(q.ops, op)
+		return true
+	}
+	q.seq++
+	op.seq = q.seq
+	q.ops = append(q.ops, op)
+	return false
+}
+
+func (q *replayQueue) peek() (replayOp, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.ops) == 0 {
+		return replayOp{}, false
+	}
+	return q.ops[0], true
+}
+
+// pop removes the write if it is still the oldest one.
+func (q *replayQueue) pop(seq uint64) {
+	q.mu.Lock()
+	if len(q.ops) > 0 && q.ops[0].seq == seq {
+		q.ops[0] = replayOp{}
+		q.ops = q.ops[1:]
+	}
+	q.mu.Unlock()
+}
+
+// discard removes the queued writes of the key, which are superseded by
+// a write that reached Redis.
+func (q *replayQueue) discard(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ops := q.ops[:0]
+	for _, op := range q.ops {
+		if op.key != key {
+			ops = append(ops, op)
+		}
+	}
+	for i := len(ops); i < len(q.ops); i++ {
+		q.ops[i] = replayOp{}
+	}
+	q.ops = ops
+}
+
+func (q *replayQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.ops)
+}
+
+// isUnavailable reports whether the error means Redis couldn't be reached,
+// as opposed to Redis rejecting the command.
+func isUnavailable(err error) bool {
+	if err == ErrFaultInjected || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// replayable reports whether the failed Set of the item can be replayed.
+// Conditional writes depend on the state of Redis when they were made,
+// so they are not.
+func replayable(item *Item) bool {
+	return !item.IfExists && !item.IfNotExists && !item.Immutable &&
+		item.FencingToken == 0 && !item.Critical
+}
+
+// queueSet queues a Set that failed because Redis was unavailable.
+func (cd *Cache) queueSet(item *Item, b []byte, err error) {
+	if cd.replays == nil || !isUnavailable(err) || !replayable(item) {
+		return
+	}
+	cd.queueReplay(replayOp{
+		key:   item.Key,
+		value: b,
+		ttl:   item.ttl(),
+	})
+}
+
+// queueDelete queues a Delete that failed because Redis was unavailable.
+func (cd *Cache) queueDelete(key string, err error) {
+	if cd.replays == nil || !isUnavailable(err) {
+		return
+	}
+	cd.queueReplay(replayOp{
+		key: key,
+		del: true,
+	})
+}
+
+func (cd *Cache) queueReplay(op replayOp) {
+	op.queuedAt = cd.now()
+	if cd.replays.push(op) {
+		atomic.AddUint64(&cd.replayDropped, 1)
+	}
+}
+
+// replayed drops the queued writes of a key written to Redis.
+func (cd *Cache) replayed(key string) {
+	if cd.replays != nil {
+		cd.replays.discard(key)
+	}
+}
+
+func (cd *Cache) startReplayer() {
+	cd.replays = newReplayQueue(cd.opt.ReplayQueueSize, cd.opt.ReplayOverflow)
+	cd.stopReplayer = make(chan struct{})
+	cd.replayerDone = make(chan struct{})
+
+	go func() {
+		defer close(cd.replayerDone)
+
+		ticker := time.NewTicker(cd.opt.ReplayInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-cd.stopReplayer:
+				return
+			case <-ticker.C:
+				_ = cd.replay()
+			}
+		}
+	}()
+}
+
+// replay writes the queued writes to Redis in order. It stops at the
+// first failure, so later writes are not applied before earlier ones.
+func (cd *Cache) replay() error {
+	for {
+		op, ok := cd.replays.peek()
+		if !ok {
+			return nil
+		}
+		if err := cd.replayOp(op); err != nil {
+			if isUnavailable(err) {
+				return err
+			}
+			// Redis rejected the write, so retrying it won't help.
+			atomic.AddUint64(&cd.replayDropped, 1)
+		}
+		cd.replays.pop(op.seq)
+	}
+}
+
+func (cd *Cache) replayOp(op replayOp) error {
+	unlock := cd.keyLocks.lock(op.key)
+	defer unlock()
+
+	// A write to the key may have reached Redis while waiting for the lock.
+	if head, ok := cd.replays.peek(); !ok || head.seq != op.seq {
+		return nil
+	}
+
+	ttl := op.ttl
+	if ttl > 0 {
+		ttl -= cd.now().Sub(op.queuedAt)
+		if ttl < time.Second {
+			// The value has expired meanwhile, but the previous one
+			// may still be in Redis.
+			op.del = true
+		}
+	} else {
+		ttl = -1
+	}
+
+	if op.del {
+		if _, err := cd.redisDel(op.key); err != nil {
+			return err
+		}
+		cd.invalidate(opDelete, []string{op.key}, nil)
+	} else {
+		if err := cd.redisSet(&Item{Key: op.key, TTL: ttl}, op.value); err != nil {
+			return err
+		}
+		cd.invalidate(opSet, []string{op.key}, [][]byte{op.value})
+	}
+	atomic.AddUint64(&cd.replayedWrites, 1)
+	return nil
+}
+
+func (cd *Cache) replayQueued() int {
+	if cd.replays == nil {
+		return 0
+	}
+	return cd.replays.len()
+}
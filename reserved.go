@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+)
+
+// Prefixes of the sidecar keys stored next to a key. They are in the
+// namespace of the package like tag keys, so they don't collide with
+// ordinary keys such as "user:token".
+const (
+	hashPrefix       = "cache:hash:"
+	tokenPrefix      = "cache:token:"
+	metaPrefix       = "cache:meta:"
+	deltaPrefix      = "cache:delta:"
+	dependentsPrefix = "cache:dependents:"
+	tagPrefix        = "cache:tag:"
+)
+
+var reservedPrefixes = []string{
+	hashPrefix,
+	tokenPrefix,
+	metaPrefix,
+	deltaPrefix,
+	dependentsPrefix,
+	tagPrefix,
+}
+
+// ErrReservedKey is returned when a key written or deleted is in the
+// namespace of sidecar keys, e.g. "cache:hash:a". Writing it would corrupt
+// the sidecar of "a".
+var ErrReservedKey = errors.New("cache: key is in the namespace of sidecar keys")
+
+// checkKey rejects keys that name a sidecar key.
+func checkKey(key string) error {
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return ErrReservedKey
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const defaultRetryBudgetBurst = 10
+
+// retryBudget is a token bucket shared by all operations: every request
+// earns Options.RetryBudget tokens and every retry spends one, so under a
+// widespread Redis failure retries stay a fraction of the requests
+// instead of multiplying the load.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	max    float64
+	tokens float64
+}
+
+func newRetryBudget(ratio float64, burst int) *retryBudget {
+	if burst <= 0 {
+		burst = defaultRetryBudgetBurst
+	}
+	return &retryBudget{
+		ratio:  ratio,
+		max:    float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+	b.mu.Unlock()
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// requested records a request that may be retried.
+func (cd *Cache) requested() {
+	if cd.retries != nil {
+		cd.retries.deposit()
+	}
+}
+
+// allowRetry reports whether the retry budget allows another retry.
+func (cd *Cache) allowRetry() bool {
+	if cd.retries == nil || cd.retries.withdraw() {
+		return true
+	}
+	atomic.AddUint64(&cd.retriesDenied, 1)
+	return false
+}
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+type keyScanner interface {
+	Scan(cursor uint64, match string, count int64) *redis.ScanCmd
+}
+
+var (
+	errMigrateNoRedis  = errors.New("cache: Migrate requires Redis")
+	errScanUnsupported = errors.New("cache: Redis client does not support SCAN")
+)
+
+// RewriteFunc returns the new key, value, and TTL of the entry stored
+// under the key, e.g. to rename a key scheme or to re-encode values. The
+// TTL is like Item.TTL. b is the stored value without its signature;
+// decode it with UnmarshalKey. Returning an empty new key leaves the
+// entry unchanged.
+type RewriteFunc func(key string, b []byte) (newKey string, newB []byte, ttl time.Duration, err error)
+
+// Migrate scans Redis for keys matching the SCAN pattern and rewrites
+// them with rewrite, fetching and writing every page of Options.BatchSize
+// keys with a single pipeline. Entries moved to a new key are deleted
+// from the old one. Local copies of all rewritten keys are dropped here
+// and, with Options.Broadcast, in other processes.
+//
+// Sidecar keys such as cache:meta:<key> are only rewritten when the
+// pattern matches them. SCAN may return a key more than once, including
+// keys written by the migration itself when they match the pattern, so
+// rewrite must be idempotent. A failed key doesn't stop the others; their
+// errors are returned in a *MultiError. Progress is reported after every
+// page.
+func (cd *Cache) Migrate(ctx context.Context, pattern string, rewrite RewriteFunc) error {
+	if cd.opt.ReadOnly {
+		return ErrReadOnly
+	}
+	if cd.opt.Redis == nil {
+		return errMigrateNoRedis
+	}
+	s, ok := cd.opt.Redis.(keyScanner)
+	if !ok {
+		return errScanUnsupported
+	}
+
+	errs := make(map[string]error)
+	var total int
+	var cursor uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		keys, next, err := s.Scan(cursor, pattern, int64(cd.batchSize())).Result()
+		if err != nil {
+			return err
+		}
+		total += len(keys)
+		cd.rewritePage(keys, rewrite, errs)
+		reportProgress(ctx, total, len(errs))
+
+		cursor = next
+		if cursor == 0 {
+			return multiError(errs, total)
+		}
+	}
+}
+
+type keyRewrite struct {
+	key, newKey string
+	b           []byte
+	ttl         time.Duration
+}
+
+func (cd *Cache) rewritePage(keys []string, fn RewriteFunc, errs map[string]error) {
+	if len(keys) == 0 {
+		return
+	}
+
+	cmds := make([]*redis.StringCmd, len(keys))
+	cd.pipelined(func(pipe RemoteStore) {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(key)
+		}
+	})
+
+	var rewrites []keyRewrite
+	for i, cmd := range cmds {
+		key := keys[i]
+		b, err := cmd.Bytes()
+		if err == redis.Nil {
+			// Expired or deleted since the scan.
+			continue
+		}
+		if err == nil {
+			b, err = cd.verify(key, b)
+		}
+		if err != nil {
+			atomic.AddUint64(&cd.errs, 1)
+			errs[key] = err
+			continue
+		}
+		if isTombstone(b) {
+			continue
+		}
+
+		newKey, newB, ttl, err := fn(key, b)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		if newKey == "" {
+			continue
+		}
+		signed, err := cd.sign(newKey, newB)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		rewrites = append(rewrites, keyRewrite{
+			key:    key,
+			newKey: newKey,
+			b:      signed,
+			ttl:    (&Item{TTL: ttl}).ttl(),
+		})
+	}
+	if len(rewrites) == 0 {
+		return
+	}
+
+	touched := make([]string, 0, 2*len(rewrites))
+	for _, r := range rewrites {
+		touched = append(touched, r.newKey)
+		if r.key != r.newKey {
+			touched = append(touched, r.key)
+		}
+	}
+	if cd.writes != nil {
+		defer func() {
+			for _, key := range touched {
+				cd.writes.touch(key)
+			}
+		}()
+	}
+	unlock := cd.keyLocks.lockAll(touched)
+	defer unlock()
+
+	setCmds := make([]*redis.StatusCmd, len(rewrites))
+	delCmds := make([]*redis.IntCmd, len(rewrites))
+	cd.pipelined(func(pipe RemoteStore) {
+		for i, r := range rewrites {
+			setCmds[i] = pipe.Set(r.newKey, r.b, r.ttl)
+			if r.key != r.newKey {
+				delCmds[i] = pipe.Del(r.key)
+			}
+		}
+	})
+
+	for i, r := range rewrites {
+		err := setCmds[i].Err()
+		if err == nil && delCmds[i] != nil {
+			err = delCmds[i].Err()
+		}
+		if err != nil {
+			atomic.AddUint64(&cd.errs, 1)
+			errs[r.key] = err
+		}
+	}
+
+	for _, key := range touched {
+		cd.forgetDecoded(key)
+		if cd.local != nil {
+			cd.local.Del([]byte(key))
+		}
+	}
+	cd.invalidate(opDelete, touched, nil)
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Struct fields tagged `cache:"omit"` are marshaled as zero values, and
+// string or []byte fields tagged `cache:"hash"` are marshaled as their
+// SHA-256, or HMAC-SHA256 with Options.FieldHashKey, so sensitive data
+// never leaves the process in cached values. Hashed strings are hex
+// encoded. The tags apply to nested structs, pointers, slices, arrays,
+// and map values too, but not to values behind interfaces.
+const (
+	scrubTag  = "cache"
+	scrubOmit = "omit"
+	scrubHash = "hash"
+)
+
+// scrubTypes caches whether values of a type have fields to scrub.
+var scrubTypes sync.Map
+
+func needsScrub(typ reflect.Type) bool {
+	if v, ok := scrubTypes.Load(typ); ok {
+		return v.(bool)
+	}
+	needs := computeNeedsScrub(typ, make(map[reflect.Type]struct{}))
+	scrubTypes.Store(typ, needs)
+	return needs
+}
+
+// computeNeedsScrub walks the type, skipping types already being walked,
+// so recursive types terminate.
+func computeNeedsScrub(typ reflect.Type, seen map[reflect.Type]struct{}) bool {
+	if _, ok := seen[typ]; ok {
+		return false
+	}
+	seen[typ] = struct{}{}
+
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return computeNeedsScrub(typ.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if scrubAction(f) != "" || computeNeedsScrub(f.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func scrubAction(f reflect.StructField) string {
+	tag := f.Tag.Get(scrubTag)
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag == scrubOmit || tag == scrubHash {
+		return tag
+	}
+	return ""
+}
+
+// scrub returns a copy of v with the tagged fields omitted or hashed.
+// v itself is not modified.
+func (cd *Cache) scrub(v reflect.Value) (reflect.Value, error) {
+	typ := v.Type()
+	if !needsScrub(typ) {
+		return v, nil
+	}
+
+	switch typ.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v, nil
+		}
+		elem, err := cd.scrub(v.Elem())
+		if err != nil {
+			return v, err
+		}
+		p := reflect.New(typ.Elem())
+		p.Elem().Set(elem)
+		return p, nil
+	case reflect.Struct:
+		dst := reflect.New(typ).Elem()
+		dst.Set(v)
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			switch scrubAction(f) {
+			case scrubOmit:
+				dst.Field(i).Set(reflect.Zero(f.Type))
+			case scrubHash:
+				if err := cd.hashField(dst.Field(i)); err != nil {
+					return v, fmt.Errorf("cache: field %s.%s: %s", typ, f.Name, err)
+				}
+			default:
+				field, err := cd.scrub(v.Field(i))
+				if err != nil {
+					return v, err
+				}
+				dst.Field(i).Set(field)
+			}
+		}
+		return dst, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return v, nil
+		}
+		dst := reflect.MakeSlice(typ, v.Len(), v.Len())
+		return dst, cd.scrubElems(dst, v)
+	case reflect.Array:
+		dst := reflect.New(typ).Elem()
+		return dst, cd.scrubElems(dst, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return v, nil
+		}
+		dst := reflect.MakeMapWithSize(typ, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			elem, err := cd.scrub(iter.Value())
+			if err != nil {
+				return v, err
+			}
+			dst.SetMapIndex(iter.Key(), elem)
+		}
+		return dst, nil
+	}
+	return v, nil
+}
+
+func (cd *Cache) scrubElems(dst, src reflect.Value) error {
+	for i := 0; i < src.Len(); i++ {
+		elem, err := cd.scrub(src.Index(i))
+		if err != nil {
+			return err
+		}
+		dst.Index(i).Set(elem)
+	}
+	return nil
+}
+
+// hashField replaces the string or []byte in v with its hash. Empty
+// values stay empty.
+func (cd *Cache) hashField(v reflect.Value) error {
+	switch {
+	case v.Kind() == reflect.String:
+		if v.Len() > 0 {
+			v.SetString(hex.EncodeToString(cd.fieldHash([]byte(v.String()))))
+		}
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		if v.Len() > 0 {
+			v.SetBytes(cd.fieldHash(v.Bytes()))
+		}
+	default:
+		return fmt.Errorf(`cache:"hash" requires a string or []byte, got %s`, v.Type())
+	}
+	return nil
+}
+
+func (cd *Cache) fieldHash(b []byte) []byte {
+	if len(cd.opt.FieldHashKey) == 0 {
+		sum := sha256.Sum256(b)
+		return sum[:]
+	}
+	mac := hmac.New(sha256.New, cd.opt.FieldHashKey)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"reflect"
+	"sync"
+)
+
+// sharedResult is a result of the Once singleflight group with
+// Options.ShareDecoded. Concurrent callers decode the bytes once per
+// destination type and get shallow copies of the decoded value.
+type sharedResult struct {
+	v interface{} // []byte or staleValue
+
+	mu      sync.Mutex
+	decoded map[reflect.Type]reflect.Value
+}
+
+func (r *sharedResult) unmarshal(cd *Cache, key string, b []byte, dst interface{}) error {
+	typ := reflect.TypeOf(dst)
+	if typ == nil || typ.Kind() != reflect.Ptr || reflect.ValueOf(dst).IsNil() {
+		return cd.UnmarshalKey(key, b, dst)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.decoded[typ]
+	if !ok {
+		v = reflect.New(typ.Elem())
+		if err := cd.UnmarshalKey(key, b, v.Interface()); err != nil {
+			return err
+		}
+		if r.decoded == nil {
+			r.decoded = make(map[reflect.Type]reflect.Value)
+		}
+		r.decoded[typ] = v
+	}
+	cd.setDecoded(reflect.ValueOf(dst).Elem(), v.Elem())
+	return nil
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrOverloaded is returned by Once when Options.MaxInflightLoads
+// loaders are already running and the value can't be served otherwise.
+var ErrOverloaded = errors.New("cache: too many inflight loads")
+
+// ShedPolicy decides what Once does on a cache miss when
+// Options.MaxInflightLoads loaders are already running.
+type ShedPolicy int
+
+const (
+	// ShedError returns ErrOverloaded.
+	ShedError ShedPolicy = iota
+	// ShedStale returns the expired local cache value if there is one
+	// and ErrOverloaded otherwise.
+	ShedStale
+	// ShedQueue waits for a running loader to finish until the item
+	// context is done or Options.LoadQueueTimeout passes and returns
+	// ErrOverloaded after that.
+	ShedQueue
+)
+
+// acquireLoad reserves a slot for calling Item.Do.
+// The returned func releases it.
+func (cd *Cache) acquireLoad(item *Item) (func(), error) {
+	if cd.loads == nil {
+		return func() {}, nil
+	}
+
+	release := func() { <-cd.loads }
+
+	select {
+	case cd.loads <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if cd.opt.ShedPolicy != ShedQueue {
+		return nil, ErrOverloaded
+	}
+
+	ctx := item.Context()
+	if cd.opt.LoadQueueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cd.opt.LoadQueueTimeout)
+		defer cancel()
+	}
+
+	select {
+	case cd.loads <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ErrOverloaded
+	}
+}
+
+// shedStale reports whether Once should serve an expired local value
+// instead of failing with err.
+func (cd *Cache) shedStale(err error) bool {
+	return err == ErrOverloaded && cd.opt.ShedPolicy == ShedStale
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// signatureLen is the size of the HMAC-SHA256 and the key ID byte
+// appended to signed values.
+const signatureLen = sha256.Size + 1
+
+// ErrInvalidSignature is returned when a value read from Redis is not
+// signed with one of Options.SigningKeys.
+var ErrInvalidSignature = errors.New("cache: invalid value signature")
+
+// sign prepares b for Redis: it encrypts b with Options.Encryption and
+// appends the HMAC of the key and b computed with the current signing key,
+// followed by the key ID.
+func (cd *Cache) sign(key string, b []byte) ([]byte, error) {
+	b, err := cd.encrypt(key, cd.withChecksum(b))
+	if err != nil {
+		return nil, err
+	}
+	if len(cd.opt.SigningKeys) == 0 {
+		return b, nil
+	}
+
+	id := cd.opt.SigningKeyID
+	secret, ok := cd.opt.SigningKeys[id]
+	if !ok {
+		return nil, fmt.Errorf("cache: signing key %d is missing", id)
+	}
+
+	signed := make([]byte, 0, len(b)+signatureLen)
+	signed = append(signed, b...)
+	signed = append(signed, valueMAC(secret, key, b)...)
+	return append(signed, id), nil
+}
+
+// verify checks the signature of a value read from Redis
+// and returns the value without it, decrypted.
+func (cd *Cache) verify(key string, b []byte) ([]byte, error) {
+	if len(cd.opt.SigningKeys) == 0 {
+		return cd.decryptChecked(key, b)
+	}
+	if len(b) < signatureLen {
+		return nil, ErrInvalidSignature
+	}
+
+	secret, ok := cd.opt.SigningKeys[b[len(b)-1]]
+	if !ok {
+		return nil, ErrInvalidSignature
+	}
+
+	pos := len(b) - signatureLen
+	if !hmac.Equal(b[pos:len(b)-1], valueMAC(secret, key, b[:pos])) {
+		return nil, ErrInvalidSignature
+	}
+	return cd.decryptChecked(key, b[:pos:pos])
+}
+
+// decryptChecked decrypts the value and verifies its checksum.
+func (cd *Cache) decryptChecked(key string, b []byte) ([]byte, error) {
+	b, err := cd.decrypt(key, b)
+	if err != nil {
+		return nil, err
+	}
+	return cd.checkChecksum(b)
+}
+
+// valueMAC binds the value to its key so a signed value
+// can't be replayed under another key.
+func valueMAC(secret []byte, key string, b []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(key)))
+
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(buf[:n])
+	_, _ = mac.Write([]byte(key))
+	_, _ = mac.Write(b)
+	return mac.Sum(nil)
+}
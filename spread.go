@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+const defaultExpiryClusterSpread = time.Minute
+
+// SpreadTTL makes the batch distribute the TTLs of items added without
+// Item.TTL over [base, base+window), so keys written together by a warm
+// job don't all expire, and get reloaded, at once. A key always gets the
+// same TTL, so keys rewritten by every run stay spread out. A zero window
+// turns it off.
+func (b *Batch) SpreadTTL(base, window time.Duration) {
+	b.spreadBase = base
+	b.spreadWindow = window
+}
+
+// spreadItem returns a copy of the item with its TTL spread by the batch.
+func (b *Batch) spreadItem(item *Item) *Item {
+	if b.spreadWindow <= 0 || item.TTL != 0 {
+		return item
+	}
+	spread := *item
+	offset := xxhash.Sum64String(item.Key) % uint64(b.spreadWindow)
+	spread.TTL = b.spreadBase + time.Duration(offset)
+	return &spread
+}
+
+// expiryGuard counts values written to Redis per expiry second, see
+// Options.ExpiryClusterSize.
+type expiryGuard struct {
+	mu        sync.Mutex
+	counts    map[int64]int
+	nextPrune int64
+}
+
+func newExpiryGuard() *expiryGuard {
+	return &expiryGuard{
+		counts: make(map[int64]int),
+	}
+}
+
+// add counts a value expiring at the given second and returns the number
+// of values expiring at that second.
+func (g *expiryGuard) add(now time.Time, sec int64) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if unix := now.Unix(); unix >= g.nextPrune {
+		for s := range g.counts {
+			if s < unix {
+				delete(g.counts, s)
+			}
+		}
+		g.nextPrune = unix + 60
+	}
+	g.counts[sec]++
+	return g.counts[sec]
+}
+
+// spreadExpiry returns a copy of the item with a random delay added to
+// its TTL when more than Options.ExpiryClusterSize values written to Redis
+// already expire in the same second.
+func (cd *Cache) spreadExpiry(item *Item) *Item {
+	ttl := item.ttl()
+	if cd.clusters == nil || ttl == 0 {
+		return item
+	}
+
+	now := cd.now()
+	expiresAt := now.Add(ttl).Truncate(time.Second)
+	n := cd.clusters.add(now, expiresAt.Unix())
+	if n <= cd.opt.ExpiryClusterSize {
+		return item
+	}
+	if n == cd.opt.ExpiryClusterSize+1 {
+		cd.opt.ExpiryClusterWarning(expiresAt, n)
+	}
+
+	spread := *item
+	spread.TTL = ttl + time.Duration(rand.Int63n(int64(cd.opt.ExpiryClusterSpread)))
+	return &spread
+}
+
+func logExpiryCluster(expiresAt time.Time, count int) {
+	log.Printf("cache: %d values expire at %s, spreading further ones", count, expiresAt.Format(time.RFC3339))
+}
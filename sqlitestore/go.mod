@@ -0,0 +1,34 @@
+module github.com/star001007/cache/sqlitestore
+
+go 1.18
+
+require (
+	github.com/go-redis/redis/v7 v7.2.0
+	github.com/mattn/go-sqlite3 v1.14.0
+	github.com/onsi/ginkgo v1.10.1
+	github.com/onsi/gomega v1.7.0
+	github.com/star001007/cache v0.0.0
+)
+
+require (
+	github.com/VictoriaMetrics/fastcache v1.5.7 // indirect
+	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/golang/protobuf v1.3.3 // indirect
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/hpcloud/tail v1.0.0 // indirect
+	github.com/klauspost/compress v1.9.8 // indirect
+	github.com/pierrec/lz4 v2.0.5+incompatible // indirect
+	github.com/vmihailenco/bufpool v0.1.5 // indirect
+	github.com/vmihailenco/msgpack/v4 v4.3.7 // indirect
+	github.com/vmihailenco/tagparser v0.1.1 // indirect
+	go4.org v0.0.0-20200104003542-c7e774b10ea0 // indirect
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e // indirect
+	golang.org/x/sys v0.0.0-20200323222414-85ca7c5b95cd // indirect
+	golang.org/x/text v0.3.2 // indirect
+	google.golang.org/appengine v1.6.5 // indirect
+	gopkg.in/fsnotify.v1 v1.4.7 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v2 v2.2.4 // indirect
+)
+
+replace github.com/star001007/cache => ../
@@ -0,0 +1,232 @@
+// Package sqlitestore provides a cache.RemoteStore on SQLite, so small
+// single-node deployments can use the cache without running Redis.
+package sqlitestore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+type Options struct {
+	// Table is the name of the table holding the values.
+	// Default is "cache".
+	Table string
+
+	// VacuumInterval is how often expired values are deleted.
+	// Default is one minute.
+	VacuumInterval time.Duration
+
+	// Now returns the current time. Default is time.Now.
+	Now func() time.Time
+}
+
+func (opt *Options) init() {
+	if opt.Table == "" {
+		opt.Table = "cache"
+	}
+	if opt.VacuumInterval <= 0 {
+		opt.VacuumInterval = time.Minute
+	}
+	if opt.Now == nil {
+		opt.Now = time.Now
+	}
+}
+
+// Store keeps values in a SQLite table with the columns key, value, and
+// expires_at, which holds the expiration time in Unix milliseconds or
+// NULL for values that never expire.
+type Store struct {
+	db  *sql.DB
+	opt *Options
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates the table if it doesn't exist and starts deleting expired
+// values in the background. db must be opened with a SQLite driver.
+// Call Close when done.
+func New(db *sql.DB, opt *Options) (*Store, error) {
+	var o Options
+	if opt != nil {
+		o = *opt
+	}
+	o.init()
+
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		key TEXT PRIMARY KEY,
+		value BLOB NOT NULL,
+		expires_at INTEGER
+	)`, o.Table))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		db:   db,
+		opt:  &o,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go s.vacuumLoop()
+	return s, nil
+}
+
+// Close stops the background vacuum. It doesn't close the database.
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+	return nil
+}
+
+func (s *Store) vacuumLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.opt.VacuumInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_, _ = s.Vacuum()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Vacuum deletes expired values and returns how many were deleted.
+func (s *Store) Vacuum() (int64, error) {
+	res, err := s.db.Exec(fmt.Sprintf(
+		"DELETE FROM %s WHERE expires_at <= ?", s.opt.Table), s.nowMillis())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Store) nowMillis() int64 {
+	return s.opt.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// expiresAt converts a TTL to the expires_at column. Zero TTL never expires.
+func (s *Store) expiresAt(expiration time.Duration) interface{} {
+	if expiration <= 0 {
+		return nil
+	}
+	return s.nowMillis() + expiration.Milliseconds()
+}
+
+// Set stores the value like Redis SET.
+func (s *Store) Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	_, err := s.db.Exec(fmt.Sprintf(`INSERT INTO %s (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+		s.opt.Table), key, toBytes(value), s.expiresAt(expiration))
+	if err != nil {
+		return redis.NewStatusResult("", err)
+	}
+	return redis.NewStatusResult("OK", nil)
+}
+
+// SetXX stores the value only if the key exists, like Redis SET XX.
+func (s *Store) SetXX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	res, err := s.db.Exec(fmt.Sprintf(`UPDATE %s SET value = ?, expires_at = ?
+		WHERE key = ? AND (expires_at IS NULL OR expires_at > ?)`, s.opt.Table),
+		toBytes(value), s.expiresAt(expiration), key, s.nowMillis())
+	return boolResult(res, err)
+}
+
+// SetNX stores the value only if the key doesn't exist, like Redis SET NX.
+func (s *Store) SetNX(key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	res, err := s.db.Exec(fmt.Sprintf(`INSERT INTO %s (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at
+		WHERE expires_at <= ?`, s.opt.Table),
+		key, toBytes(value), s.expiresAt(expiration), s.nowMillis())
+	return boolResult(res, err)
+}
+
+// Get returns the value of the key or redis.Nil when it is missing.
+func (s *Store) Get(key string) *redis.StringCmd {
+	var b []byte
+	err := s.db.QueryRow(fmt.Sprintf(`SELECT value FROM %s
+		WHERE key = ? AND (expires_at IS NULL OR expires_at > ?)`, s.opt.Table),
+		key, s.nowMillis()).Scan(&b)
+	if err == sql.ErrNoRows {
+		err = redis.Nil
+	}
+	return redis.NewStringResult(string(b), err)
+}
+
+// Del deletes the keys and returns how many of them existed.
+func (s *Store) Del(keys ...string) *redis.IntCmd {
+	if len(keys) == 0 {
+		return redis.NewIntResult(0, nil)
+	}
+
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, s.nowMillis())
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Expired keys are deleted too, but not counted.
+	var n int64
+	err = tx.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s
+		WHERE (expires_at IS NULL OR expires_at > ?) AND key IN (%s)`,
+		s.opt.Table, placeholders(len(keys))), args...).Scan(&n)
+	if err != nil {
+		return redis.NewIntResult(0, err)
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE key IN (%s)",
+		s.opt.Table, placeholders(len(keys))), args[1:]...)
+	if err != nil {
+		return redis.NewIntResult(0, err)
+	}
+	return redis.NewIntResult(n, tx.Commit())
+}
+
+func boolResult(res sql.Result, err error) *redis.BoolCmd {
+	if err != nil {
+		return redis.NewBoolResult(false, err)
+	}
+	n, err := res.RowsAffected()
+	return redis.NewBoolResult(n > 0, err)
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func toBytes(value interface{}) []byte {
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		b = []byte(fmt.Sprint(v))
+	}
+	if b == nil {
+		// database/sql stores nil slices as NULL.
+		b = []byte{}
+	}
+	return b
+}
@@ -0,0 +1,110 @@
+//go:build cgo
+// +build cgo
+
+package sqlitestore_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/star001007/cache"
+	"github.com/star001007/cache/sqlitestore"
+)
+
+func TestGinkgo(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "sqlitestore")
+}
+
+var _ = Describe("sqlitestore", func() {
+	ctx := context.TODO()
+
+	var now time.Time
+	var db *sql.DB
+	var store *sqlitestore.Store
+	var mycache *cache.Cache
+
+	BeforeEach(func() {
+		now = time.Now()
+
+		var err error
+		db, err = sql.Open("sqlite3", ":memory:")
+		Expect(err).NotTo(HaveOccurred())
+		db.SetMaxOpenConns(1)
+
+		store, err = sqlitestore.New(db, &sqlitestore.Options{
+			Now: func() time.Time {
+				return now
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		mycache = cache.New(&cache.Options{
+			Redis: store,
+		})
+	})
+
+	AfterEach(func() {
+		_ = store.Close()
+		_ = db.Close()
+	})
+
+	It("gets, sets, and deletes values", func() {
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		var got string
+		err = mycache.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("value"))
+
+		err = mycache.Delete(ctx, "mykey")
+		Expect(err).NotTo(HaveOccurred())
+
+		err = mycache.Delete(ctx, "mykey")
+		Expect(err).To(Equal(cache.ErrCacheMiss))
+	})
+
+	It("honors IfExists and IfNotExists", func() {
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v1", IfExists: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mycache.Exists(ctx, "mykey")).To(BeFalse())
+
+		err = mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v1", IfNotExists: true})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "v2", IfNotExists: true})
+		Expect(err).NotTo(HaveOccurred())
+
+		var got string
+		err = mycache.Get(ctx, "mykey", &got)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("v1"))
+	})
+
+	It("expires and vacuums values", func() {
+		err := mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value", TTL: time.Minute})
+		Expect(err).NotTo(HaveOccurred())
+
+		now = now.Add(2 * time.Minute)
+		Expect(mycache.Exists(ctx, "mykey")).To(BeFalse())
+
+		err = mycache.Set(&cache.Item{Ctx: ctx, Key: "mykey", Value: "value", IfNotExists: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mycache.Exists(ctx, "mykey")).To(BeTrue())
+
+		err = mycache.Set(&cache.Item{Ctx: ctx, Key: "other", Value: "value", TTL: time.Minute})
+		Expect(err).NotTo(HaveOccurred())
+		now = now.Add(2 * time.Minute)
+
+		n, err := store.Vacuum()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(int64(1)))
+	})
+})
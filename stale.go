@@ -0,0 +1,49 @@
+package cache
+
+// staleValue is a value past its TTL that Once serves because Item.Do
+// failed, see Item.StaleIfError.
+type staleValue []byte
+
+// staleIfErrorItem returns the item to write to Redis for an item with
+// StaleIfError: a copy whose TTL includes the grace period. The payload
+// gets a creation time, so readers in every tier can tell when the TTL
+// has passed. It returns the item itself when StaleIfError doesn't apply,
+// e.g. to values without a trailer.
+func (cd *Cache) staleIfErrorItem(item *Item, value interface{}, b []byte) (*Item, []byte) {
+	if item.StaleIfError <= 0 || item.ttl() == 0 || isRawValue(value) || len(b) == 0 ||
+		item.Marshal != nil {
+		return item, b
+	}
+	if !cd.hasTrailer(item.Key) {
+		return item, b
+	}
+	if _, bundled := cd.bundlePrefix(item.Key); bundled {
+		return item, b
+	}
+
+	trailer := b[len(b)-1]
+	if trailer&createdAtFlag == 0 {
+		withTime := make([]byte, 0, len(b)+createdAtLen)
+		withTime = append(withTime, b[:len(b)-1]...)
+		withTime = appendCreatedAt(withTime, cd.now())
+		b = append(withTime, trailer|createdAtFlag)
+	}
+
+	extended := *item
+	extended.TTL = item.ttl() + item.StaleIfError
+	return &extended, b
+}
+
+// softExpired reports whether the value of an item with StaleIfError has
+// outlived the item TTL and should be loaded again.
+func (cd *Cache) softExpired(item *Item, b []byte) bool {
+	if item.StaleIfError <= 0 || item.ttl() == 0 || len(b) == 0 || item.Marshal != nil {
+		return false
+	}
+	switch item.Value.(type) {
+	case *string, *[]byte:
+		return false
+	}
+	age, ok := cd.age(item.Key, b)
+	return ok && age > item.ttl()
+}
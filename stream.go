@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+var errCorruptStreamChunk = errors.New("cache: corrupt stream chunk")
+
+type streamHeader struct {
+	Gen    int64
+	Chunks int
+	Len    int
+}
+
+// SetStream caches a stream of values under one key. next is called until
+// it returns io.EOF. Values are stored in chunks of Options.StreamChunkSize
+// so GetStream can fetch and decode them lazily instead of decoding the
+// whole stream up front. The chunks of the previous stream are deleted
+// once the new one is written.
+func (cd *Cache) SetStream(
+	ctx context.Context, key string, next func() (interface{}, error), ttl time.Duration,
+) error {
+	hdr := streamHeader{
+		Gen: cd.now().UnixNano(),
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf).UseCompactEncoding(true)
+	var count int
+
+	flush := func() error {
+		if count == 0 {
+			return nil
+		}
+
+		chunk := encodeStreamChunk(count, buf.Bytes(), cd.opt.CompressionThreshold)
+		_, _, err := cd.set(&Item{
+			Ctx:   ctx,
+			Key:   streamChunkKey(key, hdr.Gen, hdr.Chunks),
+			Value: rawPayload(chunk),
+			TTL:   ttl,
+		})
+		if err != nil {
+			return err
+		}
+
+		hdr.Chunks++
+		buf.Reset()
+		count = 0
+		return nil
+	}
+
+	for {
+		value, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := enc.Encode(value); err != nil {
+			return err
+		}
+		count++
+		hdr.Len++
+
+		if count == cd.opt.StreamChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	var prev streamHeader
+	if err := cd.Get(ctx, key, &prev); err != nil {
+		prev = streamHeader{}
+	}
+
+	err := cd.Set(&Item{
+		Ctx:   ctx,
+		Key:   key,
+		Value: &hdr,
+		TTL:   ttl,
+	})
+	if err != nil {
+		return err
+	}
+	return cd.deleteStreamChunks(ctx, key, prev, hdr)
+}
+
+// deleteStreamChunks deletes the chunks of prev that hdr doesn't use.
+func (cd *Cache) deleteStreamChunks(ctx context.Context, key string, prev, hdr streamHeader) error {
+	first := 0
+	if prev.Gen == hdr.Gen {
+		// The chunks of hdr replaced the ones of prev.
+		first = hdr.Chunks
+	}
+	if first >= prev.Chunks {
+		return nil
+	}
+
+	keys := make([]string, 0, prev.Chunks-first)
+	for i := first; i < prev.Chunks; i++ {
+		keys = append(keys, streamChunkKey(key, prev.Gen, i))
+	}
+	return cd.DeleteMany(ctx, keys)
+}
+
+// GetStream returns an iterator over the stream cached with SetStream.
+// Chunks are fetched as the iterator reaches them.
+func (cd *Cache) GetStream(ctx context.Context, key string) (*Iterator, error) {
+	it := &Iterator{
+		cd:  cd,
+		ctx: ctx,
+		key: key,
+		dec: msgpack.NewDecoder(nil),
+	}
+	if err := cd.Get(ctx, key, &it.hdr); err != nil {
+		return nil, err
+	}
+	if cd.opt.StrictDecode {
+		it.dec.DisallowUnknownFields()
+	}
+	return it, nil
+}
+
+// Iterator iterates over values cached with SetStream.
+//
+//	for it.Next() {
+//		var v Value
+//		if err := it.Decode(&v); err != nil {
+//			return err
+//		}
+//	}
+//	return it.Err()
+type Iterator struct {
+	cd  *Cache
+	ctx context.Context
+	key string
+	hdr streamHeader
+
+	chunk   int
+	dec     *msgpack.Decoder
+	left    int
+	pending bool
+	err     error
+}
+
+// Len returns the number of values in the stream.
+func (it *Iterator) Len() int {
+	return it.hdr.Len
+}
+
+// Next advances the iterator to the next value, which can then be
+// decoded with Decode. It returns false at the end of the stream or
+// on error.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	if it.pending {
+		if err := it.dec.Skip(); err != nil {
+			it.err = err
+			return false
+		}
+		it.pending = false
+	}
+
+	if it.left == 0 {
+		if it.chunk == it.hdr.Chunks {
+			return false
+		}
+		if err := it.loadChunk(); err != nil {
+			it.err = err
+			return false
+		}
+	}
+
+	it.left--
+	it.pending = true
+	return true
+}
+
+// Decode decodes the current value.
+func (it *Iterator) Decode(value interface{}) error {
+	if !it.pending {
+		return errors.New("cache: Decode called without Next")
+	}
+	it.pending = false
+
+	if err := it.dec.Decode(value); err != nil {
+		if it.cd.opt.StrictDecode {
+			err = &DecodeError{Err: err}
+		}
+		it.err = err
+		return err
+	}
+	return nil
+}
+
+// Err returns the error that stopped the iteration, if any. A chunk that
+// expired or was evicted before it was read is reported as ErrCacheMiss.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func (it *Iterator) loadChunk() error {
+	b, err := it.cd.getBytes(it.ctx, streamChunkKey(it.key, it.hdr.Gen, it.chunk), false)
+	if err != nil {
+		return err
+	}
+
+	count, raw, err := decodeStreamChunk(b)
+	if err != nil {
+		return err
+	}
+
+	it.chunk++
+	it.left = count
+	it.dec.Reset(bytes.NewReader(raw))
+	return nil
+}
+
+func streamChunkKey(key string, gen int64, chunk int) string {
+	return key + ":" + strconv.FormatInt(gen, 10) + ":" + strconv.Itoa(chunk)
+}
+
+// encodeStreamChunk prefixes the encoded values with their count and
+// compresses the chunk like Marshal does.
+func encodeStreamChunk(count int, values []byte, threshold int) []byte {
+	b := appendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(values)+1), uint64(count))
+	b = append(b, values...)
+
+	if len(b) < threshold {
+		return append(b, noCompression)
+	}
+	b = s2.Encode(nil, b)
+	return append(b, s2Compression)
+}
+
+func decodeStreamChunk(b []byte) (int, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, errCorruptStreamChunk
+	}
+
+	switch c := b[len(b)-1]; c {
+	case noCompression:
+		b = b[:len(b)-1]
+	case s2Compression:
+		var err error
+		b, err = s2.Decode(nil, b[:len(b)-1])
+		if err != nil {
+			return 0, nil, err
+		}
+	default:
+		return 0, nil, fmt.Errorf("cache: unknown compression method: %x", c)
+	}
+
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, errCorruptStreamChunk
+	}
+	return int(count), b[n:], nil
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// expiryIndex tracks when local cache entries expire, since fastcache
+// itself never evicts entries by time.
+type expiryIndex struct {
+	mu   sync.Mutex
+	keys map[string]time.Time
+}
+
+func (idx *expiryIndex) add(key string, expiresAt time.Time) {
+	idx.mu.Lock()
+	idx.keys[key] = expiresAt
+	idx.mu.Unlock()
+}
+
+// expired removes and returns the keys that expired before now.
+func (idx *expiryIndex) expired(now time.Time) []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var keys []string
+	for key, expiresAt := range idx.keys {
+		if now.After(expiresAt) {
+			keys = append(keys, key)
+			delete(idx.keys, key)
+		}
+	}
+	return keys
+}
+
+func (cd *Cache) startSweeper() {
+	cd.expiries = &expiryIndex{
+		keys: make(map[string]time.Time),
+	}
+	cd.stopSweeper = make(chan struct{})
+	cd.sweeperDone = make(chan struct{})
+
+	go func() {
+		defer close(cd.sweeperDone)
+
+		ticker := time.NewTicker(cd.opt.LocalSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-cd.stopSweeper:
+				return
+			case <-ticker.C:
+				cd.sweep()
+			}
+		}
+	}()
+}
+
+// sweep deletes expired entries from the local cache so fastcache can reuse
+// their space when its buckets wrap around.
+func (cd *Cache) sweep() {
+	now := cd.now()
+	for _, key := range cd.expiries.expired(now) {
+		b, ok := cd.local.HasGet(nil, []byte(key))
+		if !ok || len(b) < 4 {
+			continue
+		}
+
+		tm := decodeTime(b[len(b)-4:])
+		if lifetime := now.Sub(tm); !cd.localExpired(tm, lifetime) {
+			cd.expiries.add(key, tm.Add(cd.localTTL(tm)))
+			continue
+		}
+
+		cd.local.Del([]byte(key))
+		atomic.AddUint64(&cd.swept, 1)
+		atomic.AddUint64(&cd.sweptBytes, uint64(len(key)+len(b)))
+	}
+}
+
+// localTTL returns how long local cache entries stored at the given
+// time are served without going to Redis.
+func (cd *Cache) localTTL(storedAt time.Time) time.Duration {
+	ttl := cd.opt.LocalCacheStoreTTL
+	if !cd.opt.BackgroundUpdate && cd.opt.LocalCacheTTL < cd.opt.LocalCacheStoreTTL {
+		ttl = cd.opt.LocalCacheTTL
+	}
+	return cd.degradedTTL(cd.rampTTL(ttl, storedAt))
+}
+
+func (cd *Cache) localExpired(storedAt time.Time, lifetime time.Duration) bool {
+	return lifetime > cd.localTTL(storedAt)
+}
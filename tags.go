@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const defaultTagBatchSize = 1000
+
+var errTagsUnsupported = errors.New("cache: Redis client does not support SSCAN and SREM")
+
+type setScanner interface {
+	SScan(key string, cursor uint64, match string, count int64) *redis.ScanCmd
+	SRem(key string, members ...interface{}) *redis.IntCmd
+}
+
+func tagKey(tag string) string {
+	return tagPrefix + tag
+}
+
+// InvalidateTagOptions configures InvalidateTag.
+type InvalidateTagOptions struct {
+	// BatchSize is the number of keys read from the tag and deleted
+	// at once. Default is 1000.
+	BatchSize int64
+
+	// Cursor resumes an interrupted invalidation from the cursor of
+	// the last Progress call. Zero starts from the beginning.
+	Cursor uint64
+
+	// Progress is called after every batch with the number of keys
+	// deleted so far and the cursor to resume from. The cursor is zero
+	// after the last batch.
+	Progress func(deleted int, cursor uint64)
+}
+
+func (opt *InvalidateTagOptions) batchSize() int64 {
+	if opt == nil || opt.BatchSize <= 0 {
+		return defaultTagBatchSize
+	}
+	return opt.BatchSize
+}
+
+// addTags adds the key to the tags in Redis, or to the in-process index
+// without Redis. The tags live at least as long as ttl.
+func (cd *Cache) addTags(tags []string, key string, ttl time.Duration) error {
+	if cd.dryRun() {
+		return nil
+	}
+
+	sets := make([]string, len(tags))
+	for i, tag := range tags {
+		sets[i] = tagKey(tag)
+	}
+	if !cd.useRedis() {
+		for _, set := range sets {
+			cd.deps.add(set, key)
+		}
+		return nil
+	}
+	return cd.addMember(sets, key, ttl)
+}
+
+// InvalidateTag deletes all keys set with the tag from both tiers. Keys
+// are read from Redis and deleted in batches of opt.BatchSize, so tags
+// with millions of keys are invalidated with bounded memory. When ctx is
+// canceled or a delete fails, InvalidateTag returns the error and can be
+// called again with the cursor of the last Progress call to continue.
+// Keys tagged while the invalidation runs may or may not be deleted.
+// opt may be nil.
+func (cd *Cache) InvalidateTag(ctx context.Context, tag string, opt *InvalidateTagOptions) error {
+	if cd.opt.ReadOnly {
+		return ErrReadOnly
+	}
+
+	var progress func(int, uint64)
+	var cursor uint64
+	if opt != nil {
+		progress = opt.Progress
+		cursor = opt.Cursor
+	}
+
+	if !cd.useRedis() {
+		keys := cd.deps.take(tagKey(tag))
+		if err := cd.deleteKeys(ctx, keys); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(len(keys), 0)
+		}
+		reportProgress(ctx, len(keys), 0)
+		return nil
+	}
+
+	s, ok := cd.opt.Redis.(setScanner)
+	if !ok {
+		return errTagsUnsupported
+	}
+
+	var deleted int
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		keys, next, err := s.SScan(tagKey(tag), cursor, "", opt.batchSize()).Result()
+		if err != nil {
+			return err
+		}
+		if err := cd.deleteKeys(ctx, keys); err != nil {
+			return err
+		}
+
+		// Deleted keys are removed from the tag, so a resumed or
+		// repeated invalidation doesn't delete them again.
+		if len(keys) > 0 {
+			members := make([]interface{}, len(keys))
+			for i, key := range keys {
+				members[i] = key
+			}
+			if err := s.SRem(tagKey(tag), members...).Err(); err != nil {
+				return err
+			}
+		}
+
+		deleted += len(keys)
+		cursor = next
+		if progress != nil {
+			progress(deleted, cursor)
+		}
+		reportProgress(ctx, deleted, 0)
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func (cd *Cache) deleteKeys(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := cd.Delete(ctx, key); err != nil && err != ErrCacheMiss {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+const timeKeyLayout = "20060102T150405Z"
+
+// TimedKey generates cache keys for values computed per time window, e.g.
+// statistics of the last five minutes. Windows are aligned to multiples
+// of Window since the zero time in UTC.
+type TimedKey struct {
+	Prefix string
+	Window time.Duration
+	// Grace is how long after a window starts the value of the previous
+	// window is served while the new one is missing. Default is a tenth
+	// of Window.
+	Grace time.Duration
+}
+
+// TimeKey returns a TimedKey with the given prefix and window.
+func TimeKey(prefix string, window time.Duration) *TimedKey {
+	return &TimedKey{
+		Prefix: prefix,
+		Window: window,
+		Grace:  window / 10,
+	}
+}
+
+// Start returns the start of the window containing tm.
+func (k *TimedKey) Start(tm time.Time) time.Time {
+	return tm.UTC().Truncate(k.Window)
+}
+
+// Key returns the key of the window containing tm,
+// e.g. "stats:20240101T120500Z".
+func (k *TimedKey) Key(tm time.Time) string {
+	return k.Prefix + ":" + k.Start(tm).Format(timeKeyLayout)
+}
+
+// Previous returns the key of the window before the one containing tm.
+func (k *TimedKey) Previous(tm time.Time) string {
+	return k.Key(k.Start(tm).Add(-k.Window))
+}
+
+// TTL returns the TTL of the value of the window containing tm: until the
+// window ends plus Grace, so it can be served while the next window
+// is computed.
+func (k *TimedKey) TTL(tm time.Time) time.Duration {
+	ttl := k.Start(tm).Add(k.Window + k.Grace).Sub(tm)
+	// Item treats shorter TTLs as the default TTL.
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttl
+}
+
+// inGrace reports whether tm is within Grace after its window started.
+func (k *TimedKey) inGrace(tm time.Time) bool {
+	return tm.Sub(k.Start(tm)) < k.Grace
+}
+
+// SetTimed caches the value for the current window of the key.
+func (cd *Cache) SetTimed(ctx context.Context, k *TimedKey, value interface{}) error {
+	now := cd.now()
+	return cd.Set(&Item{
+		Ctx:   ctx,
+		Key:   k.Key(now),
+		Value: value,
+		TTL:   k.TTL(now),
+	})
+}
+
+// GetTimed gets the value of the current window of the key. Within Grace
+// after the window started, it falls back to the value of the previous
+// window when the current one is missing.
+func (cd *Cache) GetTimed(ctx context.Context, k *TimedKey, value interface{}) error {
+	now := cd.now()
+	err := cd.Get(ctx, k.Key(now), value)
+	if err == ErrCacheMiss && k.inGrace(now) {
+		return cd.Get(ctx, k.Previous(now), value)
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTombstoned is returned by Set when the key was deleted with
+// Tombstone and Options.Tombstones is enabled. The caller's value is
+// stale and is discarded.
+var ErrTombstoned = errors.New("cache: key is tombstoned")
+
+// tombstone marks deleted keys in Redis. It starts with a byte msgpack
+// never uses and ends with an unknown trailer, so it can't be a marshaled
+// value.
+var tombstone = []byte("\xc1cache:tombstone\xff")
+
+var errTombstonesUnsupported = errors.New("cache: Redis client does not support tombstones")
+
+// tombstoneSetScript sets the value unless the key holds a tombstone,
+// which may be followed by a signature. KEYS: key. ARGV: value,
+// tombstone, TTL in ms, "XX" to only set existing keys.
+const tombstoneSetScript = `
+local cur = redis.call("GET", KEYS[1])
+if cur and string.sub(cur, 1, #ARGV[2]) == ARGV[2] then
+	return -1
+end
+if ARGV[4] == "XX" and not cur then
+	return 0
+end
+local ttl = tonumber(ARGV[3])
+if ttl > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ttl)
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 1
+`
+
+func isTombstone(b []byte) bool {
+	return bytes.Equal(b, tombstone)
+}
+
+// Tombstone replaces the value of the key with a tombstone that lives for
+// ttl, interpreted like Item.TTL. Reads treat the key as missing, and
+// with Options.Tombstones writers racing the delete get ErrTombstoned
+// instead of restoring stale data.
+func (cd *Cache) Tombstone(ctx context.Context, key string, ttl time.Duration) error {
+	if cd.opt.ReadOnly {
+		return ErrReadOnly
+	}
+	if cd.dryRun() {
+		return nil
+	}
+
+	if cd.writes != nil {
+		defer cd.writes.touch(key)
+	}
+
+	unlock := cd.keyLocks.lock(key)
+	cd.forgetDelta(key)
+	if cd.local != nil {
+		cd.local.Del([]byte(key))
+	}
+
+	if !cd.useRedis() {
+		unlock()
+		if cd.opt.Redis == nil && cd.local == nil {
+			return errRedisLocalCacheNil
+		}
+		return nil
+	}
+
+	item := &Item{Ctx: ctx, Key: key, TTL: ttl}
+	signed, err := cd.sign(key, tombstone)
+	if err == nil {
+		if prefix, ok := cd.bundlePrefix(key); ok {
+			err = cd.bundleSet(item, prefix, signed)
+		} else {
+			err = cd.opt.Redis.Set(key, signed, item.ttl()).Err()
+		}
+	}
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	cd.invalidate(opDelete, []string{key}, nil)
+	return cd.deleteDependents(ctx, key)
+}
+
+// tombstoneAwareSet stores the value unless the key is tombstoned.
+func (cd *Cache) tombstoneAwareSet(item *Item, b []byte) (bool, error) {
+	if !cd.useScripts() {
+		return false, errScriptsDisabled
+	}
+	s, ok := cd.opt.Redis.(scripter)
+	if !ok {
+		return false, errTombstonesUnsupported
+	}
+
+	var mode string
+	if item.IfExists {
+		mode = "XX"
+	}
+	n, err := s.Eval(tombstoneSetScript, []string{item.Key},
+		b, tombstone, item.ttl().Milliseconds(), mode).Int()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 {
+		return false, ErrTombstoned
+	}
+	return n == 1, nil
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+const defaultTrashTTL = 15 * time.Minute
+
+var errTrashRequiresRedis = errors.New("cache: SoftDelete and Restore require Redis")
+
+func trashKey(key string) string {
+	return "trash:" + key
+}
+
+// trashEntry is what SoftDelete stores under the trash key.
+type trashEntry struct {
+	Value []byte `msgpack:"v"`
+	// ExpireAt is when the value would have expired in Unix
+	// milliseconds, or zero when it had no TTL.
+	ExpireAt int64 `msgpack:"e"`
+}
+
+// SoftDelete deletes the key like Delete, but first moves the value to
+// trash:<key> for Options.TrashTTL, so Restore can bring it back after an
+// accidental invalidation. It returns ErrCacheMiss when the key is not
+// cached in Redis.
+func (cd *Cache) SoftDelete(ctx context.Context, key string) error {
+	if cd.opt.ReadOnly {
+		return ErrReadOnly
+	}
+	if !cd.useRedis() {
+		return errTrashRequiresRedis
+	}
+
+	b, err := cd.getRedisBytes(key, true)
+	if err != nil {
+		return err
+	}
+
+	entry := trashEntry{Value: b}
+	if _, bundled := cd.bundlePrefix(key); !bundled {
+		if p, ok := cd.opt.Redis.(pttler); ok {
+			ttl, err := p.PTTL(key).Result()
+			if err != nil {
+				return err
+			}
+			if ttl > 0 {
+				entry.ExpireAt = cd.now().Add(ttl).UnixNano() / int64(time.Millisecond)
+			}
+		}
+	}
+
+	packed, err := msgpack.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	signed, err := cd.sign(trashKey(key), packed)
+	if err != nil {
+		return err
+	}
+	if err := cd.opt.Redis.Set(trashKey(key), signed, cd.opt.TrashTTL).Err(); err != nil {
+		return err
+	}
+
+	err = cd.Delete(ctx, key)
+	if err == ErrCacheMiss {
+		// Deleted concurrently; the trash still has the value.
+		return nil
+	}
+	return err
+}
+
+// Restore sets the key to the value moved to the trash by SoftDelete with
+// the TTL it had left, overwriting a value set since. Tags and
+// dependencies of the value are not restored. It returns ErrCacheMiss when
+// the trash has no value for the key or the value would have expired.
+func (cd *Cache) Restore(ctx context.Context, key string) error {
+	if cd.opt.ReadOnly {
+		return ErrReadOnly
+	}
+	if !cd.useRedis() {
+		return errTrashRequiresRedis
+	}
+
+	b, err := cd.opt.Redis.Get(trashKey(key)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return ErrCacheMiss
+		}
+		return err
+	}
+	b, err = cd.verify(trashKey(key), b)
+	if err != nil {
+		return err
+	}
+
+	var entry trashEntry
+	if err := msgpack.Unmarshal(b, &entry); err != nil {
+		return err
+	}
+
+	ttl := time.Duration(-1)
+	if entry.ExpireAt > 0 {
+		ttl = time.Unix(0, entry.ExpireAt*int64(time.Millisecond)).Sub(cd.now())
+		if ttl < time.Millisecond {
+			return ErrCacheMiss
+		}
+		// Item.TTL below a second means the default TTL.
+		if ttl < time.Second {
+			ttl = time.Second
+		}
+	}
+
+	// The value is the stored payload, so it is written back as is,
+	// without the plain format or header of the key.
+	if err := cd.Set(&Item{
+		Ctx:   ctx,
+		Key:   key,
+		Value: rawPayload(entry.Value),
+		TTL:   ttl,
+	}); err != nil {
+		return err
+	}
+	return cd.opt.Redis.Del(trashKey(key)).Err()
+}
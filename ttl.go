@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ttlBuckets are the upper bounds of TTLStats buckets.
+var ttlBuckets = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+}
+
+// TTLBucket counts values written to Redis with a TTL in (previous Max, Max].
+// The last bucket has zero Max and counts longer TTLs and values that
+// never expire.
+type TTLBucket struct {
+	Max   time.Duration
+	Count uint64
+}
+
+// TTLStats is the distribution of TTLs of values written to Redis.
+type TTLStats struct {
+	Buckets []TTLBucket
+
+	// Defaulted is the number of values written without Item.TTL
+	// that got the default TTL of one hour.
+	Defaulted uint64
+}
+
+// ttlTracker records TTLs of written values and counts upcoming
+// expirations per minute.
+type ttlTracker struct {
+	mu        sync.Mutex
+	counts    []uint64
+	defaulted uint64
+	expiries  map[int64]uint64
+	nextPrune int64
+}
+
+func newTTLTracker() *ttlTracker {
+	return &ttlTracker{
+		counts:   make([]uint64, len(ttlBuckets)+1),
+		expiries: make(map[int64]uint64),
+	}
+}
+
+func (t *ttlTracker) add(now time.Time, ttl time.Duration, defaulted bool) {
+	i := len(ttlBuckets)
+	if ttl > 0 {
+		i = sort.Search(len(ttlBuckets), func(i int) bool {
+			return ttl <= ttlBuckets[i]
+		})
+	}
+	minute := now.Unix() / 60
+
+	t.mu.Lock()
+	t.counts[i]++
+	if defaulted {
+		t.defaulted++
+	}
+	if ttl > 0 {
+		t.expiries[now.Add(ttl).Unix()/60]++
+	}
+	if minute >= t.nextPrune {
+		for m := range t.expiries {
+			if m < minute {
+				delete(t.expiries, m)
+			}
+		}
+		t.nextPrune = minute + 1
+	}
+	t.mu.Unlock()
+}
+
+func (t *ttlTracker) stats() *TTLStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st := &TTLStats{
+		Buckets:   make([]TTLBucket, len(t.counts)),
+		Defaulted: t.defaulted,
+	}
+	for i, n := range t.counts {
+		if i < len(ttlBuckets) {
+			st.Buckets[i].Max = ttlBuckets[i]
+		}
+		st.Buckets[i].Count = n
+	}
+	return st
+}
+
+func (t *ttlTracker) expiring(now time.Time, d time.Duration) uint64 {
+	from := now.Unix() / 60
+	to := now.Add(d).Unix() / 60
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var n uint64
+	for m, count := range t.expiries {
+		if m >= from && m <= to {
+			n += count
+		}
+	}
+	return n
+}
+
+// recordTTL records a value written to Redis with the item TTL.
+func (cd *Cache) recordTTL(item *Item) {
+	if cd.ttls == nil {
+		return
+	}
+	defaulted := item.TTL >= 0 && item.TTL < time.Second
+	cd.ttls.add(cd.now(), item.ttl(), defaulted)
+}
+
+// TTLStats returns the distribution of TTLs of values written to Redis
+// by this process. It returns nil unless Options.StatsEnabled is set.
+func (cd *Cache) TTLStats() *TTLStats {
+	if cd.ttls == nil {
+		return nil
+	}
+	return cd.ttls.stats()
+}
+
+// ExpiringWithin forecasts how many values written to Redis by this
+// process expire within d, with one minute resolution. Values written
+// several times are counted once per write. It returns 0 unless
+// Options.StatsEnabled is set.
+func (cd *Cache) ExpiringWithin(d time.Duration) uint64 {
+	if cd.ttls == nil {
+		return 0
+	}
+	return cd.ttls.expiring(cd.now(), d)
+}
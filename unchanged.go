@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// skipUnchanged reports whether Redis already holds b for the item, in
+// which case only the TTL is refreshed instead of writing the value again.
+// It must be called before the local copy is overwritten.
+func (cd *Cache) skipUnchanged(item *Item, b []byte) bool {
+	if !cd.opt.SkipUnchangedWrites || cd.opt.Tombstones || item.ttl() == 0 ||
+		item.IfExists || item.IfNotExists || item.FencingToken > 0 ||
+		item.Immutable || item.Critical {
+		return false
+	}
+	if _, ok := cd.bundlePrefix(item.Key); ok {
+		return false
+	}
+
+	if cd.opt.ContentHash {
+		hash, err := cd.redisHash(item.Key)
+		if err != nil || hash != contentHash(b) {
+			return false
+		}
+	} else {
+		if !cd.useLocalCache() {
+			return false
+		}
+		local, ok, _ := cd.localGet(item.Key)
+		if !ok || !bytes.Equal(local, b) {
+			return false
+		}
+	}
+
+	e, ok := cd.opt.Redis.(expirer)
+	if !ok {
+		return false
+	}
+	// PEXPIRE fails when the value has expired or was deleted meanwhile.
+	if ok, err := e.PExpire(item.Key, item.ttl()).Result(); err != nil || !ok {
+		return false
+	}
+	if cd.opt.ContentHash {
+		_ = e.PExpire(hashKey(item.Key), item.ttl()).Err()
+	}
+
+	atomic.AddUint64(&cd.unchangedSkipped, 1)
+	return true
+}
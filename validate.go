@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ValidationError is returned by Set when a sampled value does not survive
+// a Marshal/Unmarshal round trip, e.g. because of unexported fields, time
+// zones, or NaNs. The value is not written.
+type ValidationError struct {
+	Key     string
+	Value   interface{}
+	Decoded interface{}
+	Err     error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("cache: value for key %q does not round trip: %s", e.Key, e.Err)
+	}
+	return fmt.Sprintf("cache: value for key %q does not round trip: got %+v, wanted %+v",
+		e.Key, e.Decoded, e.Value)
+}
+
+func (cd *Cache) shouldValidate() bool {
+	return sample(cd.opt.ValidateSampleRate)
+}
+
+// validate decodes b into a fresh instance of the value type and deep
+// compares it with the original value.
+func (cd *Cache) validate(key string, value interface{}, b []byte) error {
+	if isRawValue(value) {
+		return nil
+	}
+
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	// Omitted and hashed fields don't round trip by design.
+	if scrubbed, err := cd.scrub(v); err == nil {
+		v = scrubbed
+	}
+
+	decoded := reflect.New(v.Type())
+	if err := cd.UnmarshalKey(key, b, decoded.Interface()); err != nil {
+		return &ValidationError{
+			Key:   key,
+			Value: value,
+			Err:   err,
+		}
+	}
+
+	if !reflect.DeepEqual(decoded.Elem().Interface(), v.Interface()) {
+		return &ValidationError{
+			Key:     key,
+			Value:   v.Interface(),
+			Decoded: decoded.Elem().Interface(),
+		}
+	}
+	return nil
+}
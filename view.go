@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// View is a value derived from other cached keys and cached under its own
+// key. With Options.TrackDependencies, setting or deleting any of the
+// inputs deletes the view, so it is rebuilt on the next GetView.
+// Otherwise views only expire with their TTL.
+type View struct {
+	// Key is the cache key of the view.
+	Key string
+	// Inputs are the cache keys the view is derived from.
+	Inputs []string
+	// TTL is the TTL of the view. Default is 1 hour.
+	TTL time.Duration
+	// Build derives the value of the view, usually from the inputs read
+	// from the cache.
+	Build func(ctx context.Context) (interface{}, error)
+}
+
+// GetView gets the view from the cache into value, building and caching
+// it on a miss like Once.
+func (cd *Cache) GetView(ctx context.Context, view *View, value interface{}) error {
+	return cd.Once(&Item{
+		Ctx:       ctx,
+		Key:       view.Key,
+		Value:     value,
+		TTL:       view.TTL,
+		DependsOn: view.Inputs,
+		Do: func(item *Item) (interface{}, error) {
+			return view.Build(item.Context())
+		},
+	})
+}
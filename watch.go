@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Watcher pushes changes of watched keys to a subscriber.
+// It is created with Cache.Watch.
+type Watcher struct {
+	cd       *Cache
+	keys     []string
+	onChange func(key string, value []byte)
+
+	hashes map[string]uint64
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Watch periodically checks the given keys every Options.WatchInterval
+// with GetIfChanged and calls onChange when the content of a key changes,
+// so config-style keys can be pushed to subscribers instead of being
+// re-read on every request. With Options.ContentHash only the hashes are
+// fetched until a key changes. onChange receives the stored bytes, which
+// can be decoded with UnmarshalKey, and nil when the key is deleted.
+// Current values are delivered on the first fetch. onChange is called from
+// a single goroutine.
+func (cd *Cache) Watch(keys []string, onChange func(key string, value []byte)) *Watcher {
+	w := &Watcher{
+		cd:       cd,
+		keys:     keys,
+		onChange: onChange,
+		hashes:   make(map[string]uint64, len(keys)),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Close stops the watcher and waits for the in-flight callbacks to return.
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+	return nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.cd.opt.WatchInterval)
+	defer ticker.Stop()
+
+	for {
+		w.poll()
+
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Watcher) poll() {
+	for _, key := range w.keys {
+		prev, existed := w.hashes[key]
+		// With Options.ContentHash the hash is compared before the value
+		// is fetched.
+		b, hash, changed, err := w.cd.GetIfChanged(context.Background(), key, prev)
+		switch {
+		case err == ErrCacheMiss:
+			if existed {
+				delete(w.hashes, key)
+				w.onChange(key, nil)
+			}
+		case err != nil:
+			// The state of the key is unknown.
+		case changed:
+			w.hashes[key] = hash
+			w.onChange(key, b)
+		}
+	}
+}
+
+func contentHash(b []byte) uint64 {
+	return xxhash.Sum64(b)
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"log"
+	"time"
+)
+
+// WriteLogEntry describes a write sampled by Options.WriteLogSampleRate.
+type WriteLogEntry struct {
+	Key string
+	// Size is the size of the marshaled value in bytes.
+	Size int
+	TTL  time.Duration
+	// Payload is what Options.RedactPayload returned for the value,
+	// or nil without RedactPayload.
+	Payload interface{}
+}
+
+func logWrite(e WriteLogEntry) {
+	if e.Payload != nil {
+		log.Printf("cache: set key=%q size=%d ttl=%s payload=%+v", e.Key, e.Size, e.TTL, e.Payload)
+		return
+	}
+	log.Printf("cache: set key=%q size=%d ttl=%s", e.Key, e.Size, e.TTL)
+}
+
+// maybeLogWrite passes a sample of writes to Options.WriteLogger.
+func (cd *Cache) maybeLogWrite(item *Item, value interface{}, b []byte) {
+	if !sample(cd.opt.WriteLogSampleRate) {
+		return
+	}
+
+	e := WriteLogEntry{
+		Key:  item.Key,
+		Size: len(b),
+		TTL:  item.ttl(),
+	}
+	if cd.opt.RedactPayload != nil {
+		e.Payload = cd.opt.RedactPayload(item.Key, value)
+	}
+	cd.opt.WriteLogger(e)
+}